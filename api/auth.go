@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Roles of the actors making requests
+const (
+	RoleCustomer = "customer"
+	RoleSupport  = "support"
+	RoleAdmin    = "admin"
+	// RoleSystem is used for changes made by the API itself, requests cannot use it
+	RoleSystem = "system"
+)
+
+// systemActor makes the changes that are not requested by anyone, such as seeding the DBs
+var systemActor = Actor{Key: "system", Role: RoleSystem}
+
+// Headers that identify the actor of a request. They are set by the gateway in
+// front of the API after authenticating the caller and are trusted as they are.
+const (
+	headerActorKey  = "X-Actor-Key"
+	headerActorRole = "X-Actor-Role"
+)
+
+// Errors returned when the actor is not allowed to make a refund
+var (
+	ErrNotOrderOwner   = errors.New("order does not belong to the user")
+	ErrActorNotAllowed = errors.New("actor is not allowed to act for the user")
+)
+
+// Actor is the customer or staff member making a request
+type Actor struct {
+	Key  string
+	Role string
+}
+
+// canOverrideOwnership reports whether the actor may refund orders the user does not own
+func (a Actor) canOverrideOwnership() bool {
+	return a.Role == RoleAdmin || a.Role == RoleSupport
+}
+
+// canActFor reports whether the actor may make refunds to the user with the given key
+func (a Actor) canActFor(userKey string) bool {
+	return a.canOverrideOwnership() || a.Key == userKey
+}
+
+type actorKey struct{}
+
+// withActor returns a copy of ctx carrying the actor
+func withActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, a)
+}
+
+// actorFrom returns the actor of the request. Without one, the user is taken to act for themselves.
+func actorFrom(ctx context.Context, userKey string) Actor {
+	if a, ok := ctx.Value(actorKey{}).(Actor); ok {
+		return a
+	}
+
+	return Actor{Key: userKey, Role: RoleCustomer}
+}
+
+// actorFromRequest reads the actor from the request headers.
+// Requests without the headers are made by the user in the body of the request.
+func actorFromRequest(r *http.Request, userKey string) (Actor, error) {
+	a := Actor{
+		Key:  strings.TrimSpace(r.Header.Get(headerActorKey)),
+		Role: strings.ToLower(strings.TrimSpace(r.Header.Get(headerActorRole))),
+	}
+
+	if a.Key == "" && a.Role == "" {
+		return Actor{Key: userKey, Role: RoleCustomer}, nil
+	}
+
+	if a.Key == "" {
+		return Actor{}, fmt.Errorf("%s header is missing", headerActorKey)
+	}
+
+	switch a.Role {
+	case "":
+		a.Role = RoleCustomer
+	case RoleCustomer, RoleSupport, RoleAdmin:
+	default:
+		return Actor{}, fmt.Errorf("unknown actor role: %s", a.Role)
+	}
+
+	return a, nil
+}
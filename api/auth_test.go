@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+func Test_actorFromRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		role    string
+		want    Actor
+		wantErr bool
+	}{
+		{name: "defaults to the user", want: Actor{Key: "john-doe", Role: RoleCustomer}},
+		{name: "reads staff actors", key: "agent-1", role: "Support", want: Actor{Key: "agent-1", Role: RoleSupport}},
+		{name: "defaults to customer role", key: "jane-doe", want: Actor{Key: "jane-doe", Role: RoleCustomer}},
+		{name: "fails without actor key", role: RoleAdmin, wantErr: true},
+		{name: "fails for unknown roles", key: "agent-1", role: "root", wantErr: true},
+		{name: "fails for the system role", key: "agent-1", role: RoleSystem, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/order/1/refund/", nil)
+			if tt.key != "" {
+				r.Header.Set(headerActorKey, tt.key)
+			}
+			if tt.role != "" {
+				r.Header.Set(headerActorRole, tt.role)
+			}
+
+			got, err := actorFromRequest(r, "john-doe")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("actorFromRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("actorFromRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_makeRefund_ownership(t *testing.T) {
+	tests := []struct {
+		name      string
+		actor     *Actor
+		userKey   string
+		orderID   string
+		wantErr   error
+		wantAudit bool
+	}{
+		{
+			name:    "refunds order of the user",
+			userKey: "john-doe",
+			orderID: "1",
+		},
+		{
+			name:    "refuses order of another user",
+			userKey: "john-doe",
+			orderID: "4",
+			wantErr: ErrNotOrderOwner,
+		},
+		{
+			name:    "refuses customers acting for another user",
+			actor:   &Actor{Key: "jane-doe", Role: RoleCustomer},
+			userKey: "john-doe",
+			orderID: "1",
+			wantErr: ErrActorNotAllowed,
+		},
+		{
+			name:    "lets staff refund orders of the user without audit",
+			actor:   &Actor{Key: "agent-1", Role: RoleSupport},
+			userKey: "john-doe",
+			orderID: "1",
+		},
+		{
+			name:      "lets support override ownership",
+			actor:     &Actor{Key: "agent-1", Role: RoleSupport},
+			userKey:   "john-doe",
+			orderID:   "4",
+			wantAudit: true,
+		},
+		{
+			name:      "lets admins override ownership",
+			actor:     &Actor{Key: "admin-1", Role: RoleAdmin},
+			userKey:   "john-doe",
+			orderID:   "4",
+			wantAudit: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			ctx := context.Background()
+			if tt.actor != nil {
+				ctx = withActor(ctx, *tt.actor)
+			}
+
+			err := srv.makeRefund(ctx, tt.userKey, tt.orderID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("makeRefund() error = %v, want %v", err, tt.wantErr)
+			}
+
+			order, _ := srv.ord.Get(ctx, tt.orderID)
+			if order.IsDeleted != (tt.wantErr == nil) {
+				t.Errorf("makeRefund() order refunded = %v, want %v", order.IsDeleted, tt.wantErr == nil)
+			}
+
+			entries, _ := srv.aud.List(ctx)
+			if !tt.wantAudit {
+				if len(entries) != 0 {
+					t.Errorf("makeRefund() audited %v", entries)
+				}
+				return
+			}
+
+			if len(entries) != 1 {
+				t.Fatalf("makeRefund() audit = %v, want one entry", entries)
+			}
+
+			got := *entries[0]
+			if got.Time.IsZero() {
+				t.Errorf("makeRefund() audit entry has no time")
+			}
+
+			wantEntry := model.AuditEntry{
+				Time:      got.Time,
+				Action:    model.AuditOwnershipOverride,
+				ActorKey:  tt.actor.Key,
+				ActorRole: tt.actor.Role,
+				UserKey:   tt.userKey,
+				OrderID:   tt.orderID,
+			}
+			if got != wantEntry {
+				t.Errorf("makeRefund() audit entry = %v, want %v", got, wantEntry)
+			}
+		})
+	}
+}
+
+func Test_refundHandler_ownership(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{name: "returns forbidden for order of another user", want: http.StatusForbidden},
+		{name: "returns forbidden for customers acting for another user", headers: map[string]string{headerActorKey: "jane-doe"}, want: http.StatusForbidden},
+		{name: "returns bad request for unknown roles", headers: map[string]string{headerActorKey: "agent-1", headerActorRole: "root"}, want: http.StatusBadRequest},
+		{name: "lets support override ownership", headers: map[string]string{headerActorKey: "agent-1", headerActorRole: RoleSupport}, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+
+			req := httptest.NewRequest(http.MethodPost, "/order/4/refund/", bytes.NewBufferString(`{"user_key": "john-doe"}`))
+			req.Header.Set("Content-Type", "application/json")
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+
+			srv.Router(false).ServeHTTP(rr, req)
+
+			if rr.Code != tt.want {
+				t.Errorf("refundHandler() status = %v, want %v\n%s", rr.Code, tt.want, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+// Error codes of the API. They are part of the contract with the consumers and must not change.
+const (
+	codeInvalidRequest       = "invalid_request"
+	codeInvalidRefund        = "invalid_refund"
+	codeInvalidAmount        = "invalid_amount"
+	codeCurrencyMismatch     = "currency_mismatch"
+	codeUnsupportedCurrency  = "unsupported_currency"
+	codeInvalidUser          = "invalid_user"
+	codeUserExists           = "user_exists"
+	codeUserNotFound         = "user_not_found"
+	codeInvalidOrder         = "invalid_order"
+	codeOrderExists          = "order_exists"
+	codeOrderNotFound        = "order_not_found"
+	codeAccountNotFound      = "account_not_found"
+	codeRefundNotFound       = "refund_not_found"
+	codeRedemptionNotFound   = "redemption_not_found"
+	codeRedemptionReversed   = "redemption_already_reversed"
+	codeAlreadyRefunded      = "order_already_refunded"
+	codeRefundExceeded       = "refund_amount_exceeded"
+	codeInsufficientFunds    = "insufficient_funds"
+	codeInvalidExpiry        = "invalid_expiry"
+	codeNotOrderOwner        = "order_not_owned"
+	codeActorNotAllowed      = "actor_not_allowed"
+	codeIdempotencyKeyReused = "idempotency_key_reused"
+	codePaymentNotRefundable = "payment_not_refundable"
+	codePaymentDeclined      = "payment_declined"
+	codeInternal             = "internal_error"
+)
+
+// errInvalidRequest is returned for requests that cannot be read, such as malformed bodies or headers
+var errInvalidRequest = errors.New("invalid request")
+
+// errInvalidRefund is returned for refund requests that cannot be applied to the order
+var errInvalidRefund = errors.New("invalid refund")
+
+// errorCodes maps the errors of the API to their status and code, the first match is used
+var errorCodes = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{errInvalidRequest, http.StatusBadRequest, codeInvalidRequest},
+	{ErrNotOrderOwner, http.StatusForbidden, codeNotOrderOwner},
+	{ErrActorNotAllowed, http.StatusForbidden, codeActorNotAllowed},
+	{model.ErrUserNotFound, http.StatusNotFound, codeUserNotFound},
+	{model.ErrOrderNotFound, http.StatusNotFound, codeOrderNotFound},
+	{model.ErrAccountNotFound, http.StatusNotFound, codeAccountNotFound},
+	{model.ErrRefundNotFound, http.StatusNotFound, codeRefundNotFound},
+	{model.ErrRedemptionNotFound, http.StatusNotFound, codeRedemptionNotFound},
+	{model.ErrRedemptionReversed, http.StatusConflict, codeRedemptionReversed},
+	{model.ErrUserExists, http.StatusConflict, codeUserExists},
+	{model.ErrOrderExists, http.StatusConflict, codeOrderExists},
+	{model.ErrAlreadyRefunded, http.StatusConflict, codeAlreadyRefunded},
+	{model.ErrRefundExceeded, http.StatusUnprocessableEntity, codeRefundExceeded},
+	{model.ErrCurrencyMismatch, http.StatusUnprocessableEntity, codeCurrencyMismatch},
+	{model.ErrNoExchangeRate, http.StatusUnprocessableEntity, codeUnsupportedCurrency},
+	{model.ErrInsufficientFunds, http.StatusUnprocessableEntity, codeInsufficientFunds},
+	{model.ErrInvalidExpiry, http.StatusUnprocessableEntity, codeInvalidExpiry},
+	{model.ErrInvalidAmount, http.StatusUnprocessableEntity, codeInvalidAmount},
+	{errInvalidRefund, http.StatusUnprocessableEntity, codeInvalidRefund},
+	{model.ErrInvalidUser, http.StatusUnprocessableEntity, codeInvalidUser},
+	{model.ErrInvalidOrder, http.StatusUnprocessableEntity, codeInvalidOrder},
+	{model.ErrPaymentNotRefundable, http.StatusUnprocessableEntity, codePaymentNotRefundable},
+	{model.ErrPaymentDeclined, http.StatusUnprocessableEntity, codePaymentDeclined},
+}
+
+// errorResponse is the body of every error response:
+//
+//	{"error": {"code": "order_not_found", "message": "order not found: 987"}}
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError writes the status and the error envelope for err.
+// Errors without a code are logged and answered with 500 without their details.
+func writeError(w http.ResponseWriter, err error) {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			writeErrorCode(w, c.status, c.code, err.Error())
+			return
+		}
+	}
+
+	log.Printf("internal error: %s", err)
+	writeErrorCode(w, http.StatusInternalServerError, codeInternal, "internal error")
+}
+
+// writeErrorCode writes the error envelope with the given status, code and message
+func writeErrorCode(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(errorResponse{Error: errorBody{Code: code, Message: message}})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+func Test_writeError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantStatus  int
+		wantCode    string
+		wantMessage string
+	}{
+		{
+			name:        "maps wrapped model errors",
+			err:         fmt.Errorf("%w: 987", model.ErrOrderNotFound),
+			wantStatus:  http.StatusNotFound,
+			wantCode:    codeOrderNotFound,
+			wantMessage: "order not found: 987",
+		},
+		{
+			name:        "maps already refunded orders to conflict",
+			err:         model.ErrAlreadyRefunded,
+			wantStatus:  http.StatusConflict,
+			wantCode:    codeAlreadyRefunded,
+			wantMessage: "order already refunded",
+		},
+		{
+			name:        "maps refunds over the order total to unprocessable entity",
+			err:         fmt.Errorf("%w: 101.00 USD is more than 100.00 USD", model.ErrRefundExceeded),
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantCode:    codeRefundExceeded,
+			wantMessage: "refund amount exceeds the refundable amount: 101.00 USD is more than 100.00 USD",
+		},
+		{
+			name:        "maps the errors of conversions",
+			err:         fmt.Errorf("cannot convert 1.00 USD to KWD\n%w", fmt.Errorf("%w for KWD", model.ErrNoExchangeRate)),
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantCode:    codeUnsupportedCurrency,
+			wantMessage: "cannot convert 1.00 USD to KWD\nno exchange rate for KWD",
+		},
+		{
+			name:        "maps ownership errors to forbidden",
+			err:         ErrNotOrderOwner,
+			wantStatus:  http.StatusForbidden,
+			wantCode:    codeNotOrderOwner,
+			wantMessage: ErrNotOrderOwner.Error(),
+		},
+		{
+			name:        "hides the details of unknown errors",
+			err:         errors.New("database is locked"),
+			wantStatus:  http.StatusInternalServerError,
+			wantCode:    codeInternal,
+			wantMessage: "internal error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			writeError(rr, tt.err)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("writeError() status = %v, want %v", rr.Code, tt.wantStatus)
+			}
+
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("writeError() Content-Type = %q, want application/json", ct)
+			}
+
+			var got errorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatalf("cannot decode error response %q: %v", rr.Body.String(), err)
+			}
+
+			if want := (errorBody{Code: tt.wantCode, Message: tt.wantMessage}); got.Error != want {
+				t.Errorf("writeError() body = %+v, want %+v", got.Error, want)
+			}
+		})
+	}
+}
+
+func Test_refundHandler_errorCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		orderID  string
+		body     string
+		header   map[string]string
+		wantCode string
+	}{
+		{name: "invalid JSON", orderID: "1", body: `{"user_key":`, wantCode: codeInvalidRequest},
+		{name: "missing user key", orderID: "1", body: `{}`, wantCode: codeInvalidRequest},
+		{name: "unknown user", orderID: "1", body: `{"user_key": "barbara-streisand"}`, wantCode: codeUserNotFound},
+		{name: "unknown order", orderID: "987", body: `{"user_key": "john-doe"}`, wantCode: codeOrderNotFound},
+		{name: "refunded order", orderID: "2", body: `{"user_key": "john-doe"}`, wantCode: codeAlreadyRefunded},
+		{name: "order of another user", orderID: "4", body: `{"user_key": "john-doe"}`, wantCode: codeNotOrderOwner},
+		{name: "acting for another user", orderID: "1", body: `{"user_key": "john-doe"}`, header: map[string]string{headerActorKey: "jane-doe"}, wantCode: codeActorNotAllowed},
+		{name: "invalid amount", orderID: "1", body: `{"user_key": "john-doe", "amount": "five"}`, wantCode: codeInvalidAmount},
+		{name: "amount in another currency", orderID: "1", body: `{"user_key": "john-doe", "amount": {"amount": "1", "currency": "EUR"}}`, wantCode: codeCurrencyMismatch},
+		{name: "unknown item", orderID: "1", body: `{"user_key": "john-doe", "lines": [{"item": "SKU-9", "amount": "1"}]}`, wantCode: codeInvalidRefund},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postRefund(newTestServer().Router(false), tt.orderID, tt.body, tt.header)
+
+			var got errorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatalf("cannot decode error response %q: %v", rr.Body.String(), err)
+			}
+
+			if got.Error.Code != tt.wantCode {
+				t.Errorf("refundHandler() code = %q, want %q (%s)", got.Error.Code, tt.wantCode, got.Error.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+const (
+	headerIdempotencyKey = "Idempotency-Key"
+	// headerIdempotentReplayed is set on responses replayed for a retried request
+	headerIdempotentReplayed = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+	// idempotencyTTL is how long a response is replayed, after that the key can be used again
+	idempotencyTTL = 24 * time.Hour
+)
+
+// idempotent makes retries of requests with an Idempotency-Key header safe. The first response
+// for a key is stored and replayed as it is for retries with the same key and request. Using
+// the key with another request is rejected with 409 Conflict. Server errors are not stored so
+// the request can be retried. Requests without the header are passed to h as they are.
+// If the stored responses cannot be read the request is not run, since it may have been run
+// before. A response that cannot be stored is still written, its request is already done.
+func (s *Server) idempotent(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		key := strings.TrimSpace(r.Header.Get(headerIdempotencyKey))
+		if key == "" {
+			h(w, r, ps)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, fmt.Errorf("%w: %s header is too long", errInvalidRequest, headerIdempotencyKey))
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			writeError(w, fmt.Errorf("%w: cannot read body", errInvalidRequest))
+			return
+		}
+
+		// retries wait for the first request with the key to finish
+		unlock := s.locks.Lock("idempotency:" + key)
+		defer unlock()
+
+		hash := requestHash(r, body)
+		now := s.clock.Now()
+		rec, err := s.idk.Get(r.Context(), key)
+		if err != nil && !errors.Is(err, model.ErrIdempotencyKeyNotFound) {
+			writeError(w, err)
+			return
+		}
+
+		if err == nil && now.Sub(rec.CreatedAt) < idempotencyTTL {
+			if rec.RequestHash != hash {
+				writeErrorCode(w, http.StatusConflict, codeIdempotencyKeyReused, headerIdempotencyKey+" was used for another request")
+				return
+			}
+
+			replay(w, rec)
+			return
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		res := &bufferedResponse{header: http.Header{}}
+		h(res, r, ps)
+
+		if res.status() < 500 {
+			rec = &model.IdempotencyRecord{
+				RequestHash: hash,
+				StatusCode:  res.status(),
+				Header:      res.header,
+				Body:        res.body.Bytes(),
+				CreatedAt:   now,
+			}
+			if err = s.idk.Put(r.Context(), key, rec); err != nil {
+				log.Printf("response of %s %s is not saved for %s %q: %s", r.Method, r.URL.Path, headerIdempotencyKey, key, err)
+			}
+		}
+
+		res.writeTo(w)
+	}
+}
+
+// watchIdempotencyKeys removes the stored responses that are no longer replayed every interval until ctx is done
+func (s *Server) watchIdempotencyKeys(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.storesMu.RLock()
+			err := s.expireIdempotencyKeys(ctx)
+			s.storesMu.RUnlock()
+
+			if err != nil {
+				log.Printf("cannot remove expired idempotency keys: %s", err)
+			}
+		}
+	}
+}
+
+// expireIdempotencyKeys removes the responses stored longer than idempotencyTTL ago
+func (s *Server) expireIdempotencyKeys(ctx context.Context) error {
+	_, err := s.idk.DeleteBefore(ctx, s.clock.Now().Add(-idempotencyTTL))
+	return err
+}
+
+// requestHash identifies a request by its method, path, actor and body
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	for _, part := range []string{r.Method, r.URL.Path, r.Header.Get(headerActorKey), r.Header.Get(headerActorRole)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// replay writes a stored response
+func replay(w http.ResponseWriter, rec *model.IdempotencyRecord) {
+	for name, values := range rec.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(headerIdempotentReplayed, "true")
+
+	w.WriteHeader(rec.StatusCode)
+	w.Write(rec.Body)
+}
+
+// bufferedResponse is an http.ResponseWriter that keeps the response in memory
+type bufferedResponse struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.code == 0 {
+		b.code = code
+	}
+}
+
+// status returns the status code of the response, 200 if none was written
+func (b *bufferedResponse) status() int {
+	if b.code == 0 {
+		return http.StatusOK
+	}
+
+	return b.code
+}
+
+// writeTo writes the buffered response to w
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for name, values := range b.header {
+		w.Header()[name] = values
+	}
+
+	w.WriteHeader(b.status())
+	w.Write(b.body.Bytes())
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+// postRefund sends a refund request for the order to the router and returns the response
+func postRefund(router http.Handler, orderID, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/order/"+orderID+"/refund/", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func Test_idempotent_refund(t *testing.T) {
+	body := `{"user_key": "john-doe"}`
+
+	tests := []struct {
+		name       string
+		orderID    string
+		retryBody  string
+		retryActor string
+		wantFirst  int
+		wantRetry  int
+		wantReplay bool
+	}{
+		{
+			name:       "replays the response of a successful refund",
+			orderID:    "1",
+			retryBody:  body,
+			wantFirst:  http.StatusOK,
+			wantRetry:  http.StatusOK,
+			wantReplay: true,
+		},
+		{
+			name:       "replays the response of a failed refund",
+			orderID:    "2",
+			retryBody:  body,
+			wantFirst:  http.StatusConflict,
+			wantRetry:  http.StatusConflict,
+			wantReplay: true,
+		},
+		{
+			name:      "rejects the key with another body",
+			orderID:   "1",
+			retryBody: `{"user_key": "john-doe", "amount": "10"}`,
+			wantFirst: http.StatusOK,
+			wantRetry: http.StatusConflict,
+		},
+		{
+			name:       "rejects the key for another actor",
+			orderID:    "1",
+			retryBody:  body,
+			retryActor: "agent-1",
+			wantFirst:  http.StatusOK,
+			wantRetry:  http.StatusConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			router := srv.Router(false)
+
+			first := postRefund(router, tt.orderID, body, map[string]string{headerIdempotencyKey: "key-1"})
+			if first.Code != tt.wantFirst {
+				t.Fatalf("first response = %v, want %v\n%s", first.Code, tt.wantFirst, first.Body.String())
+			}
+
+			header := map[string]string{headerIdempotencyKey: "key-1"}
+			if tt.retryActor != "" {
+				header[headerActorKey], header[headerActorRole] = tt.retryActor, RoleSupport
+			}
+
+			retry := postRefund(router, tt.orderID, tt.retryBody, header)
+			if retry.Code != tt.wantRetry {
+				t.Fatalf("retry response = %v, want %v\n%s", retry.Code, tt.wantRetry, retry.Body.String())
+			}
+
+			if !tt.wantReplay {
+				return
+			}
+
+			if retry.Body.String() != first.Body.String() || retry.Header().Get("Content-Type") != first.Header().Get("Content-Type") {
+				t.Errorf("retry response = %q, want the first response %q", retry.Body.String(), first.Body.String())
+			}
+
+			if retry.Header().Get(headerIdempotentReplayed) != "true" {
+				t.Errorf("retry response is not marked as replayed")
+			}
+
+			if entries, _ := srv.ldg.List(context.Background(), "john-doe"); len(entries) > 2 {
+				t.Errorf("retry refunded the order again, ledger = %v", entries)
+			}
+		})
+	}
+}
+
+func Test_idempotent_withoutKey(t *testing.T) {
+	router := newTestServer().Router(false)
+	body := `{"user_key": "john-doe"}`
+
+	if rr := postRefund(router, "1", body, nil); rr.Code != http.StatusOK {
+		t.Fatalf("first response = %v, want %v", rr.Code, http.StatusOK)
+	}
+
+	if rr := postRefund(router, "1", body, nil); rr.Code != http.StatusConflict {
+		t.Errorf("retry without a key = %v, want %v", rr.Code, http.StatusConflict)
+	}
+}
+
+func Test_idempotent_concurrentRetries(t *testing.T) {
+	srv := newTestServer()
+	router := srv.Router(false)
+
+	var wg sync.WaitGroup
+	codes := make([]int, 10)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rr := postRefund(router, "3", `{"user_key": "john-doe"}`, map[string]string{headerIdempotencyKey: "key-1"})
+			codes[i] = rr.Code
+		}(i)
+	}
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d = %v, want %v", i, code, http.StatusOK)
+		}
+	}
+
+	account, _ := srv.vch.Get(context.Background(), "john-doe-usd")
+	if account == nil || account.Balance != usd(300) {
+		t.Errorf("voucher account = %v, want a single refund of %v", account, usd(300))
+	}
+}
+
+func Test_idempotent(t *testing.T) {
+	srv := newTestServer()
+
+	calls := 0
+	h := srv.idempotent(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		calls++
+		if calls == 1 {
+			http.Error(w, "database is locked", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("done"))
+	})
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/order/1/refund/", bytes.NewBufferString("{}"))
+		req.Header.Set(headerIdempotencyKey, "key-1")
+		rr := httptest.NewRecorder()
+		h(rr, req, nil)
+		return rr
+	}
+
+	if rr := send(); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("first response = %v, want %v", rr.Code, http.StatusInternalServerError)
+	}
+
+	if rr := send(); rr.Code != http.StatusOK || calls != 2 {
+		t.Fatalf("retry after a server error = %v after %d calls, want the request to run again", rr.Code, calls)
+	}
+
+	if rr := send(); rr.Body.String() != "done" || calls != 2 {
+		t.Errorf("retry = %q after %d calls, want the stored response", rr.Body.String(), calls)
+	}
+
+	// expired keys run the request again
+	rec, _ := srv.idk.Get(context.Background(), "key-1")
+	rec.CreatedAt = time.Now().Add(-idempotencyTTL)
+	srv.idk.Put(context.Background(), "key-1", rec)
+
+	if send(); calls != 3 {
+		t.Errorf("retry with an expired key did not run the request")
+	}
+}
+
+// unreadableIdempotencyStore fails every Get
+type unreadableIdempotencyStore struct {
+	model.IdempotencyStore
+}
+
+func (unreadableIdempotencyStore) Get(context.Context, string) (*model.IdempotencyRecord, error) {
+	return nil, errors.New("disk I/O error")
+}
+
+// failingIdempotencyStore fails every Put
+type failingIdempotencyStore struct {
+	model.IdempotencyStore
+}
+
+func (failingIdempotencyStore) Put(context.Context, string, *model.IdempotencyRecord) error {
+	return errors.New("disk is full")
+}
+
+func Test_idempotent_storeFails(t *testing.T) {
+	srv := newTestServer()
+	srv.idk = unreadableIdempotencyStore{srv.idk}
+
+	calls := 0
+	h := srv.idempotent(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		calls++
+		w.Write([]byte("done"))
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/order/1/refund/", bytes.NewBufferString("{}"))
+	req.Header.Set(headerIdempotencyKey, "key-1")
+	rr := httptest.NewRecorder()
+	h(rr, req, nil)
+
+	if rr.Code != http.StatusInternalServerError || calls != 0 {
+		t.Errorf("response when the keys cannot be read = %v after %d calls, want %v without running the request", rr.Code, calls, http.StatusInternalServerError)
+	}
+
+	// the keys can be read but the response cannot be saved
+	srv.idk = failingIdempotencyStore{model.NewIdempotencyKeys()}
+
+	rr = httptest.NewRecorder()
+	h(rr, req, nil)
+
+	if rr.Code != http.StatusOK || rr.Body.String() != "done" || calls != 1 {
+		t.Errorf("response when the response cannot be saved = %v %q after %d calls, want the response of the request", rr.Code, rr.Body.String(), calls)
+	}
+}
+
+func Test_expireIdempotencyKeys(t *testing.T) {
+	srv := newTestServer()
+	ctx := context.Background()
+	now := useTestClock(srv)
+
+	srv.idk.Put(ctx, "old", &model.IdempotencyRecord{CreatedAt: now.Add(-idempotencyTTL - time.Second)})
+	srv.idk.Put(ctx, "new", &model.IdempotencyRecord{CreatedAt: now.Add(-time.Hour)})
+
+	if err := srv.expireIdempotencyKeys(ctx); err != nil {
+		t.Fatalf("expireIdempotencyKeys() error = %v", err)
+	}
+
+	if _, err := srv.idk.Get(ctx, "old"); !errors.Is(err, model.ErrIdempotencyKeyNotFound) {
+		t.Errorf("Get() of an expired key error = %v, want %v", err, model.ErrIdempotencyKeyNotFound)
+	}
+
+	if _, err := srv.idk.Get(ctx, "new"); err != nil {
+		t.Errorf("Get() of a key that is not expired error = %v", err)
+	}
+}
+
+func Test_idempotent_keyTooLong(t *testing.T) {
+	router := newTestServer().Router(false)
+
+	key := string(bytes.Repeat([]byte("k"), maxIdempotencyKeyLength+1))
+	if rr := postRefund(router, "1", `{"user_key": "john-doe"}`, map[string]string{headerIdempotencyKey: key}); rr.Code != http.StatusBadRequest {
+		t.Errorf("response = %v, want %v", rr.Code, http.StatusBadRequest)
+	}
+}
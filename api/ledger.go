@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+// ledgerResponse is the body returned by the ledger endpoint
+type ledgerResponse struct {
+	UserKey  string                 `json:"user_key"`
+	Entries  []*model.LedgerEntry   `json:"entries"`
+	Balances map[string]model.Money `json:"balances"` // derived from every entry of the user, filters do not apply
+}
+
+// ledgerHandler returns the ledger entries of the user in the order they were appended.
+// The entries can be filtered by the account and reason query parameters.
+func (s *Server) ledgerHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	userKey := ps.ByName("userKey")
+
+	actor, err := actorFromRequest(r, userKey)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %s", errInvalidRequest, err))
+		return
+	}
+
+	if !actor.canActFor(userKey) {
+		writeError(w, ErrActorNotAllowed)
+		return
+	}
+
+	if _, err = s.usr.Get(r.Context(), userKey); err != nil {
+		if errors.Is(err, model.ErrUserNotFound) {
+			err = fmt.Errorf("%w: %s", model.ErrUserNotFound, userKey)
+		}
+		writeError(w, err)
+		return
+	}
+
+	entries, err := s.ldg.List(r.Context(), userKey)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	balances, err := model.Balances(entries)
+	if err != nil {
+		writeError(w, fmt.Errorf("cannot derive the balances of %s\n%s", userKey, err))
+		return
+	}
+
+	account, reason := r.URL.Query().Get("account"), r.URL.Query().Get("reason")
+	filtered := make([]*model.LedgerEntry, 0, len(entries))
+	for _, e := range entries {
+		if (account == "" || e.Account == account) && (reason == "" || e.Reason == reason) {
+			filtered = append(filtered, e)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	res := ledgerResponse{UserKey: userKey, Entries: filtered, Balances: balances}
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Printf("cannot write ledger response: %s", err)
+	}
+}
+
+// newLedgerEntry returns an entry recording a movement of the account made by the actor at now
+func newLedgerEntry(actor Actor, userKey, account string, delta model.Money, reason, orderID string, now time.Time) *model.LedgerEntry {
+	return &model.LedgerEntry{
+		Time:      now.UTC(),
+		Account:   account,
+		UserKey:   userKey,
+		Delta:     delta,
+		Reason:    reason,
+		OrderID:   orderID,
+		ActorKey:  actor.Key,
+		ActorRole: actor.Role,
+	}
+}
+
+// appendOpeningBalance records a balance that was loaded without going through the ledger,
+// so the balance can be derived from the ledger. Zero balances are not recorded.
+func appendOpeningBalance(ctx context.Context, ledger model.LedgerStore, userKey, account string, balance model.Money, now time.Time) error {
+	if balance.IsZero() {
+		return nil
+	}
+
+	return ledger.Append(ctx, newLedgerEntry(systemActor, userKey, account, balance, model.LedgerOpeningBalance, "", now))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+func Test_refund_recordsLedger(t *testing.T) {
+	srv := newTestServer()
+	ctx := context.Background()
+
+	for _, id := range []string{"1", "3"} {
+		if err := srv.makeRefund(ctx, "john-doe", id); err != nil {
+			t.Fatalf("makeRefund() error = %v", err)
+		}
+	}
+
+	entries, _ := srv.ldg.List(ctx, "john-doe")
+	if len(entries) != 3 {
+		t.Fatalf("List() = %d entries, want opening balance and two refunds", len(entries))
+	}
+
+	refund := entries[2]
+	want := model.LedgerEntry{
+		Time:      refund.Time,
+		Account:   model.VoucherAccount("john-doe-usd"),
+		UserKey:   "john-doe",
+		Delta:     usd(300),
+		Reason:    model.LedgerRefund,
+		OrderID:   "3",
+		RefundID:  refund.RefundID,
+		ActorKey:  "john-doe",
+		ActorRole: RoleCustomer,
+	}
+	if *refund != want || refund.Time.IsZero() || refund.RefundID == "" {
+		t.Errorf("refund entry = %v, want %v", *refund, want)
+	}
+
+	balances, err := model.Balances(entries)
+	if err != nil {
+		t.Fatalf("Balances() error = %v", err)
+	}
+
+	user, _ := srv.usr.Get(ctx, "john-doe")
+	account, _ := srv.vch.Get(ctx, "john-doe-usd")
+	wantBalances := map[string]model.Money{
+		model.BalanceAccount("john-doe"):     user.Balance,
+		model.VoucherAccount("john-doe-usd"): account.Balance,
+	}
+	if !reflect.DeepEqual(balances, wantBalances) {
+		t.Errorf("Balances() = %v, want the balances of the user %v", balances, wantBalances)
+	}
+}
+
+func Test_refund_failedRefundIsNotRecorded(t *testing.T) {
+	srv := newTestServer()
+	ctx := context.Background()
+
+	if err := srv.makeRefund(ctx, "john-doe", "2"); err == nil {
+		t.Fatalf("makeRefund() of a refunded order error = nil")
+	}
+
+	if entries, _ := srv.ldg.List(ctx, "john-doe"); len(entries) != 1 {
+		t.Errorf("List() = %v, want only the opening balance", entries)
+	}
+}
+
+func Test_ledgerHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		userKey     string
+		query       string
+		actorKey    string
+		wantCode    int
+		wantEntries int
+	}{
+		{name: "returns the ledger of the user", userKey: "john-doe", wantCode: http.StatusOK, wantEntries: 2},
+		{name: "filters by reason", userKey: "john-doe", query: "?reason=refund", wantCode: http.StatusOK, wantEntries: 1},
+		{name: "filters by account", userKey: "john-doe", query: "?account=voucher:john-doe-usd", wantCode: http.StatusOK, wantEntries: 0},
+		{name: "returns forbidden for another customer", userKey: "john-doe", actorKey: "jane-doe", wantCode: http.StatusForbidden},
+		{name: "returns not found for unknown users", userKey: "barbara-streisand", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			if err := srv.makeRefund(context.Background(), "john-doe", "1"); err != nil {
+				t.Fatalf("makeRefund() error = %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/users/"+tt.userKey+"/ledger"+tt.query, nil)
+			if tt.actorKey != "" {
+				req.Header.Set(headerActorKey, tt.actorKey)
+			}
+			rr := httptest.NewRecorder()
+
+			srv.Router(false).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantCode {
+				t.Fatalf("ledgerHandler() = %v, want %v\n%s", rr.Code, tt.wantCode, rr.Body.String())
+			}
+
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var res ledgerResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+				t.Fatalf("cannot decode response: %v", err)
+			}
+
+			if len(res.Entries) != tt.wantEntries {
+				t.Errorf("ledgerHandler() entries = %v, want %d", res.Entries, tt.wantEntries)
+			}
+
+			if want := usd(200); res.Balances[model.BalanceAccount("john-doe")] != want {
+				t.Errorf("ledgerHandler() balances = %v, want %v", res.Balances, want)
+			}
+		})
+	}
+}
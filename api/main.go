@@ -1,149 +1,686 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
 	"github.com/srgyrn/pact-example/api/model"
+	"github.com/srgyrn/pact-example/api/sqlite"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
-type DBGateway interface {
-	AddToDB() error
-	Find(key string) error
-	Delete(key string) bool
-}
-
 type BalanceHolder interface {
-	UpdateBalance(balance float32) (float32, error)
+	UpdateBalance(amount model.Money) (model.Money, error)
 }
 
-var dbs struct {
-	vch *model.VoucherHandler
-	usr *model.UserHandler
-	ord *model.OrderHandler
-}
+// dataDir holds the seed data files, relative to the repository root
+var dataDir = "./api/data/"
+
+// Storage backends selectable with the db flag
+const (
+	backendMemory = "memory"
+	backendSQLite = "sqlite"
+)
 
 func main() {
-	err := initDBs()
+	testMode := flag.Bool("test-mode", false, "enables the pact provider states endpoint")
+	backend := flag.String("db", backendMemory, "storage backend: memory or sqlite")
+	dbPath := flag.String("db-path", "./api/data/refunds.db", "path of the SQLite database file")
+	ratesPath := flag.String("rates", dataDir+"rates.json", "path of the exchange rates file")
+	rulesPath := flag.String("rules", dataDir+"refund-rules.json", "path of the refund rules file, built-in rules are used if empty")
+	rulesReload := flag.Duration("rules-reload", 10*time.Second, "how often the refund rules file is checked for changes, 0 disables reloading")
+	paymentSync := flag.Duration("payment-sync", 5*time.Second, "how often pending refunds are checked with the payment gateways")
+	voucherSweep := flag.Duration("voucher-sweep", time.Minute, "how often expired voucher credits are taken off the accounts, 0 disables sweeps")
+	refundCreditValidity := flag.Duration("refund-credit-validity", defaultRefundCreditValidity, "how long refunds credited to voucher accounts can be spent, 0 credits them without expiry")
+	idempotencySweep := flag.Duration("idempotency-sweep", time.Hour, "how often expired idempotency keys are removed, 0 disables sweeps")
+	fakeConfirm := flag.Duration("fake-gateway-delay", 2*time.Second, "how long the fake payment gateways take to confirm a refund")
+	flag.Usage = usage
+	flag.Parse()
+
+	st, err := openStorage(*backend, *dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer st.close()
 
+	ctx := context.Background()
+	if flag.Arg(0) == "migrate" {
+		if err = runMigrate(ctx, st, model.SystemClock, flag.Args()[1:], os.Stdout); err != nil {
+			st.close()
+			log.Fatal(err)
+		}
+		return
+	}
+
+	rates, err := model.LoadStaticRates(*ratesPath)
 	if err != nil {
-		fmt.Println(err)
+		st.close()
+		log.Fatal(err)
 	}
 
-	router := httprouter.New()
-	router.POST("/order/:orderID/refund/", refundHandler)
+	srv := NewServer(st.stores, st.uow, rates)
+	srv.useRefundCreditValidity(*refundCreditValidity)
+	if err = runSetup(ctx, setupPipeline(st, srv.clock)); err != nil {
+		st.close()
+		log.Fatal(err)
+	}
 
-	log.Fatal(http.ListenAndServe(":8090", router))
+	if *rulesPath != "" {
+		rules, err := model.OpenRefundRulesFile(*rulesPath)
+		if err != nil {
+			st.close()
+			log.Fatal(err)
+		}
+
+		if *rulesReload > 0 {
+			go rules.Watch(ctx, *rulesReload, func(err error) {
+				log.Printf("refund rules are not reloaded: %s", err)
+			})
+		}
+		srv.useRefundRules(rules)
+	}
+
+	// only fake gateways exist for now, they do not move any money
+	srv.useGateways(map[int]model.PaymentGateway{
+		model.CreditCard: model.NewFakeCardGateway(*fakeConfirm),
+		model.Paypal:     model.NewFakePaypalGateway(*fakeConfirm),
+	})
+	go srv.watchPaymentRefunds(ctx, *paymentSync)
+	if *voucherSweep > 0 {
+		go srv.watchVoucherExpiry(ctx, *voucherSweep)
+	}
+	if *idempotencySweep > 0 {
+		go srv.watchIdempotencyKeys(ctx, *idempotencySweep)
+	}
+
+	if *testMode && *backend != backendMemory {
+		log.Printf("test mode: provider states replace the %s stores with in-memory fixtures", *backend)
+	}
+
+	log.Fatal(http.ListenAndServe(":8090", srv.Router(*testMode)))
 }
 
-func refundHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params)  {
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage:\n  %[1]s [flags]\n  %[1]s [flags] migrate up|down|status [steps]\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func (s *Server) refundHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	oid := ps.ByName("orderID")
 	body, _ := ioutil.ReadAll(r.Body)
 	defer r.Body.Close()
 
 	postBody := struct {
-		UserKey string `json:"user_key"`
+		UserKey string          `json:"user_key"`
+		Amount  json.RawMessage `json:"amount,omitempty"`
+		Lines   []refundLine    `json:"lines,omitempty"`
 	}{}
-	json.Unmarshal(body, &postBody)
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		writeError(w, fmt.Errorf("%w: body is not valid JSON", errInvalidRequest))
+		return
+	}
 
 	if len(strings.TrimSpace(postBody.UserKey)) == 0 {
-		//http.Error(w, string(body), http.StatusBadRequest)
-		http.Error(w, fmt.Sprintf("%v \n %v", string(body), postBody.UserKey), http.StatusBadRequest)
+		writeError(w, fmt.Errorf("%w: user_key is missing", errInvalidRequest))
 		return
 	}
 
-	err := makeRefund(postBody.UserKey, oid)
-	if !errors.Is(err, nil) {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	actor, err := actorFromRequest(r, postBody.UserKey)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %s", errInvalidRequest, err))
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(&postBody); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	req := refundRequest{UserKey: postBody.UserKey, OrderID: oid, Amount: postBody.Amount, Lines: postBody.Lines}
+	res, err := s.refund(withActor(r.Context(), actor), req)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(res); err != nil {
+		log.Printf("cannot write refund response: %s", err)
+	}
+}
+
+// refundResult is the body returned for a successful refund. Its fields are part of the
+// contract with the consumers, new fields may be added but existing ones must not change.
+type refundResult struct {
+	RefundID    string                 `json:"refund_id"`
+	UserKey     string                 `json:"user_key"`
+	OrderID     string                 `json:"order_id"`
+	Refunded    model.Money            `json:"refunded"`        // refunded part of the order, in the currency of the order
+	Lines       map[string]model.Money `json:"lines,omitempty"` // refunded amount of each line, line refunds of orders with a breakdown only
+	Destination string                 `json:"destination"`     // one of the model.Destination constants
+	Rule        string                 `json:"rule,omitempty"`  // refund rule that picked the destination, empty for the default
+	Account     string                 `json:"account"`         // ledger account the refund was added to
+	Amount      model.Money            `json:"amount"`          // amount added to the account, in its currency
+	NewBalance  model.Money            `json:"new_balance"`     // balance of the account after the refund
+	Currency    string                 `json:"currency"`        // currency of the account
+	Status      string                 `json:"status"`          // one of the model.PaymentRefund statuses, refunds to accounts succeed right away
+	CreatedAt   time.Time              `json:"created_at"`
+}
+
+// newRefundID returns a random ID for a refund, e.g. rf_0f8fad5bd9cb469fa16570867728950e
+func newRefundID() (string, error) {
+	return randomID("rf_", "refund")
+}
+
+// randomID returns the prefix followed by 32 random hex digits, what names the ID in errors
+func randomID(prefix, what string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("cannot generate %s id\n%s", what, err)
+	}
+
+	return prefix + hex.EncodeToString(b), nil
+}
+
+// refundLine is a part of the order refunded on its own, such as an item or the shipping fee
+type refundLine struct {
+	Item   string          `json:"item"`
+	Amount json.RawMessage `json:"amount"`
 }
 
-func makeRefund(userKey, orderID string) error {
-	err := dbs.usr.Find(userKey)
-	if !errors.Is(err, nil) {
-		return fmt.Errorf("user not found: %s", userKey)
+// refundRequest is a full or partial refund of an order. A partial refund gives either the
+// amount or the lines to refund, amounts without a currency are in the currency of the order.
+// Without both, whatever is left of the order total is refunded.
+type refundRequest struct {
+	UserKey string
+	OrderID string
+	Amount  json.RawMessage
+	Lines   []refundLine
+}
+
+// amount returns the amount of the refund, remaining is the refundable amount of the order.
+// Line refunds of orders with a breakdown also return the amount of each line: their items must
+// name one of the SKUs of the order or model.ShippingItem, lines without an amount refund what
+// is left of the line. Lines of legacy orders without a breakdown need an amount.
+func (req refundRequest) amount(order *model.Order, remaining model.Money) (model.Money, map[string]model.Money, error) {
+	hasAmount := len(req.Amount) > 0 && string(req.Amount) != "null"
+	if hasAmount && len(req.Lines) > 0 {
+		return model.Money{}, nil, fmt.Errorf("%w: amount and lines cannot be given together", errInvalidRefund)
+	}
+
+	if hasAmount {
+		amount, err := model.UnmarshalMoney(req.Amount, remaining.Currency)
+		return amount, nil, err
+	}
+
+	if len(req.Lines) == 0 {
+		return remaining, nil, nil
+	}
+
+	var lines map[string]model.Money
+	if order.HasBreakdown() {
+		lines = make(map[string]model.Money, len(req.Lines))
+	}
+
+	total := model.NewMoney(0, remaining.Currency)
+	for i, line := range req.Lines {
+		if strings.TrimSpace(line.Item) == "" {
+			return model.Money{}, nil, fmt.Errorf("%w: item of line %d is missing", errInvalidRefund, i+1)
+		}
+
+		if lines != nil && !order.HasItem(line.Item) {
+			return model.Money{}, nil, fmt.Errorf("%w: order has no item %s", errInvalidRefund, line.Item)
+		}
+
+		amount, err := line.amount(order, remaining.Currency, lines)
+		if err != nil {
+			return model.Money{}, nil, err
+		}
+
+		if lines != nil {
+			if lines[line.Item], err = lines[line.Item].Add(amount); err != nil {
+				return model.Money{}, nil, err
+			}
+		}
+
+		if total, err = total.Add(amount); err != nil {
+			return model.Money{}, nil, err
+		}
 	}
 
-	err = dbs.ord.Find(orderID)
-	if !errors.Is(err, nil) {
-		return fmt.Errorf("order not found")
+	return total, lines, nil
+}
+
+// amount returns the amount of the line, requested holds what the lines before it refund of each item.
+// A line without an amount refunds what is left of its item, which the order only knows if it has a breakdown.
+func (line refundLine) amount(order *model.Order, currency string, requested map[string]model.Money) (model.Money, error) {
+	if len(line.Amount) > 0 && string(line.Amount) != "null" {
+		amount, err := model.UnmarshalMoney(line.Amount, currency)
+		if err != nil {
+			return model.Money{}, fmt.Errorf("amount for %s: %w", line.Item, err)
+		}
+
+		if amount.Amount <= 0 {
+			return model.Money{}, fmt.Errorf("%w: amount for %s must be positive", model.ErrInvalidAmount, line.Item)
+		}
+
+		return amount, nil
 	}
 
-	order := dbs.ord.Ord
+	if requested == nil {
+		return model.Money{}, fmt.Errorf("%w: amount for %s is missing, the order has no breakdown", errInvalidRefund, line.Item)
+	}
 
-	if order.IsDeleted {
-		return fmt.Errorf("order already refunded")
+	left, err := order.LineRefundable(line.Item)
+	if err != nil {
+		return model.Money{}, err
 	}
 
-	order.IsDeleted = true
+	if left, err = left.Sub(requested[line.Item]); err != nil {
+		return model.Money{}, err
+	}
 
-	refundToVoucher := false
-	if order.ShippingCountryZone == model.ZoneMena && order.PaymentWay == model.CashOnDelivery {
-		refundToVoucher = true
+	if left.Amount <= 0 {
+		return model.Money{}, fmt.Errorf("%w: %s is already refunded", model.ErrRefundExceeded, line.Item)
 	}
 
-	user := dbs.usr.Usr
+	return left, nil
+}
 
-	if !refundToVoucher {
-		user.UpdateBalance(order.Total)
-		return nil
+// makeRefund refunds whatever is left of the order total to the user
+func (s *Server) makeRefund(ctx context.Context, userKey, orderID string) error {
+	_, err := s.refund(ctx, refundRequest{UserKey: userKey, OrderID: orderID})
+	return err
+}
+
+// refund refunds the order, or a part of it, to the user and returns the refunded amount in
+// the currency of the order. The order, the user and the voucher accounts of the user are
+// locked for the whole operation so the refundable amount check and the balance updates
+// cannot interleave with a concurrent refund. All changes are made in a unit of work, so a
+// failing step leaves every store untouched.
+//
+// Only orders of the user are refunded. Support and admin actors may refund other
+// orders too, every such override is written to the audit log with the refund.
+// The balance movement is recorded in the ledger with the ID of the refund.
+//
+// The refund rules pick where the money goes. The refunded amount is converted into the
+// currency of the balance or voucher account it is added to. Refunds to the payment method
+// of the order are made through a payment gateway and stay pending until it confirms them.
+func (s *Server) refund(ctx context.Context, req refundRequest) (*refundResult, error) {
+	userKey, orderID := req.UserKey, req.OrderID
+	actor := actorFrom(ctx, userKey)
+	if !actor.canActFor(userKey) {
+		return nil, ErrActorNotAllowed
 	}
 
-	err = dbs.vch.Find(model.GenerateKeyForVoucher(userKey))
+	id, err := newRefundID()
+	if err != nil {
+		return nil, err
+	}
 
-	if err != nil && dbs.vch.Account == nil {
-		va, err := model.NewVoucher(order.Total, model.GenerateKeyForUser(user))
+	unlock := s.locks.Lock("order:"+orderID, "user:"+userKey, vouchersLock(userKey))
+	defer unlock()
 
-		if !errors.Is(err, nil) {
+	res := &refundResult{RefundID: id, UserKey: userKey, OrderID: orderID, Status: model.PaymentRefundSucceeded, CreatedAt: s.clock.Now()}
+	err = s.uow.Do(ctx, func(ctx context.Context, st model.Stores) error {
+		user, err := st.Users.Get(ctx, userKey)
+		if errors.Is(err, model.ErrUserNotFound) {
+			return fmt.Errorf("%w: %s", model.ErrUserNotFound, userKey)
+		}
+		if err != nil {
 			return err
 		}
 
-		dbs.vch.Account = &va
-		err = dbs.vch.AddToDB()
+		order, err := st.Orders.Get(ctx, orderID)
+		if errors.Is(err, model.ErrOrderNotFound) {
+			return fmt.Errorf("%w: %s", model.ErrOrderNotFound, orderID)
+		}
+		if err != nil {
+			return err
+		}
 
-		return nil
+		if !user.Owns(order.ID) {
+			if !actor.canOverrideOwnership() {
+				return ErrNotOrderOwner
+			}
+
+			err = st.Audit.Append(ctx, &model.AuditEntry{
+				Time:      res.CreatedAt,
+				Action:    model.AuditOwnershipOverride,
+				ActorKey:  actor.Key,
+				ActorRole: actor.Role,
+				UserKey:   userKey,
+				OrderID:   orderID,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if order.IsDeleted {
+			return fmt.Errorf("%w: %s", model.ErrAlreadyRefunded, orderID)
+		}
+
+		remaining, err := order.Refundable()
+		if err != nil {
+			return err
+		}
+
+		res.Refunded, res.Lines, err = req.amount(order, remaining)
+		if err != nil {
+			return err
+		}
+
+		if err = order.RefundLines(res.Lines); err != nil {
+			return err
+		}
+
+		if err = order.Refund(res.Refunded); err != nil {
+			return err
+		}
+
+		if err = st.Orders.Put(ctx, orderID, order); err != nil {
+			return err
+		}
+
+		route, err := s.route(ctx, order, userKey, user, res.Refunded)
+		if err != nil {
+			return err
+		}
+		res.Rule = route.Rule
+
+		if route.Destination == model.DestinationOriginalPayment {
+			return s.refundToPayment(ctx, st, actor, order, res)
+		}
+
+		if route.Destination == model.DestinationBalance {
+			amount, err := model.Convert(ctx, s.rates, res.Refunded, balanceCurrency(user, order))
+			if err != nil {
+				return err
+			}
+
+			if res.NewBalance, err = user.UpdateBalance(amount); err != nil {
+				return err
+			}
+
+			if err = st.Users.Put(ctx, userKey, user); err != nil {
+				return err
+			}
+
+			res.credit(model.DestinationBalance, model.BalanceAccount(userKey), amount)
+			return st.Ledger.Append(ctx, res.ledgerEntry(actor))
+		}
+
+		currency := voucherCurrency(user, order)
+		amount, err := model.Convert(ctx, s.rates, res.Refunded, currency)
+		if err != nil {
+			return err
+		}
+
+		voucherKey := model.GenerateKeyForVoucher(userKey, currency)
+		res.credit(model.DestinationVoucher, model.VoucherAccount(voucherKey), amount)
+		account, err := st.Vouchers.Get(ctx, voucherKey)
+		if errors.Is(err, model.ErrAccountNotFound) {
+			var va model.Voucher
+			if va, err = model.NewVoucher(model.Money{Currency: currency}, model.GenerateKeyForUser(user)); err != nil {
+				return err
+			}
+			account = &va
+		}
+		if err != nil {
+			return err
+		}
+
+		// a lot issued now, so credits that expire earlier are spent before the refund
+		if res.NewBalance, err = account.Credit(amount, res.CreatedAt, s.refundExpiry(res.CreatedAt)); err != nil {
+			return err
+		}
+
+		if err = st.Vouchers.Put(ctx, voucherKey, account); err != nil {
+			return err
+		}
+
+		return st.Ledger.Append(ctx, res.ledgerEntry(actor))
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	dbs.vch.UpdateBalance(order.Total)
-	return nil
+	if res.Destination != model.DestinationOriginalPayment {
+		return res, nil
+	}
+
+	err = s.submitPaymentRefund(ctx, res.RefundID)
+	if errors.Is(err, model.ErrPaymentDeclined) {
+		return nil, err
+	}
+	if err != nil {
+		// the refund is saved as pending, syncPaymentRefunds sends it again
+		log.Printf("refund %s of order %s is not sent to the payment gateway yet: %s", res.RefundID, orderID, err)
+	}
+
+	return res, nil
 }
 
+// route returns the destination the refund rules pick for the refund of the order
+func (s *Server) route(ctx context.Context, order *model.Order, userKey string, user *model.User, amount model.Money) (model.RefundRoute, error) {
+	return s.rules.RefundRules().Route(ctx, s.rates, model.RefundFacts{
+		Zone:       order.ShippingCountryZone,
+		PaymentWay: order.PaymentWay,
+		Amount:     amount,
+		UserKey:    userKey,
+		User:       user,
+	})
+}
+
+// refundToPayment records the refund of the order to its payment method as a pending payment
+// refund, refund calls the gateway of the payment way once the unit of work is committed, see
+// submitPaymentRefund. The gateway confirms the refund later, until then it is tracked as
+// pending, see syncPaymentRefunds. The account of the payment method holds the total refunded
+// to it, in the currency of the order.
+func (s *Server) refundToPayment(ctx context.Context, st model.Stores, actor Actor, order *model.Order, res *refundResult) error {
+	if _, ok := s.gateways[order.PaymentWay]; !ok {
+		return fmt.Errorf("%w: no payment gateway for payment way %d", model.ErrPaymentNotRefundable, order.PaymentWay)
+	}
+
+	if order.PaymentRef == "" {
+		return fmt.Errorf("%w: order %s has no payment reference", model.ErrPaymentNotRefundable, res.OrderID)
+	}
+
+	account := model.PaymentAccount(res.OrderID)
+	res.credit(model.DestinationOriginalPayment, account, res.Refunded)
+	res.Status = model.PaymentRefundPending
 
-func initDBs() error {
-	dbs.vch = model.NewVoucherHandler()
-	dbs.usr = model.NewUserHandler()
-	dbs.ord = model.NewOrderHandler()
+	entries, err := st.Ledger.List(ctx, res.UserKey)
+	if err != nil {
+		return err
+	}
 
-	userJSON, err := openDataFile("users")
+	balances, err := model.Balances(entries)
 	if err != nil {
 		return err
 	}
 
-	orderJSON, err := openDataFile("orders")
+	if res.NewBalance, err = balances[account].Add(res.Amount); err != nil {
+		return err
+	}
+
+	if err = st.Ledger.Append(ctx, res.ledgerEntry(actor)); err != nil {
+		return err
+	}
+
+	return st.PaymentRefunds.Put(ctx, res.RefundID, &model.PaymentRefund{
+		ID:         res.RefundID,
+		OrderID:    res.OrderID,
+		UserKey:    res.UserKey,
+		PaymentWay: order.PaymentWay,
+		PaymentRef: order.PaymentRef,
+		Amount:     res.Refunded,
+		Lines:      res.Lines,
+		Status:     model.PaymentRefundPending,
+		CreatedAt:  res.CreatedAt,
+		UpdatedAt:  res.CreatedAt,
+	})
+}
+
+// credit records the account the refund is added to and the added amount
+func (res *refundResult) credit(destination, account string, amount model.Money) {
+	res.Destination, res.Account = destination, account
+	res.Amount, res.Currency = amount, amount.Currency
+}
+
+// ledgerEntry returns the ledger entry of the refund
+func (res *refundResult) ledgerEntry(actor Actor) *model.LedgerEntry {
+	e := newLedgerEntry(actor, res.UserKey, res.Account, res.Amount, model.LedgerRefund, res.OrderID, res.CreatedAt)
+	e.RefundID = res.RefundID
+
+	return e
+}
+
+// vouchersLock returns the lock key that guards every voucher account of the user
+func vouchersLock(userKey string) string {
+	return "vouchers:" + userKey
+}
+
+// balanceCurrency returns the currency a refund to the balance of the user is made in.
+// A zero balance without a currency takes the currency of the order.
+func balanceCurrency(user *model.User, order *model.Order) string {
+	if user.Balance.Currency != "" {
+		return user.Balance.Currency
+	}
+
+	return order.Total.Currency
+}
+
+// voucherCurrency returns the currency of the voucher account a refund is added to
+func voucherCurrency(user *model.User, order *model.Order) string {
+	if user.VoucherCurrency != "" {
+		return user.VoucherCurrency
+	}
+
+	return order.Total.Currency
+}
+
+// refundExpiry returns when a refund credited to a voucher account at issuedAt expires,
+// nil if refund credits do not expire
+func (s *Server) refundExpiry(issuedAt time.Time) *time.Time {
+	if s.refundCreditValidity <= 0 {
+		return nil
+	}
+
+	expiresAt := issuedAt.Add(s.refundCreditValidity)
+	return &expiresAt
+}
+
+// storage is an opened storage backend
+type storage struct {
+	stores model.Stores
+	uow    model.UnitOfWork
+	// migrator is nil for backends without a schema
+	migrator Migrator
+	close    func() error
+}
+
+// openStorage opens the given storage backend. The schema of the backend is not migrated.
+func openStorage(backend, dbPath string) (*storage, error) {
+	switch backend {
+	case backendMemory:
+		stores := model.Stores{
+			Users:    model.NewUserHandler(),
+			Orders:   model.NewOrderHandler(),
+			Vouchers: model.NewVoucherHandler(),
+			Audit:    model.NewAuditLog(),
+			Ledger:   model.NewLedger(),
+
+			PaymentRefunds: model.NewPaymentRefunds(),
+			Redemptions:    model.NewRedemptions(),
+			Idempotency:    model.NewIdempotencyKeys(),
+		}
+		return &storage{
+			stores: stores,
+			uow:    model.NewMemoryUnitOfWork(stores),
+			close:  func() error { return nil },
+		}, nil
+	case backendSQLite:
+		db, err := sqlite.Open(dbPath)
+		if err != nil {
+			return nil, err
+		}
+		return &storage{stores: db.Stores(), uow: db, migrator: db, close: db.Close}, nil
+	}
+
+	return nil, fmt.Errorf("unknown db backend: %s", backend)
+}
+
+// initDBs loads the data files into the stores, it is the seed step of the setup pipeline.
+// Stores that already hold users, e.g. a persisted database, are left untouched.
+func initDBs(ctx context.Context, uow model.UnitOfWork, clock model.Clock) error {
+	return uow.Do(ctx, func(ctx context.Context, st model.Stores) error {
+		existing, err := st.Users.List(ctx)
+		if err != nil {
+			return err
+		}
+
+		if len(existing) > 0 {
+			return nil
+		}
+
+		users := map[string]*model.User{}
+		if err = loadDataFile("users", &users); err != nil {
+			return err
+		}
+
+		orders := map[string]*model.Order{}
+		if err = loadDataFile("orders", &orders); err != nil {
+			return err
+		}
+
+		for key, u := range users {
+			if err = st.Users.Put(ctx, key, u); err != nil {
+				return err
+			}
+
+			if err = appendOpeningBalance(ctx, st.Ledger, key, model.BalanceAccount(key), u.Balance, clock.Now()); err != nil {
+				return err
+			}
+		}
+
+		for key, o := range orders {
+			if err = o.Validate(); err != nil {
+				return fmt.Errorf("order %s in orders.json: %w", key, err)
+			}
+
+			if err = st.Orders.Put(ctx, key, o); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// loadDataFile decodes the named data file into v
+func loadDataFile(fileName string, v interface{}) error {
+	b, err := openDataFile(fileName)
 	if err != nil {
 		return err
 	}
 
-	dbs.usr.BulkInsert(userJSON)
-	dbs.ord.BulkInsert(orderJSON)
+	if err = json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("failed to unmarshal %s.json\n%s", fileName, err)
+	}
 
 	return nil
 }
 
 func openDataFile(fileName string) ([]byte, error) {
-	dataFile, err := os.Open("./api/data/" + fileName + ".json")
+	dataFile, err := os.Open(dataDir + fileName + ".json")
 	defer dataFile.Close()
 
 	if err != nil {
@@ -157,4 +694,4 @@ func openDataFile(fileName string) ([]byte, error) {
 	}
 
 	return byteValue, nil
-}
\ No newline at end of file
+}
@@ -2,35 +2,58 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/julienschmidt/httprouter"
 	"github.com/srgyrn/pact-example/api/model"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func Test_refundHandler(t *testing.T) {
 	tests := []struct {
-		name    string
-		orderID int
-		userKey string
-		wantErr bool
+		name     string
+		orderID  int
+		userKey  string
+		wantErr  bool
+		wantCode int
 	}{
 		{
-			name:    "returns bad request status when customer not found",
-			orderID: 1,
-			userKey: "barbara-streisand",
-			wantErr: true,
+			name:     "returns not found status when customer not found",
+			orderID:  1,
+			userKey:  "barbara-streisand",
+			wantErr:  true,
+			wantCode: http.StatusNotFound,
 		},
 		{
-			name:    "returns bad request status when order not found",
-			orderID: 987,
-			userKey: "john-doe",
-			wantErr: true,
+			name:     "returns not found status when order not found",
+			orderID:  987,
+			userKey:  "john-doe",
+			wantErr:  true,
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "returns conflict status when order is already refunded",
+			orderID:  2,
+			userKey:  "john-doe",
+			wantErr:  true,
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:     "returns bad request status when user key is missing",
+			orderID:  1,
+			wantErr:  true,
+			wantCode: http.StatusBadRequest,
 		},
 		{
 			name:    "returns successful status",
@@ -41,10 +64,8 @@ func Test_refundHandler(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			initTestDBs()
-
-			router := httprouter.New()
-			router.POST("/order/:orderID/refund/", refundHandler)
+			srv := newTestServer()
+			router := srv.Router(false)
 
 			data := fmt.Sprintf("{\"user_key\": \"%s\"}", tt.userKey)
 
@@ -57,20 +78,92 @@ func Test_refundHandler(t *testing.T) {
 
 			router.ServeHTTP(rr, req)
 
-			if tt.wantErr && http.StatusBadRequest != rr.Code {
+			if tt.wantErr && tt.wantCode != rr.Code {
 
-				t.Errorf("refundHandler(), want = %v, got = %v", http.StatusBadRequest, rr.Code)
+				t.Errorf("refundHandler(), want = %v, got = %v", tt.wantCode, rr.Code)
 			}
 
 			if !tt.wantErr && http.StatusOK != rr.Code {
 				r, _ := ioutil.ReadAll(rr.Result().Body)
-				t.Errorf("%v \n %v", string(r), dbs.usr)
+				t.Errorf("%v \n %v", string(r), srv.usr)
 				t.Errorf("refundHandler(), want = %v, got = %v \n %v", http.StatusOK, rr.Code, string(r))
 			}
 		})
 	}
 }
 
+func Test_refundHandler_response(t *testing.T) {
+	tests := []struct {
+		name            string
+		orderID         string
+		wantDestination string
+		wantAccount     string
+		wantRule        string
+		wantAmount      model.Money
+	}{
+		{
+			name:            "refund to the balance",
+			orderID:         "1",
+			wantDestination: model.DestinationBalance,
+			wantAccount:     model.BalanceAccount("john-doe"),
+			wantAmount:      usd(100),
+		},
+		{
+			name:            "refund to a voucher account",
+			orderID:         "3",
+			wantDestination: model.DestinationVoucher,
+			wantAccount:     model.VoucherAccount("john-doe-usd"),
+			wantRule:        "mena-cash-on-delivery",
+			wantAmount:      usd(300),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			ctx := context.Background()
+
+			req := httptest.NewRequest(http.MethodPost, "/order/"+tt.orderID+"/refund/", bytes.NewBufferString(`{"user_key": "john-doe"}`))
+			rr := httptest.NewRecorder()
+			srv.Router(false).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("refundHandler() status = %d, want %d\n%s", rr.Code, http.StatusOK, rr.Body)
+			}
+
+			var got refundResult
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatalf("refundHandler() body is not a refund\n%s", err)
+			}
+
+			entries, _ := srv.ldg.List(ctx, "john-doe")
+			balances, _ := model.Balances(entries)
+			last := entries[len(entries)-1]
+
+			want := refundResult{
+				RefundID:    last.RefundID,
+				UserKey:     "john-doe",
+				OrderID:     tt.orderID,
+				Refunded:    tt.wantAmount,
+				Destination: tt.wantDestination,
+				Rule:        tt.wantRule,
+				Account:     tt.wantAccount,
+				Amount:      tt.wantAmount,
+				NewBalance:  balances[tt.wantAccount],
+				Currency:    "USD",
+				Status:      model.PaymentRefundSucceeded,
+				CreatedAt:   last.Time,
+			}
+			if !got.CreatedAt.Equal(want.CreatedAt) || got.RefundID == "" {
+				t.Errorf("refundHandler() = %+v, want the ID and the time of the ledger entry %+v", got, last)
+			}
+			got.CreatedAt = want.CreatedAt
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("refundHandler() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
 func Test_makeRefund(t *testing.T) {
 	type args struct {
 		userKey string
@@ -128,10 +221,12 @@ func Test_makeRefund(t *testing.T) {
 			createVoucher: false,
 		},
 	}
+	ctx := context.Background()
 	for _, tt := range tests {
-		initTestDBs()
+		srv := newTestServer()
+		now := useTestClock(srv)
 		t.Run(tt.name, func(t *testing.T) {
-			if err := makeRefund(tt.args.userKey, tt.args.orderID); (err != nil) != tt.wantErr {
+			if err := srv.makeRefund(ctx, tt.args.userKey, tt.args.orderID); (err != nil) != tt.wantErr {
 				t.Errorf("makeRefund() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
@@ -140,10 +235,13 @@ func Test_makeRefund(t *testing.T) {
 				var got model.Voucher
 
 				if tt.createVoucher {
-					dbs.ord.Find(tt.args.orderID)
-					want, _ = model.NewVoucher(dbs.ord.Ord.Total, tt.args.userKey)
-					dbs.vch.Find(model.GenerateKeyForVoucher(tt.args.userKey))
-					got = *dbs.vch.Account
+					// a new account holds the refund as a lot issued now that expires after the validity
+					order, _ := srv.ord.Get(ctx, tt.args.orderID)
+					want, _ = model.NewVoucher(model.Money{Currency: order.Total.Currency}, tt.args.userKey)
+					expiresAt := now.Add(defaultRefundCreditValidity)
+					want.Credit(order.Total, *now, &expiresAt)
+					account, _ := srv.vch.Get(ctx, model.GenerateKeyForVoucher(tt.args.userKey, model.DefaultCurrency))
+					got = *account
 				}
 
 				if !reflect.DeepEqual(want, got) {
@@ -154,15 +252,65 @@ func Test_makeRefund(t *testing.T) {
 	}
 }
 
+func Test_makeRefund_creditValidity(t *testing.T) {
+	tests := []struct {
+		name        string
+		validity    time.Duration
+		wantExpired bool
+	}{
+		{name: "refund credits expire after the validity", validity: 30 * 24 * time.Hour, wantExpired: true},
+		{name: "refund credits never expire without a validity"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			ctx := context.Background()
+			now := useTestClock(srv)
+			srv.useRefundCreditValidity(tt.validity)
+
+			if err := srv.makeRefund(ctx, "john-doe", "3"); err != nil {
+				t.Fatalf("makeRefund() error = %v", err)
+			}
+
+			key := model.GenerateKeyForVoucher("john-doe", model.DefaultCurrency)
+			account, _ := srv.vch.Get(ctx, key)
+			lots := account.Lots
+			if len(lots) != 1 {
+				t.Fatalf("voucher lots = %v, want the refund", lots)
+			}
+
+			if tt.validity == 0 && lots[0].ExpiresAt != nil {
+				t.Errorf("refund lot expires at %v, want no expiry", lots[0].ExpiresAt)
+			}
+			if tt.validity != 0 && (lots[0].ExpiresAt == nil || !lots[0].ExpiresAt.Equal(now.Add(tt.validity))) {
+				t.Errorf("refund lot expires at %v, want %v", lots[0].ExpiresAt, now.Add(tt.validity))
+			}
+
+			// the day after the validity the sweep takes expired credits off the account
+			*now = now.Add(tt.validity + 24*time.Hour)
+			if err := srv.expireVouchers(ctx); err != nil {
+				t.Fatalf("expireVouchers() error = %v", err)
+			}
+
+			account, _ = srv.vch.Get(ctx, key)
+			if account.Balance.IsZero() != tt.wantExpired {
+				t.Errorf("voucher balance after the validity = %v, want expired %v", account.Balance, tt.wantExpired)
+			}
+		})
+	}
+}
+
 func Test_makeRefund_existingVoucherAccount(t *testing.T) {
-	initTestDBs()
+	srv := newTestServer()
+	ctx := context.Background()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	srv.useClock(model.ClockFunc(func() time.Time { return now }))
 
 	userKey := "jane-doe"
-	va, _ := model.NewVoucher(100, userKey)
-	dbs.vch.Account = &va
-	dbs.vch.AddToDB()
+	va, _ := model.NewVoucher(usd(100), userKey)
+	srv.vch.Put(ctx, model.GenerateKeyForVoucher(userKey, model.DefaultCurrency), &va)
 
-	if err := makeRefund(userKey, strconv.Itoa(4)); err != nil {
+	if err := srv.makeRefund(ctx, userKey, strconv.Itoa(4)); err != nil {
 		t.Errorf("makeRefund() error = %v", err)
 	}
 
@@ -171,11 +319,14 @@ func Test_makeRefund_existingVoucherAccount(t *testing.T) {
 		user    model.User
 	}
 
-	voucherExpected, _ := model.NewVoucher(250, userKey)
+	// the refund is a lot issued now on top of the balance the account had
+	voucherExpected, _ := model.NewVoucher(usd(100), userKey)
+	expiresAt := now.Add(defaultRefundCreditValidity)
+	voucherExpected.Credit(usd(150), now, &expiresAt)
 	userExpected := model.User{
 		Name:     "Jane",
 		LastName: "Doe",
-		Balance:  150,
+		Balance:  usd(150),
 		Orders:   []int{4},
 	}
 
@@ -184,79 +335,709 @@ func Test_makeRefund_existingVoucherAccount(t *testing.T) {
 		userExpected,
 	}
 
-	dbs.usr.Find(userKey)
-	dbs.vch.Find(model.GenerateKeyForVoucher(userKey))
+	account, _ := srv.vch.Get(ctx, model.GenerateKeyForVoucher(userKey, model.DefaultCurrency))
+	user, _ := srv.usr.Get(ctx, userKey)
 
 	got := resultSet{
-		*dbs.vch.Account,
-		*dbs.usr.Usr,
+		*account,
+		*user,
 	}
 
 	if !reflect.DeepEqual(want, got) {
 		t.Errorf("makeRefund() failed. want = %v, got = %v", want, got)
 	}
+
+	entries, _ := srv.ldg.List(ctx, userKey)
+	if last := entries[len(entries)-1]; last.Reason != model.LedgerRefund || !last.Time.Equal(now) {
+		t.Errorf("makeRefund() ledger entry = %+v, want a refund at %v", last, now)
+	}
 }
 
-func initTestDBs() {
-	orders := []model.Order{
+func TestNewServer_isolatesDBs(t *testing.T) {
+	first := newTestServer()
+	second := newTestServer()
+
+	ctx := context.Background()
+
+	if err := first.makeRefund(ctx, "john-doe", "1"); err != nil {
+		t.Fatalf("makeRefund() error = %v", err)
+	}
+
+	order, _ := second.ord.Get(ctx, "1")
+	if order.IsDeleted {
+		t.Errorf("makeRefund() on one server changed the DBs of another")
+	}
+}
+
+// newTestServer creates a Server loaded with the fixture data
+func newTestServer() *Server {
+	s := NewServer(model.Stores{}, nil, nil)
+	if err := s.loadFixtures(); err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+// addOrder saves the order and adds it to the orders of the user
+func addOrder(t *testing.T, srv *Server, userKey string, o model.Order) {
+	ctx := context.Background()
+
+	user, err := srv.usr.Get(ctx, userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user.Orders = append(user.Orders, o.ID)
+	srv.usr.Put(ctx, userKey, user)
+	srv.ord.Put(ctx, strconv.Itoa(o.ID), &o)
+}
+
+// Test_makeRefund_concurrent is meant to be run with the race detector:
+//
+//	go test -race ./api/...
+func Test_makeRefund_concurrent(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("refunds an order only once", func(t *testing.T) {
+		srv := newTestServer()
+		srv.useStores(model.Stores{Users: srv.usr, Orders: slowOrderStore{srv.ord}, Vouchers: srv.vch}, nil)
+
+		var wg sync.WaitGroup
+		var succeeded int32
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := srv.makeRefund(ctx, "john-doe", "3"); err == nil {
+					atomic.AddInt32(&succeeded, 1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if succeeded != 1 {
+			t.Errorf("makeRefund() succeeded %d times, want 1", succeeded)
+		}
+
+		account, _ := srv.vch.Get(ctx, model.GenerateKeyForVoucher("john-doe", model.DefaultCurrency))
+		if account.Balance != usd(300) {
+			t.Errorf("makeRefund() voucher balance = %v, want %v", account.Balance, usd(300))
+		}
+	})
+
+	t.Run("does not lose balance updates", func(t *testing.T) {
+		srv := newTestServer()
+		srv.useStores(model.Stores{Users: slowUserStore{srv.usr}, Orders: srv.ord, Vouchers: srv.vch}, nil)
+
+		const orderCount = 50
+		for i := 0; i < orderCount; i++ {
+			addOrder(t, srv, "jane-doe", model.Order{
+				ID:                  100 + i,
+				Total:               usd(2),
+				PaymentWay:          model.CreditCard,
+				ShippingCountryZone: model.ZoneEurope,
+			})
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < orderCount; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if err := srv.makeRefund(ctx, "jane-doe", strconv.Itoa(100+i)); err != nil {
+					t.Errorf("makeRefund() error = %v", err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		user, _ := srv.usr.Get(ctx, "jane-doe")
+		if want := usd(150 + 2*orderCount); user.Balance != want {
+			t.Errorf("makeRefund() balance = %v, want %v", user.Balance, want)
+		}
+	})
+}
+
+// slowOrderStore widens the window between reading and writing an order
+type slowOrderStore struct {
+	model.OrderStore
+}
+
+func (s slowOrderStore) Get(ctx context.Context, key string) (*model.Order, error) {
+	v, err := s.OrderStore.Get(ctx, key)
+	time.Sleep(time.Millisecond)
+
+	return v, err
+}
+
+// slowUserStore widens the window between reading and writing a user
+type slowUserStore struct {
+	model.UserStore
+}
+
+func (s slowUserStore) Get(ctx context.Context, key string) (*model.User, error) {
+	v, err := s.UserStore.Get(ctx, key)
+	time.Sleep(time.Millisecond)
+
+	return v, err
+}
+
+type failingUserPut struct{ model.UserStore }
+
+func (failingUserPut) Put(context.Context, string, *model.User) error {
+	return errors.New("user store is down")
+}
+
+type failingOrderPut struct{ model.OrderStore }
+
+func (failingOrderPut) Put(context.Context, string, *model.Order) error {
+	return errors.New("order store is down")
+}
+
+type failingVoucherPut struct{ model.VoucherStore }
+
+func (failingVoucherPut) Put(context.Context, string, *model.Voucher) error {
+	return errors.New("voucher store is down")
+}
+
+type failingVoucherGet struct{ model.VoucherStore }
+
+func (failingVoucherGet) Get(context.Context, string) (*model.Voucher, error) {
+	return nil, errors.New("voucher store is down")
+}
+
+func Test_makeRefund_rollsBack(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name            string
+		orderID         string
+		existingVoucher bool
+		wrap            func(st model.Stores) model.Stores
+	}{
 		{
-			ID:                  1,
-			Total:               100,
+			name:    "when order cannot be saved",
+			orderID: "1",
+			wrap: func(st model.Stores) model.Stores {
+				st.Orders = failingOrderPut{st.Orders}
+				return st
+			},
+		},
+		{
+			name:    "when user balance cannot be saved",
+			orderID: "1",
+			wrap: func(st model.Stores) model.Stores {
+				st.Users = failingUserPut{st.Users}
+				return st
+			},
+		},
+		{
+			name:    "when voucher account cannot be created",
+			orderID: "3",
+			wrap: func(st model.Stores) model.Stores {
+				st.Vouchers = failingVoucherPut{st.Vouchers}
+				return st
+			},
+		},
+		{
+			name:            "when voucher account cannot be read",
+			orderID:         "3",
+			existingVoucher: true,
+			wrap: func(st model.Stores) model.Stores {
+				st.Vouchers = failingVoucherGet{st.Vouchers}
+				return st
+			},
+		},
+		{
+			name:            "when voucher balance cannot be saved",
+			orderID:         "3",
+			existingVoucher: true,
+			wrap: func(st model.Stores) model.Stores {
+				st.Vouchers = failingVoucherPut{st.Vouchers}
+				return st
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			voucherKey := model.GenerateKeyForVoucher("john-doe", model.DefaultCurrency)
+			if tt.existingVoucher {
+				va, _ := model.NewVoucher(usd(50), "john-doe")
+				srv.vch.Put(ctx, voucherKey, &va)
+			}
+
+			stores := model.Stores{Users: srv.usr, Orders: srv.ord, Vouchers: srv.vch}
+			srv.useStores(tt.wrap(stores), nil)
+
+			if err := srv.makeRefund(ctx, "john-doe", tt.orderID); err == nil {
+				t.Fatalf("makeRefund() error = nil, want error")
+			}
+
+			order, _ := stores.Orders.Get(ctx, tt.orderID)
+			if order.IsDeleted {
+				t.Errorf("makeRefund() left order %s marked as refunded", tt.orderID)
+			}
+
+			user, _ := stores.Users.Get(ctx, "john-doe")
+			if user.Balance != usd(100) {
+				t.Errorf("makeRefund() balance = %v, want %v", user.Balance, usd(100))
+			}
+
+			account, err := stores.Vouchers.Get(ctx, voucherKey)
+			if tt.existingVoucher && account.Balance != usd(50) {
+				t.Errorf("makeRefund() voucher balance = %v, want %v", account.Balance, usd(50))
+			}
+			if !tt.existingVoucher && err == nil {
+				t.Errorf("makeRefund() left voucher account %v", account)
+			}
+		})
+	}
+}
+
+type failingAuditAppend struct{ model.AuditStore }
+
+func (failingAuditAppend) Append(context.Context, ...*model.AuditEntry) error {
+	return errors.New("disk is full")
+}
+
+func Test_auditFails_leavesLedgerUntouched(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(srv *Server) error
+	}{
+		{
+			name: "ownership override refund",
+			run: func(srv *Server) error {
+				ctx := withActor(context.Background(), Actor{Key: "agent-1", Role: RoleSupport})
+				return srv.makeRefund(ctx, "john-doe", "4")
+			},
+		},
+		{
+			name: "voucher credit",
+			run: func(srv *Server) error {
+				if rr := serveAs(srv, http.MethodPost, "/vouchers", `{"user_key": "john-doe", "amount": "5"}`, "agent-1", RoleSupport); rr.Code != http.StatusOK {
+					return fmt.Errorf("status %d", rr.Code)
+				}
+				return nil
+			},
+		},
+		{
+			name: "voucher debit",
+			run: func(srv *Server) error {
+				if rr := serveAs(srv, http.MethodPost, "/vouchers/john-doe-usd/debit", `{"amount": "5"}`, "agent-1", RoleSupport); rr.Code != http.StatusOK {
+					return fmt.Errorf("status %d", rr.Code)
+				}
+				return nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			ctx := context.Background()
+			addVoucher(t, srv, "john-doe", usd(10))
+			before, _ := srv.ldg.List(ctx, "john-doe")
+
+			stores := model.Stores{Users: srv.usr, Orders: srv.ord, Vouchers: srv.vch, Ledger: srv.ldg, Audit: failingAuditAppend{srv.aud}}
+			srv.useStores(stores, nil)
+
+			if err := tt.run(srv); err == nil {
+				t.Fatalf("error = nil, want error")
+			}
+
+			if after, _ := srv.ldg.List(ctx, "john-doe"); !reflect.DeepEqual(after, before) {
+				t.Errorf("ledger = %v, want %v", after, before)
+			}
+
+			user, _ := srv.usr.Get(ctx, "john-doe")
+			account, _ := srv.vch.Get(ctx, "john-doe-usd")
+			if user.Balance != usd(100) || account.Balance != usd(10) {
+				t.Errorf("balances = %v and %v, want %v and %v", user.Balance, account.Balance, usd(100), usd(10))
+			}
+		})
+	}
+}
+
+func Test_makeRefund_sqlite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "refunds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	path := filepath.Join(dir, "refunds.db")
+
+	st, err := openStorage(backendSQLite, path)
+	if err != nil {
+		t.Fatalf("openStorage() error = %v", err)
+	}
+
+	if _, err = st.migrator.MigrateUp(ctx, 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	srv := NewServer(st.stores, st.uow, nil)
+	for _, u := range fixtureUsers() {
+		tmp := u
+		srv.usr.Put(ctx, model.GenerateKeyForUser(&tmp), &tmp)
+	}
+	for _, o := range fixtureOrders() {
+		tmp := o
+		srv.ord.Put(ctx, strconv.Itoa(o.ID), &tmp)
+	}
+
+	if err = srv.makeRefund(ctx, "john-doe", "3"); err != nil {
+		t.Fatalf("makeRefund() error = %v", err)
+	}
+	st.close()
+
+	st, err = openStorage(backendSQLite, path)
+	if err != nil {
+		t.Fatalf("openStorage() error = %v", err)
+	}
+	defer st.close()
+
+	order, _ := st.stores.Orders.Get(ctx, "3")
+	if !order.IsDeleted {
+		t.Errorf("makeRefund() refund was not persisted")
+	}
+
+	account, err := st.stores.Vouchers.Get(ctx, model.GenerateKeyForVoucher("john-doe", model.DefaultCurrency))
+	if err != nil || account.Balance != usd(300) {
+		t.Errorf("makeRefund() voucher = %v, %v, want balance %v", account, err, usd(300))
+	}
+}
+
+func Test_makeRefund_keepsCents(t *testing.T) {
+	srv := newTestServer()
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		addOrder(t, srv, "jane-doe", model.Order{
+			ID:                  100 + i,
+			Total:               model.NewMoney(590, model.DefaultCurrency),
 			PaymentWay:          model.CreditCard,
 			ShippingCountryZone: model.ZoneEurope,
-			IsDeleted:           false,
+		})
+
+		if err := srv.makeRefund(ctx, "jane-doe", strconv.Itoa(100+i)); err != nil {
+			t.Fatalf("makeRefund() error = %v", err)
+		}
+	}
+
+	user, _ := srv.usr.Get(ctx, "jane-doe")
+	if want := model.NewMoney(20900, model.DefaultCurrency); user.Balance != want {
+		t.Errorf("makeRefund() balance = %v, want %v", user.Balance, want)
+	}
+}
+
+func Test_makeRefund_currencies(t *testing.T) {
+	rates, err := model.LoadStaticRates("./data/rates.json")
+	if err != nil {
+		t.Fatalf("LoadStaticRates() error = %v", err)
+	}
+
+	ctx := context.Background()
+	aed := func(fils int64) model.Money { return model.NewMoney(fils, "AED") }
+
+	tests := []struct {
+		name            string
+		voucherCurrency string
+		order           model.Order
+		wantVoucher     string
+		wantAmount      model.Money
+		wantErr         bool
+	}{
+		{
+			name:        "adds to the voucher account in the currency of the order",
+			order:       model.Order{Total: aed(36725), PaymentWay: model.CashOnDelivery, ShippingCountryZone: model.ZoneMena},
+			wantVoucher: "jane-doe-aed",
+			wantAmount:  aed(36725),
 		},
 		{
-			ID:                  2,
-			Total:               200,
-			PaymentWay:          model.CashOnDelivery,
-			ShippingCountryZone: model.ZoneMena,
-			IsDeleted:           true,
+			name:            "converts to the voucher currency of the user",
+			voucherCurrency: "SAR",
+			order:           model.Order{Total: aed(36725), PaymentWay: model.CashOnDelivery, ShippingCountryZone: model.ZoneMena},
+			wantVoucher:     "jane-doe-sar",
+			wantAmount:      model.NewMoney(37500, "SAR"),
 		},
 		{
-			ID:                  3,
-			Total:               300,
-			PaymentWay:          model.CashOnDelivery,
-			ShippingCountryZone: model.ZoneMena,
-			IsDeleted:           false,
+			name:       "converts to the currency of the balance",
+			order:      model.Order{Total: aed(36725), PaymentWay: model.CreditCard, ShippingCountryZone: model.ZoneMena},
+			wantAmount: usd(250),
 		},
 		{
-			ID:                  4,
-			Total:               150,
-			PaymentWay:          model.CashOnDelivery,
-			ShippingCountryZone: model.ZoneMena,
-			IsDeleted:           false,
+			name:            "fails when there is no exchange rate",
+			voucherCurrency: "KWD",
+			order:           model.Order{Total: aed(36725), PaymentWay: model.CashOnDelivery, ShippingCountryZone: model.ZoneMena},
+			wantErr:         true,
 		},
 	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			srv.rates = rates
+
+			tt.order.ID = 100
+			addOrder(t, srv, "jane-doe", tt.order)
 
-	users := []model.User{
+			user, _ := srv.usr.Get(ctx, "jane-doe")
+			user.VoucherCurrency = tt.voucherCurrency
+			srv.usr.Put(ctx, "jane-doe", user)
+
+			err := srv.makeRefund(ctx, "jane-doe", "100")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("makeRefund() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				if order, _ := srv.ord.Get(ctx, "100"); order.IsDeleted {
+					t.Errorf("makeRefund() marked the order as refunded")
+				}
+				return
+			}
+
+			if tt.wantVoucher == "" {
+				user, _ = srv.usr.Get(ctx, "jane-doe")
+				if user.Balance != tt.wantAmount {
+					t.Errorf("makeRefund() balance = %v, want %v", user.Balance, tt.wantAmount)
+				}
+				return
+			}
+
+			account, err := srv.vch.Get(ctx, tt.wantVoucher)
+			if err != nil || account.Balance != tt.wantAmount {
+				t.Errorf("makeRefund() voucher %s = %v, %v, want balance %v", tt.wantVoucher, account, err, tt.wantAmount)
+			}
+		})
+	}
+}
+
+func Test_refund_partial(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name         string
+		requests     []refundRequest
+		wantErr      bool
+		wantRefunded model.Money
+		wantBalance  model.Money
+		wantDeleted  bool
+	}{
 		{
-			Name:     "John",
-			LastName: "Doe",
-			Balance:  100,
-			Orders:   []int{1, 2, 3},
+			name:         "refunds the given amount",
+			requests:     []refundRequest{{Amount: []byte(`{"amount":"25.50","currency":"USD"}`)}},
+			wantRefunded: model.NewMoney(2550, "USD"),
+			wantBalance:  model.NewMoney(12550, "USD"),
 		},
 		{
-			Name:     "Jane",
-			LastName: "Doe",
-			Balance:  150,
-			Orders:   []int{4},
+			name:         "takes amounts without a currency in the currency of the order",
+			requests:     []refundRequest{{Amount: []byte(`"40"`)}},
+			wantRefunded: usd(40),
+			wantBalance:  usd(140),
+		},
+		{
+			name: "refunds the sum of the lines",
+			requests: []refundRequest{{Lines: []refundLine{
+				{Item: "SKU-1", Amount: []byte(`"10.25"`)},
+				{Item: "shipping", Amount: []byte(`4.75`)},
+			}}},
+			wantRefunded: usd(15),
+			wantBalance:  usd(115),
+		},
+		{
+			name: "refunds the rest of the order after partial refunds",
+			requests: []refundRequest{
+				{Amount: []byte(`"30"`)},
+				{Amount: []byte(`"50"`)},
+				{},
+			},
+			wantRefunded: usd(100),
+			wantBalance:  usd(200),
+			wantDeleted:  true,
+		},
+		{
+			name: "marks the order as refunded when partial refunds exhaust the total",
+			requests: []refundRequest{
+				{Amount: []byte(`"60"`)},
+				{Amount: []byte(`"40"`)},
+			},
+			wantRefunded: usd(100),
+			wantBalance:  usd(200),
+			wantDeleted:  true,
+		},
+		{
+			name: "fails when the order is already refunded in full",
+			requests: []refundRequest{
+				{Amount: []byte(`"100"`)},
+				{Amount: []byte(`"1"`)},
+			},
+			wantErr: true,
+		},
+		{
+			name: "fails when the amount exceeds the refundable amount",
+			requests: []refundRequest{
+				{Amount: []byte(`"70"`)},
+				{Amount: []byte(`"30.01"`)},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "fails when the amount is not positive",
+			requests: []refundRequest{{Amount: []byte(`"0"`)}},
+			wantErr:  true,
+		},
+		{
+			name:     "fails when the amount is in another currency",
+			requests: []refundRequest{{Amount: []byte(`{"amount":"10","currency":"EUR"}`)}},
+			wantErr:  true,
+		},
+		{
+			name: "fails when both amount and lines are given",
+			requests: []refundRequest{{
+				Amount: []byte(`"10"`),
+				Lines:  []refundLine{{Item: "SKU-1", Amount: []byte(`"10"`)}},
+			}},
+			wantErr: true,
+		},
+		{
+			name:     "fails when the order has no such item",
+			requests: []refundRequest{{Lines: []refundLine{{Item: "SKU-9", Amount: []byte(`"10"`)}}}},
+			wantErr:  true,
+		},
+		{
+			name:     "fails when the item of a line is missing",
+			requests: []refundRequest{{Lines: []refundLine{{Amount: []byte(`"10"`)}}}},
+			wantErr:  true,
+		},
+		{
+			name: "refunds the rest of lines without an amount",
+			requests: []refundRequest{
+				{Lines: []refundLine{{Item: "SKU-1", Amount: []byte(`"30"`)}}},
+				{Lines: []refundLine{{Item: "SKU-1"}, {Item: "shipping"}}},
+			},
+			wantRefunded: usd(95),
+			wantBalance:  usd(195),
+		},
+		{
+			name: "fails when a line exceeds what is left of it",
+			requests: []refundRequest{
+				{Lines: []refundLine{{Item: "shipping", Amount: []byte(`"15"`)}}},
+				{Lines: []refundLine{{Item: "shipping", Amount: []byte(`"15"`)}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "fails when lines of the same item exceed its total",
+			requests: []refundRequest{{Lines: []refundLine{
+				{Item: "shipping", Amount: []byte(`"10"`)},
+				{Item: "shipping", Amount: []byte(`"10"`)},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "fails when a line without an amount is already refunded",
+			requests: []refundRequest{
+				{Lines: []refundLine{{Item: "shipping"}}},
+				{Lines: []refundLine{{Item: "shipping"}}},
+			},
+			wantErr: true,
 		},
 	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
 
-	dbs.usr = model.NewUserHandler()
-	for _, u := range users {
-		tmp := u
-		dbs.usr.Usr = &tmp
-		dbs.usr.AddToDB()
+			var err error
+			for _, req := range tt.requests {
+				req.UserKey, req.OrderID = "john-doe", "1"
+				if _, err = srv.refund(ctx, req); err != nil {
+					break
+				}
+			}
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("refund() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			order, _ := srv.ord.Get(ctx, "1")
+			if order.Refunded != tt.wantRefunded || order.IsDeleted != tt.wantDeleted {
+				t.Errorf("refund() order refunded = %v, deleted = %v, want %v, %v", order.Refunded, order.IsDeleted, tt.wantRefunded, tt.wantDeleted)
+			}
+
+			user, _ := srv.usr.Get(ctx, "john-doe")
+			if user.Balance != tt.wantBalance {
+				t.Errorf("refund() balance = %v, want %v", user.Balance, tt.wantBalance)
+			}
+		})
 	}
+}
 
-	dbs.ord = model.NewOrderHandler()
-	for _, o := range orders {
-		tmp := o
-		dbs.ord.Ord = &tmp
-		dbs.ord.AddToDB()
+func Test_refund_partialKeepsOrderOnFailure(t *testing.T) {
+	srv := newTestServer()
+	ctx := context.Background()
+
+	if _, err := srv.refund(ctx, refundRequest{UserKey: "john-doe", OrderID: "1", Amount: []byte(`"30"`)}); err != nil {
+		t.Fatalf("refund() error = %v", err)
+	}
+
+	if _, err := srv.refund(ctx, refundRequest{UserKey: "john-doe", OrderID: "1", Amount: []byte(`"80"`)}); err == nil {
+		t.Fatalf("refund() error = nil, want refundable amount error")
+	}
+
+	order, _ := srv.ord.Get(ctx, "1")
+	if order.Refunded != usd(30) || order.IsDeleted {
+		t.Errorf("refund() order = %v, want 30.00 USD refunded", order)
+	}
+}
+
+func Test_refundHandler_partial(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{
+			name:     "refunds the given amount",
+			body:     `{"user_key": "john-doe", "amount": "25"}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "refunds the given lines",
+			body:     `{"user_key": "john-doe", "lines": [{"item": "SKU-1", "amount": "25"}]}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "returns unprocessable entity status when the amount is too high",
+			body:     `{"user_key": "john-doe", "amount": "100.01"}`,
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "returns unprocessable entity status when a line is too high",
+			body:     `{"user_key": "john-doe", "lines": [{"item": "shipping", "amount": "15.01"}]}`,
+			wantCode: http.StatusUnprocessableEntity,
+		},
 	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+
+			req := httptest.NewRequest(http.MethodPost, "/order/1/refund/", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
 
-	dbs.vch = model.NewVoucherHandler()
+			srv.Router(false).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantCode {
+				t.Errorf("refundHandler() = %v, want %v\n%s", rr.Code, tt.wantCode, rr.Body.String())
+			}
+		})
+	}
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/srgyrn/pact-example/api/model"
+	"github.com/srgyrn/pact-example/api/sqlite"
+)
+
+// Migrator manages the schema of a persistent storage backend
+type Migrator interface {
+	MigrateUp(ctx context.Context, steps int) ([]sqlite.Migration, error)
+	MigrateDown(ctx context.Context, steps int) ([]sqlite.Migration, error)
+	MigrationStatus(ctx context.Context) ([]sqlite.MigrationStatus, error)
+}
+
+// setupStep is one step of the pipeline that prepares the storage before the server starts
+type setupStep struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// setupPipeline returns the steps that prepare the storage: the schema migrations
+// of persistent backends first, then the seed data. Opening balances of the seed data
+// are recorded at the time the clock tells.
+func setupPipeline(st *storage, clock model.Clock) []setupStep {
+	var steps []setupStep
+	if st.migrator != nil {
+		steps = append(steps, setupStep{name: "migrate", run: func(ctx context.Context) error {
+			applied, err := st.migrator.MigrateUp(ctx, 0)
+			for _, m := range applied {
+				log.Printf("applied migration %d_%s", m.Version, m.Name)
+			}
+			return err
+		}})
+	}
+
+	return append(steps, setupStep{name: "seed", run: func(ctx context.Context) error {
+		return initDBs(ctx, st.uow, clock)
+	}})
+}
+
+// runSetup runs the steps in order and stops at the first failing one
+func runSetup(ctx context.Context, steps []setupStep) error {
+	for _, step := range steps {
+		if err := step.run(ctx); err != nil {
+			return fmt.Errorf("%s failed\n%s", step.name, err)
+		}
+	}
+
+	return nil
+}
+
+// runMigrate runs the migrate subcommand:
+//
+//	migrate up [steps]    applies pending migrations, all of them by default, then seeds the data
+//	migrate down [steps]  reverts applied migrations, the last one by default
+//	migrate status        lists the migrations and whether they are applied
+func runMigrate(ctx context.Context, st *storage, clock model.Clock, args []string, out io.Writer) error {
+	if st.migrator == nil {
+		return fmt.Errorf("the storage backend has no schema to migrate")
+	}
+
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: migrate up|down|status [steps]")
+	}
+
+	steps := 0
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("steps must be a positive number: %s", args[1])
+		}
+		steps = n
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := st.migrator.MigrateUp(ctx, steps)
+		printMigrations(out, "applied", applied)
+		if err != nil {
+			return err
+		}
+
+		status, err := st.migrator.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+
+		// seed data needs the whole schema
+		if len(status) > 0 && !status[len(status)-1].Applied {
+			return nil
+		}
+
+		return initDBs(ctx, st.uow, clock)
+	case "down":
+		if steps == 0 {
+			steps = 1
+		}
+
+		reverted, err := st.migrator.MigrateDown(ctx, steps)
+		printMigrations(out, "reverted", reverted)
+
+		return err
+	case "status":
+		status, err := st.migrator.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range status {
+			appliedAt := "pending"
+			if s.Applied {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+
+		return w.Flush()
+	}
+
+	return fmt.Errorf("unknown migrate command: %s", args[0])
+}
+
+func printMigrations(out io.Writer, action string, migrations []sqlite.Migration) {
+	if len(migrations) == 0 {
+		fmt.Fprintf(out, "no migrations %s\n", action)
+		return
+	}
+
+	for _, m := range migrations {
+		fmt.Fprintf(out, "%s %d_%s\n", action, m.Version, m.Name)
+	}
+}
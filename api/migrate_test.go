@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/srgyrn/pact-example/api/model"
+	"github.com/srgyrn/pact-example/api/sqlite"
+)
+
+func Test_runSetup(t *testing.T) {
+	var ran []string
+	step := func(name string, err error) setupStep {
+		return setupStep{name: name, run: func(context.Context) error {
+			ran = append(ran, name)
+			return err
+		}}
+	}
+
+	err := runSetup(context.Background(), []setupStep{
+		step("migrate", nil),
+		step("seed", errors.New("cannot open users.json")),
+		step("never", nil),
+	})
+
+	if err == nil || !strings.HasPrefix(err.Error(), "seed failed") {
+		t.Errorf("runSetup() error = %v, want the seed step to fail", err)
+	}
+
+	if want := []string{"migrate", "seed"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("runSetup() ran %v, want %v", ran, want)
+	}
+}
+
+func Test_setupPipeline(t *testing.T) {
+	defer func(dir string) { dataDir = dir }(dataDir)
+	dataDir = "./data/"
+
+	ctx := context.Background()
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clock := model.ClockFunc(func() time.Time { return now })
+
+	t.Run("seeds the memory backend", func(t *testing.T) {
+		st, _ := openStorage(backendMemory, "")
+		if err := runSetup(ctx, setupPipeline(st, clock)); err != nil {
+			t.Fatalf("runSetup() error = %v", err)
+		}
+
+		if _, err := st.stores.Users.Get(ctx, "john-doe"); err != nil {
+			t.Errorf("runSetup() did not seed the users: %v", err)
+		}
+
+		// amounts of the data files must be loaded without float rounding errors
+		bruce, _ := st.stores.Users.Get(ctx, "bruce-wayne")
+		if want := model.NewMoney(100000000000000000, "USD"); bruce.Balance != want {
+			t.Errorf("runSetup() balance = %v, want %v", bruce.Balance, want)
+		}
+
+		order, _ := st.stores.Orders.Get(ctx, "2")
+		if want := model.NewMoney(590, "USD"); order.Total != want {
+			t.Errorf("runSetup() order total = %v, want %v", order.Total, want)
+		}
+
+		// seeded orders keep the zone of the data file
+		if order, _ = st.stores.Orders.Get(ctx, "4"); order.ShippingCountryZone != model.ZoneMena {
+			t.Errorf("runSetup() order zone = %d, want %d", order.ShippingCountryZone, model.ZoneMena)
+		}
+	})
+
+	t.Run("migrates and seeds the sqlite backend", func(t *testing.T) {
+		st, cleanup := openTestStorage(t)
+		defer cleanup()
+
+		if err := runSetup(ctx, setupPipeline(st, clock)); err != nil {
+			t.Fatalf("runSetup() error = %v", err)
+		}
+
+		if _, err := st.stores.Users.Get(ctx, "john-doe"); err != nil {
+			t.Errorf("runSetup() did not seed the users: %v", err)
+		}
+
+		if err := runSetup(ctx, setupPipeline(st, clock)); err != nil {
+			t.Errorf("runSetup() error on second run = %v", err)
+		}
+
+		// seeded balances are recorded once as opening balances
+		entries, _ := st.stores.Ledger.List(ctx, "bruce-wayne")
+		balances, _ := model.Balances(entries)
+		if want := model.NewMoney(100000000000000000, "USD"); len(entries) != 1 || balances[model.BalanceAccount("bruce-wayne")] != want {
+			t.Fatalf("runSetup() ledger = %v, want an opening balance of %v", entries, want)
+		}
+
+		if !entries[0].Time.Equal(now) {
+			t.Errorf("runSetup() opening balance time = %v, want %v", entries[0].Time, now)
+		}
+	})
+}
+
+func Test_runMigrate(t *testing.T) {
+	defer func(dir string) { dataDir = dir }(dataDir)
+	dataDir = "./data/"
+
+	ctx := context.Background()
+	st, cleanup := openTestStorage(t)
+	defer cleanup()
+
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := runMigrate(ctx, st, model.SystemClock, args, &out)
+		return out.String(), err
+	}
+
+	out, _ := run("status")
+	if !strings.Contains(out, "pending") {
+		t.Errorf("migrate status = %q, want pending migrations", out)
+	}
+
+	if out, err := run("up"); err != nil || !strings.Contains(out, "applied 1_create_documents") {
+		t.Errorf("migrate up = %q, %v", out, err)
+	}
+
+	if _, err := st.stores.Users.Get(ctx, "john-doe"); err != nil {
+		t.Errorf("migrate up did not seed the users: %v", err)
+	}
+
+	if out, _ = run("status"); strings.Contains(out, "pending") {
+		t.Errorf("migrate status = %q, want no pending migrations", out)
+	}
+
+	all, _ := sqlite.Migrations()
+	latest := all[len(all)-1]
+	if out, err := run("down"); err != nil || out != fmt.Sprintf("reverted %d_%s\n", latest.Version, latest.Name) {
+		t.Errorf("migrate down = %q, %v, want only the latest migration reverted", out, err)
+	}
+
+	for _, args := range [][]string{{}, {"sideways"}, {"up", "zero"}, {"down", "-1"}, {"up", "1", "2"}} {
+		if _, err := run(args...); err == nil {
+			t.Errorf("migrate %v error = nil, want error", args)
+		}
+	}
+
+	memory, _ := openStorage(backendMemory, "")
+	if err := runMigrate(ctx, memory, model.SystemClock, []string{"up"}, ioutil.Discard); err == nil {
+		t.Errorf("migrate up on memory backend error = nil, want error")
+	}
+}
+
+// openTestStorage opens a SQLite storage in a temporary directory, the returned function removes it
+func openTestStorage(t *testing.T) (*storage, func()) {
+	dir, err := ioutil.TempDir("", "refunds")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := openStorage(backendSQLite, filepath.Join(dir, "refunds.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("openStorage() error = %v", err)
+	}
+
+	return st, func() {
+		st.close()
+		os.RemoveAll(dir)
+	}
+}
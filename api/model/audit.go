@@ -0,0 +1,84 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Audited actions
+const (
+	// AuditOwnershipOverride is recorded when staff refunds an order the user does not own
+	AuditOwnershipOverride = "refund.ownership_override"
+	// AuditUserCreated, AuditUserUpdated and AuditUserDeleted are recorded when staff manages users
+	AuditUserCreated = "user.created"
+	AuditUserUpdated = "user.updated"
+	AuditUserDeleted = "user.deleted"
+	// AuditVoucherCredit and AuditVoucherDebit are recorded when staff adjusts a voucher account
+	AuditVoucherCredit = "voucher.credit"
+	AuditVoucherDebit  = "voucher.debit"
+)
+
+// AuditEntry records an action that needs to be traceable to the actor who made it
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	ActorKey  string    `json:"actor_key"`
+	ActorRole string    `json:"actor_role"`
+	UserKey   string    `json:"user_key"`
+	OrderID   string    `json:"order_id,omitempty"`
+	Details   string    `json:"details,omitempty"`
+}
+
+// AuditStore is an append-only log of audit entries.
+// Append saves every given entry or none of them.
+type AuditStore interface {
+	Append(ctx context.Context, entries ...*AuditEntry) error
+	List(ctx context.Context) ([]*AuditEntry, error)
+}
+
+// AuditLog is the in-memory AuditStore, it is safe for concurrent use
+type AuditLog struct {
+	mu      sync.RWMutex
+	entries []AuditEntry
+}
+
+// NewAuditLog creates an empty AuditLog and returns it
+func NewAuditLog() *AuditLog {
+	return &AuditLog{}
+}
+
+// Append adds the entries to the end of the log
+func (l *AuditLog) Append(_ context.Context, entries ...*AuditEntry) error {
+	for _, e := range entries {
+		if e == nil {
+			return errors.New("audit entry cannot be nil")
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, e := range entries {
+		l.entries = append(l.entries, *e)
+	}
+
+	return nil
+}
+
+// List returns a copy of every entry in the order they were appended
+func (l *AuditLog) List(_ context.Context) ([]*AuditEntry, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entries := make([]*AuditEntry, 0, len(l.entries))
+	for _, e := range l.entries {
+		c := e
+		entries = append(entries, &c)
+	}
+
+	return entries, nil
+}
+
+var _ AuditStore = (*AuditLog)(nil)
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	ctx := context.Background()
+	log := NewAuditLog()
+
+	first := &AuditEntry{Action: AuditOwnershipOverride, ActorKey: "agent-1", UserKey: "john-doe", OrderID: "4"}
+	second := &AuditEntry{Action: AuditOwnershipOverride, ActorKey: "agent-2", UserKey: "jane-doe", OrderID: "1"}
+
+	if err := log.Append(ctx, first, second); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	if err := log.Append(ctx, first, nil); err == nil {
+		t.Errorf("Append() error = nil, want error for nil entry")
+	}
+
+	got, _ := log.List(ctx)
+	if want := []*AuditEntry{first, second}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("List() = %v, want %v", got, want)
+	}
+
+	got[0].ActorKey = "someone-else"
+	if again, _ := log.List(ctx); again[0].ActorKey != "agent-1" {
+		t.Errorf("List() did not return a copy")
+	}
+}
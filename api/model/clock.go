@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// Clock tells the time. Tests replace it to make times deterministic.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc lets a function be used as a Clock
+type ClockFunc func() time.Time
+
+// Now returns the time f returns
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// SystemClock is the Clock of the machine, its times are in UTC
+var SystemClock Clock = ClockFunc(func() time.Time { return time.Now().UTC() })
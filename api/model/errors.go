@@ -0,0 +1,31 @@
+package model
+
+import "errors"
+
+// Errors returned by the stores and the model. They may be wrapped with more details,
+// use errors.Is to check for them.
+var (
+	ErrUserNotFound     = errors.New("user not found")
+	ErrUserExists       = errors.New("user already exists")
+	ErrInvalidUser      = errors.New("invalid user")
+	ErrOrderNotFound    = errors.New("order not found")
+	ErrOrderExists      = errors.New("order already exists")
+	ErrInvalidOrder     = errors.New("invalid order")
+	ErrAccountNotFound  = errors.New("account not found")
+	ErrAlreadyRefunded  = errors.New("order already refunded")
+	ErrRefundExceeded   = errors.New("refund amount exceeds the refundable amount")
+	ErrInvalidAmount    = errors.New("invalid amount")
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrNoExchangeRate   = errors.New("no exchange rate")
+
+	ErrRefundNotFound       = errors.New("refund not found")
+	ErrPaymentNotRefundable = errors.New("payment cannot be refunded to its payment method")
+	ErrPaymentDeclined      = errors.New("payment gateway declined the refund")
+
+	ErrInsufficientFunds  = errors.New("insufficient funds")
+	ErrInvalidExpiry      = errors.New("invalid expiry")
+	ErrRedemptionNotFound = errors.New("redemption not found")
+	ErrRedemptionReversed = errors.New("redemption already reversed")
+
+	ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+)
@@ -0,0 +1,122 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+)
+
+// ExchangeRateProvider provides the rates used to convert amounts between currencies
+type ExchangeRateProvider interface {
+	// Rate returns how many units of the to currency one unit of the from currency buys
+	Rate(ctx context.Context, from, to string) (*big.Rat, error)
+}
+
+// Convert converts the amount into the given currency. Amounts already in that currency,
+// and zero amounts without one, are returned as they are without asking the provider.
+func Convert(ctx context.Context, p ExchangeRateProvider, m Money, to string) (Money, error) {
+	to = strings.ToUpper(to)
+	if m.Currency == to || (m.Currency == "" && m.IsZero()) {
+		return Money{Amount: m.Amount, Currency: to}, nil
+	}
+
+	rate, err := p.Rate(ctx, m.Currency, to)
+	if err != nil {
+		return Money{}, err
+	}
+
+	// amount in minor units of m, scaled to the minor units of the target currency
+	r := new(big.Rat).SetInt64(m.Amount)
+	r.Mul(r, rate)
+	r.Mul(r, new(big.Rat).SetFrac(pow10(exponent(to)), pow10(exponent(m.Currency))))
+
+	converted, err := fromRat(r, to)
+	if err != nil {
+		return Money{}, fmt.Errorf("cannot convert %v to %s\n%w", m, to, err)
+	}
+
+	return converted, nil
+}
+
+// StaticRates is an ExchangeRateProvider with fixed rates against a base currency
+type StaticRates struct {
+	base  string
+	rates map[string]*big.Rat // units of the currency one unit of base buys
+}
+
+// staticRatesJSON is the file format of StaticRates. Rates may be numbers or strings:
+//
+//	{"base": "USD", "rates": {"AED": "3.6725", "SAR": 3.75}}
+type staticRatesJSON struct {
+	Base  string                 `json:"base"`
+	Rates map[string]json.Number `json:"rates"`
+}
+
+// NewStaticRates creates a StaticRates from decimal rates against the base currency
+func NewStaticRates(base string, rates map[string]string) (*StaticRates, error) {
+	base = strings.ToUpper(base)
+	if err := CheckCurrency(base); err != nil {
+		return nil, err
+	}
+
+	s := &StaticRates{base: base, rates: map[string]*big.Rat{base: big.NewRat(1, 1)}}
+	for currency, rate := range rates {
+		currency = strings.ToUpper(currency)
+		if err := CheckCurrency(currency); err != nil {
+			return nil, err
+		}
+
+		if !decimalAmount.MatchString(rate) {
+			return nil, fmt.Errorf("invalid rate for %s: %s", currency, rate)
+		}
+
+		r, _ := new(big.Rat).SetString(rate)
+		if r.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid rate for %s: %s", currency, rate)
+		}
+
+		s.rates[currency] = r
+	}
+
+	return s, nil
+}
+
+// LoadStaticRates reads the rates from a JSON file
+func LoadStaticRates(path string) (*StaticRates, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read exchange rates\n%s", err)
+	}
+
+	var f staticRatesJSON
+	if err = json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s\n%s", path, err)
+	}
+
+	rates := make(map[string]string, len(f.Rates))
+	for currency, rate := range f.Rates {
+		rates[currency] = rate.String()
+	}
+
+	return NewStaticRates(f.Base, rates)
+}
+
+// Rate returns the rate between two currencies, through the base currency if neither is the base
+func (s *StaticRates) Rate(_ context.Context, from, to string) (*big.Rat, error) {
+	fromRate, ok := s.rates[strings.ToUpper(from)]
+	if !ok {
+		return nil, fmt.Errorf("%w for %s", ErrNoExchangeRate, from)
+	}
+
+	toRate, ok := s.rates[strings.ToUpper(to)]
+	if !ok {
+		return nil, fmt.Errorf("%w for %s", ErrNoExchangeRate, to)
+	}
+
+	return new(big.Rat).Quo(toRate, fromRate), nil
+}
+
+var _ ExchangeRateProvider = (*StaticRates)(nil)
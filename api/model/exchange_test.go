@@ -0,0 +1,98 @@
+package model
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testRates(t *testing.T) *StaticRates {
+	rates, err := NewStaticRates("USD", map[string]string{"AED": "3.6725", "SAR": "3.75", "KWD": "0.307", "JPY": "150"})
+	if err != nil {
+		t.Fatalf("NewStaticRates() error = %v", err)
+	}
+
+	return rates
+}
+
+func TestConvert(t *testing.T) {
+	rates := testRates(t)
+
+	tests := []struct {
+		name    string
+		m       Money
+		to      string
+		want    Money
+		wantErr bool
+	}{
+		{name: "converts from the base currency", m: usd(100), to: "AED", want: NewMoney(36725, "AED")},
+		{name: "converts to the base currency", m: NewMoney(36725, "AED"), to: "usd", want: usd(100)},
+		{name: "converts through the base currency", m: NewMoney(37500, "SAR"), to: "AED", want: NewMoney(36725, "AED")},
+		{name: "scales to the minor units of the target", m: usd(10), to: "KWD", want: NewMoney(3070, "KWD")},
+		{name: "scales to currencies without minor units", m: NewMoney(590, "USD"), to: "JPY", want: NewMoney(885, "JPY")},
+		{name: "rounds half away from zero", m: NewMoney(1, "USD"), to: "AED", want: NewMoney(4, "AED")},
+		{name: "does not convert the same currency", m: NewMoney(590, "EGP"), to: "EGP", want: NewMoney(590, "EGP")},
+		{name: "zero without currency takes the target currency", m: Money{}, to: "AED", want: NewMoney(0, "AED")},
+		{name: "returns error for unknown currencies", m: NewMoney(590, "EGP"), to: "AED", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Convert(context.Background(), rates, tt.m, tt.to)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("Convert() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewStaticRates(t *testing.T) {
+	tests := []struct {
+		name  string
+		base  string
+		rates map[string]string
+	}{
+		{name: "fails for invalid base currency", base: "dollar"},
+		{name: "fails for invalid currency", base: "USD", rates: map[string]string{"dirham": "3.6725"}},
+		{name: "fails for invalid rate", base: "USD", rates: map[string]string{"AED": "1/3"}},
+		{name: "fails for zero rate", base: "USD", rates: map[string]string{"AED": "0"}},
+		{name: "fails for negative rate", base: "USD", rates: map[string]string{"AED": "-3.6725"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewStaticRates(tt.base, tt.rates); err == nil {
+				t.Errorf("NewStaticRates() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestLoadStaticRates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rates.json")
+	ioutil.WriteFile(path, []byte(`{"base": "USD", "rates": {"AED": "3.6725", "SAR": 3.75}}`), 0644)
+
+	rates, err := LoadStaticRates(path)
+	if err != nil {
+		t.Fatalf("LoadStaticRates() error = %v", err)
+	}
+
+	got, err := Convert(context.Background(), rates, usd(2), "SAR")
+	if want := NewMoney(750, "SAR"); err != nil || got != want {
+		t.Errorf("Convert() = %v, %v, want %v", got, err, want)
+	}
+
+	if _, err = LoadStaticRates(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("LoadStaticRates() error = nil, want error for missing file")
+	}
+}
@@ -0,0 +1,95 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// IdempotencyRecord is the response stored for an idempotency key, it is replayed for retries of the request
+type IdempotencyRecord struct {
+	RequestHash string              `json:"request_hash"` // identifies the request the key was first used with
+	StatusCode  int                 `json:"status_code"`
+	Header      map[string][]string `json:"header"`
+	Body        []byte              `json:"body"`
+	CreatedAt   time.Time           `json:"created_at"`
+}
+
+// IdempotencyStore saves the responses of requests made with an idempotency key
+type IdempotencyStore interface {
+	Get(ctx context.Context, key string) (*IdempotencyRecord, error)
+	Put(ctx context.Context, key string, rec *IdempotencyRecord) error
+	// DeleteBefore removes the records created before t and returns how many were removed
+	DeleteBefore(ctx context.Context, t time.Time) (int, error)
+}
+
+// IdempotencyKeys is the in-memory IdempotencyStore, it is safe for concurrent use
+type IdempotencyKeys struct {
+	mu sync.RWMutex
+	db map[string]*IdempotencyRecord
+}
+
+// NewIdempotencyKeys creates and returns an empty IdempotencyKeys
+func NewIdempotencyKeys() *IdempotencyKeys {
+	return &IdempotencyKeys{db: map[string]*IdempotencyRecord{}}
+}
+
+// Get returns a copy of the record saved for the key.
+// ErrIdempotencyKeyNotFound is returned if there is no record for the key.
+func (k *IdempotencyKeys) Get(_ context.Context, key string) (*IdempotencyRecord, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	rec, ok := k.db[key]
+	if !ok {
+		return nil, ErrIdempotencyKeyNotFound
+	}
+
+	return rec.copy(), nil
+}
+
+// Put saves the record under the key, replacing any existing record
+func (k *IdempotencyKeys) Put(_ context.Context, key string, rec *IdempotencyRecord) error {
+	if rec == nil {
+		return errors.New("idempotency record cannot be nil")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.db[key] = rec.copy()
+
+	return nil
+}
+
+// DeleteBefore removes the records created before t and returns how many were removed
+func (k *IdempotencyKeys) DeleteBefore(_ context.Context, t time.Time) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var n int
+	for key, rec := range k.db {
+		if rec.CreatedAt.Before(t) {
+			delete(k.db, key)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// copy returns a deep copy of the record
+func (r *IdempotencyRecord) copy() *IdempotencyRecord {
+	c := *r
+	c.Body = append([]byte(nil), r.Body...)
+
+	c.Header = make(map[string][]string, len(r.Header))
+	for name, values := range r.Header {
+		c.Header[name] = append([]string(nil), values...)
+	}
+
+	return &c
+}
+
+var _ IdempotencyStore = (*IdempotencyKeys)(nil)
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	ctx := context.Background()
+	keys := NewIdempotencyKeys()
+
+	if _, err := keys.Get(ctx, "key-1"); err == nil {
+		t.Errorf("Get() error = nil, want not found")
+	}
+
+	if err := keys.Put(ctx, "key-1", nil); err == nil {
+		t.Errorf("Put() error = nil, want error for nil record")
+	}
+
+	rec := &IdempotencyRecord{
+		RequestHash: "abc",
+		StatusCode:  200,
+		Header:      map[string][]string{"Content-Type": {"application/json"}},
+		Body:        []byte(`{"user_key":"john-doe"}`),
+		CreatedAt:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if err := keys.Put(ctx, "key-1", rec); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	got, err := keys.Get(ctx, "key-1")
+	if err != nil || !reflect.DeepEqual(got, rec) {
+		t.Fatalf("Get() = %v, %v, want %v", got, err, rec)
+	}
+
+	got.Body[0] = '['
+	got.Header["Content-Type"][0] = "text/plain"
+	if again, _ := keys.Get(ctx, "key-1"); !reflect.DeepEqual(again, rec) {
+		t.Errorf("Get() did not return a copy")
+	}
+
+	if n, err := keys.DeleteBefore(ctx, rec.CreatedAt); err != nil || n != 0 {
+		t.Errorf("DeleteBefore() = %d, %v, want a record created at the time to be kept", n, err)
+	}
+
+	if n, err := keys.DeleteBefore(ctx, rec.CreatedAt.Add(time.Second)); err != nil || n != 1 {
+		t.Errorf("DeleteBefore() = %d, %v, want 1 record removed", n, err)
+	}
+
+	if _, err := keys.Get(ctx, "key-1"); !errors.Is(err, ErrIdempotencyKeyNotFound) {
+		t.Errorf("Get() of a removed record error = %v, want %v", err, ErrIdempotencyKeyNotFound)
+	}
+}
@@ -0,0 +1,131 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Reasons of ledger entries
+const (
+	// LedgerOpeningBalance records a balance that existed before the ledger, e.g. seeded data
+	LedgerOpeningBalance = "opening_balance"
+	// LedgerRefund records a refund added to a balance or a voucher account
+	LedgerRefund = "refund"
+	// LedgerRefundReversal takes back a refund the payment gateway failed to make
+	LedgerRefundReversal = "refund_reversal"
+	// LedgerGoodwillCredit records a credit staff added to a voucher account by hand
+	LedgerGoodwillCredit = "goodwill_credit"
+	// LedgerVoucherDebit records an amount staff took off a voucher account by hand
+	LedgerVoucherDebit = "voucher_debit"
+	// LedgerRedemption records an amount spent from a voucher account at checkout
+	LedgerRedemption = "redemption"
+	// LedgerRedemptionReversal puts back an amount spent at a checkout that was cancelled
+	LedgerRedemptionReversal = "redemption_reversal"
+	// LedgerVoucherExpiry takes the expired credits off a voucher account
+	LedgerVoucherExpiry = "voucher_expiry"
+)
+
+// LedgerEntry records a movement of the balance of an account.
+// The balance of an account is the sum of the deltas of its entries.
+type LedgerEntry struct {
+	Time         time.Time `json:"time"`
+	Account      string    `json:"account"`
+	UserKey      string    `json:"user_key"`
+	Delta        Money     `json:"delta"`
+	Reason       string    `json:"reason"`
+	OrderID      string    `json:"order_id,omitempty"`
+	RefundID     string    `json:"refund_id,omitempty"`
+	RedemptionID string    `json:"redemption_id,omitempty"` // set on redemptions and their reversals
+	ActorKey     string    `json:"actor_key"`
+	ActorRole    string    `json:"actor_role"`
+}
+
+// BalanceAccount returns the ledger account of the balance of the user
+func BalanceAccount(userKey string) string {
+	return "balance:" + userKey
+}
+
+// VoucherAccount returns the ledger account of the voucher account with the given key
+func VoucherAccount(voucherKey string) string {
+	return "voucher:" + voucherKey
+}
+
+// PaymentAccount returns the ledger account of the payment method of the order,
+// it holds what was refunded to the payment method
+func PaymentAccount(orderID string) string {
+	return "payment:" + orderID
+}
+
+// Balances sums the deltas of the entries by account
+func Balances(entries []*LedgerEntry) (map[string]Money, error) {
+	balances := map[string]Money{}
+	for _, e := range entries {
+		sum, err := balances[e.Account].Add(e.Delta)
+		if err != nil {
+			return nil, err
+		}
+
+		balances[e.Account] = sum
+	}
+
+	return balances, nil
+}
+
+// LedgerStore is an append-only log of balance movements.
+// Append saves every given entry or none of them.
+type LedgerStore interface {
+	Append(ctx context.Context, entries ...*LedgerEntry) error
+	// List returns the entries of the user in the order they were appended
+	List(ctx context.Context, userKey string) ([]*LedgerEntry, error)
+}
+
+// Ledger is the in-memory LedgerStore, it is safe for concurrent use
+type Ledger struct {
+	mu      sync.RWMutex
+	entries []LedgerEntry
+}
+
+// NewLedger creates an empty Ledger and returns it
+func NewLedger() *Ledger {
+	return &Ledger{}
+}
+
+// Append adds the entries to the end of the ledger
+func (l *Ledger) Append(_ context.Context, entries ...*LedgerEntry) error {
+	for _, e := range entries {
+		if e == nil {
+			return errors.New("ledger entry cannot be nil")
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, e := range entries {
+		l.entries = append(l.entries, *e)
+	}
+
+	return nil
+}
+
+// List returns a copy of every entry of the user in the order they were appended
+func (l *Ledger) List(_ context.Context, userKey string) ([]*LedgerEntry, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entries := []*LedgerEntry{}
+	for _, e := range l.entries {
+		if e.UserKey != userKey {
+			continue
+		}
+
+		c := e
+		entries = append(entries, &c)
+	}
+
+	return entries, nil
+}
+
+var _ LedgerStore = (*Ledger)(nil)
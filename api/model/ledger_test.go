@@ -0,0 +1,64 @@
+package model
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestLedger(t *testing.T) {
+	ctx := context.Background()
+	ledger := NewLedger()
+
+	opening := &LedgerEntry{Account: BalanceAccount("john-doe"), UserKey: "john-doe", Delta: usd(100), Reason: LedgerOpeningBalance}
+	other := &LedgerEntry{Account: BalanceAccount("jane-doe"), UserKey: "jane-doe", Delta: usd(150), Reason: LedgerOpeningBalance}
+	refund := &LedgerEntry{Account: VoucherAccount("john-doe-usd"), UserKey: "john-doe", Delta: usd(300), Reason: LedgerRefund, OrderID: "3"}
+
+	if err := ledger.Append(ctx, opening, other, refund); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	if err := ledger.Append(ctx, opening, nil); err == nil {
+		t.Errorf("Append() error = nil, want error for nil entry")
+	}
+
+	got, _ := ledger.List(ctx, "john-doe")
+	if want := []*LedgerEntry{opening, refund}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("List() = %v, want %v", got, want)
+	}
+
+	got[0].Reason = LedgerRefund
+	if again, _ := ledger.List(ctx, "john-doe"); again[0].Reason != LedgerOpeningBalance {
+		t.Errorf("List() did not return a copy")
+	}
+
+	if got, _ := ledger.List(ctx, "barbara-streisand"); got == nil || len(got) != 0 {
+		t.Errorf("List() of a user without entries = %v, want empty", got)
+	}
+}
+
+func TestBalances(t *testing.T) {
+	entries := []*LedgerEntry{
+		{Account: BalanceAccount("john-doe"), Delta: usd(100)},
+		{Account: VoucherAccount("john-doe-aed"), Delta: NewMoney(36725, "AED")},
+		{Account: BalanceAccount("john-doe"), Delta: usd(-30)},
+	}
+
+	got, err := Balances(entries)
+	if err != nil {
+		t.Fatalf("Balances() error = %v", err)
+	}
+
+	want := map[string]Money{
+		BalanceAccount("john-doe"):     usd(70),
+		VoucherAccount("john-doe-aed"): NewMoney(36725, "AED"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Balances() = %v, want %v", got, want)
+	}
+
+	entries = append(entries, &LedgerEntry{Account: BalanceAccount("john-doe"), Delta: NewMoney(1, "EUR")})
+	if _, err = Balances(entries); err == nil {
+		t.Errorf("Balances() error = nil, want currency mismatch")
+	}
+}
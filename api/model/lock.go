@@ -0,0 +1,77 @@
+package model
+
+import (
+	"sort"
+	"sync"
+)
+
+// KeyLocker hands out one mutex per key. It serialises read-modify-write
+// sequences on the same records while other records are processed in parallel.
+type KeyLocker struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int // number of goroutines holding or waiting for the lock
+}
+
+// NewKeyLocker creates a KeyLocker and returns it
+func NewKeyLocker() *KeyLocker {
+	return &KeyLocker{locks: make(map[string]*keyLock)}
+}
+
+// Lock locks every given key and returns a function that unlocks them.
+// Keys are locked in sorted order so callers locking overlapping sets of keys cannot deadlock.
+func (kl *KeyLocker) Lock(keys ...string) (unlock func()) {
+	sorted := make([]string, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if !seen[k] {
+			seen[k] = true
+			sorted = append(sorted, k)
+		}
+	}
+	sort.Strings(sorted)
+
+	held := make([]*keyLock, 0, len(sorted))
+	for _, k := range sorted {
+		l := kl.acquire(k)
+		l.Lock()
+		held = append(held, l)
+	}
+
+	return func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			held[i].Unlock()
+			kl.release(sorted[i])
+		}
+	}
+}
+
+func (kl *KeyLocker) acquire(key string) *keyLock {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	l, ok := kl.locks[key]
+	if !ok {
+		l = &keyLock{}
+		kl.locks[key] = l
+	}
+	l.refs++
+
+	return l
+}
+
+// release drops the lock of the key once nobody holds or waits for it
+func (kl *KeyLocker) release(key string) {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	l := kl.locks[key]
+	l.refs--
+	if l.refs == 0 {
+		delete(kl.locks, key)
+	}
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestKeyLocker_Lock(t *testing.T) {
+	kl := NewKeyLocker()
+	counter := map[string]int{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// overlapping key sets in different orders must not deadlock
+			keys := []string{"order:1", "user:john-doe"}
+			if i%2 == 0 {
+				keys = []string{"user:john-doe", "order:1", "order:1"}
+			}
+
+			unlock := kl.Lock(keys...)
+			defer unlock()
+
+			counter["order:1"]++
+		}(i)
+	}
+	wg.Wait()
+
+	if counter["order:1"] != 100 {
+		t.Errorf("Lock() did not serialise access, got %d increments, want %d", counter["order:1"], 100)
+	}
+
+	if len(kl.locks) != 0 {
+		t.Errorf("Lock() leaked %d locks", len(kl.locks))
+	}
+}
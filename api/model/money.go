@@ -0,0 +1,310 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// currencyExponents holds the number of minor unit digits of the currencies that do not use 2
+var currencyExponents = map[string]int{
+	"BHD": 3,
+	"JOD": 3,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"OMR": 3,
+	"TND": 3,
+}
+
+// currencyCode matches ISO 4217 currency codes
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// decimalAmount matches the decimal amounts ParseMoney accepts, JSON numbers included
+var decimalAmount = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)([eE][+-]?\d{1,2})?$`)
+
+// errAmountOutOfRange is returned when an amount does not fit into int64 minor units
+var errAmountOutOfRange = fmt.Errorf("%w: out of range", ErrInvalidAmount)
+
+// Money is an amount in the minor units of its currency, e.g. cents for USD.
+//
+// The zero value has no currency and takes the currency of the amount it is added to,
+// so zero balances do not need one.
+//
+// Money is encoded in JSON as {"amount":"5.90","currency":"USD"}. The legacy forms,
+// a number such as 5.90 or a string such as "5.90", are decoded as DefaultCurrency.
+// Amounts with more digits than the currency has are rounded half away from zero.
+type Money struct {
+	Amount   int64  // minor units
+	Currency string // ISO 4217 code
+}
+
+// NewMoney creates a Money of the given minor units
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+// ParseMoney parses a decimal amount such as "5.90" in the given currency
+func ParseMoney(amount, currency string) (Money, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if err := CheckCurrency(currency); err != nil {
+		return Money{}, err
+	}
+
+	amount = strings.TrimSpace(amount)
+	if !decimalAmount.MatchString(amount) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, amount)
+	}
+
+	r, ok := new(big.Rat).SetString(amount)
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, amount)
+	}
+
+	m, err := fromRat(r.Mul(r, new(big.Rat).SetInt(pow10(exponent(currency)))), currency)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %s", err, amount)
+	}
+
+	return m, nil
+}
+
+// CheckCurrency returns an error if the code is not an upper case ISO 4217 currency code
+func CheckCurrency(code string) error {
+	if !currencyCode.MatchString(code) {
+		return fmt.Errorf("invalid currency: %q", code)
+	}
+
+	return nil
+}
+
+// fromRat rounds an amount of minor units half away from zero
+func fromRat(r *big.Rat, currency string) (Money, error) {
+	q, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(r.Denom()) >= 0 {
+		q.Add(q, big.NewInt(int64(r.Sign())))
+	}
+
+	if !q.IsInt64() {
+		return Money{}, errAmountOutOfRange
+	}
+
+	return Money{Amount: q.Int64(), Currency: currency}, nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// exponent returns the number of minor unit digits of the currency
+func exponent(currency string) int {
+	if e, ok := currencyExponents[currency]; ok {
+		return e
+	}
+
+	return 2
+}
+
+// Add returns the sum of m and o. Amounts in different currencies cannot be added.
+func (m Money) Add(o Money) (Money, error) {
+	currency, err := m.commonCurrency(o)
+	if err != nil {
+		return Money{}, err
+	}
+
+	sum := m.Amount + o.Amount
+	if (o.Amount > 0 && sum < m.Amount) || (o.Amount < 0 && sum > m.Amount) {
+		return Money{}, errAmountOutOfRange
+	}
+
+	return Money{Amount: sum, Currency: currency}, nil
+}
+
+// Sub returns m minus o. Amounts in different currencies cannot be subtracted.
+func (m Money) Sub(o Money) (Money, error) {
+	if o.Amount == math.MinInt64 {
+		return Money{}, errAmountOutOfRange
+	}
+
+	return m.Add(o.Neg())
+}
+
+// Mul returns m multiplied by n
+func (m Money) Mul(n int64) (Money, error) {
+	if n == 0 || m.Amount == 0 {
+		return Money{Amount: 0, Currency: m.Currency}, nil
+	}
+
+	product := m.Amount * n
+	if product/n != m.Amount || (n == -1 && m.Amount == math.MinInt64) {
+		return Money{}, errAmountOutOfRange
+	}
+
+	return Money{Amount: product, Currency: m.Currency}, nil
+}
+
+// Neg returns the amount with its sign flipped
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// Cmp compares m and o and returns -1, 0 or +1. Amounts in different currencies cannot be compared.
+func (m Money) Cmp(o Money) (int, error) {
+	if _, err := m.commonCurrency(o); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case m.Amount < o.Amount:
+		return -1, nil
+	case m.Amount > o.Amount:
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// IsNegative reports whether the amount is below zero
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+func (m Money) commonCurrency(o Money) (string, error) {
+	switch {
+	case m.Currency == o.Currency:
+		return m.Currency, nil
+	case m.Currency == "" && m.Amount == 0:
+		return o.Currency, nil
+	case o.Currency == "" && o.Amount == 0:
+		return m.Currency, nil
+	}
+
+	return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+}
+
+// Decimal returns the amount in major units, e.g. "5.90"
+func (m Money) Decimal() string {
+	e := exponent(m.Currency)
+	digits := strconv.FormatUint(uint64(m.Amount), 10)
+	sign := ""
+	if m.Amount < 0 {
+		digits = strconv.FormatUint(uint64(-m.Amount), 10)
+		sign = "-"
+	}
+
+	if e == 0 {
+		return sign + digits
+	}
+
+	if len(digits) <= e {
+		digits = strings.Repeat("0", e-len(digits)+1) + digits
+	}
+
+	return sign + digits[:len(digits)-e] + "." + digits[len(digits)-e:]
+}
+
+// String returns the amount followed by the currency, e.g. "5.90 USD"
+func (m Money) String() string {
+	if m.Currency == "" {
+		return m.Decimal()
+	}
+
+	return m.Decimal() + " " + m.Currency
+}
+
+type moneyJSON struct {
+	Amount   json.RawMessage `json:"amount"`
+	Currency string          `json:"currency,omitempty"`
+}
+
+// MarshalJSON encodes the money as {"amount":"5.90","currency":"USD"}
+func (m Money) MarshalJSON() ([]byte, error) {
+	amount, _ := json.Marshal(m.Decimal())
+	return json.Marshal(moneyJSON{Amount: amount, Currency: m.Currency})
+}
+
+// UnmarshalJSON decodes an object written by MarshalJSON or a legacy number or string
+func (m *Money) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(bytes.TrimSpace(b), []byte("null")) {
+		return nil
+	}
+
+	parsed, err := UnmarshalMoney(b, DefaultCurrency)
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+// UnmarshalMoney decodes the JSON forms accepted by Money.UnmarshalJSON,
+// numbers and strings are decoded in the given currency instead of DefaultCurrency.
+// Every error wraps ErrInvalidAmount.
+func UnmarshalMoney(b []byte, currency string) (Money, error) {
+	var m Money
+	if err := m.unmarshal(b, currency); err != nil {
+		if !errors.Is(err, ErrInvalidAmount) {
+			err = fmt.Errorf("%w: %s", ErrInvalidAmount, err)
+		}
+		return Money{}, err
+	}
+
+	return m, nil
+}
+
+func (m *Money) unmarshal(b []byte, currency string) error {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '{' {
+		var mj moneyJSON
+		if err := json.Unmarshal(b, &mj); err != nil {
+			return err
+		}
+
+		b, currency = bytes.TrimSpace(mj.Amount), mj.Currency
+		if currency == "" {
+			return m.unmarshalZero(b)
+		}
+	}
+
+	amount := string(b)
+	if len(b) > 0 && b[0] == '"' {
+		if err := json.Unmarshal(b, &amount); err != nil {
+			return err
+		}
+	}
+
+	parsed, err := ParseMoney(amount, currency)
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+// unmarshalZero decodes the amount of a Money without a currency, only zero is allowed
+func (m *Money) unmarshalZero(amount []byte) error {
+	var zero Money
+	if err := zero.UnmarshalJSON(amount); err != nil {
+		return err
+	}
+
+	if !zero.IsZero() {
+		return errors.New("currency is missing")
+	}
+
+	*m = Money{}
+	return nil
+}
@@ -0,0 +1,211 @@
+package model
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+// usd returns the given whole amount of dollars
+func usd(dollars int64) Money {
+	return NewMoney(dollars*100, DefaultCurrency)
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   string
+		currency string
+		want     Money
+		wantErr  bool
+	}{
+		{name: "parses cents", amount: "5.90", currency: "USD", want: Money{590, "USD"}},
+		{name: "parses whole amounts", amount: "100", currency: "usd", want: Money{10000, "USD"}},
+		{name: "parses negative amounts", amount: "-0.5", currency: "EUR", want: Money{-50, "EUR"}},
+		{name: "parses exponents", amount: "1.5e2", currency: "USD", want: Money{15000, "USD"}},
+		{name: "uses the digits of the currency", amount: "1.234", currency: "KWD", want: Money{1234, "KWD"}},
+		{name: "currencies without minor units", amount: "1500", currency: "JPY", want: Money{1500, "JPY"}},
+		{name: "rounds half away from zero", amount: "0.005", currency: "USD", want: Money{1, "USD"}},
+		{name: "rounds negative half away from zero", amount: "-0.005", currency: "USD", want: Money{-1, "USD"}},
+		{name: "rounds down below half", amount: "0.0049", currency: "USD", want: Money{0, "USD"}},
+		{name: "does not lose precision", amount: "999999999999999.999", currency: "USD", want: Money{100000000000000000, "USD"}},
+		{name: "returns error for empty currency", amount: "1", currency: "", wantErr: true},
+		{name: "returns error for invalid amount", amount: "five", currency: "USD", wantErr: true},
+		{name: "returns error for fractions", amount: "1/3", currency: "USD", wantErr: true},
+		{name: "returns error for hex amounts", amount: "0x10", currency: "USD", wantErr: true},
+		{name: "returns error when out of range", amount: "1e30", currency: "USD", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMoney(tt.amount, tt.currency)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMoney() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("ParseMoney() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoney_Add(t *testing.T) {
+	tests := []struct {
+		name    string
+		m, o    Money
+		want    Money
+		wantErr bool
+	}{
+		{name: "adds amounts", m: Money{590, "USD"}, o: Money{410, "USD"}, want: Money{1000, "USD"}},
+		{name: "zero value takes the other currency", m: Money{}, o: Money{590, "USD"}, want: Money{590, "USD"}},
+		{name: "adds zero value", m: Money{590, "USD"}, o: Money{}, want: Money{590, "USD"}},
+		{name: "returns error for different currencies", m: Money{590, "USD"}, o: Money{590, "EUR"}, wantErr: true},
+		{name: "returns error on overflow", m: Money{math.MaxInt64, "USD"}, o: Money{1, "USD"}, wantErr: true},
+		{name: "returns error on underflow", m: Money{math.MinInt64, "USD"}, o: Money{-1, "USD"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.m.Add(tt.o)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Add() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("Add() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoney_Sub(t *testing.T) {
+	got, err := Money{1000, "USD"}.Sub(Money{1590, "USD"})
+	if err != nil || got != (Money{-590, "USD"}) {
+		t.Errorf("Sub() = %v, %v, want %v", got, err, Money{-590, "USD"})
+	}
+
+	if _, err = (Money{0, "USD"}).Sub(Money{math.MinInt64, "USD"}); err == nil {
+		t.Errorf("Sub() error = nil, want overflow error")
+	}
+}
+
+func TestMoney_Mul(t *testing.T) {
+	if got, err := NewMoney(1999, "USD").Mul(3); err != nil || got != NewMoney(5997, "USD") {
+		t.Errorf("Mul() = %v, %v, want 59.97 USD", got, err)
+	}
+
+	if got, err := NewMoney(1999, "USD").Mul(0); err != nil || got != NewMoney(0, "USD") {
+		t.Errorf("Mul() by zero = %v, %v, want 0.00 USD", got, err)
+	}
+
+	if _, err := NewMoney(math.MaxInt64/2+1, "USD").Mul(2); err == nil {
+		t.Errorf("Mul() out of range did not fail")
+	}
+
+	if _, err := NewMoney(math.MinInt64, "USD").Mul(-1); err == nil {
+		t.Errorf("Mul() of the smallest amount by -1 did not fail")
+	}
+}
+
+func TestMoney_Cmp(t *testing.T) {
+	if got, _ := usd(1).Cmp(usd(2)); got != -1 {
+		t.Errorf("Cmp() = %v, want -1", got)
+	}
+
+	if got, _ := usd(2).Cmp(Money{}); got != 1 {
+		t.Errorf("Cmp() = %v, want 1", got)
+	}
+
+	if _, err := usd(2).Cmp(Money{200, "EUR"}); err == nil {
+		t.Errorf("Cmp() error = nil, want currency mismatch")
+	}
+}
+
+func TestMoney_String(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{Money{590, "USD"}, "5.90 USD"},
+		{Money{-5, "USD"}, "-0.05 USD"},
+		{Money{1234, "KWD"}, "1.234 KWD"},
+		{Money{1500, "JPY"}, "1500 JPY"},
+		{Money{math.MinInt64, "USD"}, "-92233720368547758.08 USD"},
+		{Money{}, "0.00"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("String() = %v, want %v", got, tt.want)
+		}
+	}
+}
+
+func TestMoney_JSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    Money
+		wantErr bool
+	}{
+		{name: "decodes legacy numbers", json: `5.90`, want: Money{590, "USD"}},
+		{name: "decodes legacy numbers exactly", json: `999999999999999.999`, want: Money{100000000000000000, "USD"}},
+		{name: "decodes legacy strings", json: `"5.90"`, want: Money{590, "USD"}},
+		{name: "decodes objects", json: `{"amount":"5.900","currency":"KWD"}`, want: Money{5900, "KWD"}},
+		{name: "decodes objects with number amounts", json: `{"amount":5.9,"currency":"EUR"}`, want: Money{590, "EUR"}},
+		{name: "decodes zero without currency", json: `{"amount":"0.00"}`, want: Money{}},
+		{name: "returns error for amount without currency", json: `{"amount":"1.00"}`, wantErr: true},
+		{name: "returns error for invalid strings", json: `"five"`, wantErr: true},
+		{name: "returns error for booleans", json: `true`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Money
+			err := json.Unmarshal([]byte(tt.json), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("Unmarshal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	b, _ := json.Marshal(Money{590, "USD"})
+	if want := `{"amount":"5.90","currency":"USD"}`; string(b) != want {
+		t.Errorf("Marshal() = %s, want %s", b, want)
+	}
+
+	for _, m := range []Money{{590, "USD"}, {-1, "KWD"}, {}} {
+		var got Money
+		b, _ := json.Marshal(m)
+		if err := json.Unmarshal(b, &got); err != nil || got != m {
+			t.Errorf("Unmarshal(Marshal(%v)) = %v, %v", m, got, err)
+		}
+	}
+}
+
+func TestUnmarshalMoney(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    Money
+		wantErr bool
+	}{
+		{name: "decodes numbers in the given currency", json: `5.9`, want: Money{5900, "KWD"}},
+		{name: "decodes strings in the given currency", json: `"5.9"`, want: Money{5900, "KWD"}},
+		{name: "keeps the currency of objects", json: `{"amount":"5.90","currency":"USD"}`, want: Money{590, "USD"}},
+		{name: "returns error for invalid strings", json: `"five"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UnmarshalMoney([]byte(tt.json), "KWD")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnmarshalMoney() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("UnmarshalMoney() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
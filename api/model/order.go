@@ -1,10 +1,12 @@
 package model
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
+	"sync"
 )
 
 // Payment type IDs
@@ -21,30 +23,461 @@ const (
 	ZoneAmerica
 )
 
-// Order holds every detail related to an order
+// Refund statuses of orders
+const (
+	OrderNotRefunded       = "none"    // nothing is refunded
+	OrderPartiallyRefunded = "partial" // a part of the total is refunded
+	OrderRefunded          = "full"    // the total is refunded
+)
+
+// ShippingItem is the item name the shipping fee is refunded under
+const ShippingItem = "shipping"
+
+// LineItem is a product bought in an order
+type LineItem struct {
+	SKU       string `json:"SKU"`
+	Quantity  int    `json:"Quantity"`
+	UnitPrice Money  `json:"UnitPrice"`
+}
+
+// Total returns the unit price multiplied by the quantity
+func (l LineItem) Total() (Money, error) {
+	return l.UnitPrice.Mul(int64(l.Quantity))
+}
+
+// Discount is taken off the total of an order
+type Discount struct {
+	Code   string `json:"Code"`
+	Amount Money  `json:"Amount"`
+}
+
+// Order holds every detail related to an order.
+// Orders with line items have a Total derived from the lines, the shipping fee, the tax
+// and the discounts. Legacy orders without a breakdown only have the Total.
 type Order struct {
-	ID                  int     `json:"ID"`
-	Total               float32 `json:"Total"`
-	PaymentWay          int     `json:"PaymentWay"`
-	ShippingCountryZone int     `json:"CountryZone"`
-	IsDeleted           bool    `json:"IsDeleted"`
+	ID                  int        `json:"ID"`
+	Lines               []LineItem `json:"Lines,omitempty"`
+	ShippingFee         Money      `json:"ShippingFee"`
+	Tax                 Money      `json:"Tax"`
+	Discounts           []Discount `json:"Discounts,omitempty"`
+	Total               Money      `json:"Total"`
+	Refunded            Money      `json:"Refunded"` // refunded so far, partial refunds included
+	PaymentWay          int        `json:"PaymentWay"`
+	PaymentRef          string     `json:"PaymentRef,omitempty"` // reference of the payment at the payment gateway
+	ShippingCountryZone int        `json:"CountryZone"`
+	IsDeleted           bool       `json:"IsDeleted"` // set once the order is refunded in full
+
+	// RefundedLines holds what is refunded so far of each SKU and the shipping fee through line refunds
+	RefundedLines map[string]Money `json:"RefundedLines,omitempty"`
+}
+
+// HasBreakdown reports whether the order has line items, a shipping fee, tax or discounts
+func (ord *Order) HasBreakdown() bool {
+	return len(ord.Lines) > 0 || !ord.ShippingFee.IsZero() || !ord.Tax.IsZero() || len(ord.Discounts) > 0
+}
+
+// ComputeTotal returns the sum of the line items, the shipping fee and the tax minus the discounts.
+// An error is returned if a line or a discount is invalid, the amounts are in different currencies
+// or the discounts are more than the rest of the order.
+func (ord *Order) ComputeTotal() (Money, error) {
+	var total Money
+	seen := make(map[string]bool, len(ord.Lines))
+
+	for i, l := range ord.Lines {
+		switch {
+		case l.SKU == "":
+			return Money{}, fmt.Errorf("SKU of line %d is missing", i+1)
+		case l.SKU == ShippingItem:
+			return Money{}, fmt.Errorf("%s cannot be used as a SKU", ShippingItem)
+		case seen[l.SKU]:
+			return Money{}, fmt.Errorf("SKU %s is given more than once", l.SKU)
+		case l.Quantity <= 0:
+			return Money{}, fmt.Errorf("quantity of %s must be positive", l.SKU)
+		case l.UnitPrice.IsNegative():
+			return Money{}, fmt.Errorf("unit price of %s cannot be negative", l.SKU)
+		}
+		seen[l.SKU] = true
+
+		lineTotal, err := l.Total()
+		if err != nil {
+			return Money{}, fmt.Errorf("invalid total for %s\n%s", l.SKU, err)
+		}
+
+		if total, err = total.Add(lineTotal); err != nil {
+			return Money{}, err
+		}
+	}
+
+	if ord.ShippingFee.IsNegative() {
+		return Money{}, errors.New("shipping fee cannot be negative")
+	}
+
+	if ord.Tax.IsNegative() {
+		return Money{}, errors.New("tax cannot be negative")
+	}
+
+	total, err := total.Add(ord.ShippingFee)
+	if err != nil {
+		return Money{}, err
+	}
+
+	if total, err = total.Add(ord.Tax); err != nil {
+		return Money{}, err
+	}
+
+	for _, d := range ord.Discounts {
+		if d.Amount.Amount <= 0 {
+			return Money{}, fmt.Errorf("discount %s must be positive", d.Code)
+		}
+
+		if total, err = total.Sub(d.Amount); err != nil {
+			return Money{}, err
+		}
+	}
+
+	if total.IsNegative() {
+		return Money{}, errors.New("discounts exceed the total of the order")
+	}
+
+	return total, nil
+}
+
+// DeriveTotal sets the total of the order from its breakdown
+func (ord *Order) DeriveTotal() error {
+	total, err := ord.ComputeTotal()
+	if err != nil {
+		return err
+	}
+
+	ord.Total = total
+	return nil
+}
+
+// CheckTotal returns an error if the breakdown of the order does not add up to its total.
+// Legacy orders without a breakdown are not checked.
+func (ord *Order) CheckTotal() error {
+	if !ord.HasBreakdown() {
+		return nil
+	}
+
+	total, err := ord.ComputeTotal()
+	if err != nil {
+		return err
+	}
+
+	if cmp, err := total.Cmp(ord.Total); err != nil || cmp != 0 {
+		return fmt.Errorf("order total %v does not match the sum of its lines %v", ord.Total, total)
+	}
+
+	return nil
+}
+
+// HasItem reports whether the order has a line with the given SKU, or a shipping fee for ShippingItem
+func (ord *Order) HasItem(item string) bool {
+	if item == ShippingItem {
+		return !ord.ShippingFee.IsZero()
+	}
+
+	for _, l := range ord.Lines {
+		if l.SKU == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LineTotal returns the total of the line with the given SKU, or the shipping fee for ShippingItem.
+// An error wrapping ErrInvalidAmount is returned if the order has no such item.
+func (ord *Order) LineTotal(item string) (Money, error) {
+	if !ord.HasItem(item) {
+		return Money{}, fmt.Errorf("%w: order has no item %s", ErrInvalidAmount, item)
+	}
+
+	if item == ShippingItem {
+		return ord.ShippingFee, nil
+	}
+
+	for _, l := range ord.Lines {
+		if l.SKU == item {
+			return l.Total()
+		}
+	}
+
+	return Money{}, fmt.Errorf("%w: order has no item %s", ErrInvalidAmount, item)
+}
+
+// LineRefundable returns the part of the line with the given SKU, or of the shipping fee for
+// ShippingItem, that has not been refunded through line refunds yet
+func (ord *Order) LineRefundable(item string) (Money, error) {
+	total, err := ord.LineTotal(item)
+	if err != nil {
+		return Money{}, err
+	}
+
+	refunded, ok := ord.RefundedLines[item]
+	if !ok {
+		return total, nil
+	}
+
+	return total.Sub(refunded)
+}
+
+// RefundLines adds the amounts to what is refunded of each line of the order. The refunded total
+// is not changed, call Refund with the sum of the amounts as well.
+// The lines are left unchanged if an amount is not positive or is more than the refundable
+// amount of its line, ErrRefundExceeded is returned for the latter.
+func (ord *Order) RefundLines(amounts map[string]Money) error {
+	refunded := make(map[string]Money, len(ord.RefundedLines)+len(amounts))
+	for item, m := range ord.RefundedLines {
+		refunded[item] = m
+	}
+
+	for item, amount := range amounts {
+		if amount.Amount <= 0 {
+			return fmt.Errorf("%w: refund amount for %s must be positive", ErrInvalidAmount, item)
+		}
+
+		remaining, err := ord.LineRefundable(item)
+		if err != nil {
+			return err
+		}
+
+		cmp, err := amount.Cmp(remaining)
+		if err != nil {
+			return err
+		}
+
+		if cmp > 0 {
+			return fmt.Errorf("%w: %v is more than %v left of %s", ErrRefundExceeded, amount, remaining, item)
+		}
+
+		if refunded[item], err = refunded[item].Add(amount); err != nil {
+			return err
+		}
+	}
+
+	ord.RefundedLines = refunded
+	return nil
+}
+
+// ReverseRefundLines takes the amounts back from what is refunded of each line of the order,
+// e.g. when the payment gateway failed to make a line refund. Amounts are capped at what is
+// refunded of their line.
+func (ord *Order) ReverseRefundLines(amounts map[string]Money) error {
+	refunded := make(map[string]Money, len(ord.RefundedLines))
+	for item, m := range ord.RefundedLines {
+		refunded[item] = m
+	}
+
+	for item, amount := range amounts {
+		m, ok := refunded[item]
+		if !ok {
+			continue
+		}
+
+		left, err := m.Sub(amount)
+		if err != nil {
+			return err
+		}
+
+		if left.Amount <= 0 {
+			delete(refunded, item)
+			continue
+		}
+		refunded[item] = left
+	}
+
+	ord.RefundedLines = nil
+	if len(refunded) > 0 {
+		ord.RefundedLines = refunded
+	}
+
+	return nil
+}
+
+// Refundable returns the part of the total that has not been refunded yet
+func (ord *Order) Refundable() (Money, error) {
+	if ord.IsDeleted {
+		return NewMoney(0, ord.Total.Currency), nil
+	}
+
+	return ord.Total.Sub(ord.Refunded)
+}
+
+// Refund adds the amount to the refunded total of the order. Partial refunds are allowed until
+// the total is exhausted, then the order is marked as deleted.
+// An error is returned if the order is already refunded or the amount is not positive,
+// is in another currency or is more than the refundable amount.
+func (ord *Order) Refund(amount Money) error {
+	if ord.IsDeleted {
+		return ErrAlreadyRefunded
+	}
+
+	if amount.Amount <= 0 {
+		return fmt.Errorf("%w: refund amount must be positive", ErrInvalidAmount)
+	}
+
+	remaining, err := ord.Refundable()
+	if err != nil {
+		return err
+	}
+
+	cmp, err := amount.Cmp(remaining)
+	if err != nil {
+		return err
+	}
+
+	if cmp > 0 {
+		return fmt.Errorf("%w: %v is more than %v", ErrRefundExceeded, amount, remaining)
+	}
+
+	if ord.Refunded, err = ord.Refunded.Add(amount); err != nil {
+		return err
+	}
+
+	ord.IsDeleted = cmp == 0
+
+	return nil
 }
 
-// OrderHandler holds the needed data for every DB operation to run
+// ReverseRefund takes back a part of the refunded total, e.g. when the payment gateway failed
+// to make the refund, so the amount can be refunded again.
+// An error is returned if the amount is not positive or is more than the refunded total.
+func (ord *Order) ReverseRefund(amount Money) error {
+	if amount.Amount <= 0 {
+		return fmt.Errorf("%w: reversed amount must be positive", ErrInvalidAmount)
+	}
+
+	cmp, err := amount.Cmp(ord.Refunded)
+	if err != nil {
+		return err
+	}
+
+	if cmp > 0 {
+		return fmt.Errorf("%w: cannot reverse %v, only %v is refunded", ErrInvalidAmount, amount, ord.Refunded)
+	}
+
+	if ord.Refunded, err = ord.Refunded.Sub(amount); err != nil {
+		return err
+	}
+
+	ord.IsDeleted = false
+
+	return nil
+}
+
+// RefundStatus returns one of the order refund statuses
+func (ord *Order) RefundStatus() string {
+	switch {
+	case ord.IsDeleted:
+		return OrderRefunded
+	case ord.Refunded.Amount > 0:
+		return OrderPartiallyRefunded
+	}
+
+	return OrderNotRefunded
+}
+
+// Validate returns an error wrapping ErrInvalidOrder if the order cannot be saved
+func (ord *Order) Validate() error {
+	if ord.ID <= 0 {
+		return fmt.Errorf("%w: invalid id %d", ErrInvalidOrder, ord.ID)
+	}
+
+	if !knownID(PaymentWayNames, ord.PaymentWay) {
+		return fmt.Errorf("%w: unknown payment way %d", ErrInvalidOrder, ord.PaymentWay)
+	}
+
+	if !knownID(ZoneNames, ord.ShippingCountryZone) {
+		return fmt.Errorf("%w: unknown zone %d", ErrInvalidOrder, ord.ShippingCountryZone)
+	}
+
+	if err := CheckCurrency(ord.Total.Currency); err != nil {
+		return fmt.Errorf("%w: total: %s", ErrInvalidOrder, err)
+	}
+
+	if ord.Total.IsNegative() {
+		return fmt.Errorf("%w: total cannot be negative", ErrInvalidOrder)
+	}
+
+	if err := ord.CheckTotal(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidOrder, err)
+	}
+
+	return nil
+}
+
+func knownID(names map[string]int, id int) bool {
+	for _, v := range names {
+		if v == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OrderFilter picks orders by their attributes, zero fields match every order
+type OrderFilter struct {
+	PaymentWay   int
+	Zone         int
+	RefundStatus string // one of the order refund statuses
+	// MinTotal and MaxTotal bound the total, both inclusive.
+	// Totals in another currency are converted into the currency of the bound.
+	MinTotal *Money
+	MaxTotal *Money
+}
+
+// Match reports whether the order meets every condition of the filter.
+// Rates convert the total for bounds in another currency.
+func (f OrderFilter) Match(ctx context.Context, rates ExchangeRateProvider, ord *Order) (bool, error) {
+	if f.PaymentWay != 0 && ord.PaymentWay != f.PaymentWay {
+		return false, nil
+	}
+
+	if f.Zone != 0 && ord.ShippingCountryZone != f.Zone {
+		return false, nil
+	}
+
+	if f.RefundStatus != "" && ord.RefundStatus() != f.RefundStatus {
+		return false, nil
+	}
+
+	if f.MinTotal != nil {
+		c, err := compareConverted(ctx, rates, ord.Total, *f.MinTotal)
+		if err != nil || c < 0 {
+			return false, err
+		}
+	}
+
+	if f.MaxTotal != nil {
+		c, err := compareConverted(ctx, rates, ord.Total, *f.MaxTotal)
+		if err != nil || c > 0 {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// OrderHandler is the in-memory OrderStore, it is safe for concurrent use
 type OrderHandler struct {
-	Ord *Order
-	db  map[string]*Order
+	mu sync.RWMutex
+	db map[string]*Order
 }
 
 // NewOrderHandler creates and returns OrderHandler struct
 func NewOrderHandler() *OrderHandler {
 	return &OrderHandler{
-		Ord: nil,
-		db:  make(map[string]*Order),
+		db: make(map[string]*Order),
 	}
 }
 
 func (o *OrderHandler) BulkInsert(b []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
 	err := json.Unmarshal(b, &o.db)
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal\n%s", err)
@@ -55,44 +488,122 @@ func (o *OrderHandler) BulkInsert(b []byte) error {
 
 // AddToDB adds the order given to the DB.
 // An error is thrown in the following circumstances:
-//		- the payment way has not been set
-//		- the country zone has not been set
-func (o *OrderHandler) AddToDB() error {
-	if o.Ord.PaymentWay == 0 {
+//   - the payment way has not been set
+//   - the country zone has not been set
+//   - the line items, shipping fee, tax and discounts do not add up to the total
+func (o *OrderHandler) AddToDB(ord *Order) error {
+	if ord.PaymentWay == 0 {
 		return errors.New("payment way is missing")
 	}
 
-	if o.Ord.ShippingCountryZone == 0 {
+	if ord.ShippingCountryZone == 0 {
 		return errors.New("zone is missing")
 	}
 
+	if err := ord.CheckTotal(); err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
 	key := strconv.Itoa(len(o.db) + 1)
-	o.db[key] = o.Ord
+	o.db[key] = ord.copy()
+
+	return nil
+}
+
+// copy returns a copy of the order that shares no lines, discounts or refunded lines with it
+func (ord *Order) copy() *Order {
+	c := *ord
+	if ord.Lines != nil {
+		c.Lines = append([]LineItem(nil), ord.Lines...)
+	}
+	if ord.Discounts != nil {
+		c.Discounts = append([]Discount(nil), ord.Discounts...)
+	}
+	if ord.RefundedLines != nil {
+		c.RefundedLines = make(map[string]Money, len(ord.RefundedLines))
+		for item, m := range ord.RefundedLines {
+			c.RefundedLines[item] = m
+		}
+	}
+
+	return &c
+}
+
+// Get function finds the order from db and returns a copy of it.
+// An error is returned if key does not exist in DB map.
+func (o *OrderHandler) Get(_ context.Context, key string) (*Order, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	ord, ok := o.db[key]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+
+	return ord.copy(), nil
+}
+
+// Put function saves the order under the given key, replacing any existing order.
+func (o *OrderHandler) Put(_ context.Context, key string, ord *Order) error {
+	if ord == nil {
+		return errors.New("order cannot be nil")
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.db[key] = ord.copy()
 
 	return nil
 }
 
-// Find function finds the order from db.
+// Delete function marks the order associated with the key in parameter as deleted.
 // An error is returned if key does not exist in DB map.
-func (o *OrderHandler) Find(key string) error {
+func (o *OrderHandler) Delete(_ context.Context, key string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if ord, ok := o.db[key]; ok {
+		ord.IsDeleted = true
+		return nil
+	}
+
+	return ErrOrderNotFound
+}
+
+// Remove function removes the order associated with the key in parameter from the DB.
+// An error is returned if key does not exist in DB map.
+func (o *OrderHandler) Remove(_ context.Context, key string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
 	if _, ok := o.db[key]; !ok {
-		o.Ord = nil
-		return errors.New("order not found")
+		return ErrOrderNotFound
 	}
 
-	o.Ord = o.db[key]
+	delete(o.db, key)
 
 	return nil
 }
 
-// Delete function removes the order associated with the key in parameter from the DB.
-func (o *OrderHandler) Delete(key string) bool {
-	if _, ok := o.db[key]; ok {
-		ord := o.db[key]
-		ord.IsDeleted = true
+// List function returns a copy of every order ordered by key.
+func (o *OrderHandler) List(_ context.Context) ([]*Order, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
 
-		return true
+	keys := make([]string, 0, len(o.db))
+	for k := range o.db {
+		keys = append(keys, k)
 	}
+	sortKeys(keys)
 
-	return false
+	orders := make([]*Order, 0, len(keys))
+	for _, k := range keys {
+		orders = append(orders, o.db[k].copy())
+	}
+
+	return orders, nil
 }
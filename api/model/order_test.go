@@ -1,6 +1,9 @@
 package model
 
 import (
+	"context"
+	"errors"
+	"math"
 	"reflect"
 	"strconv"
 	"testing"
@@ -24,7 +27,7 @@ func TestOrderHandler_AddToDB(t *testing.T) {
 			fields: fields{
 				Ord: &Order{
 					ID:                  5,
-					Total:               200,
+					Total:               usd(200),
 					PaymentWay:          0,
 					ShippingCountryZone: ZoneEurope,
 					IsDeleted:           false,
@@ -38,7 +41,7 @@ func TestOrderHandler_AddToDB(t *testing.T) {
 			fields: fields{
 				Ord: &Order{
 					ID:                  5,
-					Total:               200,
+					Total:               usd(200),
 					PaymentWay:          CreditCard,
 					ShippingCountryZone: 0,
 					IsDeleted:           false,
@@ -47,12 +50,44 @@ func TestOrderHandler_AddToDB(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "fails when lines do not sum to the total",
+			fields: fields{
+				Ord: &Order{
+					ID:                  5,
+					Lines:               []LineItem{{SKU: "SKU-1", Quantity: 2, UnitPrice: usd(90)}},
+					ShippingFee:         usd(10),
+					Total:               usd(200),
+					PaymentWay:          CreditCard,
+					ShippingCountryZone: ZoneEurope,
+				},
+				db: testDB,
+			},
+			wantErr: true,
+		},
+		{
+			name: "adds order with lines successfully",
+			fields: fields{
+				Ord: &Order{
+					ID:                  5,
+					Lines:               []LineItem{{SKU: "SKU-1", Quantity: 2, UnitPrice: usd(90)}},
+					ShippingFee:         usd(20),
+					Tax:                 usd(10),
+					Discounts:           []Discount{{Code: "WELCOME", Amount: usd(10)}},
+					Total:               usd(200),
+					PaymentWay:          CreditCard,
+					ShippingCountryZone: ZoneEurope,
+				},
+				db: getOrderTestDb(),
+			},
+			wantErr: false,
+		},
 		{
 			name: "adds order successfully",
 			fields: fields{
 				Ord: &Order{
 					ID:                  5,
-					Total:               200,
+					Total:               usd(200),
 					PaymentWay:          CreditCard,
 					ShippingCountryZone: ZoneEurope,
 					IsDeleted:           false,
@@ -65,10 +100,9 @@ func TestOrderHandler_AddToDB(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			o := &OrderHandler{
-				Ord: tt.fields.Ord,
-				db:  tt.fields.db,
+				db: tt.fields.db,
 			}
-			if err := o.AddToDB(); (err != nil) != tt.wantErr {
+			if err := o.AddToDB(tt.fields.Ord); (err != nil) != tt.wantErr {
 				t.Errorf("AddToDB() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
@@ -83,90 +117,64 @@ func TestOrderHandler_AddToDB(t *testing.T) {
 }
 
 func TestOrderHandler_Delete(t *testing.T) {
-	type fields struct {
-		Ord *Order
-		db  map[string]*Order
-	}
-
 	tests := []struct {
-		name   string
-		fields fields
-		key    string
-		want   bool
+		name    string
+		db      map[string]*Order
+		key     string
+		wantErr bool
 	}{
 		{
-			name: "return false when order not found",
-			fields: fields{
-				Ord: &Order{},
-				db:  getOrderTestDb(),
-			},
-			key:  "1000",
-			want: false,
+			name:    "fails when order not found",
+			db:      getOrderTestDb(),
+			key:     "1000",
+			wantErr: true,
 		},
 		{
-			name: "returns true when order is found",
-			fields: fields{
-				Ord: &Order{},
-				db:  getOrderTestDb(),
-			},
-			key:  "1",
-			want: true,
+			name:    "marks order as deleted when found",
+			db:      getOrderTestDb(),
+			key:     "1",
+			wantErr: false,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			o := &OrderHandler{
-				Ord: tt.fields.Ord,
-				db:  tt.fields.db,
+				db: tt.db,
 			}
-			if got := o.Delete(tt.key); got != tt.want {
-				t.Errorf("Delete() = %v, want %v", got, tt.want)
+			if err := o.Delete(context.Background(), tt.key); (err != nil) != tt.wantErr {
+				t.Errorf("Delete() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
-			ord := tt.fields.db[tt.key]
+			ord := tt.db[tt.key]
 
-			if tt.want && !ord.IsDeleted {
+			if !tt.wantErr && !ord.IsDeleted {
 				t.Errorf("Delete failed, want %v, got %v", true, ord.IsDeleted)
 			}
 		})
 	}
 }
 
-func TestOrderHandler_Find(t *testing.T) {
-	type fields struct {
-		Ord *Order
-		db  map[string]*Order
-	}
-
+func TestOrderHandler_Get(t *testing.T) {
 	testDB := getOrderTestDb()
 
 	tests := []struct {
 		name    string
-		fields  fields
 		key     string
 		want    *Order
 		wantErr bool
 	}{
 		{
-			name: "fails when key is not found",
-			fields: fields{
-				Ord: nil,
-				db:  testDB,
-			},
+			name:    "fails when key is not found",
 			key:     "1000",
 			want:    nil,
 			wantErr: true,
 		},
 		{
 			name: "returns order when found",
-			fields: fields{
-				Ord: nil,
-				db:  testDB,
-			},
-			key: "1",
+			key:  "1",
 			want: &Order{
 				ID:                  1,
-				Total:               100,
+				Total:               usd(100),
 				PaymentWay:          CreditCard,
 				ShippingCountryZone: ZoneEurope,
 				IsDeleted:           false,
@@ -177,17 +185,495 @@ func TestOrderHandler_Find(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			o := &OrderHandler{
-				Ord: tt.fields.Ord,
-				db:  tt.fields.db,
+				db: testDB,
 			}
-			err := o.Find(tt.key)
-			got := o.Ord
+			got, err := o.Get(context.Background(), tt.key)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("Find() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("Get() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Find() got = %v, want %v", got, tt.want)
+				t.Errorf("Get() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderHandler_Put(t *testing.T) {
+	o := &OrderHandler{
+		db: getOrderTestDb(),
+	}
+
+	want := &Order{ID: 1, Total: usd(100), PaymentWay: CreditCard, ShippingCountryZone: ZoneEurope, IsDeleted: true}
+	if err := o.Put(context.Background(), "1", want); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	want.Total = Money{}
+	got, _ := o.Get(context.Background(), "1")
+	if got.Total != usd(100) || !got.IsDeleted {
+		t.Errorf("Put() failed. got: %v", got)
+	}
+}
+
+func TestOrderHandler_copiesOrders(t *testing.T) {
+	ctx := context.Background()
+	o := NewOrderHandler()
+
+	ord := &Order{
+		ID:            1,
+		Lines:         []LineItem{{SKU: "SKU-1", Quantity: 2, UnitPrice: usd(40)}},
+		Discounts:     []Discount{{Code: "A", Amount: usd(5)}},
+		RefundedLines: map[string]Money{"SKU-1": usd(10)},
+	}
+	if err := o.Put(ctx, "1", ord); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	ord.Lines[0].Quantity, ord.Discounts[0].Amount, ord.RefundedLines["SKU-1"] = 98, usd(6), usd(20)
+
+	got, _ := o.Get(ctx, "1")
+	got.Lines[0].Quantity, got.Discounts[0].Amount, got.RefundedLines["SKU-1"] = 99, usd(7), usd(30)
+
+	listed, _ := o.List(ctx)
+	listed[0].Lines[0].Quantity = 97
+
+	stored, _ := o.Get(ctx, "1")
+	if stored.Lines[0].Quantity != 2 || stored.Discounts[0].Amount != usd(5) || stored.RefundedLines["SKU-1"] != usd(10) {
+		t.Errorf("changing a copy changed the stored order to %+v", stored)
+	}
+}
+
+func TestOrderHandler_List(t *testing.T) {
+	db := getOrderTestDb()
+	db["10"] = &Order{ID: 10, Total: usd(10), PaymentWay: Paypal, ShippingCountryZone: ZoneEurope}
+
+	o := &OrderHandler{
+		db: db,
+	}
+
+	got, err := o.List(context.Background())
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	ids := make([]int, 0, len(got))
+	for _, ord := range got {
+		ids = append(ids, ord.ID)
+	}
+
+	want := []int{1, 2, 3, 4, 10}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("List() got IDs %v, want %v", ids, want)
+	}
+}
+
+func TestOrder_Refund(t *testing.T) {
+	tests := []struct {
+		name         string
+		order        Order
+		amount       Money
+		wantErr      bool
+		wantRefunded Money
+		wantDeleted  bool
+	}{
+		{
+			name:         "refunds a part of the total",
+			order:        Order{Total: usd(100)},
+			amount:       usd(40),
+			wantRefunded: usd(40),
+		},
+		{
+			name:         "marks the order as deleted when the total is refunded",
+			order:        Order{Total: usd(100), Refunded: usd(60)},
+			amount:       usd(40),
+			wantRefunded: usd(100),
+			wantDeleted:  true,
+		},
+		{
+			name:    "fails when the amount exceeds the refundable amount",
+			order:   Order{Total: usd(100), Refunded: usd(60)},
+			amount:  usd(41),
+			wantErr: true,
+		},
+		{
+			name:    "fails when the amount is not positive",
+			order:   Order{Total: usd(100)},
+			amount:  usd(0),
+			wantErr: true,
+		},
+		{
+			name:    "fails when the amount is in another currency",
+			order:   Order{Total: usd(100)},
+			amount:  NewMoney(100, "EUR"),
+			wantErr: true,
+		},
+		{
+			name:    "fails when the order is already refunded",
+			order:   Order{Total: usd(100), IsDeleted: true},
+			amount:  usd(1),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ord := tt.order
+			err := ord.Refund(tt.amount)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Refund() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				if !reflect.DeepEqual(ord, tt.order) {
+					t.Errorf("Refund() changed the order to %v", ord)
+				}
+				return
+			}
+
+			if ord.Refunded != tt.wantRefunded || ord.IsDeleted != tt.wantDeleted {
+				t.Errorf("Refund() refunded = %v, deleted = %v, want %v, %v", ord.Refunded, ord.IsDeleted, tt.wantRefunded, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestOrder_ReverseRefund(t *testing.T) {
+	tests := []struct {
+		name         string
+		order        Order
+		amount       Money
+		wantErr      bool
+		wantRefunded Money
+	}{
+		{
+			name:         "makes a refunded order refundable again",
+			order:        Order{Total: usd(100), Refunded: usd(100), IsDeleted: true},
+			amount:       usd(40),
+			wantRefunded: usd(60),
+		},
+		{
+			name:    "fails when the amount is more than the refunded total",
+			order:   Order{Total: usd(100), Refunded: usd(30)},
+			amount:  usd(31),
+			wantErr: true,
+		},
+		{
+			name:    "fails when the amount is not positive",
+			order:   Order{Total: usd(100), Refunded: usd(30)},
+			amount:  usd(0),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ord := tt.order
+			err := ord.ReverseRefund(tt.amount)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReverseRefund() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				if !reflect.DeepEqual(ord, tt.order) {
+					t.Errorf("ReverseRefund() changed the order to %v", ord)
+				}
+				return
+			}
+
+			if ord.Refunded != tt.wantRefunded || ord.IsDeleted {
+				t.Errorf("ReverseRefund() refunded = %v, deleted = %v, want %v, false", ord.Refunded, ord.IsDeleted, tt.wantRefunded)
+			}
+		})
+	}
+}
+
+func TestOrder_RefundLines(t *testing.T) {
+	order := func(refunded map[string]Money) Order {
+		return Order{
+			Lines:         []LineItem{{SKU: "SKU-1", Quantity: 2, UnitPrice: usd(40)}},
+			ShippingFee:   usd(15),
+			RefundedLines: refunded,
+		}
+	}
+
+	tests := []struct {
+		name         string
+		order        Order
+		amounts      map[string]Money
+		wantErr      error
+		wantRefunded map[string]Money
+	}{
+		{
+			name:         "adds the amounts to the refunded lines",
+			order:        order(map[string]Money{"SKU-1": usd(30)}),
+			amounts:      map[string]Money{"SKU-1": usd(50), ShippingItem: usd(5)},
+			wantRefunded: map[string]Money{"SKU-1": usd(80), ShippingItem: usd(5)},
+		},
+		{
+			name:    "fails when an amount is more than what is left of its line",
+			order:   order(map[string]Money{ShippingItem: usd(15)}),
+			amounts: map[string]Money{ShippingItem: usd(1)},
+			wantErr: ErrRefundExceeded,
+		},
+		{
+			name:    "fails when the order has no such item",
+			order:   order(nil),
+			amounts: map[string]Money{"SKU-9": usd(1)},
+			wantErr: ErrInvalidAmount,
+		},
+		{
+			name:    "fails when an amount is not positive",
+			order:   order(nil),
+			amounts: map[string]Money{"SKU-1": usd(0)},
+			wantErr: ErrInvalidAmount,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ord := tt.order
+			err := ord.RefundLines(tt.amounts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RefundLines() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				if !reflect.DeepEqual(ord, tt.order) {
+					t.Errorf("RefundLines() changed the order to %v", ord)
+				}
+				return
+			}
+
+			if !reflect.DeepEqual(ord.RefundedLines, tt.wantRefunded) {
+				t.Errorf("RefundLines() refunded lines = %v, want %v", ord.RefundedLines, tt.wantRefunded)
+			}
+		})
+	}
+}
+
+func TestOrder_ReverseRefundLines(t *testing.T) {
+	ord := Order{
+		Lines:         []LineItem{{SKU: "SKU-1", Quantity: 2, UnitPrice: usd(40)}},
+		ShippingFee:   usd(15),
+		RefundedLines: map[string]Money{"SKU-1": usd(30), ShippingItem: usd(15)},
+	}
+
+	if err := ord.ReverseRefundLines(map[string]Money{"SKU-1": usd(10), ShippingItem: usd(15)}); err != nil {
+		t.Fatalf("ReverseRefundLines() error = %v", err)
+	}
+
+	want := map[string]Money{"SKU-1": usd(20)}
+	if !reflect.DeepEqual(ord.RefundedLines, want) {
+		t.Errorf("ReverseRefundLines() refunded lines = %v, want %v", ord.RefundedLines, want)
+	}
+
+	if got, _ := ord.LineRefundable(ShippingItem); got != usd(15) {
+		t.Errorf("LineRefundable() = %v, want %v", got, usd(15))
+	}
+}
+
+func TestOrder_ComputeTotal(t *testing.T) {
+	line := func(sku string, qty int, price Money) LineItem {
+		return LineItem{SKU: sku, Quantity: qty, UnitPrice: price}
+	}
+
+	tests := []struct {
+		name    string
+		order   Order
+		want    Money
+		wantErr bool
+	}{
+		{
+			name: "sums lines, shipping and tax minus discounts",
+			order: Order{
+				Lines:       []LineItem{line("SKU-1", 3, NewMoney(1999, "USD")), line("SKU-2", 1, usd(5))},
+				ShippingFee: NewMoney(499, "USD"),
+				Tax:         NewMoney(650, "USD"),
+				Discounts:   []Discount{{Code: "A", Amount: usd(2)}, {Code: "B", Amount: NewMoney(50, "USD")}},
+			},
+			want: NewMoney(7396, "USD"),
+		},
+		{
+			name:  "returns zero for an order without a breakdown",
+			order: Order{Total: usd(100)},
+			want:  Money{},
+		},
+		{
+			name:    "fails when a SKU is missing",
+			order:   Order{Lines: []LineItem{line("", 1, usd(1))}},
+			wantErr: true,
+		},
+		{
+			name:    "fails when a SKU is repeated",
+			order:   Order{Lines: []LineItem{line("SKU-1", 1, usd(1)), line("SKU-1", 2, usd(1))}},
+			wantErr: true,
+		},
+		{
+			name:    "fails when the quantity is not positive",
+			order:   Order{Lines: []LineItem{line("SKU-1", 0, usd(1))}},
+			wantErr: true,
+		},
+		{
+			name:    "fails when the unit price is negative",
+			order:   Order{Lines: []LineItem{line("SKU-1", 1, usd(-1))}},
+			wantErr: true,
+		},
+		{
+			name:    "fails when the currencies differ",
+			order:   Order{Lines: []LineItem{line("SKU-1", 1, usd(1))}, ShippingFee: NewMoney(100, "EUR")},
+			wantErr: true,
+		},
+		{
+			name:    "fails when the discounts exceed the total",
+			order:   Order{Lines: []LineItem{line("SKU-1", 1, usd(1))}, Discounts: []Discount{{Code: "A", Amount: usd(2)}}},
+			wantErr: true,
+		},
+		{
+			name:    "fails when the line total is out of range",
+			order:   Order{Lines: []LineItem{line("SKU-1", 3, NewMoney(math.MaxInt64/2, "USD"))}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.order.ComputeTotal()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ComputeTotal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("ComputeTotal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrder_DeriveTotal(t *testing.T) {
+	ord := Order{
+		Lines:       []LineItem{{SKU: "SKU-1", Quantity: 2, UnitPrice: usd(40)}},
+		ShippingFee: usd(15),
+		Tax:         usd(5),
+	}
+
+	if err := ord.DeriveTotal(); err != nil || ord.Total != usd(100) {
+		t.Fatalf("DeriveTotal() total = %v, %v, want %v", ord.Total, err, usd(100))
+	}
+
+	if err := ord.CheckTotal(); err != nil {
+		t.Errorf("CheckTotal() error = %v", err)
+	}
+
+	ord.Tax = usd(6)
+	if err := ord.CheckTotal(); err == nil {
+		t.Errorf("CheckTotal() error = nil after the tax changed")
+	}
+}
+
+func TestOrder_HasItem(t *testing.T) {
+	ord := Order{Lines: []LineItem{{SKU: "SKU-1", Quantity: 1, UnitPrice: usd(1)}}}
+
+	if !ord.HasItem("SKU-1") || ord.HasItem("SKU-2") {
+		t.Errorf("HasItem() does not match the SKUs of the order")
+	}
+
+	if ord.HasItem(ShippingItem) {
+		t.Errorf("HasItem(%q) = true for an order without a shipping fee", ShippingItem)
+	}
+
+	ord.ShippingFee = usd(5)
+	if !ord.HasItem(ShippingItem) {
+		t.Errorf("HasItem(%q) = false for an order with a shipping fee", ShippingItem)
+	}
+}
+
+func TestOrder_Refundable(t *testing.T) {
+	ord := Order{Total: usd(100), Refunded: usd(30)}
+	if got, err := ord.Refundable(); err != nil || got != usd(70) {
+		t.Errorf("Refundable() = %v, %v, want %v", got, err, usd(70))
+	}
+
+	ord.IsDeleted = true
+	if got, err := ord.Refundable(); err != nil || !got.IsZero() {
+		t.Errorf("Refundable() of a refunded order = %v, %v, want zero", got, err)
+	}
+}
+
+func TestOrder_RefundStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		ord  Order
+		want string
+	}{
+		{name: "not refunded", ord: Order{Total: usd(100)}, want: OrderNotRefunded},
+		{name: "partially refunded", ord: Order{Total: usd(100), Refunded: usd(30)}, want: OrderPartiallyRefunded},
+		{name: "refunded", ord: Order{Total: usd(100), Refunded: usd(100), IsDeleted: true}, want: OrderRefunded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.ord.RefundStatus(); got != tt.want {
+				t.Errorf("RefundStatus() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrder_Validate(t *testing.T) {
+	valid := Order{ID: 1, Total: usd(100), PaymentWay: CreditCard, ShippingCountryZone: ZoneEurope}
+	tests := []struct {
+		name    string
+		change  func(o *Order)
+		wantErr bool
+	}{
+		{name: "accepts a valid order", change: func(o *Order) {}},
+		{name: "fails without an id", change: func(o *Order) { o.ID = 0 }, wantErr: true},
+		{name: "fails for unknown payment ways", change: func(o *Order) { o.PaymentWay = 9 }, wantErr: true},
+		{name: "fails for unknown zones", change: func(o *Order) { o.ShippingCountryZone = 0 }, wantErr: true},
+		{name: "fails without a currency", change: func(o *Order) { o.Total = Money{Amount: 100} }, wantErr: true},
+		{name: "fails for negative totals", change: func(o *Order) { o.Total = usd(-1) }, wantErr: true},
+		{name: "fails when lines do not sum to the total", change: func(o *Order) {
+			o.Lines = []LineItem{{SKU: "a", Quantity: 1, UnitPrice: usd(10)}}
+		}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := valid
+			tt.change(&o)
+
+			err := o.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr && !errors.Is(err, ErrInvalidOrder) {
+				t.Errorf("Validate() error = %v, want %v", err, ErrInvalidOrder)
+			}
+		})
+	}
+}
+
+func TestOrderFilter_Match(t *testing.T) {
+	ord := &Order{ID: 1, Total: usd(100), Refunded: usd(10), PaymentWay: Paypal, ShippingCountryZone: ZoneMena}
+	aed := func(n int64) *Money { m := NewMoney(n*100, "AED"); return &m }
+	tests := []struct {
+		name   string
+		filter OrderFilter
+		want   bool
+	}{
+		{name: "matches without conditions", want: true},
+		{name: "matches every condition", filter: OrderFilter{PaymentWay: Paypal, Zone: ZoneMena, RefundStatus: OrderPartiallyRefunded}, want: true},
+		{name: "does not match another payment way", filter: OrderFilter{PaymentWay: CreditCard}},
+		{name: "does not match another zone", filter: OrderFilter{Zone: ZoneEurope}},
+		{name: "does not match another refund status", filter: OrderFilter{RefundStatus: OrderNotRefunded}},
+		{name: "matches the total range inclusive", filter: OrderFilter{MinTotal: &Money{Amount: 10000, Currency: "USD"}, MaxTotal: &Money{Amount: 10000, Currency: "USD"}}, want: true},
+		{name: "converts the total into the currency of the bound", filter: OrderFilter{MinTotal: aed(360), MaxTotal: aed(370)}, want: true},
+		{name: "does not match totals below the minimum", filter: OrderFilter{MinTotal: aed(400)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.filter.Match(context.Background(), testRates(t), ord)
+			if err != nil {
+				t.Fatalf("Match() error = %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -197,28 +683,28 @@ func getOrderTestDb() map[string]*Order {
 	return map[string]*Order{
 		"1": &Order{
 			ID:                  1,
-			Total:               100,
+			Total:               usd(100),
 			PaymentWay:          CreditCard,
 			ShippingCountryZone: ZoneEurope,
 			IsDeleted:           false,
 		},
 		"2": &Order{
 			ID:                  2,
-			Total:               200,
+			Total:               usd(200),
 			PaymentWay:          CashOnDelivery,
 			ShippingCountryZone: ZoneAmerica,
 			IsDeleted:           true,
 		},
 		"3": &Order{
 			ID:                  3,
-			Total:               300,
+			Total:               usd(300),
 			PaymentWay:          Paypal,
 			ShippingCountryZone: ZoneMena,
 			IsDeleted:           false,
 		},
 		"4": &Order{
 			ID:                  4,
-			Total:               400,
+			Total:               usd(400),
 			PaymentWay:          CashOnDelivery,
 			ShippingCountryZone: ZoneMena,
 			IsDeleted:           false,
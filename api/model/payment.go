@@ -0,0 +1,226 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Statuses of refunds made through a payment gateway
+const (
+	PaymentRefundPending   = "pending"   // the gateway has not confirmed the refund yet
+	PaymentRefundSucceeded = "succeeded" // the money is back on the payment method
+	PaymentRefundFailed    = "failed"    // the gateway could not refund the payment
+)
+
+// PaymentGateway refunds payments made with a payment method such as a credit card
+type PaymentGateway interface {
+	// Refund asks the gateway to refund the amount to the payment and returns the reference
+	// of the refund at the gateway. Refunds are confirmed later, see RefundStatus.
+	// refundID identifies the refund, asking again with the same ID returns the reference of
+	// the first refund instead of refunding twice.
+	// ErrPaymentDeclined is returned if the gateway refuses the refund right away.
+	Refund(ctx context.Context, refundID, paymentRef string, amount Money) (string, error)
+	// RefundStatus returns one of the PaymentRefund statuses of the refund with the given reference
+	RefundStatus(ctx context.Context, refundRef string) (string, error)
+}
+
+// PaymentRefund tracks a refund made through a payment gateway until the gateway confirms it
+type PaymentRefund struct {
+	ID         string           `json:"id"` // ID of the refund, also used by its ledger entries
+	OrderID    string           `json:"order_id"`
+	UserKey    string           `json:"user_key"`
+	PaymentWay int              `json:"payment_way"`
+	PaymentRef string           `json:"payment_ref"` // reference of the payment at the gateway
+	GatewayRef string           `json:"gateway_ref"` // reference of the refund at the gateway
+	Amount     Money            `json:"amount"`
+	Lines      map[string]Money `json:"lines,omitempty"` // refunded amount of each line, line refunds only
+	Status     string           `json:"status"`
+	CreatedAt  time.Time        `json:"created_at"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+}
+
+// PaymentRefundStore persists payment refunds keyed by their ID.
+// Get returns a copy of the stored refund, changes are saved with Put.
+type PaymentRefundStore interface {
+	Get(ctx context.Context, id string) (*PaymentRefund, error)
+	Put(ctx context.Context, id string, r *PaymentRefund) error
+	Delete(ctx context.Context, id string) error
+	// List returns every refund ordered by ID
+	List(ctx context.Context) ([]*PaymentRefund, error)
+}
+
+// PaymentRefunds is the in-memory PaymentRefundStore, it is safe for concurrent use
+type PaymentRefunds struct {
+	mu sync.RWMutex
+	db map[string]*PaymentRefund
+}
+
+// NewPaymentRefunds creates and returns an empty PaymentRefunds
+func NewPaymentRefunds() *PaymentRefunds {
+	return &PaymentRefunds{db: map[string]*PaymentRefund{}}
+}
+
+// Get returns a copy of the refund with the given ID.
+// ErrRefundNotFound is returned if there is no such refund.
+func (p *PaymentRefunds) Get(_ context.Context, id string) (*PaymentRefund, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	r, ok := p.db[id]
+	if !ok {
+		return nil, ErrRefundNotFound
+	}
+
+	c := *r
+	return &c, nil
+}
+
+// Put saves the refund under the ID, replacing any existing refund
+func (p *PaymentRefunds) Put(_ context.Context, id string, r *PaymentRefund) error {
+	if r == nil {
+		return errors.New("payment refund cannot be nil")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c := *r
+	p.db[id] = &c
+
+	return nil
+}
+
+// Delete removes the refund with the given ID
+func (p *PaymentRefunds) Delete(_ context.Context, id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.db[id]; !ok {
+		return ErrRefundNotFound
+	}
+
+	delete(p.db, id)
+
+	return nil
+}
+
+// List returns copies of every refund ordered by ID
+func (p *PaymentRefunds) List(_ context.Context) ([]*PaymentRefund, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	ids := make([]string, 0, len(p.db))
+	for id := range p.db {
+		ids = append(ids, id)
+	}
+	sortKeys(ids)
+
+	refunds := make([]*PaymentRefund, 0, len(ids))
+	for _, id := range ids {
+		c := *p.db[id]
+		refunds = append(refunds, &c)
+	}
+
+	return refunds, nil
+}
+
+// FakeGateway is a PaymentGateway for local testing, no money is moved. Refunds are confirmed
+// once confirmAfter has passed. Payments with "decline" in their reference are declined right
+// away and refunds of payments with "fail" in their reference fail when they are confirmed.
+// It is safe for concurrent use.
+type FakeGateway struct {
+	name         string
+	refPrefix    string // prefix of the references of the refunds
+	confirmAfter time.Duration
+
+	mu      sync.Mutex
+	refunds map[string]fakeRefund
+	refs    map[string]string // references of the refunds by their ID
+}
+
+type fakeRefund struct {
+	paymentRef string
+	amount     Money
+	madeAt     time.Time
+}
+
+// NewFakeCardGateway returns a FakeGateway for credit card payments
+func NewFakeCardGateway(confirmAfter time.Duration) *FakeGateway {
+	return newFakeGateway("fake card gateway", "re_", confirmAfter)
+}
+
+// NewFakePaypalGateway returns a FakeGateway for PayPal payments
+func NewFakePaypalGateway(confirmAfter time.Duration) *FakeGateway {
+	return newFakeGateway("fake paypal gateway", "PAYPAL-R-", confirmAfter)
+}
+
+func newFakeGateway(name, refPrefix string, confirmAfter time.Duration) *FakeGateway {
+	return &FakeGateway{name: name, refPrefix: refPrefix, confirmAfter: confirmAfter, refunds: map[string]fakeRefund{}, refs: map[string]string{}}
+}
+
+// Refund records the refund and returns its reference
+func (g *FakeGateway) Refund(_ context.Context, refundID, paymentRef string, amount Money) (string, error) {
+	if strings.TrimSpace(paymentRef) == "" {
+		return "", fmt.Errorf("%s: payment reference is missing", g.name)
+	}
+
+	if amount.Amount <= 0 {
+		return "", fmt.Errorf("%w: %s: refund amount must be positive", ErrInvalidAmount, g.name)
+	}
+
+	if strings.Contains(paymentRef, "decline") {
+		return "", fmt.Errorf("%w: %s: payment %s", ErrPaymentDeclined, g.name, paymentRef)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if ref, ok := g.refs[refundID]; ok {
+		return ref, nil
+	}
+
+	// references are random so they do not repeat after a restart
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("%s: cannot generate refund reference\n%s", g.name, err)
+	}
+
+	ref := g.refPrefix + hex.EncodeToString(b)
+	g.refunds[ref] = fakeRefund{paymentRef: paymentRef, amount: amount, madeAt: time.Now()}
+	if refundID != "" {
+		g.refs[refundID] = ref
+	}
+
+	return ref, nil
+}
+
+// RefundStatus returns PaymentRefundPending until confirmAfter has passed since the refund was made
+func (g *FakeGateway) RefundStatus(_ context.Context, refundRef string) (string, error) {
+	g.mu.Lock()
+	r, ok := g.refunds[refundRef]
+	g.mu.Unlock()
+
+	if !ok {
+		return "", fmt.Errorf("%s: unknown refund %s", g.name, refundRef)
+	}
+
+	switch {
+	case time.Since(r.madeAt) < g.confirmAfter:
+		return PaymentRefundPending, nil
+	case strings.Contains(r.paymentRef, "fail"):
+		return PaymentRefundFailed, nil
+	}
+
+	return PaymentRefundSucceeded, nil
+}
+
+var (
+	_ PaymentGateway     = (*FakeGateway)(nil)
+	_ PaymentRefundStore = (*PaymentRefunds)(nil)
+)
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFakeGateway(t *testing.T) {
+	tests := []struct {
+		name       string
+		paymentRef string
+		wantErr    error
+		wantStatus string
+	}{
+		{name: "confirms refunds", paymentRef: "card_0001", wantStatus: PaymentRefundSucceeded},
+		{name: "fails refunds of failing payments", paymentRef: "card_fail", wantStatus: PaymentRefundFailed},
+		{name: "declines refunds of declined payments", paymentRef: "card_decline", wantErr: ErrPaymentDeclined},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			g := NewFakeCardGateway(0)
+
+			ref, err := g.Refund(ctx, "rf_1", tt.paymentRef, usd(10))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Refund() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				return
+			}
+
+			if got, err := g.RefundStatus(ctx, ref); got != tt.wantStatus || err != nil {
+				t.Errorf("RefundStatus() = %v, %v, want %v", got, err, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestFakeGateway_pendingUntilConfirmed(t *testing.T) {
+	ctx := context.Background()
+	g := NewFakePaypalGateway(time.Hour)
+
+	ref, err := g.Refund(ctx, "rf_1", "PAYID-0001", usd(10))
+	if err != nil {
+		t.Fatalf("Refund() error = %v", err)
+	}
+
+	if again, _ := g.Refund(ctx, "rf_1", "PAYID-0001", usd(10)); again != ref {
+		t.Errorf("Refund() again with the same ID = %v, want %v", again, ref)
+	}
+
+	if got, _ := g.RefundStatus(ctx, ref); got != PaymentRefundPending {
+		t.Errorf("RefundStatus() = %v, want %v", got, PaymentRefundPending)
+	}
+
+	if other, _ := NewFakePaypalGateway(time.Hour).Refund(ctx, "rf_1", "PAYID-0001", usd(10)); other == ref {
+		t.Errorf("Refund() of another gateway returned the same reference %v", ref)
+	}
+
+	if _, err = g.RefundStatus(ctx, "PAYPAL-R-999999"); err == nil {
+		t.Errorf("RefundStatus() of an unknown refund error = nil")
+	}
+
+	if _, err = g.Refund(ctx, "rf_2", "", usd(10)); err == nil {
+		t.Errorf("Refund() without a payment reference error = nil")
+	}
+}
+
+func TestPaymentRefunds(t *testing.T) {
+	ctx := context.Background()
+	p := NewPaymentRefunds()
+
+	if _, err := p.Get(ctx, "rf_1"); !errors.Is(err, ErrRefundNotFound) {
+		t.Errorf("Get() error = %v, want %v", err, ErrRefundNotFound)
+	}
+
+	r := &PaymentRefund{ID: "rf_1", OrderID: "1", Amount: usd(10), Status: PaymentRefundPending}
+	if err := p.Put(ctx, r.ID, r); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	r.Status = PaymentRefundFailed
+
+	got, err := p.Get(ctx, "rf_1")
+	if err != nil || got.Status != PaymentRefundPending {
+		t.Errorf("Get() = %v, %v, want the refund as it was put", got, err)
+	}
+
+	if list, _ := p.List(ctx); len(list) != 1 {
+		t.Errorf("List() = %v, want one refund", list)
+	}
+
+	if err = p.Delete(ctx, "rf_1"); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+
+	if err = p.Delete(ctx, "rf_1"); !errors.Is(err, ErrRefundNotFound) {
+		t.Errorf("Delete() of a deleted refund error = %v, want %v", err, ErrRefundNotFound)
+	}
+}
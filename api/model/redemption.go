@@ -0,0 +1,136 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Statuses of voucher redemptions
+const (
+	RedemptionRedeemed = "redeemed" // the amount is taken off the voucher account
+	RedemptionReversed = "reversed" // the checkout was cancelled and the amount is back on the account
+)
+
+// Redemption records an amount spent from a voucher account at checkout
+type Redemption struct {
+	ID          string     `json:"id"` // ID of the redemption, also used by its ledger entries
+	VoucherKey  string     `json:"voucher_key"`
+	UserKey     string     `json:"user_key"`
+	Amount      Money      `json:"amount"`
+	CheckoutRef string     `json:"checkout_ref,omitempty"` // reference of the checkout the voucher was spent on
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ReversedAt  *time.Time `json:"reversed_at,omitempty"`
+
+	// Lots are the parts of the voucher account the redemption took, restored when it is reversed
+	Lots []CreditLot `json:"lots,omitempty"`
+}
+
+// Reverse marks the redemption as reversed.
+// ErrRedemptionReversed is returned if it is already reversed.
+func (r *Redemption) Reverse(at time.Time) error {
+	if r.Status == RedemptionReversed {
+		return ErrRedemptionReversed
+	}
+
+	r.Status, r.ReversedAt = RedemptionReversed, &at
+	return nil
+}
+
+// RedemptionStore persists voucher redemptions keyed by their ID.
+// Get returns a copy of the stored redemption, changes are saved with Put.
+type RedemptionStore interface {
+	Get(ctx context.Context, id string) (*Redemption, error)
+	Put(ctx context.Context, id string, r *Redemption) error
+	Delete(ctx context.Context, id string) error
+	// List returns every redemption ordered by ID
+	List(ctx context.Context) ([]*Redemption, error)
+}
+
+// Redemptions is the in-memory RedemptionStore, it is safe for concurrent use
+type Redemptions struct {
+	mu sync.RWMutex
+	db map[string]*Redemption
+}
+
+// NewRedemptions creates and returns an empty Redemptions
+func NewRedemptions() *Redemptions {
+	return &Redemptions{db: map[string]*Redemption{}}
+}
+
+// Get returns a copy of the redemption with the given ID.
+// ErrRedemptionNotFound is returned if there is no such redemption.
+func (s *Redemptions) Get(_ context.Context, id string) (*Redemption, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.db[id]
+	if !ok {
+		return nil, ErrRedemptionNotFound
+	}
+
+	return r.copy(), nil
+}
+
+// Put saves the redemption under the ID, replacing any existing redemption
+func (s *Redemptions) Put(_ context.Context, id string, r *Redemption) error {
+	if r == nil {
+		return errors.New("redemption cannot be nil")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.db[id] = r.copy()
+
+	return nil
+}
+
+// Delete removes the redemption with the given ID
+func (s *Redemptions) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.db[id]; !ok {
+		return ErrRedemptionNotFound
+	}
+
+	delete(s.db, id)
+
+	return nil
+}
+
+// List returns copies of every redemption ordered by ID
+func (s *Redemptions) List(_ context.Context) ([]*Redemption, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.db))
+	for id := range s.db {
+		ids = append(ids, id)
+	}
+	sortKeys(ids)
+
+	redemptions := make([]*Redemption, 0, len(ids))
+	for _, id := range ids {
+		redemptions = append(redemptions, s.db[id].copy())
+	}
+
+	return redemptions, nil
+}
+
+// copy returns a copy of the redemption that shares no memory with it
+func (r *Redemption) copy() *Redemption {
+	c := *r
+	if r.ReversedAt != nil {
+		t := *r.ReversedAt
+		c.ReversedAt = &t
+	}
+	c.Lots = copyLots(r.Lots)
+
+	return &c
+}
+
+var _ RedemptionStore = (*Redemptions)(nil)
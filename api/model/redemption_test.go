@@ -0,0 +1,53 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRedemption_Reverse(t *testing.T) {
+	r := &Redemption{ID: "rd_1", Amount: usd(10), Status: RedemptionRedeemed}
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if err := r.Reverse(at); err != nil || r.Status != RedemptionReversed || !r.ReversedAt.Equal(at) {
+		t.Fatalf("Reverse() = %+v, %v, want a reversed redemption", r, err)
+	}
+
+	if err := r.Reverse(at.Add(time.Hour)); !errors.Is(err, ErrRedemptionReversed) || !r.ReversedAt.Equal(at) {
+		t.Errorf("Reverse() of a reversed redemption error = %v, want %v", err, ErrRedemptionReversed)
+	}
+}
+
+func TestRedemptions(t *testing.T) {
+	ctx := context.Background()
+	s := NewRedemptions()
+
+	if _, err := s.Get(ctx, "rd_1"); !errors.Is(err, ErrRedemptionNotFound) {
+		t.Errorf("Get() error = %v, want %v", err, ErrRedemptionNotFound)
+	}
+
+	r := &Redemption{ID: "rd_1", VoucherKey: "john-doe-usd", Amount: usd(10), Status: RedemptionRedeemed}
+	if err := s.Put(ctx, r.ID, r); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	r.Reverse(time.Now())
+
+	got, err := s.Get(ctx, "rd_1")
+	if err != nil || got.Status != RedemptionRedeemed || got.ReversedAt != nil {
+		t.Errorf("Get() = %v, %v, want the redemption as it was put", got, err)
+	}
+
+	if list, _ := s.List(ctx); len(list) != 1 {
+		t.Errorf("List() = %v, want one redemption", list)
+	}
+
+	if err = s.Delete(ctx, "rd_1"); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+
+	if err = s.Delete(ctx, "rd_1"); !errors.Is(err, ErrRedemptionNotFound) {
+		t.Errorf("Delete() of a deleted redemption error = %v, want %v", err, ErrRedemptionNotFound)
+	}
+}
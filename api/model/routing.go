@@ -0,0 +1,370 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Destinations a refund can be sent to
+const (
+	DestinationBalance         = "balance"          // the balance of the user
+	DestinationVoucher         = "voucher"          // a voucher account of the user
+	DestinationOriginalPayment = "original_payment" // the payment method the order was paid with
+)
+
+// ZoneNames maps the names used in refund rules to zone IDs
+var ZoneNames = map[string]int{
+	"europe":  ZoneEurope,
+	"mena":    ZoneMena,
+	"america": ZoneAmerica,
+}
+
+// PaymentWayNames maps the names used in refund rules to payment type IDs
+var PaymentWayNames = map[string]int{
+	"credit_card":      CreditCard,
+	"cash_on_delivery": CashOnDelivery,
+	"paypal":           Paypal,
+}
+
+// RefundRule sends the refunds matching every one of its conditions to its destination.
+// Empty conditions match every refund.
+type RefundRule struct {
+	Name        string   `json:"name"`
+	Zones       []string `json:"zones,omitempty"`        // names in ZoneNames
+	PaymentWays []string `json:"payment_ways,omitempty"` // names in PaymentWayNames
+	// MinAmount and MaxAmount bound the refunded amount, both inclusive.
+	// Refunds in another currency are converted into the currency of the bound.
+	MinAmount *Money `json:"min_amount,omitempty"`
+	MaxAmount *Money `json:"max_amount,omitempty"`
+
+	UserKeys          []string `json:"user_keys,omitempty"`
+	BalanceCurrencies []string `json:"balance_currencies,omitempty"` // currency of the balance of the user
+	VoucherCurrencies []string `json:"voucher_currencies,omitempty"` // voucher currency preferred by the user
+	MinOrders         int      `json:"min_orders,omitempty"`         // number of orders of the user
+
+	Destination string `json:"destination"`
+
+	zones, paymentWays map[int]bool
+}
+
+// RefundRules picks the destination of refunds. Rules are tried in order, the first
+// matching rule wins. Refunds matching no rule are sent to Default.
+//
+//	{"default": "balance", "rules": [{"name": "mena-cod", "zones": ["mena"], "payment_ways": ["cash_on_delivery"], "destination": "voucher"}]}
+type RefundRules struct {
+	Default string       `json:"default"`
+	Rules   []RefundRule `json:"rules"`
+}
+
+// RefundFacts are what refund rules are matched against
+type RefundFacts struct {
+	Zone       int
+	PaymentWay int
+	Amount     Money // refunded amount, in the currency of the order
+	UserKey    string
+	User       *User // may be nil for evaluations without a user
+}
+
+// RefundRoute is the destination picked for a refund
+type RefundRoute struct {
+	Destination string `json:"destination"`
+	Rule        string `json:"rule,omitempty"` // name of the matching rule, empty for the default
+}
+
+// RefundRuleSource provides the refund rules in use
+type RefundRuleSource interface {
+	RefundRules() *RefundRules
+}
+
+// DefaultRefundRules returns the rules used without a rules file: cash-on-delivery orders
+// from MENA are refunded to a voucher account, every other order to the balance of the user.
+func DefaultRefundRules() *RefundRules {
+	rules, _ := NewRefundRules(DestinationBalance, []RefundRule{{
+		Name:        "mena-cash-on-delivery",
+		Zones:       []string{"mena"},
+		PaymentWays: []string{"cash_on_delivery"},
+		Destination: DestinationVoucher,
+	}})
+
+	return rules
+}
+
+// NewRefundRules checks the rules and returns them as RefundRules
+func NewRefundRules(defaultDestination string, rules []RefundRule) (*RefundRules, error) {
+	rs := &RefundRules{Default: defaultDestination, Rules: rules}
+	if err := rs.compile(); err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+// ParseRefundRules decodes the JSON form of RefundRules and checks the rules
+func ParseRefundRules(b []byte) (*RefundRules, error) {
+	var rs RefundRules
+	if err := json.Unmarshal(b, &rs); err != nil {
+		return nil, err
+	}
+
+	return NewRefundRules(rs.Default, rs.Rules)
+}
+
+// LoadRefundRules reads the rules from a JSON file
+func LoadRefundRules(path string) (*RefundRules, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read refund rules\n%s", err)
+	}
+
+	rs, err := ParseRefundRules(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid refund rules in %s\n%s", path, err)
+	}
+
+	return rs, nil
+}
+
+// compile checks the rules and resolves the names of zones and payment ways
+func (rs *RefundRules) compile() error {
+	if err := checkDestination(rs.Default); err != nil {
+		return fmt.Errorf("default: %s", err)
+	}
+
+	for i := range rs.Rules {
+		r := &rs.Rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+
+		if err := r.compile(); err != nil {
+			return fmt.Errorf("rule %s: %s", r.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *RefundRule) compile() error {
+	if err := checkDestination(r.Destination); err != nil {
+		return err
+	}
+
+	var err error
+	if r.zones, err = resolveNames("zone", r.Zones, ZoneNames); err != nil {
+		return err
+	}
+
+	if r.paymentWays, err = resolveNames("payment way", r.PaymentWays, PaymentWayNames); err != nil {
+		return err
+	}
+
+	if r.MinAmount != nil && r.MaxAmount != nil && r.MinAmount.Currency == r.MaxAmount.Currency && r.MinAmount.Amount > r.MaxAmount.Amount {
+		return fmt.Errorf("min_amount %v is more than max_amount %v", r.MinAmount, r.MaxAmount)
+	}
+
+	if r.MinOrders < 0 {
+		return errors.New("min_orders cannot be negative")
+	}
+
+	return nil
+}
+
+func checkDestination(d string) error {
+	switch d {
+	case DestinationBalance, DestinationVoucher, DestinationOriginalPayment:
+		return nil
+	case "":
+		return errors.New("destination is missing")
+	}
+
+	return fmt.Errorf("unknown destination: %s", d)
+}
+
+// resolveNames returns the IDs of the names, nil if there are no names
+func resolveNames(kind string, names []string, ids map[string]int) (map[int]bool, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	resolved := make(map[int]bool, len(names))
+	for _, name := range names {
+		id, ok := ids[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown %s: %s", kind, name)
+		}
+
+		resolved[id] = true
+	}
+
+	return resolved, nil
+}
+
+// RefundRules returns the rules themselves, so RefundRules can be used as a RefundRuleSource
+func (rs *RefundRules) RefundRules() *RefundRules {
+	return rs
+}
+
+// Route returns the destination of the refund. Rates convert the refunded amount
+// for amount bounds in another currency.
+func (rs *RefundRules) Route(ctx context.Context, rates ExchangeRateProvider, f RefundFacts) (RefundRoute, error) {
+	for i := range rs.Rules {
+		ok, err := rs.Rules[i].matches(ctx, rates, f)
+		if err != nil {
+			return RefundRoute{}, fmt.Errorf("rule %s: %w", rs.Rules[i].Name, err)
+		}
+
+		if ok {
+			return RefundRoute{Destination: rs.Rules[i].Destination, Rule: rs.Rules[i].Name}, nil
+		}
+	}
+
+	return RefundRoute{Destination: rs.Default}, nil
+}
+
+// matches reports whether the refund meets every condition of the rule
+func (r *RefundRule) matches(ctx context.Context, rates ExchangeRateProvider, f RefundFacts) (bool, error) {
+	if r.zones != nil && !r.zones[f.Zone] {
+		return false, nil
+	}
+
+	if r.paymentWays != nil && !r.paymentWays[f.PaymentWay] {
+		return false, nil
+	}
+
+	if len(r.UserKeys) > 0 && !containsFold(r.UserKeys, f.UserKey) {
+		return false, nil
+	}
+
+	if len(r.BalanceCurrencies) > 0 && (f.User == nil || !containsFold(r.BalanceCurrencies, f.User.Balance.Currency)) {
+		return false, nil
+	}
+
+	if len(r.VoucherCurrencies) > 0 && (f.User == nil || !containsFold(r.VoucherCurrencies, f.User.VoucherCurrency)) {
+		return false, nil
+	}
+
+	if r.MinOrders > 0 && (f.User == nil || len(f.User.Orders) < r.MinOrders) {
+		return false, nil
+	}
+
+	if r.MinAmount != nil {
+		c, err := compareConverted(ctx, rates, f.Amount, *r.MinAmount)
+		if err != nil || c < 0 {
+			return false, err
+		}
+	}
+
+	if r.MaxAmount != nil {
+		c, err := compareConverted(ctx, rates, f.Amount, *r.MaxAmount)
+		if err != nil || c > 0 {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// compareConverted compares the amount with the bound after converting it into the currency of the bound
+func compareConverted(ctx context.Context, rates ExchangeRateProvider, amount, bound Money) (int, error) {
+	converted, err := Convert(ctx, rates, amount, bound.Currency)
+	if err != nil {
+		return 0, err
+	}
+
+	return converted.Cmp(bound)
+}
+
+func containsFold(values []string, v string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RefundRulesFile is a RefundRuleSource that reloads the rules when their file changes.
+// It is safe for concurrent use.
+type RefundRulesFile struct {
+	path string
+
+	mu      sync.RWMutex
+	rules   *RefundRules
+	modTime time.Time
+	size    int64
+}
+
+// OpenRefundRulesFile loads the rules of the file, they are reloaded by Reload and Watch
+func OpenRefundRulesFile(path string) (*RefundRulesFile, error) {
+	f := &RefundRulesFile{path: path}
+	if _, err := f.Reload(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// RefundRules returns the rules last loaded from the file
+func (f *RefundRulesFile) RefundRules() *RefundRules {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.rules
+}
+
+// Reload loads the rules again if the file changed since they were last loaded and reports
+// whether they were. Invalid rules are not loaded, the rules loaded before stay in use.
+func (f *RefundRulesFile) Reload() (bool, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false, fmt.Errorf("cannot read refund rules\n%s", err)
+	}
+
+	f.mu.RLock()
+	unchanged := f.rules != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size
+	f.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	rules, err := LoadRefundRules(f.path)
+	if err != nil {
+		return false, err
+	}
+
+	f.mu.Lock()
+	f.rules, f.modTime, f.size = rules, info.ModTime(), info.Size()
+	f.mu.Unlock()
+
+	return true, nil
+}
+
+// Watch reloads the rules every interval until ctx is done. Errors of Reload are passed to onError.
+func (f *RefundRulesFile) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := f.Reload(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+var (
+	_ RefundRuleSource = (*RefundRules)(nil)
+	_ RefundRuleSource = (*RefundRulesFile)(nil)
+)
@@ -0,0 +1,217 @@
+package model
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRefundRules_Route(t *testing.T) {
+	rules, err := ParseRefundRules([]byte(`{
+		"default": "balance",
+		"rules": [
+			{"name": "vip", "user_keys": ["jane-doe"], "destination": "original_payment"},
+			{"name": "large-card", "payment_ways": ["credit_card"], "min_amount": {"amount": "500", "currency": "USD"}, "destination": "original_payment"},
+			{"name": "aed-small", "max_amount": {"amount": "10", "currency": "AED"}, "destination": "voucher"},
+			{"name": "loyal", "min_orders": 3, "voucher_currencies": ["aed"], "destination": "voucher"},
+			{"zones": ["MENA"], "payment_ways": ["cash_on_delivery"], "destination": "voucher"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("ParseRefundRules() error = %v", err)
+	}
+
+	rates := testRates(t)
+	tests := []struct {
+		name  string
+		facts RefundFacts
+		want  RefundRoute
+	}{
+		{
+			name:  "falls back to the default",
+			facts: RefundFacts{Zone: ZoneEurope, PaymentWay: CreditCard, Amount: usd(100), UserKey: "john-doe"},
+			want:  RefundRoute{Destination: DestinationBalance},
+		},
+		{
+			name:  "matches the user key",
+			facts: RefundFacts{Zone: ZoneEurope, PaymentWay: CashOnDelivery, Amount: usd(100), UserKey: "jane-doe"},
+			want:  RefundRoute{Destination: DestinationOriginalPayment, Rule: "vip"},
+		},
+		{
+			name:  "matches the minimum amount inclusive",
+			facts: RefundFacts{Zone: ZoneEurope, PaymentWay: CreditCard, Amount: usd(500)},
+			want:  RefundRoute{Destination: DestinationOriginalPayment, Rule: "large-card"},
+		},
+		{
+			name:  "converts the amount into the currency of the bound",
+			facts: RefundFacts{Zone: ZoneEurope, PaymentWay: Paypal, Amount: usd(2)},
+			want:  RefundRoute{Destination: DestinationVoucher, Rule: "aed-small"},
+		},
+		{
+			name:  "matches user attributes",
+			facts: RefundFacts{Zone: ZoneEurope, PaymentWay: Paypal, Amount: usd(100), User: &User{Orders: []int{1, 2, 3}, VoucherCurrency: "AED"}},
+			want:  RefundRoute{Destination: DestinationVoucher, Rule: "loyal"},
+		},
+		{
+			name:  "does not match user attributes without a user",
+			facts: RefundFacts{Zone: ZoneEurope, PaymentWay: Paypal, Amount: usd(100)},
+			want:  RefundRoute{Destination: DestinationBalance},
+		},
+		{
+			name:  "names rules without a name by their position",
+			facts: RefundFacts{Zone: ZoneMena, PaymentWay: CashOnDelivery, Amount: usd(100)},
+			want:  RefundRoute{Destination: DestinationVoucher, Rule: "rule-5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rules.Route(context.Background(), rates, tt.facts)
+			if err != nil {
+				t.Fatalf("Route() error = %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("Route() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefundRules_Route_unknownRate(t *testing.T) {
+	rules, _ := NewRefundRules(DestinationBalance, []RefundRule{{MinAmount: &Money{Amount: 100, Currency: "EGP"}, Destination: DestinationVoucher}})
+
+	if _, err := rules.Route(context.Background(), testRates(t), RefundFacts{Amount: usd(1)}); err == nil {
+		t.Error("Route() error = nil, want missing exchange rate error")
+	}
+}
+
+func TestParseRefundRules(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{name: "fails for invalid JSON", json: `{"default": `},
+		{name: "fails without a default", json: `{"rules": []}`},
+		{name: "fails for unknown destinations", json: `{"default": "bank"}`},
+		{name: "fails for rules without a destination", json: `{"default": "balance", "rules": [{"name": "x"}]}`},
+		{name: "fails for unknown zones", json: `{"default": "balance", "rules": [{"zones": ["asia"], "destination": "voucher"}]}`},
+		{name: "fails for unknown payment ways", json: `{"default": "balance", "rules": [{"payment_ways": ["cheque"], "destination": "voucher"}]}`},
+		{name: "fails for inverted amount bounds", json: `{"default": "balance", "rules": [{"min_amount": "20", "max_amount": "10", "destination": "voucher"}]}`},
+		{name: "fails for negative order counts", json: `{"default": "balance", "rules": [{"min_orders": -1, "destination": "voucher"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseRefundRules([]byte(tt.json)); err == nil {
+				t.Error("ParseRefundRules() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestDefaultRefundRules(t *testing.T) {
+	rules := DefaultRefundRules()
+	ctx := context.Background()
+
+	got, _ := rules.Route(ctx, nil, RefundFacts{Zone: ZoneMena, PaymentWay: CashOnDelivery, Amount: usd(1)})
+	if got.Destination != DestinationVoucher {
+		t.Errorf("Route() of a MENA cash-on-delivery order = %v, want %s", got, DestinationVoucher)
+	}
+
+	got, _ = rules.Route(ctx, nil, RefundFacts{Zone: ZoneMena, PaymentWay: CreditCard, Amount: usd(1)})
+	if got.Destination != DestinationBalance {
+		t.Errorf("Route() of a MENA credit card order = %v, want %s", got, DestinationBalance)
+	}
+}
+
+func TestRefundRulesFile_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.json")
+	write := func(content string, modTime time.Time) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// the file system may not tell writes in quick succession apart
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	write(`{"default": "balance"}`, now)
+	f, err := OpenRefundRulesFile(path)
+	if err != nil {
+		t.Fatalf("OpenRefundRulesFile() error = %v", err)
+	}
+
+	if reloaded, err := f.Reload(); reloaded || err != nil {
+		t.Errorf("Reload() of an unchanged file = %v, %v, want false, nil", reloaded, err)
+	}
+
+	write(`{"default": "voucher"}`, now.Add(time.Second))
+	if reloaded, err := f.Reload(); !reloaded || err != nil {
+		t.Fatalf("Reload() = %v, %v, want true, nil", reloaded, err)
+	}
+	if got := f.RefundRules().Default; got != DestinationVoucher {
+		t.Errorf("RefundRules().Default = %s, want %s", got, DestinationVoucher)
+	}
+
+	write(`{"default": "bank"}`, now.Add(2*time.Second))
+	if _, err := f.Reload(); err == nil {
+		t.Error("Reload() of invalid rules error = nil, want error")
+	}
+	if got := f.RefundRules().Default; got != DestinationVoucher {
+		t.Errorf("RefundRules().Default after invalid rules = %s, want the last valid rules", got)
+	}
+}
+
+func TestRefundRulesFile_Watch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.json")
+	if err = ioutil.WriteFile(path, []byte(`{"default": "balance"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := OpenRefundRulesFile(path)
+	if err != nil {
+		t.Fatalf("OpenRefundRulesFile() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Watch(ctx, time.Millisecond, nil)
+		close(done)
+	}()
+
+	if err = ioutil.WriteFile(path, []byte(`{"default": "original_payment"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err = os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for f.RefundRules().Default != DestinationOriginalPayment {
+		if time.Now().After(deadline) {
+			t.Fatal("Watch() did not reload the changed file")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"context"
+	"sort"
+	"strconv"
+)
+
+// UserStore persists users keyed by GenerateKeyForUser.
+// Get returns a copy of the stored user, changes are saved with Put.
+type UserStore interface {
+	Get(ctx context.Context, key string) (*User, error)
+	Put(ctx context.Context, key string, u *User) error
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context) ([]*User, error)
+}
+
+// OrderStore persists orders keyed by their ID.
+// Get returns a copy of the stored order, changes are saved with Put.
+// Delete only marks an order as deleted, Remove removes it for good to undo saving a new order.
+type OrderStore interface {
+	Get(ctx context.Context, key string) (*Order, error)
+	Put(ctx context.Context, key string, o *Order) error
+	Delete(ctx context.Context, key string) error
+	Remove(ctx context.Context, key string) error
+	List(ctx context.Context) ([]*Order, error)
+}
+
+// VoucherStore persists voucher accounts keyed by GenerateKeyForVoucher.
+// Get returns a copy of the stored account, changes are saved with Put.
+type VoucherStore interface {
+	Get(ctx context.Context, key string) (*Voucher, error)
+	Put(ctx context.Context, key string, v *Voucher) error
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context) ([]*Voucher, error)
+}
+
+// sortKeys orders DB keys, numeric keys such as order IDs are ordered by value
+func sortKeys(keys []string) {
+	sort.Slice(keys, func(i, j int) bool {
+		a, aErr := strconv.Atoi(keys[i])
+		b, bErr := strconv.Atoi(keys[j])
+		if aErr == nil && bErr == nil {
+			return a < b
+		}
+
+		return keys[i] < keys[j]
+	})
+}
+
+var (
+	_ UserStore    = (*UserHandler)(nil)
+	_ OrderStore   = (*OrderHandler)(nil)
+	_ VoucherStore = (*VoucherHandler)(nil)
+)
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestHandlers_concurrentAccess is meant to be run with the race detector:
+//
+//	go test -race ./api/model/...
+func TestHandlers_concurrentAccess(t *testing.T) {
+	ctx := context.Background()
+	uh, oh, vh := NewUserHandler(), NewOrderHandler(), NewVoucherHandler()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			key := strconv.Itoa(i % 5)
+
+			uh.Put(ctx, key, &User{Name: "John", LastName: "Doe", Orders: []int{i}})
+			uh.Get(ctx, key)
+			uh.List(ctx)
+
+			oh.AddToDB(&Order{ID: i, PaymentWay: CreditCard, ShippingCountryZone: ZoneEurope})
+			oh.Delete(ctx, key)
+			oh.List(ctx)
+
+			vh.Put(ctx, key, &Voucher{Currency: DefaultCurrency, userKey: key})
+			vh.Get(ctx, key)
+			vh.Delete(ctx, key)
+			vh.List(ctx)
+		}(i)
+	}
+	wg.Wait()
+
+	users, _ := uh.List(ctx)
+	if len(users) != 5 {
+		t.Errorf("List() got %d users, want %d", len(users), 5)
+	}
+}
@@ -0,0 +1,550 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Stores groups the stores a unit of work operates on
+type Stores struct {
+	Users    UserStore
+	Orders   OrderStore
+	Vouchers VoucherStore
+	Audit    AuditStore
+	Ledger   LedgerStore
+
+	PaymentRefunds PaymentRefundStore
+	Redemptions    RedemptionStore
+
+	// Idempotency is used outside units of work, it is nil in the stores given to fn
+	Idempotency IdempotencyStore
+}
+
+// UnitOfWork runs fn so that every change it makes through the given stores is
+// committed together or not at all. If fn returns an error nothing is committed.
+type UnitOfWork interface {
+	Do(ctx context.Context, fn func(ctx context.Context, s Stores) error) error
+}
+
+// MemoryUnitOfWork implements UnitOfWork for stores without native transactions.
+// Changes are staged until fn returns and then applied one by one. If applying a
+// change fails, the changes applied before it are reverted to their previous values.
+//
+// Appends to the ledger and the audit log cannot be reverted, they are applied after every
+// other change, one call per log, so they are only made if the other changes succeeded.
+// The audit log is appended first, so a failing audit append leaves the ledger untouched and
+// balances keep following from the ledger. If the ledger append fails after it, the audit entries
+// stay and record an attempt that was reverted.
+//
+// It does not isolate concurrent units of work, callers lock the records they change.
+type MemoryUnitOfWork struct {
+	stores Stores
+}
+
+// NewMemoryUnitOfWork creates a MemoryUnitOfWork for the given stores and returns it
+func NewMemoryUnitOfWork(s Stores) *MemoryUnitOfWork {
+	return &MemoryUnitOfWork{stores: s}
+}
+
+// Do runs fn with stores that stage every change and commits the changes if fn succeeds
+func (m *MemoryUnitOfWork) Do(ctx context.Context, fn func(ctx context.Context, s Stores) error) error {
+	tx := &memoryTx{}
+	staged := Stores{
+		Users:    &txUserStore{base: m.stores.Users, tx: tx, staged: map[string]*User{}},
+		Orders:   &txOrderStore{base: m.stores.Orders, tx: tx, staged: map[string]*Order{}},
+		Vouchers: &txVoucherStore{base: m.stores.Vouchers, tx: tx, staged: map[string]*Voucher{}},
+
+		PaymentRefunds: &txPaymentRefundStore{base: m.stores.PaymentRefunds, tx: tx, staged: map[string]*PaymentRefund{}},
+		Redemptions:    &txRedemptionStore{base: m.stores.Redemptions, tx: tx, staged: map[string]*Redemption{}},
+	}
+
+	audit := &txAuditStore{base: m.stores.Audit}
+	staged.Audit = audit
+
+	ledger := &txLedgerStore{base: m.stores.Ledger}
+	staged.Ledger = ledger
+
+	if err := fn(ctx, staged); err != nil {
+		return err
+	}
+
+	if len(audit.staged) > 0 && audit.base == nil {
+		return errors.New("audit store is missing")
+	}
+
+	if len(ledger.staged) > 0 && ledger.base == nil {
+		return errors.New("ledger store is missing")
+	}
+
+	if len(audit.staged) > 0 || len(ledger.staged) > 0 {
+		tx.last = func(ctx context.Context) error {
+			if len(audit.staged) > 0 {
+				if err := audit.base.Append(ctx, audit.staged...); err != nil {
+					return err
+				}
+			}
+
+			if len(ledger.staged) > 0 {
+				return ledger.base.Append(ctx, ledger.staged...)
+			}
+
+			return nil
+		}
+	}
+
+	return tx.commit(ctx)
+}
+
+// memoryTx holds the staged changes of a MemoryUnitOfWork in the order they were made
+type memoryTx struct {
+	changes []change
+	last    func(ctx context.Context) error // applied after changes, it cannot be reverted
+}
+
+// change applies a staged write and returns a function that reverts it
+type change func(ctx context.Context) (undo func(ctx context.Context) error, err error)
+
+func (tx *memoryTx) stage(c change) {
+	tx.changes = append(tx.changes, c)
+}
+
+func (tx *memoryTx) commit(ctx context.Context) error {
+	undos := make([]func(context.Context) error, 0, len(tx.changes))
+
+	changes := tx.changes
+	if tx.last != nil {
+		changes = append(changes, func(ctx context.Context) (func(context.Context) error, error) {
+			return nil, tx.last(ctx)
+		})
+	}
+
+	for _, c := range changes {
+		undo, err := c(ctx)
+		if err == nil {
+			undos = append(undos, undo)
+			continue
+		}
+
+		var failed []string
+		for i := len(undos) - 1; i >= 0; i-- {
+			if uerr := undos[i](ctx); uerr != nil {
+				failed = append(failed, uerr.Error())
+			}
+		}
+
+		if len(failed) > 0 {
+			return fmt.Errorf("commit failed: %w, rollback failed: %s", err, strings.Join(failed, "; "))
+		}
+
+		return fmt.Errorf("commit failed: %w", err)
+	}
+
+	return nil
+}
+
+// txUserStore stages the changes made to a UserStore
+type txUserStore struct {
+	base   UserStore
+	tx     *memoryTx
+	staged map[string]*User // nil values are staged deletions
+}
+
+func (s *txUserStore) Get(ctx context.Context, key string) (*User, error) {
+	if u, ok := s.staged[key]; ok {
+		if u == nil {
+			return nil, ErrUserNotFound
+		}
+		return u.copy(), nil
+	}
+
+	return s.base.Get(ctx, key)
+}
+
+func (s *txUserStore) Put(_ context.Context, key string, u *User) error {
+	if u == nil {
+		return errors.New("user cannot be nil")
+	}
+
+	s.staged[key] = u.copy()
+	s.stageWrite(key, u.copy())
+
+	return nil
+}
+
+func (s *txUserStore) Delete(ctx context.Context, key string) error {
+	if _, err := s.Get(ctx, key); err != nil {
+		return err
+	}
+
+	s.staged[key] = nil
+	s.stageWrite(key, nil)
+
+	return nil
+}
+
+// List returns the committed users, changes staged in the unit of work are not visible
+func (s *txUserStore) List(ctx context.Context) ([]*User, error) {
+	return s.base.List(ctx)
+}
+
+func (s *txUserStore) stageWrite(key string, u *User) {
+	s.tx.stage(func(ctx context.Context) (func(context.Context) error, error) {
+		prev, getErr := s.base.Get(ctx, key)
+
+		var err error
+		if u == nil {
+			err = s.base.Delete(ctx, key)
+		} else {
+			err = s.base.Put(ctx, key, u)
+		}
+
+		return func(ctx context.Context) error {
+			if getErr != nil {
+				return s.base.Delete(ctx, key)
+			}
+			return s.base.Put(ctx, key, prev)
+		}, err
+	})
+}
+
+// txOrderStore stages the changes made to an OrderStore
+type txOrderStore struct {
+	base   OrderStore
+	tx     *memoryTx
+	staged map[string]*Order // nil values are staged removals
+}
+
+func (s *txOrderStore) Get(ctx context.Context, key string) (*Order, error) {
+	if o, ok := s.staged[key]; ok {
+		if o == nil {
+			return nil, ErrOrderNotFound
+		}
+		return o.copy(), nil
+	}
+
+	return s.base.Get(ctx, key)
+}
+
+func (s *txOrderStore) Put(_ context.Context, key string, o *Order) error {
+	if o == nil {
+		return errors.New("order cannot be nil")
+	}
+
+	s.staged[key] = o.copy()
+	s.stageWrite(key, o.copy())
+
+	return nil
+}
+
+// Delete stages marking the order as deleted, just like OrderHandler.Delete
+func (s *txOrderStore) Delete(ctx context.Context, key string) error {
+	o, err := s.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	o.IsDeleted = true
+
+	return s.Put(ctx, key, o)
+}
+
+func (s *txOrderStore) Remove(ctx context.Context, key string) error {
+	if _, err := s.Get(ctx, key); err != nil {
+		return err
+	}
+
+	s.staged[key] = nil
+	s.stageWrite(key, nil)
+
+	return nil
+}
+
+// List returns the committed orders, changes staged in the unit of work are not visible
+func (s *txOrderStore) List(ctx context.Context) ([]*Order, error) {
+	return s.base.List(ctx)
+}
+
+// stageWrite stages saving the order, or removing it if o is nil. An order that did not
+// exist before is removed when the change is reverted, so no trace of it is left.
+func (s *txOrderStore) stageWrite(key string, o *Order) {
+	s.tx.stage(func(ctx context.Context) (func(context.Context) error, error) {
+		prev, getErr := s.base.Get(ctx, key)
+
+		var err error
+		if o == nil {
+			err = s.base.Remove(ctx, key)
+		} else {
+			err = s.base.Put(ctx, key, o)
+		}
+
+		return func(ctx context.Context) error {
+			if getErr != nil {
+				return s.base.Remove(ctx, key)
+			}
+			return s.base.Put(ctx, key, prev)
+		}, err
+	})
+}
+
+// txVoucherStore stages the changes made to a VoucherStore
+type txVoucherStore struct {
+	base   VoucherStore
+	tx     *memoryTx
+	staged map[string]*Voucher // nil values are staged deletions
+}
+
+func (s *txVoucherStore) Get(ctx context.Context, key string) (*Voucher, error) {
+	if v, ok := s.staged[key]; ok {
+		if v == nil {
+			return nil, ErrAccountNotFound
+		}
+		return v.copy(), nil
+	}
+
+	return s.base.Get(ctx, key)
+}
+
+func (s *txVoucherStore) Put(_ context.Context, key string, v *Voucher) error {
+	if v == nil {
+		return errors.New("account cannot be nil")
+	}
+
+	c := v.copy()
+	s.staged[key] = c
+	s.stageWrite(key, c)
+
+	return nil
+}
+
+func (s *txVoucherStore) Delete(ctx context.Context, key string) error {
+	if _, err := s.Get(ctx, key); err != nil {
+		return err
+	}
+
+	s.staged[key] = nil
+	s.stageWrite(key, nil)
+
+	return nil
+}
+
+// List returns the committed accounts, changes staged in the unit of work are not visible
+func (s *txVoucherStore) List(ctx context.Context) ([]*Voucher, error) {
+	return s.base.List(ctx)
+}
+
+func (s *txVoucherStore) stageWrite(key string, v *Voucher) {
+	var c *Voucher
+	if v != nil {
+		c = v.copy()
+	}
+
+	s.tx.stage(func(ctx context.Context) (func(context.Context) error, error) {
+		prev, getErr := s.base.Get(ctx, key)
+
+		var err error
+		if c == nil {
+			err = s.base.Delete(ctx, key)
+		} else {
+			err = s.base.Put(ctx, key, c)
+		}
+
+		return func(ctx context.Context) error {
+			if getErr != nil {
+				return s.base.Delete(ctx, key)
+			}
+			return s.base.Put(ctx, key, prev)
+		}, err
+	})
+}
+
+// txPaymentRefundStore stages the changes made to a PaymentRefundStore
+type txPaymentRefundStore struct {
+	base   PaymentRefundStore
+	tx     *memoryTx
+	staged map[string]*PaymentRefund // nil values are staged deletions
+}
+
+func (s *txPaymentRefundStore) Get(ctx context.Context, id string) (*PaymentRefund, error) {
+	if r, ok := s.staged[id]; ok {
+		if r == nil {
+			return nil, ErrRefundNotFound
+		}
+		c := *r
+		return &c, nil
+	}
+
+	return s.base.Get(ctx, id)
+}
+
+func (s *txPaymentRefundStore) Put(_ context.Context, id string, r *PaymentRefund) error {
+	if r == nil {
+		return errors.New("payment refund cannot be nil")
+	}
+
+	c := *r
+	s.staged[id] = &c
+	s.stageWrite(id, &c)
+
+	return nil
+}
+
+func (s *txPaymentRefundStore) Delete(ctx context.Context, id string) error {
+	if _, err := s.Get(ctx, id); err != nil {
+		return err
+	}
+
+	s.staged[id] = nil
+	s.stageWrite(id, nil)
+
+	return nil
+}
+
+// List returns the committed refunds, changes staged in the unit of work are not visible
+func (s *txPaymentRefundStore) List(ctx context.Context) ([]*PaymentRefund, error) {
+	return s.base.List(ctx)
+}
+
+func (s *txPaymentRefundStore) stageWrite(id string, r *PaymentRefund) {
+	var c *PaymentRefund
+	if r != nil {
+		tmp := *r
+		c = &tmp
+	}
+
+	s.tx.stage(func(ctx context.Context) (func(context.Context) error, error) {
+		prev, getErr := s.base.Get(ctx, id)
+
+		var err error
+		if c == nil {
+			err = s.base.Delete(ctx, id)
+		} else {
+			err = s.base.Put(ctx, id, c)
+		}
+
+		return func(ctx context.Context) error {
+			if getErr != nil {
+				return s.base.Delete(ctx, id)
+			}
+			return s.base.Put(ctx, id, prev)
+		}, err
+	})
+}
+
+// txRedemptionStore stages the changes made to a RedemptionStore
+type txRedemptionStore struct {
+	base   RedemptionStore
+	tx     *memoryTx
+	staged map[string]*Redemption // nil values are staged deletions
+}
+
+func (s *txRedemptionStore) Get(ctx context.Context, id string) (*Redemption, error) {
+	if r, ok := s.staged[id]; ok {
+		if r == nil {
+			return nil, ErrRedemptionNotFound
+		}
+		return r.copy(), nil
+	}
+
+	return s.base.Get(ctx, id)
+}
+
+func (s *txRedemptionStore) Put(_ context.Context, id string, r *Redemption) error {
+	if r == nil {
+		return errors.New("redemption cannot be nil")
+	}
+
+	s.staged[id] = r.copy()
+	s.stageWrite(id, r.copy())
+
+	return nil
+}
+
+func (s *txRedemptionStore) Delete(ctx context.Context, id string) error {
+	if _, err := s.Get(ctx, id); err != nil {
+		return err
+	}
+
+	s.staged[id] = nil
+	s.stageWrite(id, nil)
+
+	return nil
+}
+
+// List returns the committed redemptions, changes staged in the unit of work are not visible
+func (s *txRedemptionStore) List(ctx context.Context) ([]*Redemption, error) {
+	return s.base.List(ctx)
+}
+
+func (s *txRedemptionStore) stageWrite(id string, c *Redemption) {
+	s.tx.stage(func(ctx context.Context) (func(context.Context) error, error) {
+		prev, getErr := s.base.Get(ctx, id)
+
+		var err error
+		if c == nil {
+			err = s.base.Delete(ctx, id)
+		} else {
+			err = s.base.Put(ctx, id, c)
+		}
+
+		return func(ctx context.Context) error {
+			if getErr != nil {
+				return s.base.Delete(ctx, id)
+			}
+			return s.base.Put(ctx, id, prev)
+		}, err
+	})
+}
+
+// txAuditStore stages the entries appended to an AuditStore
+type txAuditStore struct {
+	base   AuditStore
+	staged []*AuditEntry
+}
+
+func (s *txAuditStore) Append(_ context.Context, entries ...*AuditEntry) error {
+	for _, e := range entries {
+		if e == nil {
+			return errors.New("audit entry cannot be nil")
+		}
+	}
+
+	for _, e := range entries {
+		c := *e
+		s.staged = append(s.staged, &c)
+	}
+
+	return nil
+}
+
+// List returns the committed entries, entries staged in the unit of work are not visible
+func (s *txAuditStore) List(ctx context.Context) ([]*AuditEntry, error) {
+	return s.base.List(ctx)
+}
+
+// txLedgerStore stages the entries appended to a LedgerStore
+type txLedgerStore struct {
+	base   LedgerStore
+	staged []*LedgerEntry
+}
+
+func (s *txLedgerStore) Append(_ context.Context, entries ...*LedgerEntry) error {
+	for _, e := range entries {
+		if e == nil {
+			return errors.New("ledger entry cannot be nil")
+		}
+	}
+
+	for _, e := range entries {
+		c := *e
+		s.staged = append(s.staged, &c)
+	}
+
+	return nil
+}
+
+// List returns the committed entries, entries staged in the unit of work are not visible
+func (s *txLedgerStore) List(ctx context.Context, userKey string) ([]*LedgerEntry, error) {
+	return s.base.List(ctx, userKey)
+}
@@ -0,0 +1,267 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// failingUserStore fails every Put
+type failingUserStore struct {
+	UserStore
+}
+
+func (failingUserStore) Put(context.Context, string, *User) error {
+	return errors.New("disk is full")
+}
+
+// failingVoucherStore fails every Put
+type failingVoucherStore struct {
+	VoucherStore
+}
+
+func (failingVoucherStore) Put(context.Context, string, *Voucher) error {
+	return errors.New("disk is full")
+}
+
+// failingAuditStore fails every Append
+type failingAuditStore struct {
+	AuditStore
+}
+
+func (failingAuditStore) Append(context.Context, ...*AuditEntry) error {
+	return errors.New("disk is full")
+}
+
+// failingLedgerStore fails every Append
+type failingLedgerStore struct {
+	LedgerStore
+}
+
+func (failingLedgerStore) Append(context.Context, ...*LedgerEntry) error {
+	return errors.New("disk is full")
+}
+
+func TestMemoryUnitOfWork_Do(t *testing.T) {
+	ctx := context.Background()
+	entry := AuditEntry{Action: AuditOwnershipOverride, ActorKey: "agent-1", ActorRole: "support", UserKey: "john-doe", OrderID: "1"}
+	movement := LedgerEntry{Account: BalanceAccount("john-doe"), UserKey: "john-doe", Delta: usd(100), Reason: LedgerRefund, OrderID: "1"}
+
+	// refund audits the refund, records it in the ledger and changes an order, a user and a voucher account in that order
+	refund := func(ctx context.Context, s Stores) error {
+		e := entry
+		s.Audit.Append(ctx, &e)
+
+		m := movement
+		s.Ledger.Append(ctx, &m)
+
+		o, err := s.Orders.Get(ctx, "1")
+		if err != nil {
+			return err
+		}
+		o.IsDeleted = true
+		s.Orders.Put(ctx, "1", o)
+
+		u, _ := s.Users.Get(ctx, "john-doe")
+		u.UpdateBalance(usd(100))
+		s.Users.Put(ctx, "john-doe", u)
+
+		return s.Vouchers.Put(ctx, "john-doe-usd", &Voucher{Balance: usd(100), Currency: DefaultCurrency, userKey: "john-doe"})
+	}
+
+	tests := []struct {
+		name         string
+		fn           func(ctx context.Context, s Stores) error
+		failUsers    bool
+		failVouchers bool
+		failAudit    bool
+		failLedger   bool
+		wantErr      bool
+		wantCommit   bool
+		wantAudit    bool // the audit log is appended before the ledger and is kept if the ledger append fails
+	}{
+		{
+			name:       "commits every change",
+			fn:         refund,
+			wantCommit: true,
+		},
+		{
+			name: "discards changes when fn fails",
+			fn: func(ctx context.Context, s Stores) error {
+				refund(ctx, s)
+				return errors.New("voucher cannot be created")
+			},
+			wantErr: true,
+		},
+		{
+			name:      "reverts order when user cannot be saved",
+			fn:        refund,
+			failUsers: true,
+			wantErr:   true,
+		},
+		{
+			name:         "reverts order and user when voucher cannot be saved",
+			fn:           refund,
+			failVouchers: true,
+			wantErr:      true,
+		},
+		{
+			name:      "reverts every change when audit entry cannot be appended",
+			fn:        refund,
+			failAudit: true,
+			wantErr:   true,
+		},
+		{
+			name:       "reverts every change but the audit log when ledger entry cannot be appended",
+			fn:         refund,
+			failLedger: true,
+			wantErr:    true,
+			wantAudit:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &UserHandler{db: getUserTestDb()}
+			orders := &OrderHandler{db: getOrderTestDb()}
+			vouchers := &VoucherHandler{db: map[string]*Voucher{}}
+			audit := NewAuditLog()
+			ledger := NewLedger()
+
+			stores := Stores{Users: users, Orders: orders, Vouchers: vouchers, Audit: audit, Ledger: ledger}
+			if tt.failUsers {
+				stores.Users = failingUserStore{users}
+			}
+			if tt.failVouchers {
+				stores.Vouchers = failingVoucherStore{vouchers}
+			}
+			if tt.failAudit {
+				stores.Audit = failingAuditStore{audit}
+			}
+			if tt.failLedger {
+				stores.Ledger = failingLedgerStore{ledger}
+			}
+
+			err := NewMemoryUnitOfWork(stores).Do(ctx, tt.fn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Do() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			wantUsers, wantOrders, wantVouchers := getUserTestDb(), getOrderTestDb(), map[string]*Voucher{}
+			wantAudit, wantLedger := []*AuditEntry{}, []*LedgerEntry{}
+			if tt.wantCommit || tt.wantAudit {
+				wantAudit = append(wantAudit, &entry)
+			}
+			if tt.wantCommit {
+				wantLedger = append(wantLedger, &movement)
+				wantUsers["john-doe"].UpdateBalance(usd(100))
+				wantOrders["1"].IsDeleted = true
+				wantVouchers["john-doe-usd"] = &Voucher{Balance: usd(100), Currency: DefaultCurrency, userKey: "john-doe"}
+			}
+
+			if !reflect.DeepEqual(users.db, wantUsers) {
+				t.Errorf("Do() users = %v, want %v", users.db, wantUsers)
+			}
+			if !reflect.DeepEqual(orders.db, wantOrders) {
+				t.Errorf("Do() orders = %v, want %v", orders.db, wantOrders)
+			}
+			if !reflect.DeepEqual(vouchers.db, wantVouchers) {
+				t.Errorf("Do() vouchers = %v, want %v", vouchers.db, wantVouchers)
+			}
+			if got, _ := audit.List(ctx); !reflect.DeepEqual(got, wantAudit) {
+				t.Errorf("Do() audit = %v, want %v", got, wantAudit)
+			}
+			if got, _ := ledger.List(ctx, "john-doe"); !reflect.DeepEqual(got, wantLedger) {
+				t.Errorf("Do() ledger = %v, want %v", got, wantLedger)
+			}
+		})
+	}
+}
+
+func TestMemoryUnitOfWork_Do_removesNewOrdersOnRollback(t *testing.T) {
+	ctx := context.Background()
+	users := &UserHandler{db: getUserTestDb()}
+	orders := &OrderHandler{db: getOrderTestDb()}
+	uow := NewMemoryUnitOfWork(Stores{Users: failingUserStore{users}, Orders: orders, Vouchers: NewVoucherHandler()})
+
+	err := uow.Do(ctx, func(ctx context.Context, s Stores) error {
+		s.Orders.Put(ctx, "5", &Order{ID: 5, Total: usd(10), PaymentWay: CreditCard, ShippingCountryZone: ZoneEurope})
+
+		u, _ := s.Users.Get(ctx, "john-doe")
+		u.Orders = append(u.Orders, 5)
+		return s.Users.Put(ctx, "john-doe", u)
+	})
+	if err == nil {
+		t.Fatalf("Do() error = nil, want the user put to fail")
+	}
+
+	if !reflect.DeepEqual(orders.db, getOrderTestDb()) {
+		t.Errorf("Do() orders = %v, want the new order removed", orders.db)
+	}
+}
+
+func TestMemoryUnitOfWork_Do_readsStagedChanges(t *testing.T) {
+	ctx := context.Background()
+	users := &UserHandler{db: getUserTestDb()}
+	uow := NewMemoryUnitOfWork(Stores{Users: users, Orders: NewOrderHandler(), Vouchers: NewVoucherHandler()})
+
+	err := uow.Do(ctx, func(ctx context.Context, s Stores) error {
+		u, _ := s.Users.Get(ctx, "john-doe")
+		u.Balance = usd(1)
+		s.Users.Put(ctx, "john-doe", u)
+
+		if got, _ := s.Users.Get(ctx, "john-doe"); got.Balance != usd(1) {
+			t.Errorf("Get() balance = %v, want staged %v", got.Balance, 1)
+		}
+
+		if committed, _ := users.Get(ctx, "john-doe"); committed.Balance != usd(100) {
+			t.Errorf("Put() was applied before commit, balance = %v", committed.Balance)
+		}
+
+		s.Users.Delete(ctx, "jane-doe")
+		if _, err := s.Users.Get(ctx, "jane-doe"); err == nil {
+			t.Errorf("Get() found user with staged deletion")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+
+	if _, err = users.Get(ctx, "jane-doe"); err == nil {
+		t.Errorf("Do() did not commit deletion")
+	}
+}
+
+func TestMemoryUnitOfWork_Do_copiesOrders(t *testing.T) {
+	ctx := context.Background()
+	orders := NewOrderHandler()
+	orders.Put(ctx, "1", &Order{ID: 1, Lines: []LineItem{{SKU: "SKU-1", Quantity: 2, UnitPrice: usd(40)}}})
+	uow := NewMemoryUnitOfWork(Stores{Users: &UserHandler{db: getUserTestDb()}, Orders: orders, Vouchers: NewVoucherHandler()})
+
+	errRollback := errors.New("rollback")
+	err := uow.Do(ctx, func(ctx context.Context, s Stores) error {
+		o, _ := s.Orders.Get(ctx, "1")
+		o.Lines[0].Quantity = 3
+		s.Orders.Put(ctx, "1", o)
+		o.Lines[0].Quantity = 4
+
+		staged, _ := s.Orders.Get(ctx, "1")
+		staged.Lines[0].Quantity = 5
+		if got, _ := s.Orders.Get(ctx, "1"); got.Lines[0].Quantity != 3 {
+			t.Errorf("Get() quantity = %d, want staged 3", got.Lines[0].Quantity)
+		}
+
+		return errRollback
+	})
+
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("Do() error = %v, want %v", err, errRollback)
+	}
+
+	if got, _ := orders.Get(ctx, "1"); got.Lines[0].Quantity != 2 {
+		t.Errorf("Do() rolled back to quantity %d, want 2", got.Lines[0].Quantity)
+	}
+}
@@ -1,29 +1,34 @@
 package model
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 )
 
 // User holds every data related to a user
 type User struct {
-	Name     string  `json:"Name"`
-	LastName string  `json:"LastName"`
-	Balance  float32 `json:"Balance"` // Current balance of the user
-	Orders   []int   // Orders of the user
+	Name     string `json:"Name"`
+	LastName string `json:"LastName"`
+	Balance  Money  `json:"Balance"` // Current balance of the user
+	Orders   []int  // Orders of the user
+	// VoucherCurrency is the currency of the voucher account refunds are added to.
+	// Refunds go to the account in the currency of the order if it is empty.
+	VoucherCurrency string `json:"VoucherCurrency,omitempty"`
 }
 
-// UserHandler holds the needed data for every DB operation to run
+// UserHandler is the in-memory UserStore, it is safe for concurrent use
 type UserHandler struct {
-	Usr *User            // user whom the operations will be on
-	db  map[string]*User // holds every User created
+	mu sync.RWMutex
+	db map[string]*User // holds every User created
 }
 
-// NewUserHandler creates a UserDB struct with empty initial values and returns it.
+// NewUserHandler creates a UserHandler with an empty DB and returns it.
 func NewUserHandler() *UserHandler {
-	return &UserHandler{nil, make(map[string]*User)}
+	return &UserHandler{db: make(map[string]*User)}
 }
 
 // NewUser creates a User with the given name and last name and returns it.
@@ -40,6 +45,9 @@ func NewUser(name, lastName string) (*User, error) {
 }
 
 func (uh *UserHandler) BulkInsert(b []byte) error {
+	uh.mu.Lock()
+	defer uh.mu.Unlock()
+
 	err := json.Unmarshal(b, &uh.db)
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal\n%s", err)
@@ -48,53 +56,147 @@ func (uh *UserHandler) BulkInsert(b []byte) error {
 	return nil
 }
 
-// UpdateBalance function adds the given balance to the given user in UserDB struct.
-func (u *User) UpdateBalance(balance float32) (float32, error) {
-	u.Balance = u.Balance + balance
+// UpdateBalance function adds the given amount to the balance of the user.
+// The balance is left unchanged if the amount is in another currency.
+func (u *User) UpdateBalance(amount Money) (Money, error) {
+	balance, err := u.Balance.Add(amount)
+	if err != nil {
+		return u.Balance, err
+	}
+
+	u.Balance = balance
 	return u.Balance, nil
 }
 
-// AddToDB function adds user in UserDB to the DB.
-func (uh *UserHandler) AddToDB() error {
-	if err := checkName(uh.Usr.Name, uh.Usr.LastName); err != nil {
+// Owns reports whether the order with the given ID belongs to the user
+func (u *User) Owns(orderID int) bool {
+	for _, id := range u.Orders {
+		if id == orderID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// copy returns a copy of the user that shares no memory with it
+func (u *User) copy() *User {
+	c := *u
+	if u.Orders != nil {
+		c.Orders = append([]int(nil), u.Orders...)
+	}
+
+	return &c
+}
+
+// AddToDB function adds the given user to the DB.
+// An error is returned if the user is invalid or already exists.
+func (uh *UserHandler) AddToDB(u *User) error {
+	if err := u.Validate(); err != nil {
 		return err
 	}
 
-	key := GenerateKeyForUser(uh.Usr)
+	uh.mu.Lock()
+	defer uh.mu.Unlock()
+
+	key := GenerateKeyForUser(u)
 	if _, ok := uh.db[key]; ok {
-		return errors.New("user already exists")
+		return ErrUserExists
 	}
 
-	uh.db[key] = uh.Usr
+	uh.db[key] = u.copy()
 
 	return nil
 }
 
-// Find function finds the user from db and returns it.
+// Get function finds the user from db and returns a copy of it.
 // An error is returned if key does not exist in DB map.
-func (uh *UserHandler) Find(key string) error {
+func (uh *UserHandler) Get(_ context.Context, key string) (*User, error) {
+	uh.mu.RLock()
+	defer uh.mu.RUnlock()
+
 	if usr, ok := uh.db[key]; ok {
-		uh.Usr = usr
-		return nil
+		return usr.copy(), nil
 	}
 
-	uh.Usr = nil
-	return errors.New("user not found")
+	return nil, ErrUserNotFound
+}
+
+// Put function saves the user under the given key, replacing any existing user.
+func (uh *UserHandler) Put(_ context.Context, key string, u *User) error {
+	if u == nil {
+		return errors.New("user cannot be nil")
+	}
+
+	uh.mu.Lock()
+	defer uh.mu.Unlock()
+
+	uh.db[key] = u.copy()
+
+	return nil
 }
 
 // Delete function removes the user associated with the key in parameter from the DB.
-func (uh *UserHandler) Delete(key string) bool {
+// An error is returned if key does not exist in DB map.
+func (uh *UserHandler) Delete(_ context.Context, key string) error {
+	uh.mu.Lock()
+	defer uh.mu.Unlock()
+
 	if _, ok := uh.db[key]; ok {
 		delete(uh.db, key)
-		return true
+		return nil
 	}
 
-	return false
+	return ErrUserNotFound
+}
+
+// List function returns a copy of every user ordered by key.
+func (uh *UserHandler) List(_ context.Context) ([]*User, error) {
+	uh.mu.RLock()
+	defer uh.mu.RUnlock()
+
+	keys := make([]string, 0, len(uh.db))
+	for k := range uh.db {
+		keys = append(keys, k)
+	}
+	sortKeys(keys)
+
+	users := make([]*User, 0, len(keys))
+	for _, k := range keys {
+		users = append(users, uh.db[k].copy())
+	}
+
+	return users, nil
+}
+
+// Validate returns an error wrapping ErrInvalidUser if the user cannot be saved
+func (u *User) Validate() error {
+	if err := checkName(u.Name, u.LastName); err != nil {
+		return err
+	}
+
+	if u.Balance.IsNegative() {
+		return fmt.Errorf("%w: balance cannot be negative", ErrInvalidUser)
+	}
+
+	if u.VoucherCurrency != "" {
+		if err := CheckCurrency(u.VoucherCurrency); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidUser, err)
+		}
+	}
+
+	for _, id := range u.Orders {
+		if id <= 0 {
+			return fmt.Errorf("%w: invalid order id %d", ErrInvalidUser, id)
+		}
+	}
+
+	return nil
 }
 
 func checkName(name, lastName string) error {
-	if name == "" || lastName == "" {
-		return errors.New("name or last name cannot be empty")
+	if strings.TrimSpace(name) == "" || strings.TrimSpace(lastName) == "" {
+		return fmt.Errorf("%w: name or last name cannot be empty", ErrInvalidUser)
 	}
 
 	return nil
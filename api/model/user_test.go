@@ -1,6 +1,8 @@
 package model
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"testing"
@@ -20,7 +22,7 @@ func TestNewUser(t *testing.T) {
 		{
 			name:    "creates user",
 			args:    args{"Eric", "Smith"},
-			want:    &User{"Eric", "Smith", 0, nil},
+			want:    &User{"Eric", "Smith", Money{}, nil, ""},
 			wantErr: false,
 		},
 		{
@@ -52,13 +54,39 @@ func TestNewUser(t *testing.T) {
 
 func TestNewUserHandler(t *testing.T) {
 	got := NewUserHandler()
-	want := &UserHandler{nil, make(map[string]*User)}
+	want := &UserHandler{db: make(map[string]*User)}
 
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("NewUserHandler() = %v, want %v", got, want)
 	}
 }
 
+func TestUser_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    User
+		wantErr bool
+	}{
+		{name: "accepts a valid user", user: User{Name: "Eric", LastName: "Smith", Balance: usd(10), Orders: []int{1}, VoucherCurrency: "AED"}},
+		{name: "fails when name is blank", user: User{Name: " ", LastName: "Smith"}, wantErr: true},
+		{name: "fails when balance is negative", user: User{Name: "Eric", LastName: "Smith", Balance: usd(-1)}, wantErr: true},
+		{name: "fails when voucher currency is invalid", user: User{Name: "Eric", LastName: "Smith", VoucherCurrency: "aed"}, wantErr: true},
+		{name: "fails when an order id is invalid", user: User{Name: "Eric", LastName: "Smith", Orders: []int{0}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.user.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr && !errors.Is(err, ErrInvalidUser) {
+				t.Errorf("Validate() error = %v, want %v", err, ErrInvalidUser)
+			}
+		})
+	}
+}
+
 func TestUserHandler_AddToDB(t *testing.T) {
 	type fields struct {
 		Usr *User
@@ -75,7 +103,7 @@ func TestUserHandler_AddToDB(t *testing.T) {
 		{
 			name: "fails when user exists",
 			fields: fields{
-				&User{"john", "doe", 100, nil},
+				&User{"john", "doe", usd(100), nil, ""},
 				testDb,
 			},
 			wantErr: true,
@@ -83,7 +111,7 @@ func TestUserHandler_AddToDB(t *testing.T) {
 		{
 			name: "fails when user name is empty",
 			fields: fields{
-				&User{"", "doe", 100, nil},
+				&User{"", "doe", usd(100), nil, ""},
 				testDb,
 			},
 			wantErr: true,
@@ -91,7 +119,7 @@ func TestUserHandler_AddToDB(t *testing.T) {
 		{
 			name: "fails when user last name is empty",
 			fields: fields{
-				&User{"jane", "", 100, nil},
+				&User{"jane", "", usd(100), nil, ""},
 				testDb,
 			},
 			wantErr: true,
@@ -99,7 +127,7 @@ func TestUserHandler_AddToDB(t *testing.T) {
 		{
 			name: "adds user to db successfully",
 			fields: fields{
-				&User{"Eric", "Smith", 100, []int{7, 8, 9}},
+				&User{"Eric", "Smith", usd(100), []int{7, 8, 9}, ""},
 				testDb,
 			},
 			wantErr: false,
@@ -108,10 +136,9 @@ func TestUserHandler_AddToDB(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			udb := &UserHandler{
-				Usr: tt.fields.Usr,
-				db:  tt.fields.db,
+				db: tt.fields.db,
 			}
-			if err := udb.AddToDB(); (err != nil) != tt.wantErr {
+			if err := udb.AddToDB(tt.fields.Usr); (err != nil) != tt.wantErr {
 				t.Errorf("AddToDB() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
@@ -126,74 +153,55 @@ func TestUserHandler_AddToDB(t *testing.T) {
 }
 
 func TestUserHandler_Delete(t *testing.T) {
-	type fields struct {
-		Usr *User
-		db  map[string]*User
-	}
-
 	testDb := getUserTestDb()
 
 	tests := []struct {
-		name   string
-		fields fields
-		key    string
-		want   bool
+		name    string
+		key     string
+		wantErr bool
 	}{
 		{
-			name: "returns false when user not found",
-			fields: fields{
-				nil,
-				testDb,
-			},
-			key:  "qwerty",
-			want: false,
+			name:    "fails when user not found",
+			key:     "qwerty",
+			wantErr: true,
 		},
 		{
-			name: "returns true when user found",
-			fields: fields{
-				nil,
-				testDb,
-			},
-			key:  "jane-doe",
-			want: true,
+			name:    "deletes user when found",
+			key:     "jane-doe",
+			wantErr: false,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			udb := &UserHandler{
-				Usr: tt.fields.Usr,
-				db:  tt.fields.db,
+				db: testDb,
+			}
+			if err := udb.Delete(context.Background(), tt.key); (err != nil) != tt.wantErr {
+				t.Errorf("Delete() error = %v, wantErr %v", err, tt.wantErr)
 			}
-			if got := udb.Delete(tt.key); got != tt.want {
-				t.Errorf("Delete() = %v, want %v", got, tt.want)
+
+			if _, ok := testDb[tt.key]; ok {
+				t.Errorf("Delete() failed, %s is still in DB", tt.key)
 			}
 		})
 	}
 }
 
-func TestUserHandler_Find(t *testing.T) {
-	type fields struct {
-		Usr *User
-		db  map[string]*User
-	}
-
+func TestUserHandler_Get(t *testing.T) {
 	tests := []struct {
 		name    string
-		fields  fields
 		key     string
 		want    *User
 		wantErr bool
 	}{
 		{
 			name:    "finds user successfully",
-			fields:  fields{nil, getUserTestDb()},
 			key:     "john-doe",
-			want:    &User{"John", "Doe", 100, nil},
+			want:    &User{"John", "Doe", usd(100), nil, ""},
 			wantErr: false,
 		},
 		{
 			name:    "fails when user does not exist",
-			fields:  fields{nil, getUserTestDb()},
 			key:     "eric-smith",
 			want:    nil,
 			wantErr: true,
@@ -202,35 +210,88 @@ func TestUserHandler_Find(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			udb := &UserHandler{
-				Usr: tt.fields.Usr,
-				db:  tt.fields.db,
+				db: getUserTestDb(),
 			}
-			err := udb.Find(tt.key)
+			got, err := udb.Get(context.Background(), tt.key)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("Find() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("Get() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			got := udb.Usr
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Find() got = %v, want %v", got, tt.want)
+				t.Errorf("Get() got = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
+func TestUserHandler_Get_returnsCopy(t *testing.T) {
+	udb := &UserHandler{
+		db: getUserTestDb(),
+	}
+
+	got, _ := udb.Get(context.Background(), "jane-doe")
+	got.Balance = Money{}
+	got.Orders[0] = 99
+
+	want := getUserTestDb()["jane-doe"]
+	if !reflect.DeepEqual(udb.db["jane-doe"], want) {
+		t.Errorf("Get() result shares memory with DB, got %v, want %v", udb.db["jane-doe"], want)
+	}
+}
+
+func TestUserHandler_Put(t *testing.T) {
+	udb := &UserHandler{
+		db: getUserTestDb(),
+	}
+
+	want := &User{"John", "Doe", usd(250), []int{1}, ""}
+	if err := udb.Put(context.Background(), "john-doe", want); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	if err := udb.Put(context.Background(), "john-doe", nil); err == nil {
+		t.Errorf("Put() error = nil, want error for nil user")
+	}
+
+	got, _ := udb.Get(context.Background(), "john-doe")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Put() failed. want: %v, got: %v", want, got)
+	}
+}
+
+func TestUserHandler_List(t *testing.T) {
+	udb := &UserHandler{
+		db: getUserTestDb(),
+	}
+
+	got, err := udb.List(context.Background())
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	want := []*User{
+		{"Jane", "Doe", usd(100), []int{1, 2, 3}, ""},
+		{"John", "Doe", usd(100), nil, ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() got = %v, want %v", got, want)
+	}
+}
+
 func ExampleUser_UpdateBalance() {
 	usr, _ := NewUser("Jane", "Doe")
-	got, _ := usr.UpdateBalance(5.95)
+	usr.UpdateBalance(NewMoney(590, "USD"))
+	got, _ := usr.UpdateBalance(NewMoney(5, "USD"))
 
-	fmt.Printf("%.2f", got)
+	fmt.Println(got)
 
 	// Output:
-	// 5.95
+	// 5.95 USD
 }
 
 func getUserTestDb() map[string]*User {
 	return map[string]*User{
-		"john-doe": &User{"John", "Doe", 100, nil},
-		"jane-doe": &User{"Jane", "Doe", 100, []int{1, 2, 3}},
+		"john-doe": &User{"John", "Doe", usd(100), nil, ""},
+		"jane-doe": &User{"Jane", "Doe", usd(100), []int{1, 2, 3}, ""},
 	}
 }
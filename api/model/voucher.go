@@ -1,96 +1,400 @@
 package model
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"strings"
+	"sync"
+	"time"
 )
 
-// DefaultCurrency is the default currency of this project
+// DefaultCurrency is the currency of amounts stored without one, e.g. the legacy data files
 const DefaultCurrency = "USD"
 
-// Voucher holds data about the voucher account of user
+// Voucher holds data about a voucher account of user.
+// A user has one voucher account per currency. Credits are kept as lots with their issue and
+// expiry times. The part of the balance not covered by lots is untracked: it was added before
+// lots existed or through UpdateBalance, it is the oldest part of the balance and never expires.
 type Voucher struct {
-	Balance  float32 `json:"Balance"`
-	Currency string  `json:"Currency"`
+	Balance  Money       `json:"Balance"` // in the currency of the account
+	Currency string      `json:"Currency"`
+	Lots     []CreditLot `json:"Lots,omitempty"` // in the order they were credited
 	userKey  string
 }
 
-// VoucherHandler holds the needed data for every DB operation to run
+// CreditLot is an amount credited to a voucher account at once. Lots are spent first in, first out.
+type CreditLot struct {
+	Amount    Money      `json:"amount"` // what is left of the credit
+	IssuedAt  time.Time  `json:"issued_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // the lot never expires if nil
+}
+
+// Expired reports whether the lot is expired at the given time
+func (l CreditLot) Expired(at time.Time) bool {
+	return l.ExpiresAt != nil && !l.ExpiresAt.After(at)
+}
+
+// voucherJSON is the JSON form of Voucher, it carries the key of the owner as well
+type voucherJSON struct {
+	Balance  Money       `json:"Balance"`
+	Currency string      `json:"Currency"`
+	Lots     []CreditLot `json:"Lots,omitempty"`
+	UserKey  string      `json:"UserKey,omitempty"`
+}
+
+// MarshalJSON encodes the voucher account together with the key of its owner
+func (v Voucher) MarshalJSON() ([]byte, error) {
+	return json.Marshal(voucherJSON{Balance: v.Balance, Currency: v.Currency, Lots: v.Lots, UserKey: v.userKey})
+}
+
+// UnmarshalJSON decodes a voucher account encoded by MarshalJSON
+func (v *Voucher) UnmarshalJSON(b []byte) error {
+	var vj voucherJSON
+	if err := json.Unmarshal(b, &vj); err != nil {
+		return err
+	}
+
+	*v = Voucher{Balance: vj.Balance, Currency: vj.Currency, Lots: vj.Lots, userKey: vj.UserKey}
+	return nil
+}
+
+// VoucherHandler is the in-memory VoucherStore, it is safe for concurrent use
 type VoucherHandler struct {
-	Account *Voucher // Voucher account information
-	db      map[string]*Voucher
+	mu sync.RWMutex
+	db map[string]*Voucher
 }
 
-// NewVoucher creates a Voucher object. If user key is not provided, it returns an error.
-func NewVoucher(balance float32, userKey string) (Voucher, error) {
+// NewVoucher creates a Voucher object in the currency of the balance, a zero balance
+// without a currency opens an account in DefaultCurrency.
+// If user key is not provided, it returns an error.
+func NewVoucher(balance Money, userKey string) (Voucher, error) {
 	if len(strings.TrimSpace(userKey)) == 0 {
 		return Voucher{}, errors.New("user key cannot be empty")
 	}
 
+	if balance.Currency == "" && balance.IsZero() {
+		balance.Currency = DefaultCurrency
+	}
+
+	if err := CheckCurrency(balance.Currency); err != nil {
+		return Voucher{}, err
+	}
+
 	return Voucher{
 		Balance:  balance,
-		Currency: DefaultCurrency,
+		Currency: balance.Currency,
 		userKey:  userKey,
 	}, nil
 }
 
-// NewVoucherHandler creates a VoucherHandler struct with empty initial values and returns it.
+// NewVoucherHandler creates a VoucherHandler with an empty DB and returns it.
 func NewVoucherHandler() *VoucherHandler {
-	return &VoucherHandler{nil, make(map[string]*Voucher)}
+	return &VoucherHandler{db: make(map[string]*Voucher)}
+}
+
+// UserKey returns the key of the user the voucher account belongs to
+func (v *Voucher) UserKey() string {
+	return v.userKey
+}
+
+// UpdateBalance adds the given amount to the balance of the voucher account. Positive amounts
+// are added to the untracked part of the balance, negative amounts are spent like in Spend.
+// The balance is left unchanged if the amount is not in the currency of the account
+// or would take the balance below zero, ErrInsufficientFunds is returned for the latter.
+func (v *Voucher) UpdateBalance(amount Money) (Money, error) {
+	balance, err := v.Balance.Add(amount)
+	if err != nil {
+		return v.Balance, err
+	}
+
+	if amount.IsNegative() {
+		_, err = v.Spend(amount.Neg())
+		return v.Balance, err
+	}
+
+	v.Balance = balance
+	return v.Balance, nil
+}
+
+// Credit adds a lot of the amount issued and expiring at the given times to the voucher account
+// and returns the new balance. A nil expiresAt credits an amount that never expires.
+// An error is returned if the amount is not positive, is not in the currency of the account
+// or expires before it is issued.
+func (v *Voucher) Credit(amount Money, issuedAt time.Time, expiresAt *time.Time) (Money, error) {
+	if amount.Amount <= 0 {
+		return v.Balance, fmt.Errorf("%w: credited amount must be positive", ErrInvalidAmount)
+	}
+
+	if expiresAt != nil && !expiresAt.After(issuedAt) {
+		return v.Balance, fmt.Errorf("%w: credit expires at %s, before it is issued", ErrInvalidExpiry, expiresAt.Format(time.RFC3339))
+	}
+
+	balance, err := v.Balance.Add(amount)
+	if err != nil {
+		return v.Balance, err
+	}
+
+	lot := CreditLot{Amount: amount, IssuedAt: issuedAt}
+	if expiresAt != nil {
+		t := *expiresAt
+		lot.ExpiresAt = &t
+	}
+
+	v.Balance, v.Lots = balance, append(v.Lots, lot)
+	return v.Balance, nil
+}
+
+// Debit takes the amount off the balance of the voucher account and returns the new balance.
+// An error is returned if the amount is not positive, is not in the currency of the account
+// or is more than the balance.
+func (v *Voucher) Debit(amount Money) (Money, error) {
+	if amount.Amount <= 0 {
+		return v.Balance, fmt.Errorf("%w: debited amount must be positive", ErrInvalidAmount)
+	}
+
+	_, err := v.Spend(amount)
+	return v.Balance, err
+}
+
+// Spend takes the amount off the voucher account first in, first out and returns the parts of
+// the lots it took. The untracked part of the balance is spent first, it is returned as a lot
+// without an issue time. Expired lots are spent like the others, call Expire first.
+// The account is left unchanged if the amount is not positive, is not in the currency of the
+// account or is more than the balance, ErrInsufficientFunds is returned for the latter.
+func (v *Voucher) Spend(amount Money) ([]CreditLot, error) {
+	if amount.Amount <= 0 {
+		return nil, fmt.Errorf("%w: spent amount must be positive", ErrInvalidAmount)
+	}
+
+	balance, err := v.Balance.Sub(amount)
+	if err != nil {
+		return nil, err
+	}
+
+	if balance.IsNegative() {
+		return nil, fmt.Errorf("%w: balance is %v", ErrInsufficientFunds, v.Balance)
+	}
+
+	var parts []CreditLot
+	left := amount.Amount
+	if untracked := v.untracked(); untracked.Amount > 0 {
+		n := untracked.Amount
+		if n > left {
+			n = left
+		}
+
+		parts = append(parts, CreditLot{Amount: Money{Amount: n, Currency: v.Currency}})
+		left -= n
+	}
+
+	var lots []CreditLot
+	for _, l := range v.Lots {
+		if left > 0 {
+			n := l.Amount.Amount
+			if n > left {
+				n = left
+			}
+
+			part := l
+			part.Amount.Amount = n
+			parts = append(parts, part)
+
+			l.Amount.Amount -= n
+			left -= n
+		}
+
+		if l.Amount.Amount > 0 {
+			lots = append(lots, l)
+		}
+	}
+
+	v.Balance, v.Lots = balance, lots
+	return parts, nil
+}
+
+// Restore puts the parts Spend took back on the voucher account and returns the new balance.
+// Parts without an issue time go back to the untracked part of the balance, the others become
+// the oldest lots. Parts expired in the meantime are restored as well, Expire takes them off.
+func (v *Voucher) Restore(parts []CreditLot) (Money, error) {
+	balance := v.Balance
+	var lots []CreditLot
+	for _, p := range parts {
+		if p.Amount.Amount <= 0 {
+			return v.Balance, fmt.Errorf("%w: restored amount must be positive", ErrInvalidAmount)
+		}
+
+		var err error
+		if balance, err = balance.Add(p.Amount); err != nil {
+			return v.Balance, err
+		}
+
+		if !p.IssuedAt.IsZero() {
+			lots = append(lots, p)
+		}
+	}
+
+	v.Balance, v.Lots = balance, append(lots, v.Lots...)
+	return v.Balance, nil
+}
+
+// Expire takes the lots expired at the given time off the voucher account and returns the
+// expired amount, which is zero if no lot is expired
+func (v *Voucher) Expire(at time.Time) Money {
+	expired := Money{Currency: v.Currency}
+
+	var lots []CreditLot
+	for _, l := range v.Lots {
+		if l.Expired(at) {
+			expired.Amount += l.Amount.Amount
+			continue
+		}
+		lots = append(lots, l)
+	}
+
+	if expired.IsZero() {
+		return expired
+	}
+
+	v.Balance.Amount -= expired.Amount
+	v.Lots = lots
+	return expired
+}
+
+// HasExpired reports whether any lot of the voucher account is expired at the given time
+func (v *Voucher) HasExpired(at time.Time) bool {
+	for _, l := range v.Lots {
+		if l.Expired(at) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// untracked returns the part of the balance not covered by lots
+func (v *Voucher) untracked() Money {
+	rest := v.Balance
+	for _, l := range v.Lots {
+		rest.Amount -= l.Amount.Amount
+	}
+
+	return rest
+}
+
+// copy returns a copy of the voucher account that shares no lots with it
+func (v *Voucher) copy() *Voucher {
+	c := *v
+	c.Lots = copyLots(v.Lots)
+
+	return &c
 }
 
-// AddToDB adds given voucher account to DB
-func (v *VoucherHandler) AddToDB() error {
-	if v.Account.Currency != DefaultCurrency {
+// copyLots returns a copy of the lots that shares no memory with them
+func copyLots(lots []CreditLot) []CreditLot {
+	if lots == nil {
+		return nil
+	}
+
+	c := make([]CreditLot, len(lots))
+	for i, l := range lots {
+		if l.ExpiresAt != nil {
+			t := *l.ExpiresAt
+			l.ExpiresAt = &t
+		}
+		c[i] = l
+	}
+
+	return c
+}
+
+// AddToDB adds given voucher account to DB under the key of its user and currency.
+// An error is returned if the balance is not in the currency of the account.
+func (vh *VoucherHandler) AddToDB(v *Voucher) error {
+	if err := CheckCurrency(v.Currency); err != nil {
+		return err
+	}
+
+	if _, err := v.Balance.Cmp(Money{Currency: v.Currency}); err != nil {
 		return errors.New("wrong currency given")
 	}
 
-	key := GenerateKeyForVoucher(v.Account.userKey)
-	v.db[key] = v.Account
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+
+	key := GenerateKeyForVoucher(v.userKey, v.Currency)
+	vh.db[key] = v.copy()
 
 	return nil
 }
 
-// Find looks for the given key in DB and returns it if it exists.
+// Get looks for the given key in DB and returns a copy of the account if it exists.
 // If key is not provided or not found, function returns an error.
-func (v *VoucherHandler) Find(key string) error {
+func (vh *VoucherHandler) Get(_ context.Context, key string) (*Voucher, error) {
 	if len(strings.TrimSpace(key)) == 0 {
-		return errors.New("key cannot be empty")
+		return nil, errors.New("key cannot be empty")
 	}
 
-	if account, ok := v.db[key]; ok {
-		v.Account = account
-		return nil
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+
+	if account, ok := vh.db[key]; ok {
+		return account.copy(), nil
 	}
 
-	v.Account = nil
-	return errors.New("account not found")
+	return nil, ErrAccountNotFound
 }
 
-// Delete removes the given key from DB
-func (v *VoucherHandler) Delete(key string) bool {
-	if _, ok := v.db[key]; ok {
-		delete(v.db, key)
-		return true
+// Put saves the account under the given key, replacing any existing account.
+func (vh *VoucherHandler) Put(_ context.Context, key string, v *Voucher) error {
+	if v == nil {
+		return errors.New("account cannot be nil")
 	}
 
-	return false
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+
+	vh.db[key] = v.copy()
+
+	return nil
 }
 
-// UpdateBalance updates the balance of the given voucher account
-func (v *VoucherHandler) UpdateBalance(amount float32) (float32, error) {
-	key := GenerateKeyForVoucher(v.Account.userKey)
+// Delete removes the given key from DB.
+// An error is returned if key does not exist in DB.
+func (vh *VoucherHandler) Delete(_ context.Context, key string) error {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+
+	if _, ok := vh.db[key]; ok {
+		delete(vh.db, key)
+		return nil
+	}
+
+	return ErrAccountNotFound
+}
+
+// List returns a copy of every account ordered by key.
+func (vh *VoucherHandler) List(_ context.Context) ([]*Voucher, error) {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+
+	keys := make([]string, 0, len(vh.db))
+	for k := range vh.db {
+		keys = append(keys, k)
+	}
+	sortKeys(keys)
 
-	if _, ok := v.db[key]; !ok {
-		return 0, errors.New("account not found in DB")
+	accounts := make([]*Voucher, 0, len(keys))
+	for _, k := range keys {
+		accounts = append(accounts, vh.db[k].copy())
 	}
 
-	va := v.db[key]
-	va.Balance += amount
-	return va.Balance, nil
+	return accounts, nil
 }
 
 // GenerateKeyForVoucher is a helper function that creates the key for the voucher account
-func GenerateKeyForVoucher(userKey string) string {
-	return userKey + "-" + strings.ToLower(DefaultCurrency)
+// of the user in the given currency
+func GenerateKeyForVoucher(userKey, currency string) string {
+	return userKey + "-" + strings.ToLower(currency)
 }
@@ -1,13 +1,17 @@
 package model
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestNewVoucher(t *testing.T) {
 	type args struct {
-		balance float32
+		balance Money
 		userKey string
 	}
 	tests := []struct {
@@ -19,7 +23,7 @@ func TestNewVoucher(t *testing.T) {
 		{
 			name: "fails when user key is not provided",
 			args: args{
-				balance: 100,
+				balance: usd(100),
 				userKey: "",
 			},
 			want:    Voucher{},
@@ -28,16 +32,51 @@ func TestNewVoucher(t *testing.T) {
 		{
 			name: "creates voucher account successfully",
 			args: args{
-				balance: 150,
+				balance: usd(150),
 				userKey: "eric-smith",
 			},
 			want: Voucher{
-				Balance:  150,
+				Balance:  usd(150),
 				Currency: "USD",
 				userKey:  "eric-smith",
 			},
 			wantErr: false,
 		},
+		{
+			name: "uses default currency for zero balance",
+			args: args{
+				balance: Money{},
+				userKey: "eric-smith",
+			},
+			want: Voucher{
+				Balance:  NewMoney(0, DefaultCurrency),
+				Currency: "USD",
+				userKey:  "eric-smith",
+			},
+			wantErr: false,
+		},
+		{
+			name: "creates voucher account in the currency of the balance",
+			args: args{
+				balance: NewMoney(15000, "AED"),
+				userKey: "eric-smith",
+			},
+			want: Voucher{
+				Balance:  NewMoney(15000, "AED"),
+				Currency: "AED",
+				userKey:  "eric-smith",
+			},
+			wantErr: false,
+		},
+		{
+			name: "fails when currency is invalid",
+			args: args{
+				balance: Money{Amount: 100, Currency: "dollars"},
+				userKey: "eric-smith",
+			},
+			want:    Voucher{},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -55,8 +94,7 @@ func TestNewVoucher(t *testing.T) {
 
 func TestNewVoucherDB(t *testing.T) {
 	want := &VoucherHandler{
-		Account: nil,
-		db:      make(map[string]*Voucher),
+		db: make(map[string]*Voucher),
 	}
 	got := NewVoucherHandler()
 
@@ -79,10 +117,10 @@ func TestVoucherDB_AddToDB(t *testing.T) {
 		wantErr bool
 	}{
 		{
-			name: "fails when currency is other than the default currency",
+			name: "fails when balance is not in the currency of the account",
 			fields: fields{
 				V: &Voucher{
-					Balance:  150,
+					Balance:  usd(150),
 					Currency: "EUR",
 					userKey:  "eric-smith",
 				},
@@ -94,7 +132,7 @@ func TestVoucherDB_AddToDB(t *testing.T) {
 			name: "adds new voucher account successfully",
 			fields: fields{
 				V: &Voucher{
-					Balance:  150,
+					Balance:  usd(150),
 					Currency: "USD",
 					userKey:  "eric-smith",
 				},
@@ -102,124 +140,118 @@ func TestVoucherDB_AddToDB(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "adds voucher account in another currency",
+			fields: fields{
+				V: &Voucher{
+					Balance:  NewMoney(55000, "SAR"),
+					Currency: "SAR",
+					userKey:  "eric-smith",
+				},
+				db: testDB,
+			},
+			wantErr: false,
+		},
+		{
+			name: "fails when currency is invalid",
+			fields: fields{
+				V: &Voucher{
+					Currency: "usd",
+					userKey:  "eric-smith",
+				},
+				db: testDB,
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			v := &VoucherHandler{
-				Account: tt.fields.V,
-				db:      tt.fields.db,
+				db: tt.fields.db,
 			}
-			if err := v.AddToDB(); (err != nil) != tt.wantErr {
+			if err := v.AddToDB(tt.fields.V); (err != nil) != tt.wantErr {
 				t.Errorf("AddToDB() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
-}
 
-func TestVoucherDB_Delete(t *testing.T) {
-	type fields struct {
-		V  *Voucher
-		db map[string]*Voucher
+	for _, key := range []string{"eric-smith-usd", "eric-smith-sar"} {
+		if _, ok := testDB[key]; !ok {
+			t.Errorf("AddToDB() did not add account %s", key)
+		}
 	}
+}
 
+func TestVoucherDB_Delete(t *testing.T) {
 	tests := []struct {
-		name   string
-		fields fields
-		key    string
-		want   bool
+		name    string
+		db      map[string]*Voucher
+		key     string
+		wantErr bool
 	}{
 		{
-			name: "returns false when account is not found",
-			fields: fields{
-				V:  nil,
-				db: getVoucherTestDB(),
-			},
-			key:  "qwerty",
-			want: false,
+			name:    "fails when account is not found",
+			db:      getVoucherTestDB(),
+			key:     "qwerty",
+			wantErr: true,
 		},
 		{
-			name: "removes account successfully",
-			fields: fields{
-				V:  nil,
-				db: getVoucherTestDB(),
-			},
-			key:  "john-doe-usd",
-			want: true,
+			name:    "removes account successfully",
+			db:      getVoucherTestDB(),
+			key:     "john-doe-usd",
+			wantErr: false,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			v := &VoucherHandler{
-				Account: tt.fields.V,
-				db:      tt.fields.db,
+				db: tt.db,
 			}
-			if got := v.Delete(tt.key); got != tt.want {
-				t.Errorf("Delete() = %v, want %v", got, tt.want)
+			if err := v.Delete(context.Background(), tt.key); (err != nil) != tt.wantErr {
+				t.Errorf("Delete() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
 			want := len(getVoucherTestDB()) - 1
 
-			if tt.want && len(tt.fields.db) != want {
+			if !tt.wantErr && len(tt.db) != want {
 				t.Errorf("Delete failed.")
 			}
 		})
 	}
 }
 
-func TestVoucherDB_Find(t *testing.T) {
-	type fields struct {
-		V  *Voucher
-		db map[string]*Voucher
-	}
-
+func TestVoucherDB_Get(t *testing.T) {
 	testDB := getVoucherTestDB()
 
 	tests := []struct {
 		name    string
-		fields  fields
 		key     string
 		want    *Voucher
 		wantErr bool
 	}{
 		{
-			name: "returns error when key is empty",
-			fields: fields{
-				V:  nil,
-				db: testDB,
-			},
+			name:    "returns error when key is empty",
 			key:     "",
 			want:    nil,
 			wantErr: true,
 		},
 		{
-			name: "returns error when key is a single space",
-			fields: fields{
-				V:  nil,
-				db: testDB,
-			},
+			name:    "returns error when key is a single space",
 			key:     " ",
 			want:    nil,
 			wantErr: true,
 		},
 		{
-			name: "returns error when key is not found",
-			fields: fields{
-				V:  nil,
-				db: testDB,
-			},
+			name:    "returns error when key is not found",
 			key:     "test-user",
 			want:    nil,
 			wantErr: true,
 		},
 		{
 			name: "finds voucher account successfully",
-			fields: fields{
-				V:  nil,
-				db: testDB,
-			},
-			key: "john-doe-usd",
+			key:  "john-doe-usd",
 			want: &Voucher{
-				Balance:  200,
+				Balance:  usd(200),
 				Currency: DefaultCurrency,
 				userKey:  "john-doe",
 			},
@@ -229,51 +261,299 @@ func TestVoucherDB_Find(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			v := &VoucherHandler{
-				Account: tt.fields.V,
-				db:      tt.fields.db,
+				db: testDB,
 			}
-			err := v.Find(tt.key)
+			got, err := v.Get(context.Background(), tt.key)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("Find() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("Get() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			got := v.Account
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Find() got = %v, want %v", got, tt.want)
+				t.Errorf("Get() got = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestVoucher_UpdateBalance(t *testing.T) {
-	vdb := VoucherHandler{
-		Account: &Voucher{
-			Balance:  100,
-			Currency: DefaultCurrency,
-			userKey:  "jane-doe",
-		},
+func TestVoucherDB_PutAndList(t *testing.T) {
+	v := &VoucherHandler{
 		db: getVoucherTestDB(),
 	}
 
-	want := float32(105.95)
-	got, err := vdb.UpdateBalance(5.95)
+	account := &Voucher{Balance: usd(50), Currency: DefaultCurrency, userKey: "eric-smith"}
+	if err := v.Put(context.Background(), "eric-smith-usd", account); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	got, err := v.List(context.Background())
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	want := []*Voucher{
+		account,
+		{Balance: usd(100), Currency: DefaultCurrency, userKey: "jane-doe"},
+		{Balance: usd(200), Currency: DefaultCurrency, userKey: "john-doe"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() got = %v, want %v", got, want)
+	}
+}
+
+func TestVoucher_UpdateBalance(t *testing.T) {
+	account := &Voucher{
+		Balance:  usd(100),
+		Currency: DefaultCurrency,
+		userKey:  "jane-doe",
+	}
+
+	want := NewMoney(10590, DefaultCurrency)
+	got, err := account.UpdateBalance(NewMoney(590, DefaultCurrency))
 
 	if err != nil || got != want {
 		t.Errorf("UpdateBalance failed. Got: %v, want: %v", got, want)
 	}
+
+	if _, err = account.UpdateBalance(NewMoney(590, "EUR")); err == nil || account.Balance != want {
+		t.Errorf("UpdateBalance() in another currency = %v, %v", account.Balance, err)
+	}
+
+	if _, err = account.UpdateBalance(usd(-106)); !errors.Is(err, ErrInsufficientFunds) || account.Balance != want {
+		t.Errorf("UpdateBalance() below zero = %v, %v, want %v", account.Balance, err, ErrInsufficientFunds)
+	}
+}
+
+func TestVoucher_Debit(t *testing.T) {
+	tests := []struct {
+		name        string
+		amount      Money
+		wantBalance Money
+		wantErr     error
+	}{
+		{name: "debits the amount", amount: usd(40), wantBalance: usd(60)},
+		{name: "debits the whole balance", amount: usd(100), wantBalance: usd(0)},
+		{name: "fails when funds are insufficient", amount: NewMoney(10001, DefaultCurrency), wantBalance: usd(100), wantErr: ErrInsufficientFunds},
+		{name: "fails for amounts that are not positive", amount: usd(0), wantBalance: usd(100), wantErr: ErrInvalidAmount},
+		{name: "fails for amounts in another currency", amount: NewMoney(100, "EUR"), wantBalance: usd(100), wantErr: ErrCurrencyMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account := &Voucher{Balance: usd(100), Currency: DefaultCurrency, userKey: "jane-doe"}
+
+			got, err := account.Debit(tt.amount)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Debit() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if got != tt.wantBalance || account.Balance != tt.wantBalance {
+				t.Errorf("Debit() = %v, balance %v, want %v", got, account.Balance, tt.wantBalance)
+			}
+		})
+	}
 }
 
 func getVoucherTestDB() map[string]*Voucher {
 	return map[string]*Voucher{
 		"jane-doe-usd": &Voucher{
-			Balance:  100,
+			Balance:  usd(100),
 			Currency: DefaultCurrency,
 			userKey:  "jane-doe",
 		},
 		"john-doe-usd": &Voucher{
-			Balance:  200,
+			Balance:  usd(200),
 			Currency: DefaultCurrency,
 			userKey:  "john-doe",
 		},
 	}
 }
+
+func TestVoucher_JSON(t *testing.T) {
+	expiry := day(30)
+	want := Voucher{Balance: usd(100), Currency: DefaultCurrency, userKey: "jane-doe", Lots: []CreditLot{
+		{Amount: usd(40), IssuedAt: day(0), ExpiresAt: &expiry},
+	}}
+
+	b, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("MarshalJSON() error = %v", err)
+	}
+
+	var got Voucher
+	if err = json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("UnmarshalJSON() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON round trip failed. want: %v, got: %v", want, got)
+	}
+}
+
+// day returns the given day of 2020 in UTC
+func day(n int) time.Time {
+	return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, n)
+}
+
+// lotsVoucher returns an account with $10 untracked, a $20 lot expiring on day 10 and a $30 lot
+// that never expires
+func lotsVoucher(t *testing.T) *Voucher {
+	v := &Voucher{Balance: usd(10), Currency: DefaultCurrency, userKey: "jane-doe"}
+
+	expiry := day(10)
+	if _, err := v.Credit(usd(20), day(1), &expiry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Credit(usd(30), day(2), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return v
+}
+
+func TestVoucher_Credit(t *testing.T) {
+	v := lotsVoucher(t)
+	if v.Balance != usd(60) || len(v.Lots) != 2 || v.Lots[0].Amount != usd(20) || !v.Lots[0].ExpiresAt.Equal(day(10)) || v.Lots[1].ExpiresAt != nil {
+		t.Fatalf("Credit() = %v, %+v, want two lots on top of $10", v.Balance, v.Lots)
+	}
+
+	past := day(2)
+	tests := []struct {
+		name      string
+		amount    Money
+		expiresAt *time.Time
+		wantErr   error
+	}{
+		{name: "fails for amounts that are not positive", amount: usd(0), wantErr: ErrInvalidAmount},
+		{name: "fails for amounts in another currency", amount: NewMoney(100, "EUR"), wantErr: ErrCurrencyMismatch},
+		{name: "fails for expiries before the issue time", amount: usd(1), expiresAt: &past, wantErr: ErrInvalidExpiry},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := lotsVoucher(t)
+			if _, err := v.Credit(tt.amount, day(3), tt.expiresAt); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Credit() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if v.Balance != usd(60) || len(v.Lots) != 2 {
+				t.Errorf("Credit() changed the account to %v, %+v", v.Balance, v.Lots)
+			}
+		})
+	}
+}
+
+func TestVoucher_Spend(t *testing.T) {
+	tests := []struct {
+		name      string
+		amount    Money
+		wantParts []Money // taken from the untracked balance and the lots in order
+		wantLots  []Money
+		wantErr   error
+	}{
+		{name: "spends the untracked balance first", amount: usd(5), wantParts: []Money{usd(5)}, wantLots: []Money{usd(20), usd(30)}},
+		{name: "spends the oldest lot next", amount: usd(25), wantParts: []Money{usd(10), usd(15)}, wantLots: []Money{usd(5), usd(30)}},
+		{name: "drops the spent lots", amount: usd(45), wantParts: []Money{usd(10), usd(20), usd(15)}, wantLots: []Money{usd(15)}},
+		{name: "spends the whole balance", amount: usd(60), wantParts: []Money{usd(10), usd(20), usd(30)}, wantLots: []Money{}},
+		{name: "fails when funds are insufficient", amount: usd(61), wantLots: []Money{usd(20), usd(30)}, wantErr: ErrInsufficientFunds},
+		{name: "fails for amounts that are not positive", amount: usd(-1), wantLots: []Money{usd(20), usd(30)}, wantErr: ErrInvalidAmount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := lotsVoucher(t)
+
+			parts, err := v.Spend(tt.amount)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Spend() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if len(parts) != len(tt.wantParts) {
+				t.Fatalf("Spend() = %+v, want %v", parts, tt.wantParts)
+			}
+			for i, p := range parts {
+				if p.Amount != tt.wantParts[i] {
+					t.Errorf("Spend() part %d = %v, want %v", i, p.Amount, tt.wantParts[i])
+				}
+			}
+
+			balance := usd(0)
+			if len(v.Lots) != len(tt.wantLots) {
+				t.Fatalf("lots = %+v, want %v", v.Lots, tt.wantLots)
+			}
+			for i, l := range v.Lots {
+				if l.Amount != tt.wantLots[i] {
+					t.Errorf("lot %d = %v, want %v", i, l.Amount, tt.wantLots[i])
+				}
+				balance, _ = balance.Add(l.Amount)
+			}
+
+			if v.untracked().IsNegative() || balance.Amount > v.Balance.Amount {
+				t.Errorf("balance %v is below the lots %v", v.Balance, balance)
+			}
+		})
+	}
+}
+
+func TestVoucher_Restore(t *testing.T) {
+	v := lotsVoucher(t)
+	parts, err := v.Spend(usd(25))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := v.Restore(parts); err != nil || got != usd(60) {
+		t.Fatalf("Restore() = %v, %v, want %v", got, err, usd(60))
+	}
+
+	if v.untracked() != usd(10) || len(v.Lots) != 3 || v.Lots[0].Amount != usd(15) || !v.Lots[0].ExpiresAt.Equal(day(10)) {
+		t.Errorf("Restore() left untracked %v and lots %+v, want the spent parts back first", v.untracked(), v.Lots)
+	}
+
+	if _, err = v.Restore([]CreditLot{{Amount: NewMoney(100, "EUR")}}); !errors.Is(err, ErrCurrencyMismatch) || v.Balance != usd(60) {
+		t.Errorf("Restore() in another currency = %v, %v, want %v", v.Balance, err, ErrCurrencyMismatch)
+	}
+}
+
+func TestVoucher_Expire(t *testing.T) {
+	tests := []struct {
+		name        string
+		at          time.Time
+		wantExpired Money
+		wantBalance Money
+	}{
+		{name: "keeps lots before their expiry", at: day(9), wantExpired: usd(0), wantBalance: usd(60)},
+		{name: "expires lots at their expiry", at: day(10), wantExpired: usd(20), wantBalance: usd(40)},
+		{name: "never expires the other lots", at: day(1000), wantExpired: usd(20), wantBalance: usd(40)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := lotsVoucher(t)
+
+			if got := v.HasExpired(tt.at); got != !tt.wantExpired.IsZero() {
+				t.Errorf("HasExpired() = %v", got)
+			}
+
+			if got := v.Expire(tt.at); got != tt.wantExpired || v.Balance != tt.wantBalance {
+				t.Errorf("Expire() = %v, balance %v, want %v, %v", got, v.Balance, tt.wantExpired, tt.wantBalance)
+			}
+
+			if v.HasExpired(tt.at) {
+				t.Errorf("lots %+v are left expired", v.Lots)
+			}
+		})
+	}
+}
+
+func TestVoucherHandler_copiesLots(t *testing.T) {
+	ctx := context.Background()
+	vh := NewVoucherHandler()
+	v := lotsVoucher(t)
+	if err := vh.Put(ctx, "jane-doe-usd", v); err != nil {
+		t.Fatal(err)
+	}
+
+	v.Lots[0].Amount = usd(1)
+	got, _ := vh.Get(ctx, "jane-doe-usd")
+	got.Spend(usd(20))
+
+	if stored, _ := vh.Get(ctx, "jane-doe-usd"); stored.Balance != usd(60) || stored.Lots[0].Amount != usd(20) {
+		t.Errorf("stored account = %v, %+v, want the lots as they were put", stored.Balance, stored.Lots)
+	}
+}
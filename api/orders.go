@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+// ordersResponse is a page of the orders matching the filters of the request
+type ordersResponse struct {
+	Orders []*model.Order `json:"orders"`
+	Total  int            `json:"total"` // number of matching orders on every page
+	Offset int            `json:"offset"`
+	Limit  int            `json:"limit"`
+}
+
+// createOrderRequest is the body of a new order. The order is added to the orders of the
+// user with the given key, if there is one.
+type createOrderRequest struct {
+	model.Order
+	UserKey string `json:"UserKey,omitempty"`
+}
+
+// listOrdersHandler returns a page of the orders ordered by ID, filtered by the query
+// parameters read by orderFilterFromRequest. Only support and admin actors can list the orders.
+func (s *Server) listOrdersHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if _, ok := s.staffFromRequest(w, r); !ok {
+		return
+	}
+
+	orders, err := s.ord.List(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.writeOrders(w, r, orders)
+}
+
+// userOrdersHandler returns a page of the orders of the user in the order of User.Orders,
+// filtered like listOrdersHandler. Orders that no longer exist are left out.
+// Users can only see their own orders.
+func (s *Server) userOrdersHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	userKey := ps.ByName("userKey")
+
+	actor, err := actorFromRequest(r, userKey)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %s", errInvalidRequest, err))
+		return
+	}
+
+	if !actor.canActFor(userKey) {
+		writeError(w, ErrActorNotAllowed)
+		return
+	}
+
+	user, err := s.usr.Get(r.Context(), userKey)
+	if errors.Is(err, model.ErrUserNotFound) {
+		err = fmt.Errorf("%w: %s", model.ErrUserNotFound, userKey)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	orders := make([]*model.Order, 0, len(user.Orders))
+	for _, id := range user.Orders {
+		order, err := s.ord.Get(r.Context(), strconv.Itoa(id))
+		if errors.Is(err, model.ErrOrderNotFound) {
+			continue
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		orders = append(orders, order)
+	}
+
+	s.writeOrders(w, r, orders)
+}
+
+// orderHandler returns the order with the given ID. Customers can only see their own orders,
+// the orders of others are not found for them so they cannot tell which orders exist.
+// Requests without an actor key are invalid.
+func (s *Server) orderHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	orderID := ps.ByName("orderID")
+
+	actor, err := actorFromRequest(r, "")
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %s", errInvalidRequest, err))
+		return
+	}
+
+	if actor.Key == "" {
+		writeError(w, fmt.Errorf("%w: %s header is missing", errInvalidRequest, headerActorKey))
+		return
+	}
+
+	order, err := s.ord.Get(r.Context(), orderID)
+	if err == nil && !actor.canOverrideOwnership() {
+		user, uerr := s.usr.Get(r.Context(), actor.Key)
+		if uerr != nil || !user.Owns(order.ID) {
+			err = model.ErrOrderNotFound
+		}
+	}
+	if errors.Is(err, model.ErrOrderNotFound) {
+		err = fmt.Errorf("%w: %s", model.ErrOrderNotFound, orderID)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, order, "order")
+}
+
+// createOrderHandler adds the order in the body. Orders without an ID get the next free ID and
+// orders with a breakdown but no total get the total of the breakdown. New orders cannot be
+// refunded yet. Only support and admin actors can create orders.
+func (s *Server) createOrderHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	actor, ok := s.staffFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var req createOrderRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, fmt.Errorf("%w: body is not a valid order\n%s", errInvalidRequest, err))
+		return
+	}
+
+	order := &req.Order
+	if !order.Refunded.IsZero() || order.IsDeleted {
+		writeError(w, fmt.Errorf("%w: new orders cannot be refunded", model.ErrInvalidOrder))
+		return
+	}
+
+	if order.Total.IsZero() && order.HasBreakdown() {
+		if err := order.DeriveTotal(); err != nil {
+			writeError(w, fmt.Errorf("%w: %s", model.ErrInvalidOrder, err))
+			return
+		}
+	}
+	order.Refunded = model.NewMoney(0, order.Total.Currency)
+
+	// new IDs are picked from the existing orders, so orders are created one at a time
+	locks := []string{"orders"}
+	if req.UserKey != "" {
+		locks = append(locks, "user:"+req.UserKey)
+	}
+	unlock := s.locks.Lock(locks...)
+	defer unlock()
+
+	err := s.uow.Do(r.Context(), func(ctx context.Context, st model.Stores) error {
+		if order.ID == 0 {
+			id, err := nextOrderID(ctx, st.Orders)
+			if err != nil {
+				return err
+			}
+			order.ID = id
+		}
+
+		if err := order.Validate(); err != nil {
+			return err
+		}
+
+		orderID := strconv.Itoa(order.ID)
+		_, err := st.Orders.Get(ctx, orderID)
+		if err == nil {
+			return fmt.Errorf("%w: %s", model.ErrOrderExists, orderID)
+		}
+		if !errors.Is(err, model.ErrOrderNotFound) {
+			return err
+		}
+
+		if err = st.Orders.Put(ctx, orderID, order); err != nil {
+			return err
+		}
+
+		if req.UserKey == "" {
+			return nil
+		}
+
+		user, err := st.Users.Get(ctx, req.UserKey)
+		if errors.Is(err, model.ErrUserNotFound) {
+			return fmt.Errorf("%w: %s", model.ErrUserNotFound, req.UserKey)
+		}
+		if err != nil {
+			return err
+		}
+
+		user.Orders = append(user.Orders, order.ID)
+		if err = st.Users.Put(ctx, req.UserKey, user); err != nil {
+			return err
+		}
+
+		e := userAuditEntry(actor, model.AuditUserUpdated, req.UserKey, "Orders", s.clock.Now())
+		e.OrderID = orderID
+
+		return st.Audit.Append(ctx, e)
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/orders/"+strconv.Itoa(order.ID))
+	writeJSON(w, http.StatusCreated, order, "order")
+}
+
+// writeOrders writes the page of the orders matching the filters of the request
+func (s *Server) writeOrders(w http.ResponseWriter, r *http.Request, orders []*model.Order) {
+	filter, err := orderFilterFromRequest(r)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %s", errInvalidRequest, err))
+		return
+	}
+
+	offset, limit, err := pageFromRequest(r)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %s", errInvalidRequest, err))
+		return
+	}
+
+	matching := make([]*model.Order, 0, len(orders))
+	for _, o := range orders {
+		ok, err := filter.Match(r.Context(), s.rates, o)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if ok {
+			matching = append(matching, o)
+		}
+	}
+
+	from, to := pageBounds(len(matching), offset, limit)
+	res := ordersResponse{Orders: matching[from:to], Total: len(matching), Offset: offset, Limit: limit}
+	writeJSON(w, http.StatusOK, &res, "orders")
+}
+
+// orderFilterFromRequest reads the filters of the order lists:
+//
+//	payment_way  name in model.PaymentWayNames, e.g. credit_card
+//	zone         name in model.ZoneNames, e.g. mena
+//	refunded     none, partial or full
+//	min_total    inclusive lower bound of the total, e.g. 50.00
+//	max_total    inclusive upper bound of the total
+//	currency     currency of the bounds, model.DefaultCurrency by default
+func orderFilterFromRequest(r *http.Request) (model.OrderFilter, error) {
+	q := r.URL.Query()
+	var f model.OrderFilter
+
+	if v := q.Get("payment_way"); v != "" {
+		id, ok := model.PaymentWayNames[strings.ToLower(v)]
+		if !ok {
+			return f, fmt.Errorf("unknown payment way: %s", v)
+		}
+		f.PaymentWay = id
+	}
+
+	if v := q.Get("zone"); v != "" {
+		id, ok := model.ZoneNames[strings.ToLower(v)]
+		if !ok {
+			return f, fmt.Errorf("unknown zone: %s", v)
+		}
+		f.Zone = id
+	}
+
+	switch v := strings.ToLower(q.Get("refunded")); v {
+	case "", model.OrderNotRefunded, model.OrderPartiallyRefunded, model.OrderRefunded:
+		f.RefundStatus = v
+	default:
+		return f, fmt.Errorf("refunded must be one of none, partial or full: %s", v)
+	}
+
+	currency := q.Get("currency")
+	if currency == "" {
+		currency = model.DefaultCurrency
+	}
+
+	for _, b := range []struct {
+		param string
+		bound **model.Money
+	}{{"min_total", &f.MinTotal}, {"max_total", &f.MaxTotal}} {
+		v := q.Get(b.param)
+		if v == "" {
+			continue
+		}
+
+		m, err := model.ParseMoney(v, currency)
+		if err != nil {
+			return f, fmt.Errorf("%s: %s", b.param, err)
+		}
+		*b.bound = &m
+	}
+
+	if f.MinTotal != nil && f.MaxTotal != nil && f.MinTotal.Amount > f.MaxTotal.Amount {
+		return f, fmt.Errorf("min_total %v is more than max_total %v", f.MinTotal, f.MaxTotal)
+	}
+
+	return f, nil
+}
+
+// nextOrderID returns the ID after the highest ID of the orders
+func nextOrderID(ctx context.Context, orders model.OrderStore) (int, error) {
+	all, err := orders.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	next := 1
+	for _, o := range all {
+		if o.ID >= next {
+			next = o.ID + 1
+		}
+	}
+
+	return next, nil
+}
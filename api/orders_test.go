@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+// orderIDs returns the IDs of the orders in the response
+func orderIDs(t *testing.T, body []byte) ([]int, int) {
+	var res ordersResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []int{}
+	for _, o := range res.Orders {
+		ids = append(ids, o.ID)
+	}
+
+	return ids, res.Total
+}
+
+func equalIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func Test_listOrdersHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		role      string
+		wantCode  int
+		wantIDs   []int
+		wantTotal int
+	}{
+		{name: "returns every order", role: RoleSupport, wantCode: http.StatusOK, wantIDs: []int{1, 2, 3, 4}, wantTotal: 4},
+		{name: "filters by payment way and zone", query: "?payment_way=cash_on_delivery&zone=MENA", role: RoleAdmin, wantCode: http.StatusOK, wantIDs: []int{2, 3, 4}, wantTotal: 3},
+		{name: "filters by refund status", query: "?refunded=full", role: RoleSupport, wantCode: http.StatusOK, wantIDs: []int{2}, wantTotal: 1},
+		{name: "filters by total range", query: "?min_total=150&max_total=200", role: RoleSupport, wantCode: http.StatusOK, wantIDs: []int{2, 4}, wantTotal: 2},
+		{name: "converts totals into the currency of the range", query: "?min_total=1000&currency=AED", role: RoleSupport, wantCode: http.StatusOK, wantIDs: []int{3}, wantTotal: 1},
+		{name: "pages the matching orders", query: "?zone=mena&offset=1&limit=1", role: RoleSupport, wantCode: http.StatusOK, wantIDs: []int{3}, wantTotal: 3},
+		{name: "returns bad request for unknown zones", query: "?zone=asia", role: RoleSupport, wantCode: http.StatusBadRequest},
+		{name: "returns bad request for unknown refund statuses", query: "?refunded=yes", role: RoleSupport, wantCode: http.StatusBadRequest},
+		{name: "returns bad request for inverted total ranges", query: "?min_total=20&max_total=10", role: RoleSupport, wantCode: http.StatusBadRequest},
+		{name: "returns forbidden for customers", role: RoleCustomer, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			rates, _ := model.NewStaticRates(model.DefaultCurrency, map[string]string{"AED": "3.6725"})
+			srv.rates = rates
+
+			rr := serveAs(srv, http.MethodGet, "/orders"+tt.query, "", "agent-1", tt.role)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("listOrdersHandler() status = %d, want %d\n%s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			if ids, total := orderIDs(t, rr.Body.Bytes()); !equalIDs(ids, tt.wantIDs) || total != tt.wantTotal {
+				t.Errorf("listOrdersHandler() = %v of %d, want %v of %d", ids, total, tt.wantIDs, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func Test_userOrdersHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		userKey  string
+		query    string
+		actor    string
+		role     string
+		wantCode int
+		wantIDs  []int
+	}{
+		{name: "returns the orders of the user", userKey: "john-doe", wantCode: http.StatusOK, wantIDs: []int{1, 2, 3}},
+		{name: "filters the orders of the user", userKey: "john-doe", query: "?refunded=none", actor: "agent-1", role: RoleSupport, wantCode: http.StatusOK, wantIDs: []int{1, 3}},
+		{name: "leaves out orders that do not exist", userKey: "jane-doe", wantCode: http.StatusOK, wantIDs: []int{4}},
+		{name: "returns forbidden to other users", userKey: "john-doe", actor: "jane-doe", wantCode: http.StatusForbidden},
+		{name: "returns not found for unknown users", userKey: "eric-smith", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			ctx := context.Background()
+
+			jane, _ := srv.usr.Get(ctx, "jane-doe")
+			jane.Orders = append(jane.Orders, 987)
+			srv.usr.Put(ctx, "jane-doe", jane)
+
+			rr := serveAs(srv, http.MethodGet, "/users/"+tt.userKey+"/orders"+tt.query, "", tt.actor, tt.role)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("userOrdersHandler() status = %d, want %d\n%s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			if ids, _ := orderIDs(t, rr.Body.Bytes()); !equalIDs(ids, tt.wantIDs) {
+				t.Errorf("userOrdersHandler() = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func Test_orderHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		orderID  string
+		actor    string
+		role     string
+		wantCode int
+	}{
+		{name: "returns the order to its owner", orderID: "1", actor: "john-doe", wantCode: http.StatusOK},
+		{name: "returns the order to staff", orderID: "1", actor: "agent-1", role: RoleSupport, wantCode: http.StatusOK},
+		{name: "returns not found to other users", orderID: "1", actor: "jane-doe", wantCode: http.StatusNotFound},
+		{name: "returns bad request without an actor", orderID: "1", wantCode: http.StatusBadRequest},
+		{name: "returns bad request without an actor for unknown orders", orderID: "987", wantCode: http.StatusBadRequest},
+		{name: "returns not found for unknown orders", orderID: "987", actor: "agent-1", role: RoleSupport, wantCode: http.StatusNotFound},
+		{name: "returns not found to users for unknown orders", orderID: "987", actor: "jane-doe", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serveAs(newTestServer(), http.MethodGet, "/orders/"+tt.orderID, "", tt.actor, tt.role)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("orderHandler() status = %d, want %d\n%s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var got model.Order
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if got.ID != 1 || got.Total != usd(100) || got.PaymentRef != "card_1001" {
+				t.Errorf("orderHandler() = %+v, want order 1", got)
+			}
+		})
+	}
+}
+
+func Test_createOrderHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		role      string
+		wantCode  int
+		wantID    int
+		wantTotal model.Money
+	}{
+		{
+			name:      "creates the order with the next id",
+			body:      `{"Total": {"amount": "50.00", "currency": "USD"}, "PaymentWay": 1, "PaymentRef": "card_5", "CountryZone": 1}`,
+			role:      RoleSupport,
+			wantCode:  http.StatusCreated,
+			wantID:    5,
+			wantTotal: usd(50),
+		},
+		{
+			name:      "derives the total from the breakdown and adds the order to the user",
+			body:      `{"ID": 10, "Lines": [{"SKU": "A", "Quantity": 2, "UnitPrice": "20"}], "ShippingFee": "5", "PaymentWay": 3, "CountryZone": 3, "UserKey": "jane-doe"}`,
+			role:      RoleAdmin,
+			wantCode:  http.StatusCreated,
+			wantID:    10,
+			wantTotal: usd(45),
+		},
+		{name: "returns conflict for existing ids", body: `{"ID": 1, "Total": "10", "PaymentWay": 1, "CountryZone": 1}`, role: RoleSupport, wantCode: http.StatusConflict},
+		{name: "returns unprocessable entity for unknown payment ways", body: `{"Total": "10", "PaymentWay": 7, "CountryZone": 1}`, role: RoleSupport, wantCode: http.StatusUnprocessableEntity},
+		{name: "returns unprocessable entity for refunded orders", body: `{"Total": "10", "Refunded": "10", "PaymentWay": 1, "CountryZone": 1}`, role: RoleSupport, wantCode: http.StatusUnprocessableEntity},
+		{name: "returns not found for unknown users", body: `{"Total": "10", "PaymentWay": 1, "CountryZone": 1, "UserKey": "eric-smith"}`, role: RoleSupport, wantCode: http.StatusNotFound},
+		{name: "returns forbidden for customers", body: `{"Total": "10", "PaymentWay": 1, "CountryZone": 1}`, role: RoleCustomer, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			ctx := context.Background()
+
+			rr := serveAs(srv, http.MethodPost, "/orders", tt.body, "agent-1", tt.role)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("createOrderHandler() status = %d, want %d\n%s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			if tt.wantCode != http.StatusCreated {
+				if orders, _ := srv.ord.List(ctx); len(orders) != 4 {
+					t.Errorf("createOrderHandler() left %d orders, want 4", len(orders))
+				}
+				return
+			}
+
+			var got model.Order
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if got.ID != tt.wantID || got.Total != tt.wantTotal || got.RefundStatus() != model.OrderNotRefunded {
+				t.Errorf("createOrderHandler() = %+v, want order %d of %v", got, tt.wantID, tt.wantTotal)
+			}
+
+			if _, err := srv.ord.Get(ctx, rr.Header().Get("Location")[len("/orders/"):]); err != nil {
+				t.Errorf("created order at %s: %v", rr.Header().Get("Location"), err)
+			}
+
+			if jane, _ := srv.usr.Get(ctx, "jane-doe"); jane.Owns(tt.wantID) != (tt.wantID == 10) {
+				t.Errorf("orders of jane-doe = %v", jane.Orders)
+			}
+		})
+	}
+}
+
+func Test_orderHandler_hidesOtherOrders(t *testing.T) {
+	srv := newTestServer()
+
+	// order 4 belongs to jane-doe, order 987 does not exist
+	other := serveAs(srv, http.MethodGet, "/orders/4", "", "john-doe", "")
+	missing := serveAs(srv, http.MethodGet, "/orders/987", "", "john-doe", "")
+
+	strip := func(body string) string { return strings.Replace(body, "987", "4", 1) }
+	if other.Code != missing.Code || other.Body.String() != strip(missing.Body.String()) {
+		t.Errorf("orderHandler() of another user's order = %d %s, want the response for a missing order %d %s", other.Code, other.Body, missing.Code, missing.Body)
+	}
+}
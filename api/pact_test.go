@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/srgyrn/pact-example/api/model"
+	"github.com/srgyrn/pact-example/pact"
+)
+
+// defaultPactDir holds the contracts of every consumer of this API
+const defaultPactDir = "../pacts"
+
+func TestPactProvider(t *testing.T) {
+	dir := os.Getenv("PACT_DIR")
+	if dir == "" {
+		dir = defaultPactDir
+	}
+
+	files, err := pact.LoadDir(dir)
+	if err != nil {
+		t.Fatalf("cannot load pact files: %v", err)
+	}
+
+	if len(files) == 0 {
+		t.Skipf("no pact files found in %s", dir)
+	}
+
+	// contracts are verified against the rules the binary ships with
+	rules, err := model.OpenRefundRulesFile("./data/refund-rules.json")
+	if err != nil {
+		t.Fatalf("cannot load refund rules: %v", err)
+	}
+
+	srv := newTestServer()
+	srv.useRefundRules(rules)
+	verifier := &pact.Verifier{
+		Handler: srv.Router(false),
+		Setup: func(pact.Interaction) error {
+			return srv.loadFixtures()
+		},
+		StateHandler: func(s pact.ProviderState) error {
+			return providerStates.Setup(context.Background(), srv, s.Name, s.Params)
+		},
+	}
+
+	for _, f := range files {
+		t.Run(f.Consumer.Name, func(t *testing.T) {
+			for _, res := range verifier.VerifyFile(f) {
+				res := res
+				t.Run(res.Interaction.Description, func(t *testing.T) {
+					if res.Err != nil {
+						t.Fatalf("cannot verify interaction: %v", res.Err)
+					}
+
+					for _, m := range res.Mismatches {
+						t.Error(m)
+					}
+				})
+			}
+		})
+	}
+}
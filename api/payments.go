@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+// paymentRefundHandler returns the refund made through a payment gateway with the given ID and
+// its confirmation status. Users can only see their own refunds.
+func (s *Server) paymentRefundHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("refundID")
+
+	refund, err := s.prf.Get(r.Context(), id)
+	if errors.Is(err, model.ErrRefundNotFound) {
+		err = fmt.Errorf("%w: %s", model.ErrRefundNotFound, id)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	actor, err := actorFromRequest(r, refund.UserKey)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %s", errInvalidRequest, err))
+		return
+	}
+
+	if !actor.canActFor(refund.UserKey) {
+		writeError(w, ErrActorNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(refund); err != nil {
+		log.Printf("cannot write payment refund response: %s", err)
+	}
+}
+
+// watchPaymentRefunds syncs the payment refunds every interval until ctx is done
+func (s *Server) watchPaymentRefunds(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.storesMu.RLock()
+			err := s.syncPaymentRefunds(ctx)
+			s.storesMu.RUnlock()
+
+			if err != nil {
+				log.Printf("cannot sync payment refunds: %s", err)
+			}
+		}
+	}
+}
+
+// syncPaymentRefunds asks the gateways for the status of the pending payment refunds and saves
+// the confirmed ones. Failed refunds are reversed so their amount can be refunded again.
+// A refund that cannot be synced does not stop the others, the first error is returned.
+func (s *Server) syncPaymentRefunds(ctx context.Context) error {
+	refunds, err := s.prf.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	var first error
+	for _, refund := range refunds {
+		if refund.Status != model.PaymentRefundPending {
+			continue
+		}
+
+		if err = s.syncPaymentRefund(ctx, refund); err != nil && first == nil {
+			first = fmt.Errorf("refund %s: %w", refund.ID, err)
+		}
+	}
+
+	return first
+}
+
+// syncPaymentRefund saves the status the gateway reports for the refund if it is confirmed.
+// Refunds the gateway has not accepted yet, e.g. because it could not be reached, are sent again.
+func (s *Server) syncPaymentRefund(ctx context.Context, refund *model.PaymentRefund) error {
+	gateway, ok := s.gateways[refund.PaymentWay]
+	if !ok {
+		return fmt.Errorf("%w: no payment gateway for payment way %d", model.ErrPaymentNotRefundable, refund.PaymentWay)
+	}
+
+	if refund.GatewayRef == "" {
+		unlock := s.locks.Lock("order:"+refund.OrderID, "user:"+refund.UserKey)
+		defer unlock()
+
+		return s.submitPaymentRefund(ctx, refund.ID)
+	}
+
+	status, err := gateway.RefundStatus(ctx, refund.GatewayRef)
+	if err != nil || status == model.PaymentRefundPending {
+		return err
+	}
+
+	unlock := s.locks.Lock("order:"+refund.OrderID, "user:"+refund.UserKey)
+	defer unlock()
+
+	return s.updatePaymentRefund(ctx, refund.ID, status, "")
+}
+
+// submitPaymentRefund asks the gateway of the saved pending refund to make it and saves the
+// reference the gateway returns. The gateway is called outside of any unit of work, so the
+// stores are not held during the call. Refunds the gateway declines are reversed and
+// ErrPaymentDeclined is returned. Refunds already accepted by the gateway are left as they are.
+// The caller holds the locks of the order and the user of the refund.
+func (s *Server) submitPaymentRefund(ctx context.Context, id string) error {
+	refund, err := s.prf.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if refund.Status != model.PaymentRefundPending || refund.GatewayRef != "" {
+		return nil
+	}
+
+	gateway, ok := s.gateways[refund.PaymentWay]
+	if !ok {
+		return fmt.Errorf("%w: no payment gateway for payment way %d", model.ErrPaymentNotRefundable, refund.PaymentWay)
+	}
+
+	ref, err := gateway.Refund(ctx, refund.ID, refund.PaymentRef, refund.Amount)
+	if errors.Is(err, model.ErrPaymentDeclined) {
+		if uerr := s.updatePaymentRefund(ctx, id, model.PaymentRefundFailed, ""); uerr != nil {
+			return uerr
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.updatePaymentRefund(ctx, id, model.PaymentRefundPending, ref)
+}
+
+// updatePaymentRefund saves the status of the pending refund and the reference of the refund at
+// the gateway if it is not empty. Failed refunds are reversed so their amount can be refunded again.
+// The caller holds the locks of the order and the user of the refund.
+func (s *Server) updatePaymentRefund(ctx context.Context, id, status, gatewayRef string) error {
+	return s.uow.Do(ctx, func(ctx context.Context, st model.Stores) error {
+		refund, err := st.PaymentRefunds.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		// synced by someone else since it was listed
+		if refund.Status != model.PaymentRefundPending {
+			return nil
+		}
+
+		if gatewayRef != "" {
+			refund.GatewayRef = gatewayRef
+		}
+
+		now := s.clock.Now()
+		refund.Status, refund.UpdatedAt = status, now
+		if err = st.PaymentRefunds.Put(ctx, refund.ID, refund); err != nil {
+			return err
+		}
+
+		if status != model.PaymentRefundFailed {
+			return nil
+		}
+
+		order, err := st.Orders.Get(ctx, refund.OrderID)
+		if err != nil {
+			return err
+		}
+
+		if err = order.ReverseRefund(refund.Amount); err != nil {
+			return err
+		}
+
+		if err = order.ReverseRefundLines(refund.Lines); err != nil {
+			return err
+		}
+
+		if err = st.Orders.Put(ctx, refund.OrderID, order); err != nil {
+			return err
+		}
+
+		e := newLedgerEntry(systemActor, refund.UserKey, model.PaymentAccount(refund.OrderID), refund.Amount.Neg(), model.LedgerRefundReversal, refund.OrderID, now)
+		e.RefundID = refund.ID
+
+		return st.Ledger.Append(ctx, e)
+	})
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+// newPaymentTestServer returns a test server that refunds every order to its payment method
+func newPaymentTestServer(t *testing.T) *Server {
+	srv := newTestServer()
+
+	rules, err := model.NewRefundRules(model.DestinationOriginalPayment, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.useRefundRules(rules)
+
+	return srv
+}
+
+// setPaymentRef changes the payment reference of the order
+func setPaymentRef(t *testing.T, srv *Server, orderID, ref string) {
+	ctx := context.Background()
+
+	order, err := srv.ord.Get(ctx, orderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order.PaymentRef = ref
+	if err = srv.ord.Put(ctx, orderID, order); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_refund_toPaymentMethod(t *testing.T) {
+	tests := []struct {
+		name         string
+		orderID      string
+		paymentRef   string
+		wantErr      error
+		wantStatus   string
+		wantRefunded model.Money
+		wantPayment  model.Money // balance of the payment account after the sync
+	}{
+		{
+			name:         "confirms the refund",
+			orderID:      "1",
+			paymentRef:   "card_1001",
+			wantStatus:   model.PaymentRefundSucceeded,
+			wantRefunded: usd(100),
+			wantPayment:  usd(100),
+		},
+		{
+			name:         "reverses a failed refund",
+			orderID:      "1",
+			paymentRef:   "card_fail",
+			wantStatus:   model.PaymentRefundFailed,
+			wantRefunded: usd(0),
+			wantPayment:  usd(0),
+		},
+		{
+			name:       "fails when the gateway declines the refund",
+			orderID:    "1",
+			paymentRef: "card_decline",
+			wantErr:    model.ErrPaymentDeclined,
+		},
+		{
+			name:       "fails when the order has no payment reference",
+			orderID:    "1",
+			paymentRef: "",
+			wantErr:    model.ErrPaymentNotRefundable,
+		},
+		{
+			name:       "fails when the payment way has no gateway",
+			orderID:    "3",
+			paymentRef: "cod_3",
+			wantErr:    model.ErrPaymentNotRefundable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newPaymentTestServer(t)
+			ctx := context.Background()
+			setPaymentRef(t, srv, tt.orderID, tt.paymentRef)
+
+			res, err := srv.refund(ctx, refundRequest{UserKey: "john-doe", OrderID: tt.orderID})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("refund() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				order, _ := srv.ord.Get(ctx, tt.orderID)
+				if !order.Refunded.IsZero() {
+					t.Errorf("refund() left order %v", order)
+				}
+
+				// refunds declined by the gateway are kept as failed
+				refunds, _ := srv.prf.List(ctx)
+				for _, refund := range refunds {
+					if refund.Status != model.PaymentRefundFailed {
+						t.Errorf("refund() left payment refund %+v", refund)
+					}
+				}
+
+				entries, _ := srv.ldg.List(ctx, "john-doe")
+				balances, _ := model.Balances(entries)
+				if got := balances[model.PaymentAccount(tt.orderID)]; !got.IsZero() {
+					t.Errorf("payment account balance = %v, want zero", got)
+				}
+				return
+			}
+
+			if res.Status != model.PaymentRefundPending || res.Destination != model.DestinationOriginalPayment {
+				t.Errorf("refund() = %+v, want a pending refund to the payment method", res)
+			}
+
+			if err = srv.syncPaymentRefunds(ctx); err != nil {
+				t.Fatalf("syncPaymentRefunds() error = %v", err)
+			}
+
+			refund, err := srv.prf.Get(ctx, res.RefundID)
+			if err != nil || refund.Status != tt.wantStatus {
+				t.Fatalf("payment refund = %v, %v, want status %s", refund, err, tt.wantStatus)
+			}
+
+			order, _ := srv.ord.Get(ctx, tt.orderID)
+			if order.Refunded != tt.wantRefunded {
+				t.Errorf("order refunded = %v, want %v", order.Refunded, tt.wantRefunded)
+			}
+
+			entries, _ := srv.ldg.List(ctx, "john-doe")
+			balances, _ := model.Balances(entries)
+			if got := balances[model.PaymentAccount(tt.orderID)]; got != tt.wantPayment {
+				t.Errorf("payment account balance = %v, want %v", got, tt.wantPayment)
+			}
+
+			user, _ := srv.usr.Get(ctx, "john-doe")
+			if user.Balance != usd(100) {
+				t.Errorf("refund() changed the balance of the user to %v", user.Balance)
+			}
+		})
+	}
+}
+
+func Test_syncPaymentRefunds_keepsPending(t *testing.T) {
+	srv := newPaymentTestServer(t)
+	srv.useGateways(map[int]model.PaymentGateway{model.CreditCard: model.NewFakeCardGateway(time.Hour)})
+	ctx := context.Background()
+
+	res, err := srv.refund(ctx, refundRequest{UserKey: "john-doe", OrderID: "1"})
+	if err != nil {
+		t.Fatalf("refund() error = %v", err)
+	}
+
+	if err = srv.syncPaymentRefunds(ctx); err != nil {
+		t.Fatalf("syncPaymentRefunds() error = %v", err)
+	}
+
+	if refund, _ := srv.prf.Get(ctx, res.RefundID); refund.Status != model.PaymentRefundPending {
+		t.Errorf("payment refund status = %s, want %s", refund.Status, model.PaymentRefundPending)
+	}
+}
+
+// unreachableGateway fails every refund until it is reachable, it checks the refund is
+// saved before the gateway is asked to make it
+type unreachableGateway struct {
+	*model.FakeGateway
+	t         *testing.T
+	refunds   model.PaymentRefundStore
+	reachable bool
+}
+
+func (g *unreachableGateway) Refund(ctx context.Context, refundID, paymentRef string, amount model.Money) (string, error) {
+	if _, err := g.refunds.Get(ctx, refundID); err != nil {
+		g.t.Errorf("Refund() of refund %s before it is saved: %v", refundID, err)
+	}
+
+	if !g.reachable {
+		return "", errors.New("connection refused")
+	}
+
+	return g.FakeGateway.Refund(ctx, refundID, paymentRef, amount)
+}
+
+func Test_refund_gatewayUnreachable(t *testing.T) {
+	srv := newPaymentTestServer(t)
+	gateway := &unreachableGateway{FakeGateway: model.NewFakeCardGateway(0), t: t, refunds: srv.prf}
+	srv.useGateways(map[int]model.PaymentGateway{model.CreditCard: gateway})
+	ctx := context.Background()
+
+	res, err := srv.refund(ctx, refundRequest{UserKey: "john-doe", OrderID: "1"})
+	if err != nil {
+		t.Fatalf("refund() error = %v", err)
+	}
+
+	refund, _ := srv.prf.Get(ctx, res.RefundID)
+	if res.Status != model.PaymentRefundPending || refund.Status != model.PaymentRefundPending || refund.GatewayRef != "" {
+		t.Fatalf("refund() = %+v, payment refund = %+v, want a pending refund the gateway has not accepted", res, refund)
+	}
+
+	gateway.reachable = true
+	if err = srv.syncPaymentRefunds(ctx); err != nil {
+		t.Fatalf("syncPaymentRefunds() error = %v", err)
+	}
+
+	if refund, _ = srv.prf.Get(ctx, res.RefundID); refund.GatewayRef == "" {
+		t.Fatalf("payment refund = %+v, want it sent to the gateway again", refund)
+	}
+
+	if err = srv.syncPaymentRefunds(ctx); err != nil {
+		t.Fatalf("syncPaymentRefunds() error = %v", err)
+	}
+
+	if refund, _ = srv.prf.Get(ctx, res.RefundID); refund.Status != model.PaymentRefundSucceeded {
+		t.Errorf("payment refund status = %s, want %s", refund.Status, model.PaymentRefundSucceeded)
+	}
+}
+
+func Test_paymentRefundHandler(t *testing.T) {
+	srv := newPaymentTestServer(t)
+	res, err := srv.refund(context.Background(), refundRequest{UserKey: "john-doe", OrderID: "1"})
+	if err != nil {
+		t.Fatalf("refund() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		refundID string
+		actor    string
+		role     string
+		wantCode int
+	}{
+		{name: "returns the refund to its user", refundID: res.RefundID, wantCode: http.StatusOK},
+		{name: "returns the refund to staff", refundID: res.RefundID, actor: "agent-1", role: RoleSupport, wantCode: http.StatusOK},
+		{name: "returns forbidden to other users", refundID: res.RefundID, actor: "jane-doe", wantCode: http.StatusForbidden},
+		{name: "returns not found for unknown refunds", refundID: "rf_unknown", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/refunds/"+tt.refundID, nil)
+			if tt.actor != "" {
+				req.Header.Set(headerActorKey, tt.actor)
+				req.Header.Set(headerActorRole, tt.role)
+			}
+			rr := httptest.NewRecorder()
+			srv.Router(false).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantCode {
+				t.Fatalf("paymentRefundHandler() status = %d, want %d\n%s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var got model.PaymentRefund
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if got.ID != res.RefundID || got.Status != model.PaymentRefundPending || got.Amount != usd(100) {
+				t.Errorf("paymentRefundHandler() = %+v, want the pending refund %s", got, res.RefundID)
+			}
+		})
+	}
+}
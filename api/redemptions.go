@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+// redemptionResult is the body returned when a voucher is redeemed or a redemption is reversed
+type redemptionResult struct {
+	RedemptionID string      `json:"redemption_id"`
+	VoucherKey   string      `json:"voucher_key"`
+	UserKey      string      `json:"user_key"`
+	Amount       model.Money `json:"amount"`
+	NewBalance   model.Money `json:"new_balance"` // balance of the voucher account after the change
+	CheckoutRef  string      `json:"checkout_ref,omitempty"`
+	Status       string      `json:"status"` // one of the model.Redemption statuses
+	CreatedAt    time.Time   `json:"created_at"`
+}
+
+// newRedemptionResult returns the result of a change to the redemption leaving the voucher
+// account with the given balance
+func newRedemptionResult(r *model.Redemption, balance model.Money) *redemptionResult {
+	return &redemptionResult{
+		RedemptionID: r.ID,
+		VoucherKey:   r.VoucherKey,
+		UserKey:      r.UserKey,
+		Amount:       r.Amount,
+		NewBalance:   balance,
+		CheckoutRef:  r.CheckoutRef,
+		Status:       r.Status,
+		CreatedAt:    r.CreatedAt,
+	}
+}
+
+// newRedemptionID returns a random ID for a redemption, e.g. rd_0f8fad5bd9cb469fa16570867728950e
+func newRedemptionID() (string, error) {
+	return randomID("rd_", "redemption")
+}
+
+// redeemHandler spends an amount of the voucher account with the given key at checkout.
+// Amounts without a currency are in the currency of the account. Users can only redeem
+// their own vouchers.
+func (s *Server) redeemHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key := ps.ByName("key")
+	body, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	postBody := struct {
+		Amount      json.RawMessage `json:"amount"`
+		CheckoutRef string          `json:"checkout_ref,omitempty"`
+	}{}
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		writeError(w, fmt.Errorf("%w: body is not valid JSON", errInvalidRequest))
+		return
+	}
+
+	if len(postBody.Amount) == 0 || string(postBody.Amount) == "null" {
+		writeError(w, fmt.Errorf("%w: amount is missing", errInvalidRequest))
+		return
+	}
+
+	account, err := s.vch.Get(r.Context(), key)
+	if errors.Is(err, model.ErrAccountNotFound) {
+		err = fmt.Errorf("%w: %s", model.ErrAccountNotFound, key)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	actor, err := actorFromRequest(r, account.UserKey())
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %s", errInvalidRequest, err))
+		return
+	}
+
+	res, err := s.redeem(withActor(r.Context(), actor), account.UserKey(), key, postBody.Amount, postBody.CheckoutRef)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, res, "redemption")
+}
+
+// redeem takes the amount off the voucher account of the user and records the redemption.
+// The vouchers of the user are locked so the balance check and the debit cannot interleave
+// with another change to the account. Expired credits are taken off first, the amount is
+// spent from the oldest lots. The debit, the redemption and its ledger entries are saved in
+// a unit of work. ErrInsufficientFunds is returned if the balance is too low.
+func (s *Server) redeem(ctx context.Context, userKey, key string, rawAmount json.RawMessage, checkoutRef string) (*redemptionResult, error) {
+	actor := actorFrom(ctx, userKey)
+	if !actor.canActFor(userKey) {
+		return nil, ErrActorNotAllowed
+	}
+
+	id, err := newRedemptionID()
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := s.locks.Lock(vouchersLock(userKey))
+	defer unlock()
+
+	var res *redemptionResult
+	err = s.uow.Do(ctx, func(ctx context.Context, st model.Stores) error {
+		account, err := st.Vouchers.Get(ctx, key)
+		if errors.Is(err, model.ErrAccountNotFound) {
+			return fmt.Errorf("%w: %s", model.ErrAccountNotFound, key)
+		}
+		if err != nil {
+			return err
+		}
+
+		// the account may have been replaced since its owner was read
+		if account.UserKey() != userKey {
+			return ErrActorNotAllowed
+		}
+
+		amount, err := model.UnmarshalMoney(rawAmount, account.Currency)
+		if err != nil {
+			return err
+		}
+
+		now := s.clock.Now()
+		if err = expireVoucher(ctx, st, account, key, now); err != nil {
+			return err
+		}
+
+		lots, err := account.Spend(amount)
+		if err != nil {
+			return err
+		}
+
+		if err = st.Vouchers.Put(ctx, key, account); err != nil {
+			return err
+		}
+
+		redemption := &model.Redemption{
+			ID:          id,
+			VoucherKey:  key,
+			UserKey:     userKey,
+			Amount:      amount,
+			CheckoutRef: checkoutRef,
+			Status:      model.RedemptionRedeemed,
+			CreatedAt:   now,
+			Lots:        lots,
+		}
+		if err = st.Redemptions.Put(ctx, id, redemption); err != nil {
+			return err
+		}
+
+		e := newLedgerEntry(actor, userKey, model.VoucherAccount(key), amount.Neg(), model.LedgerRedemption, "", now)
+		e.RedemptionID = id
+
+		res = newRedemptionResult(redemption, account.Balance)
+		return st.Ledger.Append(ctx, e)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// redemptionHandler returns the redemption with the given ID. Users can only see their own redemptions.
+func (s *Server) redemptionHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	redemption, ok := s.redemptionFromRequest(w, r, ps)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, redemption, "redemption")
+}
+
+// reverseRedemptionHandler puts the amount of the redemption with the given ID back on its
+// voucher account, e.g. when the checkout is cancelled. A redemption is reversed only once.
+// Users can only reverse their own redemptions.
+func (s *Server) reverseRedemptionHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	redemption, ok := s.redemptionFromRequest(w, r, ps)
+	if !ok {
+		return
+	}
+
+	actor, err := actorFromRequest(r, redemption.UserKey)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %s", errInvalidRequest, err))
+		return
+	}
+
+	res, err := s.reverseRedemption(withActor(r.Context(), actor), redemption.UserKey, redemption.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res, "redemption")
+}
+
+// reverseRedemption puts the lots the redemption took back on its voucher account and marks
+// the redemption as reversed in a unit of work, the ledger records the reversal. Lots that
+// expired since the redemption are expired again. ErrRedemptionReversed is returned if the
+// redemption is already reversed. Users can only reverse their own redemptions.
+func (s *Server) reverseRedemption(ctx context.Context, userKey, id string) (*redemptionResult, error) {
+	actor := actorFrom(ctx, userKey)
+	if !actor.canActFor(userKey) {
+		return nil, ErrActorNotAllowed
+	}
+
+	unlock := s.locks.Lock(vouchersLock(userKey))
+	defer unlock()
+
+	var res *redemptionResult
+	err := s.uow.Do(ctx, func(ctx context.Context, st model.Stores) error {
+		redemption, err := st.Redemptions.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if redemption.UserKey != userKey {
+			return ErrActorNotAllowed
+		}
+
+		now := s.clock.Now()
+		if err = redemption.Reverse(now); err != nil {
+			return fmt.Errorf("%w: %s", err, id)
+		}
+
+		account, err := st.Vouchers.Get(ctx, redemption.VoucherKey)
+		if errors.Is(err, model.ErrAccountNotFound) {
+			return fmt.Errorf("%w: %s", model.ErrAccountNotFound, redemption.VoucherKey)
+		}
+		if err != nil {
+			return err
+		}
+
+		// redemptions made before voucher accounts had lots only know their amount
+		if len(redemption.Lots) > 0 {
+			_, err = account.Restore(redemption.Lots)
+		} else {
+			_, err = account.UpdateBalance(redemption.Amount)
+		}
+		if err != nil {
+			return err
+		}
+
+		e := newLedgerEntry(actor, userKey, model.VoucherAccount(redemption.VoucherKey), redemption.Amount, model.LedgerRedemptionReversal, "", now)
+		e.RedemptionID = id
+		if err = st.Ledger.Append(ctx, e); err != nil {
+			return err
+		}
+
+		// restored lots that expired since the redemption are taken off again
+		if err = expireVoucher(ctx, st, account, redemption.VoucherKey, now); err != nil {
+			return err
+		}
+
+		if err = st.Vouchers.Put(ctx, redemption.VoucherKey, account); err != nil {
+			return err
+		}
+
+		res = newRedemptionResult(redemption, account.Balance)
+		return st.Redemptions.Put(ctx, id, redemption)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// redemptionFromRequest returns the redemption with the ID in the path if the actor of the
+// request may act for its user. Otherwise the error is written and false is returned.
+func (s *Server) redemptionFromRequest(w http.ResponseWriter, r *http.Request, ps httprouter.Params) (*model.Redemption, bool) {
+	id := ps.ByName("redemptionID")
+
+	redemption, err := s.rdm.Get(r.Context(), id)
+	if errors.Is(err, model.ErrRedemptionNotFound) {
+		err = fmt.Errorf("%w: %s", model.ErrRedemptionNotFound, id)
+	}
+	if err != nil {
+		writeError(w, err)
+		return nil, false
+	}
+
+	actor, err := actorFromRequest(r, redemption.UserKey)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %s", errInvalidRequest, err))
+		return nil, false
+	}
+
+	if !actor.canActFor(redemption.UserKey) {
+		writeError(w, ErrActorNotAllowed)
+		return nil, false
+	}
+
+	return redemption, true
+}
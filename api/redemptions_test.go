@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+// redeemVoucher redeems the amount of the voucher account as its owner and returns the redemption
+func redeemVoucher(t *testing.T, srv *Server, key, amount string) redemptionResult {
+	rr := serveAs(srv, http.MethodPost, "/vouchers/"+key+"/redeem", `{"amount": "`+amount+`", "checkout_ref": "cart-1"}`, "", "")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("redeemHandler() status = %d, want %d\n%s", rr.Code, http.StatusCreated, rr.Body)
+	}
+
+	var res redemptionResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	return res
+}
+
+func Test_redeemHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		body        string
+		actor       string
+		role        string
+		wantCode    int
+		wantBalance model.Money
+	}{
+		{name: "redeems the amount", key: "john-doe-usd", body: `{"amount": "4", "checkout_ref": "cart-1"}`, wantCode: http.StatusCreated, wantBalance: usd(6)},
+		{name: "redeems the whole balance", key: "john-doe-usd", body: `{"amount": "10"}`, wantCode: http.StatusCreated, wantBalance: usd(0)},
+		{name: "redeems for staff", key: "john-doe-usd", body: `{"amount": "1"}`, actor: "agent-1", role: RoleSupport, wantCode: http.StatusCreated, wantBalance: usd(9)},
+		{name: "returns unprocessable entity when funds are insufficient", key: "john-doe-usd", body: `{"amount": "10.01"}`, wantCode: http.StatusUnprocessableEntity, wantBalance: usd(10)},
+		{name: "returns unprocessable entity for zero amounts", key: "john-doe-usd", body: `{"amount": "0"}`, wantCode: http.StatusUnprocessableEntity, wantBalance: usd(10)},
+		{name: "returns unprocessable entity for another currency", key: "john-doe-usd", body: `{"amount": {"amount": "1", "currency": "EUR"}}`, wantCode: http.StatusUnprocessableEntity, wantBalance: usd(10)},
+		{name: "returns bad request without an amount", key: "john-doe-usd", body: `{}`, wantCode: http.StatusBadRequest, wantBalance: usd(10)},
+		{name: "returns not found for unknown accounts", key: "john-doe-eur", body: `{"amount": "1"}`, wantCode: http.StatusNotFound, wantBalance: usd(10)},
+		{name: "returns forbidden to other users", key: "john-doe-usd", body: `{"amount": "1"}`, actor: "jane-doe", role: RoleCustomer, wantCode: http.StatusForbidden, wantBalance: usd(10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			ctx := context.Background()
+			addVoucher(t, srv, "john-doe", usd(10))
+
+			rr := serveAs(srv, http.MethodPost, "/vouchers/"+tt.key+"/redeem", tt.body, tt.actor, tt.role)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("redeemHandler() status = %d, want %d\n%s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			if account, _ := srv.vch.Get(ctx, "john-doe-usd"); account.Balance != tt.wantBalance {
+				t.Errorf("balance = %v, want %v", account.Balance, tt.wantBalance)
+			}
+
+			entries, _ := srv.ldg.List(ctx, "john-doe")
+			redemptions, _ := srv.rdm.List(ctx)
+			if tt.wantCode != http.StatusCreated {
+				if len(entries) != 1 || len(redemptions) > 0 {
+					t.Errorf("redeemHandler() left ledger %v and redemptions %v", entries, redemptions)
+				}
+				return
+			}
+
+			var got redemptionResult
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			redeemed, _ := usd(10).Sub(tt.wantBalance)
+			if got.Status != model.RedemptionRedeemed || got.Amount != redeemed || got.NewBalance != tt.wantBalance || got.UserKey != "john-doe" {
+				t.Errorf("redeemHandler() = %+v, want a redemption of %v", got, redeemed)
+			}
+
+			if len(redemptions) != 1 || redemptions[0].ID != got.RedemptionID || redemptions[0].VoucherKey != tt.key {
+				t.Errorf("redemptions = %v, want %s", redemptions, got.RedemptionID)
+			}
+
+			if len(entries) != 2 || entries[1].Reason != model.LedgerRedemption || entries[1].Delta != redeemed.Neg() || entries[1].RedemptionID != got.RedemptionID {
+				t.Errorf("ledger = %v, want the redemption of %v", entries, redeemed)
+			}
+		})
+	}
+}
+
+func Test_redeemHandler_idempotent(t *testing.T) {
+	srv := newTestServer()
+	ctx := context.Background()
+	addVoucher(t, srv, "john-doe", usd(10))
+	router := srv.Router(false)
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/vouchers/john-doe-usd/redeem", bytes.NewBufferString(`{"amount": "4"}`))
+		req.Header.Set(headerIdempotencyKey, "key-1")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var got redemptionResult
+		if err := json.Unmarshal(rr.Body.Bytes(), &got); rr.Code != http.StatusCreated || err != nil {
+			t.Fatalf("redeemHandler() status = %d, %v\n%s", rr.Code, err, rr.Body)
+		}
+		ids = append(ids, got.RedemptionID)
+	}
+
+	if ids[0] != ids[1] {
+		t.Errorf("redemption IDs = %v, want the first response to be replayed", ids)
+	}
+
+	if account, _ := srv.vch.Get(ctx, "john-doe-usd"); account.Balance != usd(6) {
+		t.Errorf("balance = %v, want a single redemption", account.Balance)
+	}
+}
+
+func Test_reverseRedemptionHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		actor       string
+		role        string
+		reversed    bool // reverse the redemption before the request
+		wantCode    int
+		wantBalance model.Money
+	}{
+		{name: "puts the amount back on the account", wantCode: http.StatusOK, wantBalance: usd(10)},
+		{name: "reverses for staff", actor: "agent-1", role: RoleAdmin, wantCode: http.StatusOK, wantBalance: usd(10)},
+		{name: "returns conflict for reversed redemptions", reversed: true, wantCode: http.StatusConflict, wantBalance: usd(10)},
+		{name: "returns forbidden to other users", actor: "jane-doe", role: RoleCustomer, wantCode: http.StatusForbidden, wantBalance: usd(6)},
+		{name: "returns bad request for unknown roles", actor: "agent-1", role: "wizard", wantCode: http.StatusBadRequest, wantBalance: usd(6)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			ctx := context.Background()
+			addVoucher(t, srv, "john-doe", usd(10))
+			redeemed := redeemVoucher(t, srv, "john-doe-usd", "4")
+
+			path := "/redemptions/" + redeemed.RedemptionID + "/reverse"
+			if tt.reversed {
+				if rr := serveAs(srv, http.MethodPost, path, "", "", ""); rr.Code != http.StatusOK {
+					t.Fatalf("reverseRedemptionHandler() status = %d\n%s", rr.Code, rr.Body)
+				}
+			}
+
+			rr := serveAs(srv, http.MethodPost, path, "", tt.actor, tt.role)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("reverseRedemptionHandler() status = %d, want %d\n%s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			if account, _ := srv.vch.Get(ctx, "john-doe-usd"); account.Balance != tt.wantBalance {
+				t.Errorf("balance = %v, want %v", account.Balance, tt.wantBalance)
+			}
+
+			entries, _ := srv.ldg.List(ctx, "john-doe")
+			var reversals int
+			for _, e := range entries {
+				if e.Reason == model.LedgerRedemptionReversal && e.RedemptionID == redeemed.RedemptionID && e.Delta == usd(4) {
+					reversals++
+				}
+			}
+
+			wantReversals := 1
+			if tt.wantCode == http.StatusForbidden || tt.wantCode == http.StatusBadRequest {
+				wantReversals = 0
+			}
+			if reversals != wantReversals {
+				t.Errorf("ledger = %v, want %d reversal", entries, wantReversals)
+			}
+
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			stored, err := srv.rdm.Get(ctx, redeemed.RedemptionID)
+			if err != nil || stored.Status != model.RedemptionReversed || stored.ReversedAt == nil {
+				t.Errorf("redemption = %+v, %v, want it reversed", stored, err)
+			}
+		})
+	}
+}
+
+func Test_reverseRedemption_otherUsers(t *testing.T) {
+	srv := newTestServer()
+	ctx := context.Background()
+	addVoucher(t, srv, "john-doe", usd(10))
+	redeemed := redeemVoucher(t, srv, "john-doe-usd", "4")
+
+	jane := withActor(ctx, Actor{Key: "jane-doe", Role: RoleCustomer})
+	if _, err := srv.reverseRedemption(jane, "john-doe", redeemed.RedemptionID); !errors.Is(err, ErrActorNotAllowed) {
+		t.Errorf("reverseRedemption() by another user error = %v, want %v", err, ErrActorNotAllowed)
+	}
+
+	// the user key must be the owner of the redemption, not only someone the actor may act for
+	if _, err := srv.reverseRedemption(jane, "jane-doe", redeemed.RedemptionID); !errors.Is(err, ErrActorNotAllowed) {
+		t.Errorf("reverseRedemption() for another user error = %v, want %v", err, ErrActorNotAllowed)
+	}
+
+	if stored, _ := srv.rdm.Get(ctx, redeemed.RedemptionID); stored.Status != model.RedemptionRedeemed {
+		t.Errorf("reverseRedemption() changed the redemption to %+v", stored)
+	}
+}
+
+func Test_redemptionHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string // the redemption made by the test if empty
+		actor    string
+		role     string
+		wantCode int
+	}{
+		{name: "returns the redemption to its user", wantCode: http.StatusOK},
+		{name: "returns the redemption to staff", actor: "agent-1", role: RoleSupport, wantCode: http.StatusOK},
+		{name: "returns forbidden to other users", actor: "jane-doe", role: RoleCustomer, wantCode: http.StatusForbidden},
+		{name: "returns not found for unknown redemptions", id: "rd_unknown", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			addVoucher(t, srv, "john-doe", usd(10))
+			redeemed := redeemVoucher(t, srv, "john-doe-usd", "4")
+
+			id := tt.id
+			if id == "" {
+				id = redeemed.RedemptionID
+			}
+
+			rr := serveAs(srv, http.MethodGet, "/redemptions/"+id, "", tt.actor, tt.role)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("redemptionHandler() status = %d, want %d\n%s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var got model.Redemption
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if got.ID != id || got.Amount != usd(4) || got.CheckoutRef != "cart-1" || got.Status != model.RedemptionRedeemed {
+				t.Errorf("redemptionHandler() = %+v, want redemption %s", got, id)
+			}
+		})
+	}
+}
+
+func Test_redeem_lots(t *testing.T) {
+	srv := newTestServer()
+	ctx := context.Background()
+	now := useTestClock(srv)
+	addVoucher(t, srv, "john-doe", usd(10))
+	creditVoucher(t, srv, "john-doe", "20", 30)
+	creditVoucher(t, srv, "john-doe", "5", 0)
+
+	// spends the untracked $10 and $5 of the lot expiring on day 30
+	redeemed := redeemVoucher(t, srv, "john-doe-usd", "15")
+	stored, _ := srv.rdm.Get(ctx, redeemed.RedemptionID)
+	if len(stored.Lots) != 2 || stored.Lots[0].Amount != usd(10) || stored.Lots[1].Amount != usd(5) || stored.Lots[1].ExpiresAt == nil {
+		t.Fatalf("redemption lots = %+v, want the untracked balance and the oldest lot", stored.Lots)
+	}
+
+	*now = now.AddDate(0, 0, 30)
+	if rr := serveAs(srv, http.MethodPost, "/vouchers/john-doe-usd/redeem", `{"amount": "6"}`, "", ""); rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("redeemHandler() of expired credits status = %d, want %d\n%s", rr.Code, http.StatusUnprocessableEntity, rr.Body)
+	}
+
+	// the $5 of the expired lot are restored and expired again, the untracked $10 is back
+	rr := serveAs(srv, http.MethodPost, "/redemptions/"+redeemed.RedemptionID+"/reverse", "", "", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("reverseRedemptionHandler() status = %d\n%s", rr.Code, rr.Body)
+	}
+
+	var got redemptionResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	account, _ := srv.vch.Get(ctx, "john-doe-usd")
+	if got.NewBalance != usd(15) || account.Balance != usd(15) || account.HasExpired(*now) {
+		t.Errorf("balance = %v, %v, want %v without expired lots", got.NewBalance, account.Balance, usd(15))
+	}
+
+	entries, _ := srv.ldg.List(ctx, "john-doe")
+	var expired model.Money
+	for _, e := range entries {
+		if e.Reason == model.LedgerVoucherExpiry {
+			expired, _ = expired.Add(e.Delta)
+		}
+	}
+
+	if expired != usd(-20) {
+		t.Errorf("expired = %v, want the whole lot of %v", expired, usd(20))
+	}
+}
+
+// Test_redeem_sweepConcurrent is meant to be run with the race detector
+func Test_redeem_sweepConcurrent(t *testing.T) {
+	srv := newTestServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	creditVoucher(t, srv, "john-doe", "50", 0)
+
+	done := make(chan struct{})
+	go func() {
+		srv.watchVoucherExpiry(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	for i := 0; i < 10; i++ {
+		redeemVoucher(t, srv, "john-doe-usd", "1")
+	}
+	cancel()
+	<-done
+
+	if account, _ := srv.vch.Get(context.Background(), "john-doe-usd"); account.Balance != usd(40) {
+		t.Errorf("balance = %v, want %v", account.Balance, usd(40))
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+// evaluateRequest is the body of a dry-run evaluation of the refund rules. Either the refund
+// of an order is evaluated, with the same amount or lines as a refund request, or a made-up
+// refund described by its zone, payment way and amount.
+type evaluateRequest struct {
+	UserKey string          `json:"user_key,omitempty"`
+	OrderID string          `json:"order_id,omitempty"`
+	Amount  json.RawMessage `json:"amount,omitempty"`
+	Lines   []refundLine    `json:"lines,omitempty"`
+
+	Zone       string `json:"zone,omitempty"`        // name in model.ZoneNames, only without an order
+	PaymentWay string `json:"payment_way,omitempty"` // name in model.PaymentWayNames, only without an order
+}
+
+// evaluateResponse is the destination the rules pick for the refund
+type evaluateResponse struct {
+	model.RefundRoute
+	Amount model.Money `json:"amount"`
+}
+
+// evaluateRulesHandler returns where the refund rules in use would send a refund, nothing is
+// refunded. Only support and admin actors can evaluate the rules.
+func (s *Server) evaluateRulesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	body, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var req evaluateRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, fmt.Errorf("%w: body is not valid JSON", errInvalidRequest))
+		return
+	}
+
+	actor, err := actorFromRequest(r, "")
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %s", errInvalidRequest, err))
+		return
+	}
+
+	if !actor.canOverrideOwnership() {
+		writeError(w, ErrActorNotAllowed)
+		return
+	}
+
+	facts, err := s.evaluationFacts(r, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	route, err := s.rules.RefundRules().Route(r.Context(), s.rates, facts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(evaluateResponse{RefundRoute: route, Amount: facts.Amount}); err != nil {
+		log.Printf("cannot write evaluation response: %s", err)
+	}
+}
+
+// evaluationFacts returns the facts the rules are evaluated against
+func (s *Server) evaluationFacts(r *http.Request, req evaluateRequest) (model.RefundFacts, error) {
+	ctx := r.Context()
+	facts := model.RefundFacts{UserKey: req.UserKey}
+
+	if req.UserKey != "" {
+		user, err := s.usr.Get(ctx, req.UserKey)
+		if errors.Is(err, model.ErrUserNotFound) {
+			return facts, fmt.Errorf("%w: %s", model.ErrUserNotFound, req.UserKey)
+		}
+		if err != nil {
+			return facts, err
+		}
+		facts.User = user
+	}
+
+	if strings.TrimSpace(req.OrderID) == "" {
+		return madeUpFacts(req, facts)
+	}
+
+	if req.Zone != "" || req.PaymentWay != "" {
+		return facts, fmt.Errorf("%w: zone and payment_way cannot be given with order_id", errInvalidRequest)
+	}
+
+	order, err := s.ord.Get(ctx, req.OrderID)
+	if errors.Is(err, model.ErrOrderNotFound) {
+		return facts, fmt.Errorf("%w: %s", model.ErrOrderNotFound, req.OrderID)
+	}
+	if err != nil {
+		return facts, err
+	}
+
+	if order.IsDeleted {
+		return facts, fmt.Errorf("%w: %s", model.ErrAlreadyRefunded, req.OrderID)
+	}
+
+	remaining, err := order.Refundable()
+	if err != nil {
+		return facts, err
+	}
+
+	refund := refundRequest{UserKey: req.UserKey, OrderID: req.OrderID, Amount: req.Amount, Lines: req.Lines}
+	var lines map[string]model.Money
+	if facts.Amount, lines, err = refund.amount(order, remaining); err != nil {
+		return facts, err
+	}
+
+	// the same checks as the refund, so an evaluation does not pick a route for a refund that would fail
+	if err = order.RefundLines(lines); err != nil {
+		return facts, err
+	}
+
+	facts.Zone, facts.PaymentWay = order.ShippingCountryZone, order.PaymentWay
+
+	return facts, nil
+}
+
+// madeUpFacts returns the facts of a refund that is not made for an existing order
+func madeUpFacts(req evaluateRequest, facts model.RefundFacts) (model.RefundFacts, error) {
+	if len(req.Lines) > 0 {
+		return facts, fmt.Errorf("%w: lines can only be given with order_id", errInvalidRequest)
+	}
+
+	var ok bool
+	if facts.Zone, ok = model.ZoneNames[strings.ToLower(req.Zone)]; !ok {
+		return facts, fmt.Errorf("%w: unknown zone: %q", errInvalidRequest, req.Zone)
+	}
+
+	if facts.PaymentWay, ok = model.PaymentWayNames[strings.ToLower(req.PaymentWay)]; !ok {
+		return facts, fmt.Errorf("%w: unknown payment_way: %q", errInvalidRequest, req.PaymentWay)
+	}
+
+	if len(req.Amount) == 0 {
+		return facts, fmt.Errorf("%w: amount is missing", errInvalidRequest)
+	}
+
+	amount, err := model.UnmarshalMoney(req.Amount, model.DefaultCurrency)
+	if err != nil {
+		return facts, err
+	}
+	facts.Amount = amount
+
+	return facts, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+func Test_evaluateRulesHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		role     string
+		body     string
+		wantCode int
+		want     evaluateResponse
+	}{
+		{
+			name:     "evaluates the refund of an order",
+			role:     RoleSupport,
+			body:     `{"user_key": "john-doe", "order_id": "3"}`,
+			wantCode: http.StatusOK,
+			want:     evaluateResponse{RefundRoute: model.RefundRoute{Destination: model.DestinationVoucher, Rule: "mena-cash-on-delivery"}, Amount: usd(300)},
+		},
+		{
+			name:     "evaluates a partial refund of an order",
+			role:     RoleAdmin,
+			body:     `{"order_id": "1", "amount": "25"}`,
+			wantCode: http.StatusOK,
+			want:     evaluateResponse{RefundRoute: model.RefundRoute{Destination: model.DestinationBalance}, Amount: usd(25)},
+		},
+		{
+			name:     "evaluates a made-up refund",
+			role:     RoleSupport,
+			body:     `{"zone": "mena", "payment_way": "cash_on_delivery", "amount": {"amount": "10.00", "currency": "USD"}}`,
+			wantCode: http.StatusOK,
+			want:     evaluateResponse{RefundRoute: model.RefundRoute{Destination: model.DestinationVoucher, Rule: "mena-cash-on-delivery"}, Amount: usd(10)},
+		},
+		{
+			name:     "returns forbidden for customers",
+			role:     RoleCustomer,
+			body:     `{"order_id": "1"}`,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "returns bad request for unknown zones",
+			role:     RoleSupport,
+			body:     `{"zone": "asia", "payment_way": "paypal", "amount": "10"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "returns not found for unknown orders",
+			role:     RoleSupport,
+			body:     `{"order_id": "987"}`,
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "returns conflict for refunded orders",
+			role:     RoleSupport,
+			body:     `{"order_id": "2"}`,
+			wantCode: http.StatusConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+
+			req := httptest.NewRequest(http.MethodPost, "/refund-rules/evaluate", bytes.NewBufferString(tt.body))
+			req.Header.Set(headerActorKey, "agent-1")
+			req.Header.Set(headerActorRole, tt.role)
+			rr := httptest.NewRecorder()
+			srv.Router(false).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantCode {
+				t.Fatalf("evaluateRulesHandler() status = %d, want %d\n%s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var got evaluateResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("evaluateRulesHandler() = %+v, want %+v", got, tt.want)
+			}
+
+			order, _ := srv.ord.Get(context.Background(), "1")
+			if !order.Refunded.IsZero() {
+				t.Errorf("evaluateRulesHandler() refunded the order")
+			}
+		})
+	}
+}
+
+func Test_refund_originalPayment(t *testing.T) {
+	srv := newTestServer()
+	ctx := context.Background()
+
+	rules, err := model.NewRefundRules(model.DestinationBalance, []model.RefundRule{
+		{Name: "cards", PaymentWays: []string{"credit_card"}, Destination: model.DestinationOriginalPayment},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.useRefundRules(rules)
+
+	for _, amount := range []string{`"30"`, `"20"`} {
+		if _, err = srv.refund(ctx, refundRequest{UserKey: "john-doe", OrderID: "1", Amount: []byte(amount)}); err != nil {
+			t.Fatalf("refund() error = %v", err)
+		}
+	}
+
+	res, err := srv.refund(ctx, refundRequest{UserKey: "john-doe", OrderID: "1", Amount: []byte(`"10"`)})
+	if err != nil {
+		t.Fatalf("refund() error = %v", err)
+	}
+
+	if res.Destination != model.DestinationOriginalPayment || res.Rule != "cards" || res.Account != model.PaymentAccount("1") {
+		t.Errorf("refund() = %+v, want a refund to the payment of the order", res)
+	}
+
+	if res.NewBalance != usd(60) {
+		t.Errorf("refund() new balance = %v, want the total refunded to the payment %v", res.NewBalance, usd(60))
+	}
+
+	user, _ := srv.usr.Get(ctx, "john-doe")
+	if user.Balance != usd(100) {
+		t.Errorf("refund() changed the balance of the user to %v", user.Balance)
+	}
+}
+
+func Test_shippedRefundRules(t *testing.T) {
+	f, err := model.OpenRefundRulesFile("./data/refund-rules.json")
+	if err != nil {
+		t.Fatalf("OpenRefundRulesFile() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		paymentWay int
+		zone       int
+		want       string
+	}{
+		{name: "refunds card payments to the card", paymentWay: model.CreditCard, zone: model.ZoneEurope, want: model.DestinationOriginalPayment},
+		{name: "refunds PayPal payments to PayPal", paymentWay: model.Paypal, zone: model.ZoneAmerica, want: model.DestinationOriginalPayment},
+		{name: "refunds cash on delivery in MENA to a voucher", paymentWay: model.CashOnDelivery, zone: model.ZoneMena, want: model.DestinationVoucher},
+		{name: "refunds other cash on delivery to the balance", paymentWay: model.CashOnDelivery, zone: model.ZoneEurope, want: model.DestinationBalance},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, err := f.RefundRules().Route(context.Background(), nil, model.RefundFacts{
+				Zone:       tt.zone,
+				PaymentWay: tt.paymentWay,
+				Amount:     usd(10),
+				UserKey:    "john-doe",
+				User:       &model.User{Name: "John", LastName: "Doe", Balance: usd(100)},
+			})
+			if err != nil || route.Destination != tt.want {
+				t.Errorf("Route() = %+v, %v, want %s", route, err, tt.want)
+			}
+		})
+	}
+}
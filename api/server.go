@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+// defaultRefundCreditValidity is how long refunds credited to voucher accounts can be spent,
+// store credit must carry an expiry date in some markets
+const defaultRefundCreditValidity = 365 * 24 * time.Hour
+
+// Server serves the API using its own DBs, so several servers can run in one process
+type Server struct {
+	usr model.UserStore
+	ord model.OrderStore
+	vch model.VoucherStore
+	aud model.AuditStore
+	ldg model.LedgerStore
+	idk model.IdempotencyStore   // responses replayed for retried requests
+	prf model.PaymentRefundStore // refunds waiting for the confirmation of a payment gateway
+	rdm model.RedemptionStore    // amounts spent from voucher accounts at checkout
+	uow model.UnitOfWork         // commits the changes of a refund across the stores atomically
+
+	rates model.ExchangeRateProvider // converts refunds into the currency of the account
+	rules model.RefundRuleSource     // picks where refunds go
+
+	gateways map[int]model.PaymentGateway // refund payments by payment way
+
+	clock model.Clock // tells the time of voucher credits, redemptions and expiries
+
+	refundCreditValidity time.Duration // how long refunds credited to voucher accounts can be spent, 0 never expires
+
+	locks *model.KeyLocker // serialises changes to the same user, order or voucher account
+
+	// storesMu is held for reading while the stores are used in test mode and by the background
+	// workers, provider states hold it for writing while they replace the stores
+	storesMu sync.RWMutex
+}
+
+// NewServer creates a Server that operates on the given stores.
+// If uow is nil, a MemoryUnitOfWork over the stores is used.
+// If rates is nil, refunds can only be made in the currency of the account.
+// Refunds are routed by model.DefaultRefundRules until useRefundRules is called.
+// Credit card and PayPal payments are refunded by fake gateways that confirm refunds
+// right away until useGateways is called.
+// Voucher credits expire by model.SystemClock until useClock is called.
+// Refunds credited to voucher accounts expire after defaultRefundCreditValidity
+// until useRefundCreditValidity is called.
+func NewServer(stores model.Stores, uow model.UnitOfWork, rates model.ExchangeRateProvider) *Server {
+	if rates == nil {
+		rates, _ = model.NewStaticRates(model.DefaultCurrency, nil)
+	}
+
+	s := &Server{rates: rates, rules: model.DefaultRefundRules(), locks: model.NewKeyLocker(), clock: model.SystemClock}
+	s.refundCreditValidity = defaultRefundCreditValidity
+	s.useGateways(map[int]model.PaymentGateway{
+		model.CreditCard: model.NewFakeCardGateway(0),
+		model.Paypal:     model.NewFakePaypalGateway(0),
+	})
+	s.useStores(stores, uow)
+
+	return s
+}
+
+// useStores replaces the stores of the server.
+// Without a uow, a missing audit store, ledger, payment refund or redemption store is replaced
+// by an in-memory one.
+// A missing idempotency store is always replaced by an in-memory one.
+func (s *Server) useStores(stores model.Stores, uow model.UnitOfWork) {
+	if uow == nil {
+		if stores.Audit == nil {
+			stores.Audit = model.NewAuditLog()
+		}
+		if stores.Ledger == nil {
+			stores.Ledger = model.NewLedger()
+		}
+		if stores.PaymentRefunds == nil {
+			stores.PaymentRefunds = model.NewPaymentRefunds()
+		}
+		if stores.Redemptions == nil {
+			stores.Redemptions = model.NewRedemptions()
+		}
+		uow = model.NewMemoryUnitOfWork(stores)
+	}
+
+	if stores.Idempotency == nil {
+		stores.Idempotency = model.NewIdempotencyKeys()
+	}
+
+	s.usr, s.ord, s.vch, s.aud, s.ldg, s.uow = stores.Users, stores.Orders, stores.Vouchers, stores.Audit, stores.Ledger, uow
+	s.idk, s.prf, s.rdm = stores.Idempotency, stores.PaymentRefunds, stores.Redemptions
+}
+
+// useRefundRules replaces the rules that pick where refunds go
+func (s *Server) useRefundRules(rules model.RefundRuleSource) {
+	s.rules = rules
+}
+
+// useGateways replaces the payment gateways, payments of other payment ways cannot be
+// refunded to their payment method
+func (s *Server) useGateways(gateways map[int]model.PaymentGateway) {
+	s.gateways = gateways
+}
+
+// useClock replaces the clock that tells the time of voucher credits, redemptions and expiries
+func (s *Server) useClock(clock model.Clock) {
+	s.clock = clock
+}
+
+// useRefundCreditValidity sets how long refunds credited to voucher accounts can be spent,
+// 0 credits them without an expiry
+func (s *Server) useRefundCreditValidity(d time.Duration) {
+	s.refundCreditValidity = d
+}
+
+// Router registers every route of the API and returns the router.
+// The pact provider states endpoint is only registered in test mode.
+func (s *Server) Router(testMode bool) *httprouter.Router {
+	// provider states replace the stores in test mode, requests must not see the swap
+	handle := func(h httprouter.Handle) httprouter.Handle { return h }
+	if testMode {
+		handle = s.sharingStores
+	}
+
+	router := httprouter.New()
+	router.POST("/order/:orderID/refund/", handle(s.idempotent(s.refundHandler)))
+	router.GET("/users", handle(s.listUsersHandler))
+	router.POST("/users", handle(s.createUserHandler))
+	router.GET("/users/:userKey", handle(s.userHandler))
+	router.PATCH("/users/:userKey", handle(s.updateUserHandler))
+	router.DELETE("/users/:userKey", handle(s.deleteUserHandler))
+	router.GET("/users/:userKey/ledger", handle(s.ledgerHandler))
+	router.GET("/users/:userKey/orders", handle(s.userOrdersHandler))
+	router.GET("/users/:userKey/vouchers", handle(s.userVouchersHandler))
+	router.POST("/vouchers", handle(s.creditVoucherHandler))
+	router.POST("/vouchers/:key/debit", handle(s.debitVoucherHandler))
+	router.POST("/vouchers/:key/redeem", handle(s.idempotent(s.redeemHandler)))
+	router.GET("/redemptions/:redemptionID", handle(s.redemptionHandler))
+	router.POST("/redemptions/:redemptionID/reverse", handle(s.idempotent(s.reverseRedemptionHandler)))
+	router.GET("/orders", handle(s.listOrdersHandler))
+	router.POST("/orders", handle(s.createOrderHandler))
+	router.GET("/orders/:orderID", handle(s.orderHandler))
+	router.POST("/refund-rules/evaluate", handle(s.evaluateRulesHandler))
+	router.GET("/refunds/:refundID", handle(s.paymentRefundHandler))
+
+	if testMode {
+		router.POST("/_pact/provider-states", s.providerStatesHandler)
+	}
+
+	return router
+}
+
+// sharingStores runs h while the stores cannot be replaced
+func (s *Server) sharingStores(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		s.storesMu.RLock()
+		defer s.storesMu.RUnlock()
+
+		h(w, r, ps)
+	}
+}
@@ -0,0 +1,281 @@
+package sqlite
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// migrationFiles holds the schema migrations. Every version has an up and a down file
+// named <version>_<name>.up.sql and <version>_<name>.down.sql.
+// Never change a migration that has been released, add a new one instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// MigrationStatus tells whether a migration is applied to the database
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrations returns the embedded migrations ordered by version
+func Migrations() ([]Migration, error) {
+	return parseMigrations(migrationFiles, "migrations")
+}
+
+// parseMigrations reads the migrations in dir, versions must start at 1 and have no gaps
+func parseMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		m := migrationName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", e.Name())
+		}
+
+		version, _ := strconv.Atoi(m[1])
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has more than one name: %s, %s", version, mig.Name, m[2])
+		}
+
+		b, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		if m[3] == "up" {
+			mig.up = string(b)
+		} else {
+			mig.down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for v := 1; v <= len(byVersion); v++ {
+		mig, ok := byVersion[v]
+		if !ok {
+			return nil, fmt.Errorf("migration %d is missing", v)
+		}
+
+		if mig.up == "" || mig.down == "" {
+			return nil, fmt.Errorf("migration %d needs both an up and a down file", v)
+		}
+
+		migrations = append(migrations, *mig)
+	}
+
+	return migrations, nil
+}
+
+// MigrateUp applies at most steps pending migrations, all of them if steps is 0.
+// It returns the migrations that were applied.
+func (d *DB) MigrateUp(ctx context.Context, steps int) ([]Migration, error) {
+	status, err := d.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, s := range status {
+		if s.Applied {
+			continue
+		}
+
+		if steps > 0 && len(applied) == steps {
+			break
+		}
+
+		err = d.runMigration(ctx, s.Migration, s.up,
+			"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+			s.Version, s.Name, time.Now().UTC().Format(time.RFC3339))
+		if err != nil {
+			return applied, err
+		}
+
+		applied = append(applied, s.Migration)
+	}
+
+	return applied, nil
+}
+
+// MigrateDown reverts the last steps applied migrations, every applied migration if steps is 0.
+// It returns the migrations that were reverted.
+func (d *DB) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	status, err := d.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	for i := len(status) - 1; i >= 0; i-- {
+		s := status[i]
+		if !s.Applied {
+			continue
+		}
+
+		if steps > 0 && len(reverted) == steps {
+			break
+		}
+
+		err = d.runMigration(ctx, s.Migration, s.down, "DELETE FROM schema_migrations WHERE version = ?", s.Version)
+		if err != nil {
+			return reverted, err
+		}
+
+		reverted = append(reverted, s.Migration)
+	}
+
+	return reverted, nil
+}
+
+// MigrationStatus returns every known migration with its state in the database
+func (d *DB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = d.ensureMigrationTable(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appliedAt := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at string
+		if err = rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		appliedAt[version], _ = time.Parse(time.RFC3339, at)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		at, ok := appliedAt[m.Version]
+		status = append(status, MigrationStatus{Migration: m, Applied: ok, AppliedAt: at})
+		delete(appliedAt, m.Version)
+	}
+
+	if len(appliedAt) > 0 {
+		unknown := make([]int, 0, len(appliedAt))
+		for v := range appliedAt {
+			unknown = append(unknown, v)
+		}
+		sort.Ints(unknown)
+
+		return nil, fmt.Errorf("database has migrations this binary does not know: %v", unknown)
+	}
+
+	return status, nil
+}
+
+// runMigration runs the migration script and records it in one transaction
+func (d *DB) runMigration(ctx context.Context, m Migration, script, record string, args ...interface{}) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %d_%s failed\n%s", m.Version, m.Name, err)
+	}
+
+	if _, err = tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ensureMigrationTable creates the table the applied migrations are recorded in.
+// Databases created before the table existed kept their schema version in
+// PRAGMA user_version, those versions are recorded as applied.
+func (d *DB) ensureMigrationTable(ctx context.Context) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'").Scan(&exists)
+	if err != nil || exists == 1 {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `CREATE TABLE schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var legacy int
+	if err = tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&legacy); err != nil {
+		return fmt.Errorf("cannot read schema version\n%s", err)
+	}
+
+	if legacy > 0 {
+		migrations, err := Migrations()
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if m.Version > legacy {
+				break
+			}
+
+			_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+				m.Version, m.Name, time.Now().UTC().Format(time.RFC3339))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,310 @@
+package sqlite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+func Test_parseMigrations(t *testing.T) {
+	file := func(s string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(s)} }
+
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		want    []int
+		wantErr bool
+	}{
+		{
+			name: "orders migrations by version",
+			files: fstest.MapFS{
+				"m/0002_add_index.up.sql":      file("CREATE INDEX"),
+				"m/0002_add_index.down.sql":    file("DROP INDEX"),
+				"m/0001_create_users.up.sql":   file("CREATE TABLE"),
+				"m/0001_create_users.down.sql": file("DROP TABLE"),
+			},
+			want: []int{1, 2},
+		},
+		{
+			name: "returns error when a version is missing",
+			files: fstest.MapFS{
+				"m/0001_create_users.up.sql":   file("CREATE TABLE"),
+				"m/0001_create_users.down.sql": file("DROP TABLE"),
+				"m/0003_add_index.up.sql":      file("CREATE INDEX"),
+				"m/0003_add_index.down.sql":    file("DROP INDEX"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "returns error when the down file is missing",
+			files: fstest.MapFS{
+				"m/0001_create_users.up.sql": file("CREATE TABLE"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "returns error when up and down names differ",
+			files: fstest.MapFS{
+				"m/0001_create_users.up.sql":    file("CREATE TABLE"),
+				"m/0001_create_people.down.sql": file("DROP TABLE"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "returns error for an invalid file name",
+			files: fstest.MapFS{
+				"m/create_users.sql": file("CREATE TABLE"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMigrations(tt.files, "m")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMigrations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseMigrations() = %v, want versions %v", got, tt.want)
+			}
+
+			for i, m := range got {
+				if m.Version != tt.want[i] || m.up == "" || m.down == "" {
+					t.Errorf("parseMigrations()[%d] = %v, want version %d", i, m, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMigrations_embedded(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations() error = %v", err)
+	}
+
+	if len(migrations) == 0 {
+		t.Errorf("Migrations() returned no migrations")
+	}
+}
+
+func TestDB_migrateUpAndDown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	all, _ := Migrations()
+
+	applied, err := db.MigrateUp(ctx, 0)
+	if err != nil || len(applied) != len(all) {
+		t.Fatalf("MigrateUp() = %v, %v, want %d migrations", applied, err, len(all))
+	}
+
+	if applied, _ = db.MigrateUp(ctx, 0); len(applied) != 0 {
+		t.Errorf("MigrateUp() applied %v again", applied)
+	}
+
+	status, err := db.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("MigrationStatus() error = %v", err)
+	}
+	for _, s := range status {
+		if !s.Applied || s.AppliedAt.IsZero() {
+			t.Errorf("MigrationStatus() migration %d is not applied", s.Version)
+		}
+	}
+
+	reverted, err := db.MigrateDown(ctx, 0)
+	if err != nil || len(reverted) != len(all) {
+		t.Fatalf("MigrateDown() = %v, %v, want %d migrations", reverted, err, len(all))
+	}
+
+	if reverted[0].Version != all[len(all)-1].Version {
+		t.Errorf("MigrateDown() reverted %d first, want the latest migration", reverted[0].Version)
+	}
+
+	if _, err = db.Stores().Users.List(ctx); err == nil {
+		t.Errorf("MigrateDown() left the users table")
+	}
+
+	if applied, err = db.MigrateUp(ctx, 1); err != nil || len(applied) != 1 {
+		t.Errorf("MigrateUp(1) = %v, %v, want one migration", applied, err)
+	}
+}
+
+func TestDB_MigrationStatus_legacyVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	all, _ := Migrations()
+
+	// schema created before the migrations table existed
+	if _, err = db.db.ExecContext(ctx, all[0].up+"; PRAGMA user_version = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := db.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("MigrationStatus() error = %v", err)
+	}
+
+	if !status[0].Applied {
+		t.Errorf("MigrationStatus() did not record the legacy schema version")
+	}
+
+	if _, err = db.MigrateUp(ctx, 0); err != nil {
+		t.Errorf("MigrateUp() error = %v", err)
+	}
+}
+
+func TestDB_MigrationStatus_unknownVersion(t *testing.T) {
+	db, _, cleanup := openTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	_, err := db.db.ExecContext(ctx,
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES (999, 'from_the_future', '2020-01-01T00:00:00Z')")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = db.MigrationStatus(ctx); err == nil {
+		t.Errorf("MigrationStatus() error = nil, want error for unknown migration")
+	}
+}
+
+func TestDB_migrateUp_recordsOpeningBalances(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err = db.MigrateUp(ctx, 2); err != nil {
+		t.Fatalf("MigrateUp(2) error = %v", err)
+	}
+
+	// balances saved before the ledger existed
+	stores := db.Stores()
+	stores.Users.Put(ctx, "john-doe", &model.User{Name: "John", LastName: "Doe", Balance: usd(100)})
+	stores.Users.Put(ctx, "jane-doe", &model.User{Name: "Jane", LastName: "Doe"})
+	voucher, _ := model.NewVoucher(model.NewMoney(36725, "AED"), "john-doe")
+	stores.Vouchers.Put(ctx, "john-doe-aed", &voucher)
+
+	// legacy balances saved as a bare number or string
+	_, err = db.db.ExecContext(ctx, `INSERT INTO users (key, data) VALUES
+		('old-doe', '{"Name":"Old","LastName":"Doe","Balance":12.5}'),
+		('str-doe', '{"Name":"Str","LastName":"Doe","Balance":"7.25"}'),
+		('nil-doe', '{"Name":"Nil","LastName":"Doe","Balance":0}')`)
+	if err != nil {
+		t.Fatalf("insert legacy users error = %v", err)
+	}
+
+	if _, err = db.MigrateUp(ctx, 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	entries, err := stores.Ledger.List(ctx, "john-doe")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	balances, _ := model.Balances(entries)
+	want := map[string]model.Money{
+		model.BalanceAccount("john-doe"):     usd(100),
+		model.VoucherAccount("john-doe-aed"): model.NewMoney(36725, "AED"),
+	}
+	if !reflect.DeepEqual(balances, want) {
+		t.Errorf("opening balances = %v, want %v", balances, want)
+	}
+
+	for _, e := range entries {
+		if e.Reason != model.LedgerOpeningBalance || e.UserKey != "john-doe" || e.Time.IsZero() {
+			t.Errorf("opening balance entry = %v", e)
+		}
+	}
+
+	for key, want := range map[string]model.Money{"old-doe": model.NewMoney(1250, "USD"), "str-doe": model.NewMoney(725, "USD")} {
+		entries, _ = stores.Ledger.List(ctx, key)
+		if balances, _ = model.Balances(entries); !reflect.DeepEqual(balances, map[string]model.Money{model.BalanceAccount(key): want}) {
+			t.Errorf("%s opening balances = %v, want %v", key, balances, want)
+		}
+	}
+
+	for _, key := range []string{"jane-doe", "nil-doe"} {
+		if entries, _ = stores.Ledger.List(ctx, key); len(entries) != 0 {
+			t.Errorf("zero balance of %s was recorded: %v", key, entries)
+		}
+	}
+}
+
+func TestDB_migrateUp_ledgerIsAppendOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err = db.MigrateUp(ctx, 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	entry := &model.LedgerEntry{Account: model.BalanceAccount("john-doe"), UserKey: "john-doe", Delta: usd(10)}
+	if err = db.Stores().Ledger.Append(ctx, entry); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	for _, query := range []string{
+		`UPDATE ledger SET data = json_set(data, '$.delta.amount', '20.00')`,
+		`DELETE FROM ledger`,
+	} {
+		if _, err = db.db.ExecContext(ctx, query); err == nil {
+			t.Errorf("%q error = nil, want an error", query)
+		}
+	}
+
+	entries, _ := db.Stores().Ledger.List(ctx, "john-doe")
+	if len(entries) != 1 || !reflect.DeepEqual(entries[0].Delta, usd(10)) {
+		t.Errorf("ledger entries = %v, want the appended entry", entries)
+	}
+}
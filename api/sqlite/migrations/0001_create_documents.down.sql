@@ -0,0 +1,3 @@
+DROP TABLE vouchers;
+DROP TABLE orders;
+DROP TABLE users;
@@ -0,0 +1,14 @@
+CREATE TABLE users (
+	key  TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
+
+CREATE TABLE orders (
+	key  TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
+
+CREATE TABLE vouchers (
+	key  TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
@@ -0,0 +1,4 @@
+CREATE TABLE audit_log (
+	id   INTEGER PRIMARY KEY AUTOINCREMENT,
+	data TEXT NOT NULL
+);
@@ -0,0 +1,61 @@
+CREATE TABLE ledger (
+	id       INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_key TEXT NOT NULL,
+	data     TEXT NOT NULL
+);
+
+CREATE INDEX ledger_user_key ON ledger (user_key, id);
+
+-- balances saved before the ledger existed are recorded as opening balances,
+-- so every balance can be derived from the ledger, legacy balances saved as a
+-- bare number or string are amounts in USD like Money.UnmarshalJSON reads them
+INSERT INTO ledger (user_key, data)
+SELECT key, json_object(
+	'time', strftime('%Y-%m-%dT%H:%M:%SZ', 'now'),
+	'account', 'balance:' || key,
+	'user_key', key,
+	'delta', CASE
+		WHEN json_type(data, '$.Balance') IN ('integer', 'real', 'text')
+		THEN json_object('amount', printf('%.2f', json_extract(data, '$.Balance')), 'currency', 'USD')
+		ELSE json(json_extract(data, '$.Balance'))
+	END,
+	'reason', 'opening_balance',
+	'actor_key', 'system',
+	'actor_role', 'system'
+)
+FROM users
+WHERE CAST(COALESCE(json_extract(data, '$.Balance.amount'), json_extract(data, '$.Balance')) AS REAL) != 0
+ORDER BY key;
+
+INSERT INTO ledger (user_key, data)
+SELECT user_key, json_object(
+	'time', strftime('%Y-%m-%dT%H:%M:%SZ', 'now'),
+	'account', 'voucher:' || key,
+	'user_key', user_key,
+	'delta', CASE
+		WHEN json_type(data, '$.Balance') IN ('integer', 'real', 'text')
+		THEN json_object('amount', printf('%.2f', json_extract(data, '$.Balance')), 'currency', 'USD')
+		ELSE json(json_extract(data, '$.Balance'))
+	END,
+	'reason', 'opening_balance',
+	'actor_key', 'system',
+	'actor_role', 'system'
+)
+FROM (
+	-- voucher keys are the user key followed by the lower case currency
+	SELECT key, data, COALESCE(json_extract(data, '$.UserKey'), substr(key, 1, length(key) - 4)) AS user_key
+	FROM vouchers
+)
+WHERE CAST(COALESCE(json_extract(data, '$.Balance.amount'), json_extract(data, '$.Balance')) AS REAL) != 0
+ORDER BY key;
+
+-- entries are only ever appended, a correction is a new entry
+CREATE TRIGGER ledger_no_update BEFORE UPDATE ON ledger
+BEGIN
+	SELECT RAISE(ABORT, 'ledger entries cannot be changed');
+END;
+
+CREATE TRIGGER ledger_no_delete BEFORE DELETE ON ledger
+BEGIN
+	SELECT RAISE(ABORT, 'ledger entries cannot be deleted');
+END;
@@ -0,0 +1,4 @@
+CREATE TABLE idempotency_keys (
+	key  TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
@@ -0,0 +1,4 @@
+CREATE TABLE payment_refunds (
+	key  TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
@@ -0,0 +1,4 @@
+CREATE TABLE redemptions (
+	key  TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
@@ -0,0 +1,85 @@
+// Package sqlite stores users, orders, voucher accounts, the audit log, the ledger,
+// refunds made through payment gateways, voucher redemptions and the responses of
+// idempotent requests in a SQLite database.
+//
+// Every record is stored as a JSON document keyed the same way as the
+// in-memory handlers of the model package, so both backends behave alike.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+
+	"github.com/srgyrn/pact-example/api/model"
+
+	// registers the pure Go "sqlite" driver
+	_ "modernc.org/sqlite"
+)
+
+// DB is an open SQLite database
+type DB struct {
+	db *sql.DB
+}
+
+// Open opens the database file at path, creating it if needed.
+// The schema is not touched, call MigrateUp before using the stores.
+func Open(path string) (*DB, error) {
+	// transactions take the write lock up front so concurrent read-modify-write
+	// transactions wait for each other instead of failing with SQLITE_BUSY
+	dsn := "file:" + path + "?" + url.Values{
+		"_pragma": {"busy_timeout(5000)", "journal_mode(WAL)", "foreign_keys(1)"},
+		"_txlock": {"immediate"},
+	}.Encode()
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s\n%s", path, err)
+	}
+
+	return &DB{db: db}, nil
+}
+
+// Close closes the database
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Stores returns the stores backed by the database
+func (d *DB) Stores() model.Stores {
+	s := storesFor(d.db)
+	s.Idempotency = &IdempotencyStore{q: d.db}
+
+	return s
+}
+
+// Do runs fn in a database transaction, the transaction is committed if fn succeeds
+func (d *DB) Do(ctx context.Context, fn func(ctx context.Context, s model.Stores) error) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(ctx, storesFor(tx)); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func storesFor(q querier) model.Stores {
+	return model.Stores{
+		Users:    &UserStore{q: q},
+		Orders:   &OrderStore{q: q},
+		Vouchers: &VoucherStore{q: q},
+		Audit:    &AuditStore{q: q},
+		Ledger:   &LedgerStore{q: q},
+
+		PaymentRefunds: &PaymentRefundStore{q: q},
+		Redemptions:    &RedemptionStore{q: q},
+	}
+}
+
+var _ model.UnitOfWork = (*DB)(nil)
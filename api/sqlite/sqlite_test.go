@@ -0,0 +1,427 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+// openTestDB opens a database in a temporary directory, the returned function removes it
+func openTestDB(t *testing.T) (*DB, string, func()) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "test.db")
+	db, err := Open(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	if _, err = db.MigrateUp(context.Background(), 0); err != nil {
+		db.Close()
+		os.RemoveAll(dir)
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	return db, path, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestUserStore(t *testing.T) {
+	db, _, cleanup := openTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	users := db.Stores().Users
+
+	if _, err := users.Get(ctx, "john-doe"); err == nil {
+		t.Errorf("Get() error = nil, want not found")
+	}
+
+	john := &model.User{Name: "John", LastName: "Doe", Balance: usd(100), Orders: []int{1, 2}}
+	jane := &model.User{Name: "Jane", LastName: "Doe", Balance: usd(50)}
+	users.Put(ctx, "john-doe", john)
+	users.Put(ctx, "jane-doe", jane)
+
+	got, err := users.Get(ctx, "john-doe")
+	if err != nil || !reflect.DeepEqual(got, john) {
+		t.Errorf("Get() = %v, %v, want %v", got, err, john)
+	}
+
+	list, _ := users.List(ctx)
+	if !reflect.DeepEqual(list, []*model.User{jane, john}) {
+		t.Errorf("List() = %v, want %v", list, []*model.User{jane, john})
+	}
+
+	if err = users.Delete(ctx, "jane-doe"); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+
+	if err = users.Delete(ctx, "jane-doe"); err == nil {
+		t.Errorf("Delete() error = nil, want not found")
+	}
+}
+
+func TestOrderStore(t *testing.T) {
+	db, _, cleanup := openTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	orders := db.Stores().Orders
+
+	for _, id := range []string{"10", "2", "1"} {
+		orders.Put(ctx, id, &model.Order{Total: usd(10), PaymentWay: model.CreditCard, ShippingCountryZone: model.ZoneEurope})
+	}
+
+	if err := orders.Delete(ctx, "2"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	got, _ := orders.Get(ctx, "2")
+	if !got.IsDeleted {
+		t.Errorf("Delete() did not mark order as deleted")
+	}
+
+	if err := orders.Delete(ctx, "99"); err == nil {
+		t.Errorf("Delete() error = nil, want not found")
+	}
+
+	orders.Put(ctx, "30", &model.Order{Total: usd(10), PaymentWay: model.CreditCard, ShippingCountryZone: model.ZoneEurope})
+	if err := orders.Remove(ctx, "30"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+
+	if _, err := orders.Get(ctx, "30"); !errors.Is(err, model.ErrOrderNotFound) {
+		t.Errorf("Get() of a removed order error = %v, want %v", err, model.ErrOrderNotFound)
+	}
+
+	if err := orders.Remove(ctx, "30"); !errors.Is(err, model.ErrOrderNotFound) {
+		t.Errorf("Remove() error = %v, want %v", err, model.ErrOrderNotFound)
+	}
+
+	list, _ := orders.List(ctx)
+	if len(list) != 3 || !list[1].IsDeleted {
+		t.Errorf("List() is not ordered by key value: %v", list)
+	}
+
+	want := &model.Order{
+		ID:          20,
+		Lines:       []model.LineItem{{SKU: "SKU-1", Quantity: 2, UnitPrice: usd(40)}},
+		ShippingFee: usd(15),
+		Tax:         usd(5),
+		Discounts:   []model.Discount{{Code: "WELCOME", Amount: usd(10)}},
+		Total:       usd(90),
+		Refunded:    usd(30),
+	}
+	orders.Put(ctx, "20", want)
+	if got, err := orders.Get(ctx, "20"); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Get() = %v, %v, want %v", got, err, want)
+	}
+}
+
+func TestVoucherStore(t *testing.T) {
+	db, _, cleanup := openTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	vouchers := db.Stores().Vouchers
+
+	if _, err := vouchers.Get(ctx, " "); err == nil {
+		t.Errorf("Get() error = nil, want error for empty key")
+	}
+
+	want, _ := model.NewVoucher(usd(150), "jane-doe")
+	vouchers.Put(ctx, "jane-doe-usd", &want)
+
+	got, err := vouchers.Get(ctx, "jane-doe-usd")
+	if err != nil || !reflect.DeepEqual(*got, want) {
+		t.Errorf("Get() = %v, %v, want %v", got, err, want)
+	}
+
+	if got.UserKey() != "jane-doe" {
+		t.Errorf("Get() lost the user key, got %q", got.UserKey())
+	}
+}
+
+func TestAuditStore(t *testing.T) {
+	db, _, cleanup := openTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	audit := db.Stores().Audit
+
+	first := &model.AuditEntry{Action: model.AuditOwnershipOverride, ActorKey: "agent-1", UserKey: "john-doe", OrderID: "4"}
+	second := &model.AuditEntry{Action: model.AuditOwnershipOverride, ActorKey: "agent-2", UserKey: "jane-doe", OrderID: "1"}
+
+	if err := audit.Append(ctx, first, second); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	if err := audit.Append(ctx, first, nil); err == nil {
+		t.Errorf("Append() error = nil, want error for nil entry")
+	}
+
+	got, _ := audit.List(ctx)
+	if want := []*model.AuditEntry{first, second}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+
+	err := db.Do(ctx, func(ctx context.Context, s model.Stores) error {
+		s.Audit.Append(ctx, first)
+		return errors.New("refund failed")
+	})
+	if err == nil {
+		t.Fatalf("Do() error = nil, want error")
+	}
+
+	if got, _ = audit.List(ctx); len(got) != 2 {
+		t.Errorf("Do() did not roll back the audit entry, got %d entries", len(got))
+	}
+}
+
+func TestLedgerStore(t *testing.T) {
+	db, _, cleanup := openTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	ledger := db.Stores().Ledger
+
+	opening := &model.LedgerEntry{Account: model.BalanceAccount("john-doe"), UserKey: "john-doe", Delta: usd(100), Reason: model.LedgerOpeningBalance}
+	other := &model.LedgerEntry{Account: model.BalanceAccount("jane-doe"), UserKey: "jane-doe", Delta: usd(150), Reason: model.LedgerOpeningBalance}
+	refund := &model.LedgerEntry{Account: model.VoucherAccount("john-doe-usd"), UserKey: "john-doe", Delta: usd(300), Reason: model.LedgerRefund, OrderID: "3"}
+
+	if err := ledger.Append(ctx, opening, other, refund); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	if err := ledger.Append(ctx, opening, nil); err == nil {
+		t.Errorf("Append() error = nil, want error for nil entry")
+	}
+
+	got, _ := ledger.List(ctx, "john-doe")
+	if want := []*model.LedgerEntry{opening, refund}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+
+	err := db.Do(ctx, func(ctx context.Context, s model.Stores) error {
+		s.Ledger.Append(ctx, refund)
+		return errors.New("refund failed")
+	})
+	if err == nil {
+		t.Fatalf("Do() error = nil, want error")
+	}
+
+	if got, _ = ledger.List(ctx, "john-doe"); len(got) != 2 {
+		t.Errorf("Do() did not roll back the ledger entry, got %d entries", len(got))
+	}
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	db, _, cleanup := openTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	keys := db.Stores().Idempotency
+
+	if _, err := keys.Get(ctx, "key-1"); !errors.Is(err, model.ErrIdempotencyKeyNotFound) {
+		t.Errorf("Get() error = %v, want %v", err, model.ErrIdempotencyKeyNotFound)
+	}
+
+	rec := &model.IdempotencyRecord{
+		RequestHash: "abc",
+		StatusCode:  200,
+		Header:      map[string][]string{"Content-Type": {"application/json"}},
+		Body:        []byte(`{"user_key":"john-doe"}`),
+		CreatedAt:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if err := keys.Put(ctx, "key-1", rec); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	if got, err := keys.Get(ctx, "key-1"); err != nil || !reflect.DeepEqual(got, rec) {
+		t.Errorf("Get() = %v, %v, want %v", got, err, rec)
+	}
+
+	newer := &model.IdempotencyRecord{RequestHash: "def", CreatedAt: rec.CreatedAt.Add(time.Minute + 500*time.Millisecond)}
+	if err := keys.Put(ctx, "key-2", newer); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	if n, err := keys.DeleteBefore(ctx, rec.CreatedAt.Add(time.Minute)); err != nil || n != 1 {
+		t.Errorf("DeleteBefore() = %d, %v, want 1 record removed", n, err)
+	}
+
+	if _, err := keys.Get(ctx, "key-1"); !errors.Is(err, model.ErrIdempotencyKeyNotFound) {
+		t.Errorf("Get() of a removed record error = %v, want %v", err, model.ErrIdempotencyKeyNotFound)
+	}
+
+	if _, err := keys.Get(ctx, "key-2"); err != nil {
+		t.Errorf("Get() of a kept record error = %v", err)
+	}
+
+	if s := storesFor(db.db); s.Idempotency != nil {
+		t.Errorf("stores of a transaction have an idempotency store")
+	}
+}
+
+func TestPaymentRefundStore(t *testing.T) {
+	db, _, cleanup := openTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	refunds := db.Stores().PaymentRefunds
+
+	if _, err := refunds.Get(ctx, "rf_1"); !errors.Is(err, model.ErrRefundNotFound) {
+		t.Errorf("Get() error = %v, want %v", err, model.ErrRefundNotFound)
+	}
+
+	r := &model.PaymentRefund{
+		ID:         "rf_1",
+		OrderID:    "1",
+		UserKey:    "john-doe",
+		PaymentWay: model.CreditCard,
+		PaymentRef: "card_0001",
+		GatewayRef: "re_000001",
+		Amount:     usd(10),
+		Status:     model.PaymentRefundPending,
+		CreatedAt:  time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		UpdatedAt:  time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if err := refunds.Put(ctx, r.ID, r); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	if got, err := refunds.Get(ctx, r.ID); err != nil || !reflect.DeepEqual(got, r) {
+		t.Errorf("Get() = %v, %v, want %v", got, err, r)
+	}
+
+	if got, err := refunds.List(ctx); err != nil || len(got) != 1 {
+		t.Errorf("List() = %v, %v, want one refund", got, err)
+	}
+
+	if err := refunds.Delete(ctx, r.ID); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+
+	if err := refunds.Delete(ctx, r.ID); !errors.Is(err, model.ErrRefundNotFound) {
+		t.Errorf("Delete() of a deleted refund error = %v, want %v", err, model.ErrRefundNotFound)
+	}
+}
+
+func TestRedemptionStore(t *testing.T) {
+	db, _, cleanup := openTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	redemptions := db.Stores().Redemptions
+
+	if _, err := redemptions.Get(ctx, "rd_1"); !errors.Is(err, model.ErrRedemptionNotFound) {
+		t.Errorf("Get() error = %v, want %v", err, model.ErrRedemptionNotFound)
+	}
+
+	reversedAt := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	r := &model.Redemption{
+		ID:          "rd_1",
+		VoucherKey:  "john-doe-usd",
+		UserKey:     "john-doe",
+		Amount:      usd(10),
+		CheckoutRef: "checkout-1",
+		Status:      model.RedemptionReversed,
+		CreatedAt:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		ReversedAt:  &reversedAt,
+	}
+	if err := redemptions.Put(ctx, r.ID, r); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	if got, err := redemptions.Get(ctx, r.ID); err != nil || !reflect.DeepEqual(got, r) {
+		t.Errorf("Get() = %v, %v, want %v", got, err, r)
+	}
+
+	if got, err := redemptions.List(ctx); err != nil || len(got) != 1 {
+		t.Errorf("List() = %v, %v, want one redemption", got, err)
+	}
+
+	if err := redemptions.Delete(ctx, r.ID); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+
+	if err := redemptions.Delete(ctx, r.ID); !errors.Is(err, model.ErrRedemptionNotFound) {
+		t.Errorf("Delete() of a deleted redemption error = %v, want %v", err, model.ErrRedemptionNotFound)
+	}
+}
+
+func TestDB_Do(t *testing.T) {
+	db, _, cleanup := openTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	users := db.Stores().Users
+	users.Put(ctx, "john-doe", &model.User{Name: "John", LastName: "Doe", Balance: usd(100)})
+
+	err := db.Do(ctx, func(ctx context.Context, s model.Stores) error {
+		u, _ := s.Users.Get(ctx, "john-doe")
+		u.Balance = model.Money{}
+		s.Users.Put(ctx, "john-doe", u)
+		s.Orders.Put(ctx, "1", &model.Order{ID: 1})
+
+		return errors.New("voucher cannot be created")
+	})
+	if err == nil {
+		t.Fatalf("Do() error = nil, want error")
+	}
+
+	if u, _ := users.Get(ctx, "john-doe"); u.Balance != usd(100) {
+		t.Errorf("Do() did not roll back, balance = %v", u.Balance)
+	}
+
+	if _, err = db.Stores().Orders.Get(ctx, "1"); err == nil {
+		t.Errorf("Do() did not roll back, order was created")
+	}
+
+	err = db.Do(ctx, func(ctx context.Context, s model.Stores) error {
+		return s.Orders.Put(ctx, "1", &model.Order{ID: 1})
+	})
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+
+	if _, err = db.Stores().Orders.Get(ctx, "1"); err != nil {
+		t.Errorf("Do() did not commit: %v", err)
+	}
+}
+
+func TestOpen_persistsData(t *testing.T) {
+	db, path, cleanup := openTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db.Stores().Users.Put(ctx, "john-doe", &model.User{Name: "John", LastName: "Doe", Balance: usd(100)})
+	db.Close()
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer reopened.Close()
+
+	if _, err = reopened.Stores().Users.Get(ctx, "john-doe"); err != nil {
+		t.Errorf("Open() lost data: %v", err)
+	}
+}
+
+func usd(dollars int64) model.Money {
+	return model.NewMoney(dollars*100, model.DefaultCurrency)
+}
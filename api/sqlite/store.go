@@ -0,0 +1,503 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// UserStore is the SQLite model.UserStore
+type UserStore struct {
+	q querier
+}
+
+// Get returns the user stored under key
+func (s *UserStore) Get(ctx context.Context, key string) (*model.User, error) {
+	u := &model.User{}
+	if err := getDoc(ctx, s.q, "users", key, u); err != nil {
+		return nil, notFound(err, model.ErrUserNotFound)
+	}
+
+	return u, nil
+}
+
+// Put saves the user under key, replacing any existing user
+func (s *UserStore) Put(ctx context.Context, key string, u *model.User) error {
+	if u == nil {
+		return errors.New("user cannot be nil")
+	}
+
+	return putDoc(ctx, s.q, "users", key, u)
+}
+
+// Delete removes the user stored under key
+func (s *UserStore) Delete(ctx context.Context, key string) error {
+	return notFound(deleteDoc(ctx, s.q, "users", key), model.ErrUserNotFound)
+}
+
+// List returns every user ordered by key
+func (s *UserStore) List(ctx context.Context) ([]*model.User, error) {
+	docs, err := listDocs(ctx, s.q, "users", "key")
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*model.User, 0, len(docs))
+	for _, d := range docs {
+		u := &model.User{}
+		if err = json.Unmarshal(d, u); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	return users, nil
+}
+
+// OrderStore is the SQLite model.OrderStore
+type OrderStore struct {
+	q querier
+}
+
+// Get returns the order stored under key
+func (s *OrderStore) Get(ctx context.Context, key string) (*model.Order, error) {
+	o := &model.Order{}
+	if err := getDoc(ctx, s.q, "orders", key, o); err != nil {
+		return nil, notFound(err, model.ErrOrderNotFound)
+	}
+
+	return o, nil
+}
+
+// Put saves the order under key, replacing any existing order
+func (s *OrderStore) Put(ctx context.Context, key string, o *model.Order) error {
+	if o == nil {
+		return errors.New("order cannot be nil")
+	}
+
+	return putDoc(ctx, s.q, "orders", key, o)
+}
+
+// Delete marks the order stored under key as deleted, orders are never removed
+func (s *OrderStore) Delete(ctx context.Context, key string) error {
+	o, err := s.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	o.IsDeleted = true
+
+	return s.Put(ctx, key, o)
+}
+
+// Remove removes the order stored under key
+func (s *OrderStore) Remove(ctx context.Context, key string) error {
+	return notFound(deleteDoc(ctx, s.q, "orders", key), model.ErrOrderNotFound)
+}
+
+// List returns every order, numeric keys are ordered by value
+func (s *OrderStore) List(ctx context.Context) ([]*model.Order, error) {
+	docs, err := listDocs(ctx, s.q, "orders", "CAST(key AS INTEGER), key")
+	if err != nil {
+		return nil, err
+	}
+
+	orders := make([]*model.Order, 0, len(docs))
+	for _, d := range docs {
+		o := &model.Order{}
+		if err = json.Unmarshal(d, o); err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+
+	return orders, nil
+}
+
+// VoucherStore is the SQLite model.VoucherStore
+type VoucherStore struct {
+	q querier
+}
+
+// Get returns the voucher account stored under key
+func (s *VoucherStore) Get(ctx context.Context, key string) (*model.Voucher, error) {
+	if len(strings.TrimSpace(key)) == 0 {
+		return nil, errors.New("key cannot be empty")
+	}
+
+	v := &model.Voucher{}
+	if err := getDoc(ctx, s.q, "vouchers", key, v); err != nil {
+		return nil, notFound(err, model.ErrAccountNotFound)
+	}
+
+	return v, nil
+}
+
+// Put saves the voucher account under key, replacing any existing account
+func (s *VoucherStore) Put(ctx context.Context, key string, v *model.Voucher) error {
+	if v == nil {
+		return errors.New("account cannot be nil")
+	}
+
+	return putDoc(ctx, s.q, "vouchers", key, v)
+}
+
+// Delete removes the voucher account stored under key
+func (s *VoucherStore) Delete(ctx context.Context, key string) error {
+	return notFound(deleteDoc(ctx, s.q, "vouchers", key), model.ErrAccountNotFound)
+}
+
+// List returns every voucher account ordered by key
+func (s *VoucherStore) List(ctx context.Context) ([]*model.Voucher, error) {
+	docs, err := listDocs(ctx, s.q, "vouchers", "key")
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := make([]*model.Voucher, 0, len(docs))
+	for _, d := range docs {
+		v := &model.Voucher{}
+		if err = json.Unmarshal(d, v); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, v)
+	}
+
+	return accounts, nil
+}
+
+// AuditStore is the SQLite model.AuditStore
+type AuditStore struct {
+	q querier
+}
+
+// Append adds the entries to the end of the log in one statement, so either all or none are saved
+func (s *AuditStore) Append(ctx context.Context, entries ...*model.AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(entries))
+	args := make([]interface{}, 0, len(entries))
+	for _, e := range entries {
+		if e == nil {
+			return errors.New("audit entry cannot be nil")
+		}
+
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		values = append(values, "(?)")
+		args = append(args, string(data))
+	}
+
+	_, err := s.q.ExecContext(ctx, "INSERT INTO audit_log (data) VALUES "+strings.Join(values, ", "), args...)
+
+	return err
+}
+
+// List returns every entry in the order they were appended
+func (s *AuditStore) List(ctx context.Context) ([]*model.AuditEntry, error) {
+	docs, err := listDocs(ctx, s.q, "audit_log", "id")
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*model.AuditEntry, 0, len(docs))
+	for _, d := range docs {
+		e := &model.AuditEntry{}
+		if err = json.Unmarshal(d, e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// IdempotencyStore is the SQLite model.IdempotencyStore
+type IdempotencyStore struct {
+	q querier
+}
+
+// Get returns the record stored under key
+func (s *IdempotencyStore) Get(ctx context.Context, key string) (*model.IdempotencyRecord, error) {
+	rec := &model.IdempotencyRecord{}
+	if err := getDoc(ctx, s.q, "idempotency_keys", key, rec); err != nil {
+		return nil, notFound(err, model.ErrIdempotencyKeyNotFound)
+	}
+
+	return rec, nil
+}
+
+// Put saves the record under key, replacing any existing record
+func (s *IdempotencyStore) Put(ctx context.Context, key string, rec *model.IdempotencyRecord) error {
+	if rec == nil {
+		return errors.New("idempotency record cannot be nil")
+	}
+
+	return putDoc(ctx, s.q, "idempotency_keys", key, rec)
+}
+
+// DeleteBefore removes the records created before t and returns how many were removed
+func (s *IdempotencyStore) DeleteBefore(ctx context.Context, t time.Time) (int, error) {
+	res, err := s.q.ExecContext(ctx,
+		"DELETE FROM idempotency_keys WHERE julianday(json_extract(data, '$.created_at')) < julianday(?)",
+		t.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// LedgerStore is the SQLite model.LedgerStore
+type LedgerStore struct {
+	q querier
+}
+
+// Append adds the entries to the end of the ledger in one statement, so either all or none are saved
+func (s *LedgerStore) Append(ctx context.Context, entries ...*model.LedgerEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(entries))
+	args := make([]interface{}, 0, 2*len(entries))
+	for _, e := range entries {
+		if e == nil {
+			return errors.New("ledger entry cannot be nil")
+		}
+
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		values = append(values, "(?, ?)")
+		args = append(args, e.UserKey, string(data))
+	}
+
+	_, err := s.q.ExecContext(ctx, "INSERT INTO ledger (user_key, data) VALUES "+strings.Join(values, ", "), args...)
+
+	return err
+}
+
+// List returns the entries of the user in the order they were appended
+func (s *LedgerStore) List(ctx context.Context, userKey string) ([]*model.LedgerEntry, error) {
+	docs, err := queryDocs(ctx, s.q, "SELECT data FROM ledger WHERE user_key = ? ORDER BY id", userKey)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*model.LedgerEntry, 0, len(docs))
+	for _, d := range docs {
+		e := &model.LedgerEntry{}
+		if err = json.Unmarshal(d, e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// PaymentRefundStore is the SQLite model.PaymentRefundStore
+type PaymentRefundStore struct {
+	q querier
+}
+
+// Get returns the refund stored under id
+func (s *PaymentRefundStore) Get(ctx context.Context, id string) (*model.PaymentRefund, error) {
+	r := &model.PaymentRefund{}
+	if err := getDoc(ctx, s.q, "payment_refunds", id, r); err != nil {
+		return nil, notFound(err, model.ErrRefundNotFound)
+	}
+
+	return r, nil
+}
+
+// Put saves the refund under id, replacing any existing refund
+func (s *PaymentRefundStore) Put(ctx context.Context, id string, r *model.PaymentRefund) error {
+	if r == nil {
+		return errors.New("payment refund cannot be nil")
+	}
+
+	return putDoc(ctx, s.q, "payment_refunds", id, r)
+}
+
+// Delete removes the refund stored under id
+func (s *PaymentRefundStore) Delete(ctx context.Context, id string) error {
+	return notFound(deleteDoc(ctx, s.q, "payment_refunds", id), model.ErrRefundNotFound)
+}
+
+// List returns every refund ordered by id
+func (s *PaymentRefundStore) List(ctx context.Context) ([]*model.PaymentRefund, error) {
+	docs, err := listDocs(ctx, s.q, "payment_refunds", "key")
+	if err != nil {
+		return nil, err
+	}
+
+	refunds := make([]*model.PaymentRefund, 0, len(docs))
+	for _, d := range docs {
+		r := &model.PaymentRefund{}
+		if err = json.Unmarshal(d, r); err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, r)
+	}
+
+	return refunds, nil
+}
+
+// RedemptionStore is the SQLite model.RedemptionStore
+type RedemptionStore struct {
+	q querier
+}
+
+// Get returns the redemption stored under id
+func (s *RedemptionStore) Get(ctx context.Context, id string) (*model.Redemption, error) {
+	r := &model.Redemption{}
+	if err := getDoc(ctx, s.q, "redemptions", id, r); err != nil {
+		return nil, notFound(err, model.ErrRedemptionNotFound)
+	}
+
+	return r, nil
+}
+
+// Put saves the redemption under id, replacing any existing redemption
+func (s *RedemptionStore) Put(ctx context.Context, id string, r *model.Redemption) error {
+	if r == nil {
+		return errors.New("redemption cannot be nil")
+	}
+
+	return putDoc(ctx, s.q, "redemptions", id, r)
+}
+
+// Delete removes the redemption stored under id
+func (s *RedemptionStore) Delete(ctx context.Context, id string) error {
+	return notFound(deleteDoc(ctx, s.q, "redemptions", id), model.ErrRedemptionNotFound)
+}
+
+// List returns every redemption ordered by id
+func (s *RedemptionStore) List(ctx context.Context) ([]*model.Redemption, error) {
+	docs, err := listDocs(ctx, s.q, "redemptions", "key")
+	if err != nil {
+		return nil, err
+	}
+
+	redemptions := make([]*model.Redemption, 0, len(docs))
+	for _, d := range docs {
+		r := &model.Redemption{}
+		if err = json.Unmarshal(d, r); err != nil {
+			return nil, err
+		}
+		redemptions = append(redemptions, r)
+	}
+
+	return redemptions, nil
+}
+
+// table names are never user input, they are interpolated into the queries below
+
+func getDoc(ctx context.Context, q querier, table, key string, v interface{}) error {
+	var data []byte
+	err := q.QueryRowContext(ctx, "SELECT data FROM "+table+" WHERE key = ?", key).Scan(&data)
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("cannot decode %s %s\n%s", table, key, err)
+	}
+
+	return nil
+}
+
+func putDoc(ctx context.Context, q querier, table, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.ExecContext(ctx,
+		"INSERT INTO "+table+" (key, data) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET data = excluded.data",
+		key, string(data))
+
+	return err
+}
+
+func deleteDoc(ctx context.Context, q querier, table, key string) error {
+	res, err := q.ExecContext(ctx, "DELETE FROM "+table+" WHERE key = ?", key)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func listDocs(ctx context.Context, q querier, table, orderBy string) ([][]byte, error) {
+	return queryDocs(ctx, q, "SELECT data FROM "+table+" ORDER BY "+orderBy)
+}
+
+// queryDocs runs a query selecting the data column and returns the documents
+func queryDocs(ctx context.Context, q querier, query string, args ...interface{}) ([][]byte, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs [][]byte
+	for rows.Next() {
+		var data []byte
+		if err = rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		docs = append(docs, data)
+	}
+
+	return docs, rows.Err()
+}
+
+// notFound replaces sql.ErrNoRows with the not found error of the in-memory stores
+func notFound(err error, notFoundErr error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFoundErr
+	}
+
+	return err
+}
+
+var (
+	_ model.UserStore    = (*UserStore)(nil)
+	_ model.OrderStore   = (*OrderStore)(nil)
+	_ model.VoucherStore = (*VoucherStore)(nil)
+	_ model.AuditStore   = (*AuditStore)(nil)
+	_ model.LedgerStore  = (*LedgerStore)(nil)
+
+	_ model.PaymentRefundStore = (*PaymentRefundStore)(nil)
+	_ model.RedemptionStore    = (*RedemptionStore)(nil)
+
+	_ model.IdempotencyStore = (*IdempotencyStore)(nil)
+)
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+// StateHandler sets the DBs of the server up for a named provider state.
+// Params are the optional v3 provider state parameters.
+type StateHandler func(ctx context.Context, s *Server, params map[string]interface{}) error
+
+// stateRegistry maps provider state names to their handlers
+type stateRegistry map[string]StateHandler
+
+// providerStates holds every provider state a consumer contract may declare.
+// States describing a scenario reset the DBs to the fixtures first, states
+// that create a single record add it on top of the current data.
+var providerStates = stateRegistry{
+	"user john-doe exists with a credit card order":           withFixtures(nil),
+	"user john-doe exists with a MENA cash-on-delivery order": withFixtures(nil),
+	"user john-doe has a refunded order":                      withFixtures(nil),
+	"user barbara-streisand does not exist":                   withFixtures(nil),
+	"order 987 does not exist":                                withFixtures(nil),
+	"order 4 belongs to jane-doe":                             withFixtures(nil),
+	"user jane-doe has a voucher account": withFixtures(func(ctx context.Context, s *Server) error {
+		return s.addVoucher(ctx, "jane-doe", usd(100))
+	}),
+	"a user exists":            addUserState,
+	"an order exists":          addOrderState,
+	"a voucher account exists": addVoucherState,
+}
+
+// Setup runs the handler of the given state on the server.
+// An error is returned if the state is not registered.
+func (sr stateRegistry) Setup(ctx context.Context, s *Server, state string, params map[string]interface{}) error {
+	handler, ok := sr[state]
+	if !ok {
+		return fmt.Errorf("unknown provider state: %s", state)
+	}
+
+	return handler(ctx, s, params)
+}
+
+// providerStateRequest is the body sent by pact verifiers to the provider states endpoint.
+// Both the single state and the list of states forms are accepted.
+type providerStateRequest struct {
+	Action string                 `json:"action"`
+	State  string                 `json:"state"`
+	States []string               `json:"states"`
+	Params map[string]interface{} `json:"params"`
+}
+
+// providerStatesHandler sets up the provider states requested by an external pact verifier.
+// It is only registered when the API runs in test mode.
+func (s *Server) providerStatesHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	s.storesMu.Lock()
+	defer s.storesMu.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	req := providerStateRequest{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, fmt.Errorf("%w: invalid provider state request", errInvalidRequest))
+		return
+	}
+
+	if req.Action == "teardown" {
+		if err := s.loadFixtures(); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	states := req.States
+	if req.State != "" {
+		states = append(states, req.State)
+	}
+
+	for _, state := range states {
+		if err := providerStates.Setup(r.Context(), s, state, req.Params); err != nil {
+			writeError(w, fmt.Errorf("%w: %s", errInvalidRequest, err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
+
+// withFixtures returns a state handler that resets the DBs to the fixtures and then runs setup
+func withFixtures(setup func(ctx context.Context, s *Server) error) StateHandler {
+	return func(ctx context.Context, s *Server, _ map[string]interface{}) error {
+		if err := s.loadFixtures(); err != nil {
+			return err
+		}
+
+		if setup == nil {
+			return nil
+		}
+
+		return setup(ctx, s)
+	}
+}
+
+// loadFixtures replaces the DBs of the server with in-memory stores holding the fixture data
+// used by tests and contracts, whatever backend the server was started with. While requests
+// may be served, the caller holds storesMu for writing. If a fixture cannot be loaded, the
+// DBs are left as they were.
+func (s *Server) loadFixtures() error {
+	usr := model.NewUserHandler()
+	for _, u := range fixtureUsers() {
+		tmp := u
+		if err := usr.AddToDB(&tmp); err != nil {
+			return fmt.Errorf("cannot load fixture user %s %s: %w", u.Name, u.LastName, err)
+		}
+	}
+
+	ord := model.NewOrderHandler()
+	for _, o := range fixtureOrders() {
+		tmp := o
+		if err := ord.AddToDB(&tmp); err != nil {
+			return fmt.Errorf("cannot load fixture order %d: %w", o.ID, err)
+		}
+	}
+
+	ldg := model.NewLedger()
+	for _, u := range fixtureUsers() {
+		key := model.GenerateKeyForUser(&u)
+		if err := appendOpeningBalance(context.Background(), ldg, key, model.BalanceAccount(key), u.Balance, s.clock.Now()); err != nil {
+			return fmt.Errorf("cannot load fixture balance of %s: %w", key, err)
+		}
+	}
+
+	s.useStores(model.Stores{Users: usr, Orders: ord, Vouchers: model.NewVoucherHandler(), Ledger: ldg}, nil)
+	return nil
+}
+
+func addUserState(ctx context.Context, s *Server, params map[string]interface{}) error {
+	u := model.User{}
+	if err := decodeParams(params, &u); err != nil {
+		return err
+	}
+
+	if _, err := model.NewUser(u.Name, u.LastName); err != nil {
+		return err
+	}
+
+	key := model.GenerateKeyForUser(&u)
+	if err := s.usr.Put(ctx, key, &u); err != nil {
+		return err
+	}
+
+	return appendOpeningBalance(ctx, s.ldg, key, model.BalanceAccount(key), u.Balance, s.clock.Now())
+}
+
+func addOrderState(ctx context.Context, s *Server, params map[string]interface{}) error {
+	o := model.Order{}
+	if err := decodeParams(params, &o); err != nil {
+		return err
+	}
+
+	if err := o.CheckTotal(); err != nil {
+		return err
+	}
+
+	// orders are keyed by their ID so the state can refer to it in the request path
+	return s.ord.Put(ctx, strconv.Itoa(o.ID), &o)
+}
+
+func addVoucherState(ctx context.Context, s *Server, params map[string]interface{}) error {
+	p := struct {
+		UserKey string      `json:"user_key"`
+		Balance model.Money `json:"balance"`
+	}{}
+	if err := decodeParams(params, &p); err != nil {
+		return err
+	}
+
+	return s.addVoucher(ctx, p.UserKey, p.Balance)
+}
+
+func (s *Server) addVoucher(ctx context.Context, userKey string, balance model.Money) error {
+	va, err := model.NewVoucher(balance, userKey)
+	if err != nil {
+		return err
+	}
+
+	voucherKey := model.GenerateKeyForVoucher(userKey, va.Currency)
+	if err = s.vch.Put(ctx, voucherKey, &va); err != nil {
+		return err
+	}
+
+	return appendOpeningBalance(ctx, s.ldg, userKey, model.VoucherAccount(voucherKey), va.Balance, s.clock.Now())
+}
+
+// decodeParams copies the provider state params into the given struct using its JSON tags
+func decodeParams(params map[string]interface{}, v interface{}) error {
+	if len(params) == 0 {
+		return errors.New("provider state params are missing")
+	}
+
+	b, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("invalid provider state params\n%s", err)
+	}
+
+	return nil
+}
+
+// usd returns the given whole amount of dollars
+func usd(dollars int64) model.Money {
+	return model.NewMoney(dollars*100, model.DefaultCurrency)
+}
+
+func fixtureOrders() []model.Order {
+	return []model.Order{
+		{
+			ID:                  1,
+			Lines:               []model.LineItem{{SKU: "SKU-1", Quantity: 2, UnitPrice: usd(40)}},
+			ShippingFee:         usd(15),
+			Tax:                 usd(5),
+			Total:               usd(100),
+			PaymentWay:          model.CreditCard,
+			PaymentRef:          "card_1001",
+			ShippingCountryZone: model.ZoneEurope,
+			IsDeleted:           false,
+		},
+		{
+			ID:                  2,
+			Total:               usd(200),
+			PaymentWay:          model.CashOnDelivery,
+			ShippingCountryZone: model.ZoneMena,
+			IsDeleted:           true,
+		},
+		{
+			ID:                  3,
+			Total:               usd(300),
+			PaymentWay:          model.CashOnDelivery,
+			ShippingCountryZone: model.ZoneMena,
+			IsDeleted:           false,
+		},
+		{
+			ID:                  4,
+			Total:               usd(150),
+			PaymentWay:          model.CashOnDelivery,
+			ShippingCountryZone: model.ZoneMena,
+			IsDeleted:           false,
+		},
+	}
+}
+
+func fixtureUsers() []model.User {
+	return []model.User{
+		{
+			Name:     "John",
+			LastName: "Doe",
+			Balance:  usd(100),
+			Orders:   []int{1, 2, 3},
+		},
+		{
+			Name:     "Jane",
+			LastName: "Doe",
+			Balance:  usd(150),
+			Orders:   []int{4},
+		},
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+func Test_stateRegistry_Setup(t *testing.T) {
+	tests := []struct {
+		name    string
+		state   string
+		params  map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name:    "fails when state is unknown",
+			state:   "the moon is made of cheese",
+			wantErr: true,
+		},
+		{
+			name:    "fails when params are missing",
+			state:   "a user exists",
+			wantErr: true,
+		},
+		{
+			name:    "sets up fixture state",
+			state:   "user jane-doe has a voucher account",
+			wantErr: false,
+		},
+		{
+			name:  "adds user",
+			state: "a user exists",
+			params: map[string]interface{}{
+				"Name":     "Eric",
+				"LastName": "Smith",
+				"Balance":  10,
+			},
+			wantErr: false,
+		},
+		{
+			name:  "adds order keyed by its ID",
+			state: "an order exists",
+			params: map[string]interface{}{
+				"ID":          987,
+				"Total":       10,
+				"PaymentWay":  model.Paypal,
+				"CountryZone": model.ZoneAmerica,
+			},
+			wantErr: false,
+		},
+	}
+	srv := newTestServer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := providerStates.Setup(context.Background(), srv, tt.state, tt.params); (err != nil) != tt.wantErr {
+				t.Errorf("Setup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := srv.ord.Get(context.Background(), "987"); err != nil {
+		t.Errorf("Setup() did not add order 987: %v", err)
+	}
+}
+
+func Test_providerStatesHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		testMode bool
+		body     string
+		want     int
+	}{
+		{
+			name:     "is not registered outside test mode",
+			testMode: false,
+			body:     `{"state": "user jane-doe has a voucher account"}`,
+			want:     http.StatusNotFound,
+		},
+		{
+			name:     "returns bad request status when body is invalid",
+			testMode: true,
+			body:     `state`,
+			want:     http.StatusBadRequest,
+		},
+		{
+			name:     "returns bad request status when state is unknown",
+			testMode: true,
+			body:     `{"state": "the moon is made of cheese"}`,
+			want:     http.StatusBadRequest,
+		},
+		{
+			name:     "sets up single state",
+			testMode: true,
+			body:     `{"action": "setup", "state": "user jane-doe has a voucher account"}`,
+			want:     http.StatusOK,
+		},
+		{
+			name:     "sets up list of states",
+			testMode: true,
+			body:     `{"consumer": "refund-client", "states": ["user john-doe has a refunded order"]}`,
+			want:     http.StatusOK,
+		},
+		{
+			name:     "tears down state",
+			testMode: true,
+			body:     `{"action": "teardown", "state": "user jane-doe has a voucher account"}`,
+			want:     http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+
+			req := httptest.NewRequest(http.MethodPost, "/_pact/provider-states", bytes.NewBufferString(tt.body))
+			rr := httptest.NewRecorder()
+
+			srv.Router(tt.testMode).ServeHTTP(rr, req)
+
+			if rr.Code != tt.want {
+				t.Errorf("providerStatesHandler() = %v, want %v", rr.Code, tt.want)
+			}
+
+			if tt.want == http.StatusBadRequest {
+				var res errorResponse
+				if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil || res.Error.Code != codeInvalidRequest {
+					t.Errorf("providerStatesHandler() body = %s, want an %s error", rr.Body, codeInvalidRequest)
+				}
+			}
+		})
+	}
+}
+
+func Test_providerStatesHandler_whileServing(t *testing.T) {
+	router := newTestServer().Router(true)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/_pact/provider-states", bytes.NewBufferString(`{"action": "teardown"}`))
+			router.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/users/john-doe", nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Errorf("userHandler() while the stores are replaced = %v, want %v", rr.Code, http.StatusOK)
+			}
+		}()
+	}
+	wg.Wait()
+}
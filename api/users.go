@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+// Page sizes of the lists
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// userResponse is a user as returned by the users endpoints, with the key it is stored under
+type userResponse struct {
+	Key string `json:"Key"`
+	*model.User
+}
+
+// usersResponse is a page of the user list ordered by key
+type usersResponse struct {
+	Users  []userResponse `json:"users"`
+	Total  int            `json:"total"` // number of users on every page
+	Offset int            `json:"offset"`
+	Limit  int            `json:"limit"`
+}
+
+// userPatch holds the fields of a user a PATCH request changes, missing fields are left as they are.
+// The balance only changes through the ledger, so it cannot be patched.
+type userPatch struct {
+	Name            *string         `json:"Name"`
+	LastName        *string         `json:"LastName"`
+	Orders          *[]int          `json:"Orders"`
+	VoucherCurrency *string         `json:"VoucherCurrency"`
+	Balance         json.RawMessage `json:"Balance"`
+}
+
+// listUsersHandler returns a page of the users. The page is picked by the offset and limit
+// query parameters. Only support and admin actors can list the users.
+func (s *Server) listUsersHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if _, ok := s.staffFromRequest(w, r); !ok {
+		return
+	}
+
+	offset, limit, err := pageFromRequest(r)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %s", errInvalidRequest, err))
+		return
+	}
+
+	users, err := s.usr.List(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res := usersResponse{Users: []userResponse{}, Total: len(users), Offset: offset, Limit: limit}
+	from, to := pageBounds(len(users), offset, limit)
+	for _, u := range users[from:to] {
+		res.Users = append(res.Users, userResponse{Key: model.GenerateKeyForUser(u), User: u})
+	}
+
+	writeJSON(w, http.StatusOK, &res, "users")
+}
+
+// userHandler returns the user with the given key. Users can only see themselves.
+func (s *Server) userHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	userKey := ps.ByName("userKey")
+
+	actor, err := actorFromRequest(r, userKey)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %s", errInvalidRequest, err))
+		return
+	}
+
+	if !actor.canActFor(userKey) {
+		writeError(w, ErrActorNotAllowed)
+		return
+	}
+
+	user, err := s.usr.Get(r.Context(), userKey)
+	if errors.Is(err, model.ErrUserNotFound) {
+		err = fmt.Errorf("%w: %s", model.ErrUserNotFound, userKey)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, userResponse{Key: userKey, User: user}, "user")
+}
+
+// createUserHandler adds the user in the body under the key generated from its name.
+// The orders of the user must exist. New users start with a zero balance, like updates
+// the balance can only change through refunds. Only support and admin actors can create users.
+func (s *Server) createUserHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	actor, ok := s.staffFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var user model.User
+	if err := json.Unmarshal(body, &user); err != nil {
+		writeError(w, fmt.Errorf("%w: body is not a valid user\n%s", errInvalidRequest, err))
+		return
+	}
+
+	if !user.Balance.IsZero() {
+		writeError(w, fmt.Errorf("%w: balance can only change through refunds", model.ErrInvalidUser))
+		return
+	}
+
+	user.Name, user.LastName = strings.TrimSpace(user.Name), strings.TrimSpace(user.LastName)
+	if err := user.Validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	userKey := model.GenerateKeyForUser(&user)
+
+	// owners of orders are checked across users, so orders are assigned one user at a time
+	unlock := s.locks.Lock("orders", "user:"+userKey)
+	defer unlock()
+
+	err := s.uow.Do(r.Context(), func(ctx context.Context, st model.Stores) error {
+		_, err := st.Users.Get(ctx, userKey)
+		if err == nil {
+			return fmt.Errorf("%w: %s", model.ErrUserExists, userKey)
+		}
+		if !errors.Is(err, model.ErrUserNotFound) {
+			return err
+		}
+
+		if err = checkOrders(ctx, st, userKey, user.Orders); err != nil {
+			return err
+		}
+
+		if err = st.Users.Put(ctx, userKey, &user); err != nil {
+			return err
+		}
+
+		return st.Audit.Append(ctx, userAuditEntry(actor, model.AuditUserCreated, userKey, "", s.clock.Now()))
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/users/"+userKey)
+	writeJSON(w, http.StatusCreated, userResponse{Key: userKey, User: &user}, "user")
+}
+
+// updateUserHandler changes the fields of the user given in the body.
+// The name can only change as long as the key of the user stays the same.
+// Only support and admin actors can update users.
+func (s *Server) updateUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	userKey := ps.ByName("userKey")
+
+	actor, ok := s.staffFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var patch userPatch
+	if err := json.Unmarshal(body, &patch); err != nil {
+		writeError(w, fmt.Errorf("%w: body is not a valid user\n%s", errInvalidRequest, err))
+		return
+	}
+
+	if len(patch.Balance) > 0 {
+		writeError(w, fmt.Errorf("%w: balance can only change through refunds", model.ErrInvalidUser))
+		return
+	}
+
+	locks := []string{"user:" + userKey}
+	if patch.Orders != nil {
+		// owners of orders are checked across users, so orders are assigned one user at a time
+		locks = append(locks, "orders")
+	}
+	unlock := s.locks.Lock(locks...)
+	defer unlock()
+
+	var user *model.User
+	err := s.uow.Do(r.Context(), func(ctx context.Context, st model.Stores) error {
+		var err error
+		user, err = st.Users.Get(ctx, userKey)
+		if errors.Is(err, model.ErrUserNotFound) {
+			return fmt.Errorf("%w: %s", model.ErrUserNotFound, userKey)
+		}
+		if err != nil {
+			return err
+		}
+
+		changed := patch.apply(user)
+		if err = user.Validate(); err != nil {
+			return err
+		}
+
+		if key := model.GenerateKeyForUser(user); key != userKey {
+			return fmt.Errorf("%w: renaming the user would change its key to %s", model.ErrInvalidUser, key)
+		}
+
+		if patch.Orders != nil {
+			if err = checkOrders(ctx, st, userKey, user.Orders); err != nil {
+				return err
+			}
+		}
+
+		if err = st.Users.Put(ctx, userKey, user); err != nil {
+			return err
+		}
+
+		return st.Audit.Append(ctx, userAuditEntry(actor, model.AuditUserUpdated, userKey, strings.Join(changed, ","), s.clock.Now()))
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, userResponse{Key: userKey, User: user}, "user")
+}
+
+// deleteUserHandler removes the user with the given key. The ledger and the voucher accounts
+// of the user are kept. Only support and admin actors can delete users.
+func (s *Server) deleteUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	userKey := ps.ByName("userKey")
+
+	actor, ok := s.staffFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	unlock := s.locks.Lock("user:" + userKey)
+	defer unlock()
+
+	err := s.uow.Do(r.Context(), func(ctx context.Context, st model.Stores) error {
+		err := st.Users.Delete(ctx, userKey)
+		if errors.Is(err, model.ErrUserNotFound) {
+			return fmt.Errorf("%w: %s", model.ErrUserNotFound, userKey)
+		}
+		if err != nil {
+			return err
+		}
+
+		return st.Audit.Append(ctx, userAuditEntry(actor, model.AuditUserDeleted, userKey, "", s.clock.Now()))
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apply copies the patched fields to the user and returns their names
+func (p userPatch) apply(u *model.User) []string {
+	var changed []string
+	if p.Name != nil {
+		u.Name, changed = strings.TrimSpace(*p.Name), append(changed, "Name")
+	}
+	if p.LastName != nil {
+		u.LastName, changed = strings.TrimSpace(*p.LastName), append(changed, "LastName")
+	}
+	if p.Orders != nil {
+		u.Orders, changed = *p.Orders, append(changed, "Orders")
+	}
+	if p.VoucherCurrency != nil {
+		u.VoucherCurrency, changed = *p.VoucherCurrency, append(changed, "VoucherCurrency")
+	}
+
+	return changed
+}
+
+// staffFromRequest returns the support or admin actor of the request.
+// Otherwise the error is written and false is returned.
+func (s *Server) staffFromRequest(w http.ResponseWriter, r *http.Request) (Actor, bool) {
+	actor, err := actorFromRequest(r, "")
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %s", errInvalidRequest, err))
+		return Actor{}, false
+	}
+
+	if !actor.canOverrideOwnership() {
+		writeError(w, ErrActorNotAllowed)
+		return Actor{}, false
+	}
+
+	return actor, true
+}
+
+// pageFromRequest reads the offset and limit query parameters
+func pageFromRequest(r *http.Request) (offset, limit int, err error) {
+	q := r.URL.Query()
+	offset, limit = 0, defaultPageLimit
+
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer: %s", v)
+		}
+	}
+
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxPageLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d: %s", maxPageLimit, v)
+		}
+	}
+
+	return offset, limit, nil
+}
+
+// pageBounds returns the bounds of the page in a list of n items
+func pageBounds(n, offset, limit int) (from, to int) {
+	if offset > n {
+		offset = n
+	}
+
+	if limit > n-offset {
+		limit = n - offset
+	}
+
+	return offset, offset + limit
+}
+
+// checkOrders returns an error wrapping model.ErrInvalidUser if one of the orders does not exist
+// or belongs to a user other than the one with the given key
+func checkOrders(ctx context.Context, st model.Stores, userKey string, orders []int) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	users, err := st.Users.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	owners := make(map[int]string)
+	for _, u := range users {
+		if key := model.GenerateKeyForUser(u); key != userKey {
+			for _, id := range u.Orders {
+				owners[id] = key
+			}
+		}
+	}
+
+	for _, id := range orders {
+		_, err := st.Orders.Get(ctx, strconv.Itoa(id))
+		if errors.Is(err, model.ErrOrderNotFound) {
+			return fmt.Errorf("%w: order %d does not exist", model.ErrInvalidUser, id)
+		}
+		if err != nil {
+			return err
+		}
+
+		if owner, ok := owners[id]; ok {
+			return fmt.Errorf("%w: order %d belongs to %s", model.ErrInvalidUser, id, owner)
+		}
+	}
+
+	return nil
+}
+
+// userAuditEntry returns an entry recording a change the actor made to the user at now
+func userAuditEntry(actor Actor, action, userKey, details string, now time.Time) *model.AuditEntry {
+	return &model.AuditEntry{
+		Time:      now.UTC(),
+		Action:    action,
+		ActorKey:  actor.Key,
+		ActorRole: actor.Role,
+		UserKey:   userKey,
+		Details:   details,
+	}
+}
+
+// writeJSON writes v as the body of the response with the given status, name is used in the log
+func writeJSON(w http.ResponseWriter, status int, v interface{}, name string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("cannot write %s response: %s", name, err)
+	}
+}
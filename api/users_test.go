@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+// serveAs serves the request with the actor headers set, without a role the user acts for themselves
+func serveAs(srv *Server, method, path, body, actor, role string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	if actor != "" {
+		req.Header.Set(headerActorKey, actor)
+		req.Header.Set(headerActorRole, role)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.Router(false).ServeHTTP(rr, req)
+
+	return rr
+}
+
+func Test_listUsersHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		role     string
+		wantCode int
+		wantKeys []string
+	}{
+		{name: "returns the first page", role: RoleSupport, wantCode: http.StatusOK, wantKeys: []string{"jane-doe", "john-doe"}},
+		{name: "returns the given page", query: "?offset=1&limit=1", role: RoleAdmin, wantCode: http.StatusOK, wantKeys: []string{"john-doe"}},
+		{name: "returns an empty page past the end", query: "?offset=5", role: RoleSupport, wantCode: http.StatusOK, wantKeys: []string{}},
+		{name: "returns bad request for invalid limits", query: "?limit=1000", role: RoleSupport, wantCode: http.StatusBadRequest},
+		{name: "returns bad request for negative offsets", query: "?offset=-1", role: RoleSupport, wantCode: http.StatusBadRequest},
+		{name: "returns forbidden for customers", role: RoleCustomer, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serveAs(newTestServer(), http.MethodGet, "/users"+tt.query, "", "agent-1", tt.role)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("listUsersHandler() status = %d, want %d\n%s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var got usersResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			keys := []string{}
+			for _, u := range got.Users {
+				keys = append(keys, u.Key)
+			}
+
+			if got.Total != 2 || len(keys) != len(tt.wantKeys) {
+				t.Fatalf("listUsersHandler() = %d of %d users %v, want %v", len(keys), got.Total, keys, tt.wantKeys)
+			}
+
+			for i := range keys {
+				if keys[i] != tt.wantKeys[i] {
+					t.Errorf("listUsersHandler() keys = %v, want %v", keys, tt.wantKeys)
+				}
+			}
+		})
+	}
+}
+
+func Test_userHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		userKey  string
+		actor    string
+		role     string
+		wantCode int
+	}{
+		{name: "returns the user to themselves", userKey: "john-doe", wantCode: http.StatusOK},
+		{name: "returns the user to staff", userKey: "john-doe", actor: "agent-1", role: RoleSupport, wantCode: http.StatusOK},
+		{name: "returns forbidden to other users", userKey: "john-doe", actor: "jane-doe", wantCode: http.StatusForbidden},
+		{name: "returns not found for unknown users", userKey: "eric-smith", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serveAs(newTestServer(), http.MethodGet, "/users/"+tt.userKey, "", tt.actor, tt.role)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("userHandler() status = %d, want %d\n%s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var got userResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Key != tt.userKey || got.Name != "John" || got.Balance != usd(100) || len(got.Orders) != 3 {
+				t.Errorf("userHandler() = %+v, want john-doe", got)
+			}
+		})
+	}
+}
+
+// addUnownedOrder saves a copy of order 3 under the given ID without adding it to a user
+func addUnownedOrder(t *testing.T, srv *Server, id int) {
+	ctx := context.Background()
+
+	order, err := srv.ord.Get(ctx, "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order.ID = id
+	if err = srv.ord.Put(ctx, strconv.Itoa(id), order); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_createUserHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		role     string
+		wantCode int
+	}{
+		{
+			name:     "creates the user",
+			body:     `{"Name": "Eric", "LastName": "Smith", "Balance": {"amount": "0.00", "currency": "USD"}, "Orders": [5], "VoucherCurrency": "AED"}`,
+			role:     RoleAdmin,
+			wantCode: http.StatusCreated,
+		},
+		{name: "returns unprocessable entity for a balance", body: `{"Name": "Eric", "LastName": "Smith", "Balance": {"amount": "25.00", "currency": "USD"}}`, role: RoleAdmin, wantCode: http.StatusUnprocessableEntity},
+		{name: "returns unprocessable entity for empty names", body: `{"Name": "Eric", "LastName": " "}`, role: RoleSupport, wantCode: http.StatusUnprocessableEntity},
+		{name: "returns unprocessable entity for unknown orders", body: `{"Name": "Eric", "LastName": "Smith", "Orders": [987]}`, role: RoleSupport, wantCode: http.StatusUnprocessableEntity},
+		{name: "returns unprocessable entity for orders of other users", body: `{"Name": "Eric", "LastName": "Smith", "Orders": [5, 1]}`, role: RoleSupport, wantCode: http.StatusUnprocessableEntity},
+		{name: "returns conflict for existing users", body: `{"Name": "JOHN", "LastName": "Doe"}`, role: RoleSupport, wantCode: http.StatusConflict},
+		{name: "returns bad request for invalid bodies", body: `{"Name": 1}`, role: RoleSupport, wantCode: http.StatusBadRequest},
+		{name: "returns forbidden for customers", body: `{"Name": "Eric", "LastName": "Smith"}`, role: RoleCustomer, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			ctx := context.Background()
+			now := useTestClock(srv)
+			addUnownedOrder(t, srv, 5)
+
+			rr := serveAs(srv, http.MethodPost, "/users", tt.body, "agent-1", tt.role)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("createUserHandler() status = %d, want %d\n%s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			if tt.wantCode != http.StatusCreated {
+				if users, _ := srv.usr.List(ctx); len(users) != 2 {
+					t.Errorf("createUserHandler() left %d users, want 2", len(users))
+				}
+				return
+			}
+
+			if got := rr.Header().Get("Location"); got != "/users/eric-smith" {
+				t.Errorf("createUserHandler() location = %s, want /users/eric-smith", got)
+			}
+
+			user, err := srv.usr.Get(ctx, "eric-smith")
+			if err != nil || !user.Balance.IsZero() || user.VoucherCurrency != "AED" {
+				t.Fatalf("created user = %+v, %v", user, err)
+			}
+
+			if entries, _ := srv.ldg.List(ctx, "eric-smith"); len(entries) != 0 {
+				t.Errorf("ledger of the created user = %v, want no entries", entries)
+			}
+
+			audit, _ := srv.aud.List(ctx)
+			if len(audit) != 1 || audit[0].Action != model.AuditUserCreated || audit[0].ActorKey != "agent-1" || !audit[0].Time.Equal(*now) {
+				t.Errorf("audit log = %v, want the creation of the user at %v", audit, *now)
+			}
+		})
+	}
+}
+
+func Test_updateUserHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		userKey  string
+		body     string
+		role     string
+		wantCode int
+		want     model.User
+	}{
+		{
+			name:     "changes the given fields",
+			userKey:  "john-doe",
+			body:     `{"Orders": [1, 2], "VoucherCurrency": "EUR"}`,
+			role:     RoleSupport,
+			wantCode: http.StatusOK,
+			want:     model.User{Name: "John", LastName: "Doe", Balance: usd(100), Orders: []int{1, 2}, VoucherCurrency: "EUR"},
+		},
+		{
+			name:     "changes the case of the name",
+			userKey:  "john-doe",
+			body:     `{"Name": "JOHN"}`,
+			role:     RoleAdmin,
+			wantCode: http.StatusOK,
+			want:     model.User{Name: "JOHN", LastName: "Doe", Balance: usd(100), Orders: []int{1, 2, 3}},
+		},
+		{name: "returns unprocessable entity for orders of other users", userKey: "john-doe", body: `{"Orders": [1, 2, 3, 4]}`, role: RoleSupport, wantCode: http.StatusUnprocessableEntity},
+		{name: "returns unprocessable entity for renames changing the key", userKey: "john-doe", body: `{"Name": "Johnny"}`, role: RoleSupport, wantCode: http.StatusUnprocessableEntity},
+		{name: "returns unprocessable entity for balances", userKey: "john-doe", body: `{"Balance": "1000"}`, role: RoleSupport, wantCode: http.StatusUnprocessableEntity},
+		{name: "returns unprocessable entity for invalid currencies", userKey: "john-doe", body: `{"VoucherCurrency": "euro"}`, role: RoleSupport, wantCode: http.StatusUnprocessableEntity},
+		{name: "returns not found for unknown users", userKey: "eric-smith", body: `{}`, role: RoleSupport, wantCode: http.StatusNotFound},
+		{name: "returns forbidden for customers", userKey: "john-doe", body: `{"VoucherCurrency": "EUR"}`, role: RoleCustomer, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+
+			rr := serveAs(srv, http.MethodPatch, "/users/"+tt.userKey, tt.body, "agent-1", tt.role)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("updateUserHandler() status = %d, want %d\n%s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			user, _ := srv.usr.Get(context.Background(), "john-doe")
+			if tt.wantCode != http.StatusOK {
+				if user.Name != "John" || user.VoucherCurrency != "" {
+					t.Errorf("updateUserHandler() changed the user to %+v", user)
+				}
+				return
+			}
+
+			if user.Name != tt.want.Name || user.VoucherCurrency != tt.want.VoucherCurrency || len(user.Orders) != len(tt.want.Orders) || user.Balance != tt.want.Balance {
+				t.Errorf("updated user = %+v, want %+v", user, tt.want)
+			}
+		})
+	}
+}
+
+func Test_deleteUserHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		userKey  string
+		role     string
+		wantCode int
+	}{
+		{name: "deletes the user", userKey: "jane-doe", role: RoleAdmin, wantCode: http.StatusNoContent},
+		{name: "returns not found for unknown users", userKey: "eric-smith", role: RoleSupport, wantCode: http.StatusNotFound},
+		{name: "returns forbidden for customers", userKey: "jane-doe", role: RoleCustomer, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+
+			rr := serveAs(srv, http.MethodDelete, "/users/"+tt.userKey, "", "agent-1", tt.role)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("deleteUserHandler() status = %d, want %d\n%s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			_, err := srv.usr.Get(context.Background(), "jane-doe")
+			if deleted := err != nil; deleted != (tt.wantCode == http.StatusNoContent) {
+				t.Errorf("deleteUserHandler() deleted jane-doe = %v", deleted)
+			}
+		})
+	}
+}
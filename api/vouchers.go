@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+// voucherResponse is a voucher account as returned by the voucher endpoints
+type voucherResponse struct {
+	Key      string            `json:"Key"`
+	UserKey  string            `json:"UserKey"`
+	Balance  model.Money       `json:"Balance"`
+	Currency string            `json:"Currency"`
+	Lots     []model.CreditLot `json:"Lots,omitempty"`
+}
+
+// userVouchersResponse is the body returned by the voucher accounts endpoint of a user
+type userVouchersResponse struct {
+	UserKey  string            `json:"user_key"`
+	Vouchers []voucherResponse `json:"vouchers"`
+}
+
+// voucherAdjustment is the body of a goodwill credit or a debit made by staff.
+// Amounts without a currency are in the currency of the voucher account.
+type voucherAdjustment struct {
+	UserKey   string          `json:"user_key,omitempty"` // credits only
+	Amount    json.RawMessage `json:"amount"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"` // credits only, the credit never expires if nil
+	Note      string          `json:"note,omitempty"`       // written to the audit log
+}
+
+// newVoucherResponse returns the voucher account stored under the key as returned by the API
+func newVoucherResponse(key string, v *model.Voucher) voucherResponse {
+	return voucherResponse{Key: key, UserKey: v.UserKey(), Balance: v.Balance, Currency: v.Currency, Lots: v.Lots}
+}
+
+// userVouchersHandler returns the voucher accounts of the user ordered by key.
+// Users can only see their own accounts.
+func (s *Server) userVouchersHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	userKey := ps.ByName("userKey")
+
+	actor, err := actorFromRequest(r, userKey)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %s", errInvalidRequest, err))
+		return
+	}
+
+	if !actor.canActFor(userKey) {
+		writeError(w, ErrActorNotAllowed)
+		return
+	}
+
+	if _, err = s.usr.Get(r.Context(), userKey); err != nil {
+		if errors.Is(err, model.ErrUserNotFound) {
+			err = fmt.Errorf("%w: %s", model.ErrUserNotFound, userKey)
+		}
+		writeError(w, err)
+		return
+	}
+
+	accounts, err := s.vch.List(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res := userVouchersResponse{UserKey: userKey, Vouchers: []voucherResponse{}}
+	for _, v := range accounts {
+		if v.UserKey() == userKey {
+			res.Vouchers = append(res.Vouchers, newVoucherResponse(model.GenerateKeyForVoucher(userKey, v.Currency), v))
+		}
+	}
+
+	writeJSON(w, http.StatusOK, &res, "vouchers")
+}
+
+// creditVoucherHandler adds a goodwill credit to the voucher account of the user. Amounts
+// without a currency go to the account in the voucher currency of the user, an account is
+// opened if the user has none in the currency. The credit is a lot of the account issued now,
+// it expires at expires_at if given. Only support and admin actors can credit voucher
+// accounts, every credit is written to the ledger and the audit log.
+func (s *Server) creditVoucherHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	actor, ok := s.staffFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	adj, ok := readVoucherAdjustment(w, r)
+	if !ok {
+		return
+	}
+
+	if strings.TrimSpace(adj.UserKey) == "" {
+		writeError(w, fmt.Errorf("%w: user_key is missing", errInvalidRequest))
+		return
+	}
+
+	unlock := s.locks.Lock("user:"+adj.UserKey, vouchersLock(adj.UserKey))
+	defer unlock()
+
+	var res voucherResponse
+	err := s.uow.Do(r.Context(), func(ctx context.Context, st model.Stores) error {
+		user, err := st.Users.Get(ctx, adj.UserKey)
+		if errors.Is(err, model.ErrUserNotFound) {
+			return fmt.Errorf("%w: %s", model.ErrUserNotFound, adj.UserKey)
+		}
+		if err != nil {
+			return err
+		}
+
+		currency := user.VoucherCurrency
+		if currency == "" {
+			currency = model.DefaultCurrency
+		}
+
+		amount, err := model.UnmarshalMoney(adj.Amount, currency)
+		if err != nil {
+			return err
+		}
+
+		key := model.GenerateKeyForVoucher(adj.UserKey, amount.Currency)
+		account, err := st.Vouchers.Get(ctx, key)
+		if errors.Is(err, model.ErrAccountNotFound) {
+			var va model.Voucher
+			if va, err = model.NewVoucher(model.Money{Currency: amount.Currency}, adj.UserKey); err != nil {
+				return err
+			}
+			account = &va
+		}
+		if err != nil {
+			return err
+		}
+
+		now := s.clock.Now()
+		if _, err = account.Credit(amount, now, adj.ExpiresAt); err != nil {
+			return err
+		}
+
+		if err = st.Vouchers.Put(ctx, key, account); err != nil {
+			return err
+		}
+
+		res = newVoucherResponse(key, account)
+		return recordVoucherAdjustment(ctx, st, actor, account, key, amount, model.LedgerGoodwillCredit, model.AuditVoucherCredit, adj.Note, now)
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &res, "voucher")
+}
+
+// debitVoucherHandler takes an amount off the voucher account with the given key, the balance
+// cannot go below zero. Expired credits are taken off first and cannot be debited. Only support
+// and admin actors can debit voucher accounts, every debit is written to the ledger and the audit log.
+func (s *Server) debitVoucherHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key := ps.ByName("key")
+
+	actor, ok := s.staffFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	adj, ok := readVoucherAdjustment(w, r)
+	if !ok {
+		return
+	}
+
+	// the owner is needed to lock the vouchers of the user, the account is read again once locked
+	account, err := s.vch.Get(r.Context(), key)
+	if errors.Is(err, model.ErrAccountNotFound) {
+		err = fmt.Errorf("%w: %s", model.ErrAccountNotFound, key)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	unlock := s.locks.Lock(vouchersLock(account.UserKey()))
+	defer unlock()
+
+	var res voucherResponse
+	err = s.uow.Do(r.Context(), func(ctx context.Context, st model.Stores) error {
+		account, err := st.Vouchers.Get(ctx, key)
+		if errors.Is(err, model.ErrAccountNotFound) {
+			return fmt.Errorf("%w: %s", model.ErrAccountNotFound, key)
+		}
+		if err != nil {
+			return err
+		}
+
+		amount, err := model.UnmarshalMoney(adj.Amount, account.Currency)
+		if err != nil {
+			return err
+		}
+
+		now := s.clock.Now()
+		if err = expireVoucher(ctx, st, account, key, now); err != nil {
+			return err
+		}
+
+		if _, err = account.Debit(amount); err != nil {
+			return err
+		}
+
+		if err = st.Vouchers.Put(ctx, key, account); err != nil {
+			return err
+		}
+
+		res = newVoucherResponse(key, account)
+		return recordVoucherAdjustment(ctx, st, actor, account, key, amount.Neg(), model.LedgerVoucherDebit, model.AuditVoucherDebit, adj.Note, now)
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &res, "voucher")
+}
+
+// readVoucherAdjustment reads the body of a credit or a debit.
+// Otherwise the error is written and false is returned.
+func readVoucherAdjustment(w http.ResponseWriter, r *http.Request) (voucherAdjustment, bool) {
+	body, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var adj voucherAdjustment
+	if err := json.Unmarshal(body, &adj); err != nil {
+		writeError(w, fmt.Errorf("%w: body is not valid JSON", errInvalidRequest))
+		return adj, false
+	}
+
+	if len(adj.Amount) == 0 || string(adj.Amount) == "null" {
+		writeError(w, fmt.Errorf("%w: amount is missing", errInvalidRequest))
+		return adj, false
+	}
+
+	return adj, true
+}
+
+// recordVoucherAdjustment writes the change staff made to the voucher account at now to the ledger and the audit log
+func recordVoucherAdjustment(ctx context.Context, st model.Stores, actor Actor, account *model.Voucher, key string, delta model.Money, reason, action, note string, now time.Time) error {
+	if err := st.Ledger.Append(ctx, newLedgerEntry(actor, account.UserKey(), model.VoucherAccount(key), delta, reason, "", now)); err != nil {
+		return err
+	}
+
+	details := fmt.Sprintf("%s %v", key, delta)
+	if note = strings.TrimSpace(note); note != "" {
+		details += ": " + note
+	}
+
+	return st.Audit.Append(ctx, userAuditEntry(actor, action, account.UserKey(), details, now))
+}
+
+// expireVoucher takes the credits expired at now off the voucher account and writes them to the
+// ledger. The account is not saved, the caller puts it with the rest of its changes.
+func expireVoucher(ctx context.Context, st model.Stores, account *model.Voucher, key string, now time.Time) error {
+	expired := account.Expire(now)
+	if expired.IsZero() {
+		return nil
+	}
+
+	return st.Ledger.Append(ctx, newLedgerEntry(systemActor, account.UserKey(), model.VoucherAccount(key), expired.Neg(), model.LedgerVoucherExpiry, "", now))
+}
+
+// watchVoucherExpiry takes the expired credits off the voucher accounts every interval until ctx is done
+func (s *Server) watchVoucherExpiry(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.storesMu.RLock()
+			err := s.expireVouchers(ctx)
+			s.storesMu.RUnlock()
+
+			if err != nil {
+				log.Printf("cannot expire voucher credits: %s", err)
+			}
+		}
+	}
+}
+
+// expireVouchers takes the credits expired by now off every voucher account and writes them to
+// the ledger. An account that cannot be swept does not stop the others, the first error is returned.
+func (s *Server) expireVouchers(ctx context.Context) error {
+	now := s.clock.Now()
+
+	accounts, err := s.vch.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	var first error
+	for _, account := range accounts {
+		if !account.HasExpired(now) {
+			continue
+		}
+
+		key := model.GenerateKeyForVoucher(account.UserKey(), account.Currency)
+		if err = s.expireVoucherAccount(ctx, key, account.UserKey(), now); err != nil && first == nil {
+			first = fmt.Errorf("voucher %s: %w", key, err)
+		}
+	}
+
+	return first
+}
+
+// expireVoucherAccount takes the credits expired at now off the voucher account with the given key
+func (s *Server) expireVoucherAccount(ctx context.Context, key, userKey string, now time.Time) error {
+	unlock := s.locks.Lock(vouchersLock(userKey))
+	defer unlock()
+
+	return s.uow.Do(ctx, func(ctx context.Context, st model.Stores) error {
+		account, err := st.Vouchers.Get(ctx, key)
+		if errors.Is(err, model.ErrAccountNotFound) {
+			// deleted since it was listed
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// spent, debited or swept by someone else since it was listed
+		if !account.HasExpired(now) {
+			return nil
+		}
+
+		if err = expireVoucher(ctx, st, account, key, now); err != nil {
+			return err
+		}
+
+		return st.Vouchers.Put(ctx, key, account)
+	})
+}
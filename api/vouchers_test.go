@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/srgyrn/pact-example/api/model"
+)
+
+// addVoucher opens a voucher account of the user with the given balance
+func addVoucher(t *testing.T, srv *Server, userKey string, balance model.Money) string {
+	va, err := model.NewVoucher(balance, userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := model.GenerateKeyForVoucher(userKey, balance.Currency)
+	if err = srv.vch.Put(context.Background(), key, &va); err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+// useTestClock makes the server tell the time the returned pointer points at, starting at 2020-01-01
+func useTestClock(srv *Server) *time.Time {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	srv.useClock(model.ClockFunc(func() time.Time { return now }))
+
+	return &now
+}
+
+// creditVoucher adds a goodwill credit of the amount to the account of the user in USD,
+// expiring after the given number of days if it is not zero
+func creditVoucher(t *testing.T, srv *Server, userKey, amount string, days int) {
+	body := `{"user_key": "` + userKey + `", "amount": "` + amount + `"`
+	if days != 0 {
+		b, _ := json.Marshal(srv.clock.Now().AddDate(0, 0, days))
+		body += `, "expires_at": ` + string(b)
+	}
+
+	if rr := serveAs(srv, http.MethodPost, "/vouchers", body+"}", "agent-1", RoleSupport); rr.Code != http.StatusOK {
+		t.Fatalf("creditVoucherHandler() status = %d\n%s", rr.Code, rr.Body)
+	}
+}
+
+func Test_userVouchersHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		userKey  string
+		actor    string
+		role     string
+		wantCode int
+		wantKeys []string
+	}{
+		{name: "returns the accounts of the user", userKey: "john-doe", wantCode: http.StatusOK, wantKeys: []string{"john-doe-aed", "john-doe-usd"}},
+		{name: "returns the accounts to staff", userKey: "john-doe", actor: "agent-1", role: RoleSupport, wantCode: http.StatusOK, wantKeys: []string{"john-doe-aed", "john-doe-usd"}},
+		{name: "returns no accounts for users without one", userKey: "jane-doe", wantCode: http.StatusOK, wantKeys: []string{}},
+		{name: "returns forbidden to other users", userKey: "john-doe", actor: "jane-doe", wantCode: http.StatusForbidden},
+		{name: "returns not found for unknown users", userKey: "eric-smith", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			addVoucher(t, srv, "john-doe", usd(10))
+			addVoucher(t, srv, "john-doe", model.NewMoney(500, "AED"))
+
+			rr := serveAs(srv, http.MethodGet, "/users/"+tt.userKey+"/vouchers", "", tt.actor, tt.role)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("userVouchersHandler() status = %d, want %d\n%s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var got userVouchersResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got.Vouchers) != len(tt.wantKeys) {
+				t.Fatalf("userVouchersHandler() = %+v, want %v", got.Vouchers, tt.wantKeys)
+			}
+
+			for i, v := range got.Vouchers {
+				if v.Key != tt.wantKeys[i] || v.UserKey != tt.userKey {
+					t.Errorf("userVouchersHandler() voucher %d = %+v, want %s", i, v, tt.wantKeys[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_creditVoucherHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		role        string
+		wantCode    int
+		wantKey     string
+		wantBalance model.Money
+	}{
+		{
+			name:        "adds the credit to the account",
+			body:        `{"user_key": "john-doe", "amount": "5", "note": "late delivery"}`,
+			role:        RoleSupport,
+			wantCode:    http.StatusOK,
+			wantKey:     "john-doe-usd",
+			wantBalance: usd(15),
+		},
+		{
+			name:        "opens an account in the currency of the credit",
+			body:        `{"user_key": "john-doe", "amount": {"amount": "20.00", "currency": "EUR"}}`,
+			role:        RoleAdmin,
+			wantCode:    http.StatusOK,
+			wantKey:     "john-doe-eur",
+			wantBalance: model.NewMoney(2000, "EUR"),
+		},
+		{name: "returns unprocessable entity for negative credits", body: `{"user_key": "john-doe", "amount": "-5"}`, role: RoleSupport, wantCode: http.StatusUnprocessableEntity},
+		{name: "returns bad request without an amount", body: `{"user_key": "john-doe"}`, role: RoleSupport, wantCode: http.StatusBadRequest},
+		{name: "returns not found for unknown users", body: `{"user_key": "eric-smith", "amount": "5"}`, role: RoleSupport, wantCode: http.StatusNotFound},
+		{name: "returns forbidden for customers", body: `{"user_key": "john-doe", "amount": "5"}`, role: RoleCustomer, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			ctx := context.Background()
+			addVoucher(t, srv, "john-doe", usd(10))
+
+			rr := serveAs(srv, http.MethodPost, "/vouchers", tt.body, "agent-1", tt.role)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("creditVoucherHandler() status = %d, want %d\n%s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			entries, _ := srv.ldg.List(ctx, "john-doe")
+			audit, _ := srv.aud.List(ctx)
+			if tt.wantCode != http.StatusOK {
+				if len(audit) > 0 || len(entries) != 1 {
+					t.Errorf("creditVoucherHandler() left ledger %v and audit log %v", entries, audit)
+				}
+				return
+			}
+
+			account, err := srv.vch.Get(ctx, tt.wantKey)
+			if err != nil || account.Balance != tt.wantBalance {
+				t.Fatalf("voucher %s = %v, %v, want balance %v", tt.wantKey, account, err, tt.wantBalance)
+			}
+
+			last := entries[len(entries)-1]
+			if last.Reason != model.LedgerGoodwillCredit || last.Account != model.VoucherAccount(tt.wantKey) || last.ActorKey != "agent-1" {
+				t.Errorf("last ledger entry = %+v, want the goodwill credit", last)
+			}
+
+			if len(audit) != 1 || audit[0].Action != model.AuditVoucherCredit {
+				t.Errorf("audit log = %v, want the credit", audit)
+			}
+		})
+	}
+}
+
+func Test_debitVoucherHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		body        string
+		role        string
+		wantCode    int
+		wantBalance model.Money
+	}{
+		{name: "debits the account", key: "john-doe-usd", body: `{"amount": "4"}`, role: RoleSupport, wantCode: http.StatusOK, wantBalance: usd(6)},
+		{name: "debits the whole balance", key: "john-doe-usd", body: `{"amount": "10"}`, role: RoleAdmin, wantCode: http.StatusOK, wantBalance: usd(0)},
+		{name: "returns unprocessable entity when funds are insufficient", key: "john-doe-usd", body: `{"amount": "10.01"}`, role: RoleSupport, wantCode: http.StatusUnprocessableEntity, wantBalance: usd(10)},
+		{name: "returns unprocessable entity for another currency", key: "john-doe-usd", body: `{"amount": {"amount": "1", "currency": "EUR"}}`, role: RoleSupport, wantCode: http.StatusUnprocessableEntity, wantBalance: usd(10)},
+		{name: "returns not found for unknown accounts", key: "john-doe-eur", body: `{"amount": "1"}`, role: RoleSupport, wantCode: http.StatusNotFound, wantBalance: usd(10)},
+		{name: "returns forbidden for customers", key: "john-doe-usd", body: `{"amount": "1"}`, role: RoleCustomer, wantCode: http.StatusForbidden, wantBalance: usd(10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			ctx := context.Background()
+			addVoucher(t, srv, "john-doe", usd(10))
+
+			rr := serveAs(srv, http.MethodPost, "/vouchers/"+tt.key+"/debit", tt.body, "agent-1", tt.role)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("debitVoucherHandler() status = %d, want %d\n%s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			if account, _ := srv.vch.Get(ctx, "john-doe-usd"); account.Balance != tt.wantBalance {
+				t.Errorf("balance = %v, want %v", account.Balance, tt.wantBalance)
+			}
+
+			entries, _ := srv.ldg.List(ctx, "john-doe")
+			if tt.wantCode != http.StatusOK {
+				if len(entries) != 1 {
+					t.Errorf("debitVoucherHandler() wrote ledger entries %v", entries)
+				}
+				return
+			}
+
+			debited, _ := usd(10).Sub(tt.wantBalance)
+			if len(entries) != 2 || entries[1].Reason != model.LedgerVoucherDebit || entries[1].Delta != debited.Neg() {
+				t.Errorf("ledger = %v, want a debit of %v", entries, debited)
+			}
+		})
+	}
+}
+
+func Test_creditVoucherHandler_expiry(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresAt string
+		wantCode  int
+		wantLot   bool
+	}{
+		{name: "credits a lot that expires", expiresAt: "2020-03-01T00:00:00Z", wantCode: http.StatusOK, wantLot: true},
+		{name: "credits a lot that never expires", wantCode: http.StatusOK},
+		{name: "returns unprocessable entity for expiries in the past", expiresAt: "2019-12-31T00:00:00Z", wantCode: http.StatusUnprocessableEntity},
+		{name: "returns bad request for invalid expiries", expiresAt: "next month", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			now := useTestClock(srv)
+			addVoucher(t, srv, "john-doe", usd(10))
+
+			body := `{"user_key": "john-doe", "amount": "5"}`
+			if tt.expiresAt != "" {
+				body = `{"user_key": "john-doe", "amount": "5", "expires_at": "` + tt.expiresAt + `"}`
+			}
+
+			rr := serveAs(srv, http.MethodPost, "/vouchers", body, "agent-1", RoleSupport)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("creditVoucherHandler() status = %d, want %d\n%s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var got voucherResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got.Lots) != 1 || got.Lots[0].Amount != usd(5) || !got.Lots[0].IssuedAt.Equal(*now) || (got.Lots[0].ExpiresAt != nil) != tt.wantLot {
+				t.Errorf("creditVoucherHandler() lots = %+v, want a lot of $5 issued at %v", got.Lots, now)
+			}
+		})
+	}
+}
+
+func Test_expireVouchers(t *testing.T) {
+	srv := newTestServer()
+	ctx := context.Background()
+	now := useTestClock(srv)
+	addVoucher(t, srv, "john-doe", usd(10))
+	creditVoucher(t, srv, "john-doe", "20", 30)
+	creditVoucher(t, srv, "john-doe", "5", 0)
+	creditVoucher(t, srv, "jane-doe", "7", 60)
+
+	tests := []struct {
+		name        string
+		days        int // days passed since the credits
+		wantJohn    model.Money
+		wantJane    model.Money
+		wantExpired int // voucher_expiry entries in the ledgers so far
+	}{
+		{name: "keeps credits before their expiry", days: 29, wantJohn: usd(35), wantJane: usd(7)},
+		{name: "expires credits at their expiry", days: 30, wantJohn: usd(15), wantJane: usd(7), wantExpired: 1},
+		{name: "does not expire credits twice", days: 31, wantJohn: usd(15), wantJane: usd(7), wantExpired: 1},
+		{name: "expires the credits of every account", days: 90, wantJohn: usd(15), wantJane: usd(0), wantExpired: 2},
+	}
+	start := *now
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*now = start.AddDate(0, 0, tt.days)
+			if err := srv.expireVouchers(ctx); err != nil {
+				t.Fatalf("expireVouchers() error = %v", err)
+			}
+
+			john, _ := srv.vch.Get(ctx, "john-doe-usd")
+			jane, _ := srv.vch.Get(ctx, "jane-doe-usd")
+			if john.Balance != tt.wantJohn || jane.Balance != tt.wantJane {
+				t.Errorf("balances = %v, %v, want %v, %v", john.Balance, jane.Balance, tt.wantJohn, tt.wantJane)
+			}
+
+			var expired int
+			for _, userKey := range []string{"john-doe", "jane-doe"} {
+				entries, _ := srv.ldg.List(ctx, userKey)
+				for _, e := range entries {
+					if e.Reason == model.LedgerVoucherExpiry {
+						expired++
+						if e.ActorRole != RoleSystem || !e.Delta.IsNegative() {
+							t.Errorf("expiry entry = %+v, want a debit by the system", e)
+						}
+					}
+				}
+			}
+
+			if expired != tt.wantExpired {
+				t.Errorf("expiry entries = %d, want %d", expired, tt.wantExpired)
+			}
+		})
+	}
+}
+
+func Test_debitVoucherHandler_expired(t *testing.T) {
+	srv := newTestServer()
+	ctx := context.Background()
+	now := useTestClock(srv)
+	creditVoucher(t, srv, "john-doe", "20", 30)
+	creditVoucher(t, srv, "john-doe", "5", 0)
+	*now = now.AddDate(0, 0, 30)
+
+	if rr := serveAs(srv, http.MethodPost, "/vouchers/john-doe-usd/debit", `{"amount": "6"}`, "agent-1", RoleSupport); rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("debitVoucherHandler() of expired credits status = %d, want %d\n%s", rr.Code, http.StatusUnprocessableEntity, rr.Body)
+	}
+
+	if rr := serveAs(srv, http.MethodPost, "/vouchers/john-doe-usd/debit", `{"amount": "5"}`, "agent-1", RoleSupport); rr.Code != http.StatusOK {
+		t.Fatalf("debitVoucherHandler() status = %d, want %d\n%s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	entries, _ := srv.ldg.List(ctx, "john-doe")
+	last := entries[len(entries)-2:]
+	if last[0].Reason != model.LedgerVoucherExpiry || last[0].Delta != usd(-20) || last[1].Reason != model.LedgerVoucherDebit {
+		t.Errorf("ledger = %v, want the expiry before the debit", entries)
+	}
+
+	for _, e := range last {
+		if !e.Time.Equal(*now) {
+			t.Errorf("ledger entry %s time = %v, want the time of the clock %v", e.Reason, e.Time, *now)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Package client is a typed Go client for the refund API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client sends requests to the refund API
+type Client struct {
+	BaseURL    string       // e.g. http://localhost:8090
+	HTTPClient *http.Client // http.DefaultClient is used when nil
+}
+
+// Destinations of a refund
+const (
+	DestinationBalance = "balance" // the wallet balance of the user
+	DestinationVoucher = "voucher" // a voucher account of the user
+	// DestinationOriginalPayment is the payment method the order was paid with
+	DestinationOriginalPayment = "original_payment"
+)
+
+// Statuses of a refund. Refunds to the payment method are pending until the payment gateway
+// confirms them, every other refund succeeds right away.
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Money is an amount of the API, e.g. {"amount": "5.90", "currency": "USD"}
+type Money struct {
+	Amount   string `json:"amount"` // decimal amount in major units
+	Currency string `json:"currency"`
+}
+
+// RefundResponse is the body returned for a successful refund
+type RefundResponse struct {
+	RefundID    string    `json:"refund_id"`
+	UserKey     string    `json:"user_key"`
+	OrderID     string    `json:"order_id"`
+	Refunded    Money     `json:"refunded"`    // refunded part of the order, in the currency of the order
+	Destination string    `json:"destination"` // one of the Destination constants
+	Rule        string    `json:"rule"`        // refund rule that picked the destination, empty for the default
+	Account     string    `json:"account"`     // account the refund was added to, e.g. "balance:john-doe"
+	Amount      Money     `json:"amount"`      // amount added to the account, in its currency
+	NewBalance  Money     `json:"new_balance"` // balance of the account after the refund
+	Currency    string    `json:"currency"`    // currency of the account
+	Status      string    `json:"status"`      // one of the Status constants
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// RefundLine is a part of an order refunded on its own, such as an item or the shipping fee
+type RefundLine struct {
+	Item   string `json:"item"`
+	Amount string `json:"amount"` // decimal amount in the currency of the order, e.g. "5.90"
+}
+
+// PartialRefund is the part of an order to refund, either an amount or lines
+type PartialRefund struct {
+	Amount string       `json:"amount,omitempty"` // decimal amount in the currency of the order
+	Lines  []RefundLine `json:"lines,omitempty"`
+}
+
+// Error codes of APIError. They are stable, unlike the messages.
+const (
+	CodeInvalidRequest       = "invalid_request"
+	CodeInvalidRefund        = "invalid_refund"
+	CodeInvalidAmount        = "invalid_amount"
+	CodeCurrencyMismatch     = "currency_mismatch"
+	CodeUnsupportedCurrency  = "unsupported_currency"
+	CodeUserNotFound         = "user_not_found"
+	CodeOrderNotFound        = "order_not_found"
+	CodeAccountNotFound      = "account_not_found"
+	CodeRefundNotFound       = "refund_not_found"
+	CodeAlreadyRefunded      = "order_already_refunded"
+	CodeRefundExceeded       = "refund_amount_exceeded"
+	CodeNotOrderOwner        = "order_not_owned"
+	CodeActorNotAllowed      = "actor_not_allowed"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodePaymentNotRefundable = "payment_not_refundable"
+	CodePaymentDeclined      = "payment_declined"
+	CodeInternal             = "internal_error"
+)
+
+// APIError is returned when the API answers with a non 2xx status
+type APIError struct {
+	StatusCode int
+	Code       string // one of the Code constants, empty if the response has no error envelope
+	Message    string // message of the error envelope, or the body of the response without one
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("refund api returned %d: %s", e.StatusCode, e.Message)
+	}
+
+	return fmt.Sprintf("refund api returned %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// errorResponse is the error envelope of the API
+type errorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey returns a copy of ctx that sends refunds with the given Idempotency-Key header.
+// Refunds sent again with the same key and request get the response of the first refund,
+// so they can be retried safely after a timeout.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// New creates a Client for the API running at baseURL
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// Refund refunds the order with the given ID to the user with the given key.
+// An *APIError is returned if the API refuses the refund.
+func (c *Client) Refund(ctx context.Context, orderID int, userKey string) (*RefundResponse, error) {
+	return c.RefundPartial(ctx, orderID, userKey, PartialRefund{})
+}
+
+// RefundPartial refunds a part of the order with the given ID to the user with the given key.
+// An empty PartialRefund refunds whatever is left of the order total.
+// An *APIError is returned if the API refuses the refund.
+func (c *Client) RefundPartial(ctx context.Context, orderID int, userKey string, part PartialRefund) (*RefundResponse, error) {
+	body, err := json.Marshal(struct {
+		UserKey string `json:"user_key"`
+		PartialRefund
+	}{userKey, part})
+	if err != nil {
+		return nil, err
+	}
+
+	url := c.BaseURL + "/order/" + strconv.Itoa(orderID) + "/refund/"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if key, ok := ctx.Value(idempotencyKey{}).(string); ok && key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+
+	res := &RefundResponse{}
+	if err = c.do(req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// do sends the request and decodes a successful response into v
+func (c *Client) do(req *http.Request, v interface{}) error {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+
+		var envelope errorResponse
+		if json.Unmarshal(body, &envelope) == nil && envelope.Error.Code != "" {
+			apiErr.Code, apiErr.Message = envelope.Error.Code, envelope.Error.Message
+		}
+
+		return apiErr
+	}
+
+	if err = json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("cannot decode response\n%s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,269 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/srgyrn/pact-example/pact"
+)
+
+// pactDir is where the contract of this client is written for the provider to verify
+const pactDir = "../pacts"
+
+func TestClient_Refund(t *testing.T) {
+	mp := pact.NewMockProvider("refund-client", "refund-api")
+	defer mp.Close()
+
+	tests := []struct {
+		name       string
+		state      string
+		orderID    int
+		userKey    string
+		part       PartialRefund
+		key        string // Idempotency-Key of the request
+		status     int
+		want       *RefundResponse
+		wantStatus int
+		wantCode   string
+		message    string // example message of the error envelope
+	}{
+		{
+			name:    "a refund request for a credit card order",
+			state:   "user john-doe exists with a credit card order",
+			orderID: 1,
+			userKey: "john-doe",
+			status:  http.StatusOK,
+			want:    refundResponse("1", "100.00", DestinationOriginalPayment, "payment:1", "100.00"),
+		},
+		{
+			name:    "a refund request for a MENA cash-on-delivery order",
+			state:   "user john-doe exists with a MENA cash-on-delivery order",
+			orderID: 3,
+			userKey: "john-doe",
+			status:  http.StatusOK,
+			want:    refundResponse("3", "300.00", DestinationVoucher, "voucher:john-doe-usd", "300.00"),
+		},
+		{
+			name:    "a refund request with an idempotency key",
+			state:   "user john-doe exists with a credit card order",
+			orderID: 1,
+			userKey: "john-doe",
+			key:     "7b4cbd6e-0c6a-4d4e-9a55-3f1b8d7a1c11",
+			status:  http.StatusOK,
+			want:    refundResponse("1", "100.00", DestinationOriginalPayment, "payment:1", "100.00"),
+		},
+		{
+			name:    "a partial refund request for a credit card order",
+			state:   "user john-doe exists with a credit card order",
+			orderID: 1,
+			userKey: "john-doe",
+			part:    PartialRefund{Amount: "25.00"},
+			status:  http.StatusOK,
+			want:    refundResponse("1", "25.00", DestinationOriginalPayment, "payment:1", "25.00"),
+		},
+		{
+			name:    "a refund request for line items of a credit card order",
+			state:   "user john-doe exists with a credit card order",
+			orderID: 1,
+			userKey: "john-doe",
+			part:    PartialRefund{Lines: []RefundLine{{Item: "SKU-1", Amount: "40.00"}, {Item: "shipping", Amount: "5.00"}}},
+			status:  http.StatusOK,
+			want:    refundResponse("1", "45.00", DestinationOriginalPayment, "payment:1", "45.00"),
+		},
+		{
+			name:       "a partial refund request for more than the order total",
+			state:      "user john-doe exists with a credit card order",
+			orderID:    1,
+			userKey:    "john-doe",
+			part:       PartialRefund{Amount: "100.01"},
+			status:     http.StatusUnprocessableEntity,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantCode:   CodeRefundExceeded,
+			message:    "refund amount exceeds the refundable amount: 100.01 USD is more than 100.00 USD",
+		},
+		{
+			name:       "a refund request for an unknown user",
+			state:      "user barbara-streisand does not exist",
+			orderID:    1,
+			userKey:    "barbara-streisand",
+			status:     http.StatusNotFound,
+			wantStatus: http.StatusNotFound,
+			wantCode:   CodeUserNotFound,
+			message:    "user not found: barbara-streisand",
+		},
+		{
+			name:       "a refund request for an unknown order",
+			state:      "order 987 does not exist",
+			orderID:    987,
+			userKey:    "john-doe",
+			status:     http.StatusNotFound,
+			wantStatus: http.StatusNotFound,
+			wantCode:   CodeOrderNotFound,
+			message:    "order not found: 987",
+		},
+		{
+			name:       "a refund request for an order that is already refunded",
+			state:      "user john-doe has a refunded order",
+			orderID:    2,
+			userKey:    "john-doe",
+			status:     http.StatusConflict,
+			wantStatus: http.StatusConflict,
+			wantCode:   CodeAlreadyRefunded,
+			message:    "order already refunded: 2",
+		},
+		{
+			name:       "a refund request for an order of another user",
+			state:      "order 4 belongs to jane-doe",
+			orderID:    4,
+			userKey:    "john-doe",
+			status:     http.StatusForbidden,
+			wantStatus: http.StatusForbidden,
+			wantCode:   CodeNotOrderOwner,
+			message:    "order does not belong to the user",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqBody, _ := json.Marshal(struct {
+				UserKey string `json:"user_key"`
+				PartialRefund
+			}{tt.userKey, tt.part})
+
+			var template interface{}
+			if tt.want != nil {
+				template = refundTemplate(tt.want)
+			}
+			if tt.wantCode != "" {
+				// consumers pin the error code, the message is only an example
+				template = map[string]interface{}{"error": map[string]interface{}{
+					"code":    tt.wantCode,
+					"message": pact.Like(tt.message),
+				}}
+			}
+
+			res := pact.Response{Status: tt.status}
+			if template != nil {
+				body, rules, err := pact.Body(template)
+				if err != nil {
+					t.Fatal(err)
+				}
+				res.Headers = pact.Headers{"Content-Type": "application/json"}
+				res.Body, res.MatchingRules = body, rules
+			}
+
+			reqHeaders := pact.Headers{"Content-Type": "application/json"}
+			ctx := context.Background()
+			if tt.key != "" {
+				reqHeaders["Idempotency-Key"] = tt.key
+				ctx = WithIdempotencyKey(ctx, tt.key)
+			}
+
+			mp.AddInteraction(pact.Interaction{
+				Description:    tt.name,
+				ProviderStates: []pact.ProviderState{{Name: tt.state}},
+				Request: pact.Request{
+					Method:  http.MethodPost,
+					Path:    "/order/" + strconv.Itoa(tt.orderID) + "/refund/",
+					Headers: reqHeaders,
+					Body:    reqBody,
+				},
+				Response: res,
+			})
+
+			err := mp.ExecuteTest(func(baseURL string) error {
+				got, err := New(baseURL).RefundPartial(ctx, tt.orderID, tt.userKey, tt.part)
+
+				var apiErr *APIError
+				if tt.wantStatus != 0 {
+					if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.wantStatus || apiErr.Code != tt.wantCode {
+						return errors.New("expected an APIError with status " + http.StatusText(tt.wantStatus) + " and code " + tt.wantCode)
+					}
+					return nil
+				}
+
+				if err != nil {
+					return err
+				}
+
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Refund() = %v, want %v", got, tt.want)
+				}
+
+				return nil
+			})
+
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	if t.Failed() {
+		return
+	}
+
+	if _, err := mp.WritePact(pactDir); err != nil {
+		t.Fatalf("cannot write pact file: %v", err)
+	}
+}
+
+// refundResponse returns the example response of a refund in USD made at exampleTime.
+// Refunds to the payment method are pending until the payment gateway confirms them.
+func refundResponse(orderID, amount, destination, account, newBalance string) *RefundResponse {
+	status := StatusSucceeded
+	if destination == DestinationOriginalPayment {
+		status = StatusPending
+	}
+
+	return &RefundResponse{
+		RefundID:    "rf_0f8fad5bd9cb469fa16570867728950e",
+		UserKey:     "john-doe",
+		OrderID:     orderID,
+		Refunded:    Money{Amount: amount, Currency: "USD"},
+		Destination: destination,
+		Account:     account,
+		Amount:      Money{Amount: amount, Currency: "USD"},
+		NewBalance:  Money{Amount: newBalance, Currency: "USD"},
+		Currency:    "USD",
+		Status:      status,
+		CreatedAt:   exampleTime,
+	}
+}
+
+var exampleTime = time.Date(2026, 10, 17, 10, 30, 0, 0, time.UTC)
+
+// refundTemplate returns the body template of the example response. The ID, the time and the new
+// balance depend on the provider, every other field is pinned.
+func refundTemplate(r *RefundResponse) map[string]interface{} {
+	money := func(m Money) map[string]interface{} {
+		return map[string]interface{}{"amount": m.Amount, "currency": m.Currency}
+	}
+
+	return map[string]interface{}{
+		"refund_id":   pact.Term(r.RefundID, `^rf_[0-9a-f]{32}$`),
+		"user_key":    r.UserKey,
+		"order_id":    r.OrderID,
+		"refunded":    money(r.Refunded),
+		"destination": r.Destination,
+		"account":     r.Account,
+		"amount":      money(r.Amount),
+		"new_balance": pact.Like(money(r.NewBalance)),
+		"currency":    r.Currency,
+		"status":      r.Status,
+		"created_at":  pact.Term(r.CreatedAt.Format(time.RFC3339Nano), `^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})$`),
+	}
+}
+
+func TestClient_Refund_connectionError(t *testing.T) {
+	c := New("http://127.0.0.1:0")
+
+	if _, err := c.Refund(context.Background(), 1, "john-doe"); err == nil {
+		t.Error("Refund() error = nil, want connection error")
+	}
+}
@@ -0,0 +1,106 @@
+package pact
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// Matched is an example value in a body template together with the rule it is matched by
+type Matched struct {
+	Example interface{}
+	Rule    Matcher
+	each    bool
+}
+
+// Like matches any value of the same type as the example
+func Like(example interface{}) Matched {
+	return Matched{Example: example, Rule: Matcher{Match: "type"}}
+}
+
+// Term matches any string matching regex, the example must match it as well
+func Term(example, regex string) Matched {
+	return Matched{Example: example, Rule: Matcher{Match: "regex", Regex: regex}}
+}
+
+// EachLike matches an array with at least min items, each of them like the example
+func EachLike(example interface{}, min int) Matched {
+	if min < 1 {
+		min = 1
+	}
+
+	return Matched{Example: example, Rule: Matcher{Match: "type", Min: &min}, each: true}
+}
+
+// Body builds the example body and its matching rules from a template.
+// Templates are made of maps, slices, primitive values and matchers created
+// with Like, Term and EachLike.
+func Body(template interface{}) (json.RawMessage, MatchingRules, error) {
+	rules := MatchingRules{}
+
+	example, err := buildExample([]string{"$", "body"}, template, rules)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b, err := json.Marshal(example)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(rules) == 0 {
+		rules = nil
+	}
+
+	return b, rules, nil
+}
+
+func buildExample(path []string, template interface{}, rules MatchingRules) (interface{}, error) {
+	switch t := template.(type) {
+	case Matched:
+		rules[formatPath(path)] = []Matcher{t.Rule}
+
+		if t.Rule.Match == "regex" {
+			if ok, err := regexp.MatchString(t.Rule.Regex, fmt.Sprint(t.Example)); err != nil || !ok {
+				return nil, fmt.Errorf("example %v at %s does not match %q", t.Example, formatPath(path), t.Rule.Regex)
+			}
+		}
+
+		if !t.each {
+			return buildExample(path, t.Example, rules)
+		}
+
+		item, err := buildExample(appendToken(path, "[*]"), t.Example, rules)
+		if err != nil {
+			return nil, err
+		}
+
+		items := make([]interface{}, *t.Rule.Min)
+		for i := range items {
+			items[i] = item
+		}
+		return items, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			item, err := buildExample(appendToken(path, k), v, rules)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = item
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, v := range t {
+			item, err := buildExample(appendToken(path, index(i)), v, rules)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = item
+		}
+		return out, nil
+	}
+
+	return template, nil
+}
@@ -0,0 +1,62 @@
+package pact
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		template  interface{}
+		wantBody  string
+		wantRules MatchingRules
+		wantErr   bool
+	}{
+		{
+			name:     "builds body without rules",
+			template: map[string]interface{}{"user_key": "john-doe"},
+			wantBody: `{"user_key":"john-doe"}`,
+		},
+		{
+			name: "builds body with rules",
+			template: map[string]interface{}{
+				"user_key": Like("john-doe"),
+				"status":   Term("ok", "^(ok|pending)$"),
+				"orders":   EachLike(map[string]interface{}{"id": Like(1)}, 2),
+			},
+			wantBody: `{"orders":[{"id":1},{"id":1}],"status":"ok","user_key":"john-doe"}`,
+			wantRules: MatchingRules{
+				"$.body.user_key":     {{Match: "type"}},
+				"$.body.status":       {{Match: "regex", Regex: "^(ok|pending)$"}},
+				"$.body.orders":       {{Match: "type", Min: intPtr(2)}},
+				"$.body.orders[*].id": {{Match: "type"}},
+			},
+		},
+		{
+			name:     "fails when example does not match regex",
+			template: map[string]interface{}{"status": Term("done", "^(ok|pending)$")},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, rules, err := Body(tt.template)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Body() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if string(body) != tt.wantBody {
+				t.Errorf("Body() body = %s, want %s", body, tt.wantBody)
+			}
+
+			if !reflect.DeepEqual(rules, tt.wantRules) {
+				t.Errorf("Body() rules = %v, want %v", rules, tt.wantRules)
+			}
+		})
+	}
+}
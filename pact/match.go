@@ -0,0 +1,327 @@
+package pact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Mismatch describes a single difference between the expected and the actual response
+type Mismatch struct {
+	Type     string      // status, header or body
+	Path     string      // location of the difference, e.g. $.body.user_key
+	Expected interface{} // expected value
+	Actual   interface{} // actual value
+	Message  string
+}
+
+// String returns a human readable description of the mismatch
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s mismatch at %s: %s (expected %v, got %v)", m.Type, m.Path, m.Message, m.Expected, m.Actual)
+}
+
+// decodeJSON decodes b keeping numbers as json.Number so they can be compared exactly
+func decodeJSON(b []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// compareBodies compares an expected pact body with the body received from the provider
+func compareBodies(expected json.RawMessage, actual []byte, rules MatchingRules) []Mismatch {
+	if len(expected) == 0 {
+		return nil
+	}
+
+	want, err := decodeJSON(expected)
+	if err != nil {
+		return []Mismatch{{Type: "body", Path: "$.body", Message: "expected body is not valid JSON: " + err.Error()}}
+	}
+
+	got, err := decodeJSON(actual)
+	if err != nil {
+		// plain text bodies are stored as JSON strings in the pact file
+		if s, ok := want.(string); ok {
+			return compareValues([]string{"$", "body"}, s, string(actual), rules)
+		}
+
+		return []Mismatch{{Type: "body", Path: "$.body", Expected: string(expected), Actual: string(actual), Message: "body is not valid JSON"}}
+	}
+
+	return compareValues([]string{"$", "body"}, want, got, rules)
+}
+
+func compareValues(path []string, expected, actual interface{}, rules MatchingRules) []Mismatch {
+	matchers := rules.forPath(path)
+	if len(matchers) == 0 {
+		return compareEqual(path, expected, actual, rules)
+	}
+
+	var mismatches []Mismatch
+	for _, m := range matchers {
+		if msg := m.check(expected, actual); msg != "" {
+			mismatches = append(mismatches, newMismatch(path, expected, actual, msg))
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return mismatches
+	}
+
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		if a, ok := actual.(map[string]interface{}); ok {
+			return compareObjects(path, e, a, rules)
+		}
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok {
+			return nil
+		}
+
+		if !isTypeMatch(matchers) {
+			return compareArrays(path, e, a, rules)
+		}
+
+		if len(e) == 0 {
+			return nil
+		}
+
+		for i, item := range a {
+			mismatches = append(mismatches, compareValues(appendToken(path, index(i)), e[0], item, rules)...)
+		}
+	}
+
+	return mismatches
+}
+
+func compareEqual(path []string, expected, actual interface{}, rules MatchingRules) []Mismatch {
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			return []Mismatch{newMismatch(path, expected, actual, "expected an object")}
+		}
+		return compareObjects(path, e, a, rules)
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok {
+			return []Mismatch{newMismatch(path, expected, actual, "expected an array")}
+		}
+		return compareArrays(path, e, a, rules)
+	}
+
+	if !equalValues(expected, actual) {
+		return []Mismatch{newMismatch(path, expected, actual, "values are not equal")}
+	}
+
+	return nil
+}
+
+// compareObjects checks every expected key, additional keys in the actual object are allowed
+func compareObjects(path []string, expected, actual map[string]interface{}, rules MatchingRules) []Mismatch {
+	var mismatches []Mismatch
+
+	for _, k := range sortedKeys(expected) {
+		p := appendToken(path, k)
+		a, ok := actual[k]
+		if !ok {
+			mismatches = append(mismatches, newMismatch(p, expected[k], nil, "key is missing"))
+			continue
+		}
+
+		mismatches = append(mismatches, compareValues(p, expected[k], a, rules)...)
+	}
+
+	return mismatches
+}
+
+func compareArrays(path []string, expected, actual []interface{}, rules MatchingRules) []Mismatch {
+	if len(expected) != len(actual) {
+		return []Mismatch{newMismatch(path, len(expected), len(actual), "array lengths differ")}
+	}
+
+	var mismatches []Mismatch
+	for i := range expected {
+		mismatches = append(mismatches, compareValues(appendToken(path, index(i)), expected[i], actual[i], rules)...)
+	}
+
+	return mismatches
+}
+
+// check returns an empty string when actual satisfies the matcher, otherwise the reason it does not
+func (m Matcher) check(expected, actual interface{}) string {
+	switch m.Match {
+	case "type":
+		if kindOf(expected) != kindOf(actual) {
+			return fmt.Sprintf("expected a value of type %s, got %s", kindOf(expected), kindOf(actual))
+		}
+
+		if a, ok := actual.([]interface{}); ok {
+			if m.Min != nil && len(a) < *m.Min {
+				return fmt.Sprintf("expected at least %d items, got %d", *m.Min, len(a))
+			}
+			if m.Max != nil && len(a) > *m.Max {
+				return fmt.Sprintf("expected at most %d items, got %d", *m.Max, len(a))
+			}
+		}
+	case "equality":
+		if !reflect.DeepEqual(normalizeNumbers(expected), normalizeNumbers(actual)) {
+			return "values are not equal"
+		}
+	case "regex":
+		re, err := regexp.Compile(m.Regex)
+		if err != nil {
+			return fmt.Sprintf("invalid regex %q: %s", m.Regex, err)
+		}
+		if !re.MatchString(stringValue(actual)) {
+			return fmt.Sprintf("value does not match %q", m.Regex)
+		}
+	case "include":
+		if !strings.Contains(stringValue(actual), m.Value) {
+			return fmt.Sprintf("value does not include %q", m.Value)
+		}
+	case "number", "integer", "decimal":
+		n, ok := actual.(json.Number)
+		if !ok {
+			return "expected a number"
+		}
+		isInt := !strings.ContainsAny(n.String(), ".eE")
+		if m.Match == "integer" && !isInt {
+			return "expected an integer"
+		}
+		if m.Match == "decimal" && isInt {
+			return "expected a decimal number"
+		}
+	case "boolean":
+		if _, ok := actual.(bool); !ok {
+			return "expected a boolean"
+		}
+	case "null":
+		if actual != nil {
+			return "expected null"
+		}
+	default:
+		return fmt.Sprintf("unsupported matcher %q", m.Match)
+	}
+
+	return ""
+}
+
+// isTypeMatch reports whether array items are matched against the first expected item
+func isTypeMatch(matchers []Matcher) bool {
+	for _, m := range matchers {
+		if m.Match == "type" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func kindOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number, float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+
+	return fmt.Sprintf("%T", v)
+}
+
+func equalValues(expected, actual interface{}) bool {
+	e, eok := expected.(json.Number)
+	a, aok := actual.(json.Number)
+	if eok && aok {
+		return equalNumbers(e, a)
+	}
+
+	return reflect.DeepEqual(expected, actual)
+}
+
+// equalNumbers compares numbers by value so that 100 and 100.0 are equal
+func equalNumbers(a, b json.Number) bool {
+	x, ok := new(big.Rat).SetString(a.String())
+	if !ok {
+		return a == b
+	}
+
+	y, ok := new(big.Rat).SetString(b.String())
+	if !ok {
+		return a == b
+	}
+
+	return x.Cmp(y) == 0
+}
+
+// normalizeNumbers rewrites numbers into their canonical form for deep comparisons
+func normalizeNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if r, ok := new(big.Rat).SetString(t.String()); ok {
+			return r.RatString()
+		}
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			out[k] = normalizeNumbers(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, item := range t {
+			out[i] = normalizeNumbers(item)
+		}
+		return out
+	}
+
+	return v
+}
+
+func stringValue(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case nil:
+		return ""
+	}
+
+	return fmt.Sprint(v)
+}
+
+func newMismatch(path []string, expected, actual interface{}, msg string) Mismatch {
+	return Mismatch{Type: "body", Path: formatPath(path), Expected: expected, Actual: actual, Message: msg}
+}
+
+func appendToken(path []string, token string) []string {
+	p := make([]string, len(path), len(path)+1)
+	copy(p, path)
+
+	return append(p, token)
+}
+
+func index(i int) string {
+	return "[" + strconv.Itoa(i) + "]"
+}
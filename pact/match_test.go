@@ -0,0 +1,117 @@
+package pact
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_compareBodies(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		actual   string
+		rules    MatchingRules
+		wantPath []string
+	}{
+		{
+			name:     "matches equal bodies",
+			expected: `{"user_key": "john-doe", "total": 100}`,
+			actual:   `{"user_key": "john-doe", "total": 100.0}`,
+		},
+		{
+			name:     "allows unexpected keys",
+			expected: `{"user_key": "john-doe"}`,
+			actual:   `{"user_key": "john-doe", "balance": 5}`,
+		},
+		{
+			name:     "reports missing keys",
+			expected: `{"user_key": "john-doe"}`,
+			actual:   `{}`,
+			wantPath: []string{"$.body.user_key"},
+		},
+		{
+			name:     "reports different values",
+			expected: `{"user_key": "john-doe"}`,
+			actual:   `{"user_key": "jane-doe"}`,
+			wantPath: []string{"$.body.user_key"},
+		},
+		{
+			name:     "reports different array lengths",
+			expected: `{"orders": [1, 2]}`,
+			actual:   `{"orders": [1]}`,
+			wantPath: []string{"$.body.orders"},
+		},
+		{
+			name:     "matches by type",
+			expected: `{"user_key": "john-doe"}`,
+			actual:   `{"user_key": "jane-doe"}`,
+			rules:    MatchingRules{"$.body.user_key": {{Match: "type"}}},
+		},
+		{
+			name:     "type rules cascade to children",
+			expected: `{"user": {"name": "John", "orders": [1]}}`,
+			actual:   `{"user": {"name": "Jane", "orders": [4, 5, 6]}}`,
+			rules:    MatchingRules{"$.body.user": {{Match: "type"}}},
+		},
+		{
+			name:     "reports type mismatches",
+			expected: `{"total": 100}`,
+			actual:   `{"total": "100"}`,
+			rules:    MatchingRules{"$.body.total": {{Match: "type"}}},
+			wantPath: []string{"$.body.total"},
+		},
+		{
+			name:     "checks array minimum",
+			expected: `{"orders": [1]}`,
+			actual:   `{"orders": []}`,
+			rules:    MatchingRules{"$.body.orders": {{Match: "type", Min: intPtr(1)}}},
+			wantPath: []string{"$.body.orders"},
+		},
+		{
+			name:     "matches wildcard paths",
+			expected: `{"orders": [{"id": 1}]}`,
+			actual:   `{"orders": [{"id": 1}, {"id": "x"}]}`,
+			rules: MatchingRules{
+				"$.body.orders":       {{Match: "type"}},
+				"$.body.orders[*].id": {{Match: "integer"}},
+			},
+			wantPath: []string{"$.body.orders[1].id"},
+		},
+		{
+			name:     "matches regex",
+			expected: `{"user_key": "john-doe"}`,
+			actual:   `{"user_key": "JANE"}`,
+			rules:    MatchingRules{"$.body.user_key": {{Match: "regex", Regex: "^[a-z]+-[a-z]+$"}}},
+			wantPath: []string{"$.body.user_key"},
+		},
+		{
+			name:     "compares plain text bodies",
+			expected: `"order not found"`,
+			actual:   "order not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compareBodies(json.RawMessage(tt.expected), []byte(tt.actual), tt.rules)
+
+			if len(got) != len(tt.wantPath) {
+				t.Fatalf("compareBodies() = %v, want mismatches at %v", got, tt.wantPath)
+			}
+
+			for i, m := range got {
+				if m.Path != tt.wantPath[i] {
+					t.Errorf("compareBodies() mismatch path = %v, want %v", m.Path, tt.wantPath[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_parsePath(t *testing.T) {
+	got := formatPath(parsePath("$.body.orders[*].id['first.name']"))
+	want := "$.body.orders[*].id.first.name"
+
+	if got != want {
+		t.Errorf("parsePath() = %v, want %v", got, want)
+	}
+}
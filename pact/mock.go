@@ -0,0 +1,182 @@
+package pact
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// MockProvider is a local stand-in for a provider used by consumer tests.
+// It answers requests with the registered interactions and records every
+// interaction that was exercised so it can be written to a pact file.
+type MockProvider struct {
+	Consumer string
+	Provider string
+
+	mu         sync.Mutex
+	server     *httptest.Server
+	pending    []Interaction
+	matched    []bool
+	unexpected []string
+	verified   []Interaction
+}
+
+// NewMockProvider creates a mock provider for the given consumer and provider names
+func NewMockProvider(consumer, provider string) *MockProvider {
+	m := &MockProvider{
+		Consumer: consumer,
+		Provider: provider,
+	}
+	m.server = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+
+	return m
+}
+
+// URL returns the base URL the consumer should send its requests to
+func (m *MockProvider) URL() string {
+	return m.server.URL
+}
+
+// Close shuts the mock provider down
+func (m *MockProvider) Close() {
+	m.server.Close()
+}
+
+// AddInteraction registers an interaction the next test is expected to exercise
+func (m *MockProvider) AddInteraction(i Interaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pending = append(m.pending, i)
+	m.matched = append(m.matched, false)
+}
+
+// ExecuteTest runs the consumer test against the mock provider.
+// An error is returned if the test fails, if a registered interaction was not
+// exercised or if an unexpected request was received. Interactions of a
+// successful test are kept for the pact file, registered interactions are
+// cleared either way.
+func (m *MockProvider) ExecuteTest(test func(baseURL string) error) error {
+	testErr := test(m.URL())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() {
+		m.pending, m.matched, m.unexpected = nil, nil, nil
+	}()
+
+	var problems []string
+	if testErr != nil {
+		problems = append(problems, "test failed: "+testErr.Error())
+	}
+
+	for idx, i := range m.pending {
+		if !m.matched[idx] {
+			problems = append(problems, "interaction not exercised: "+i.Description)
+		}
+	}
+
+	problems = append(problems, m.unexpected...)
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+
+	m.verified = append(m.verified, m.pending...)
+
+	return nil
+}
+
+// WritePact writes every verified interaction to a pact file in dir and returns its path
+func (m *MockProvider) WritePact(dir string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f := &File{
+		Consumer:     Pacticipant{Name: m.Consumer},
+		Provider:     Pacticipant{Name: m.Provider},
+		Interactions: m.verified,
+	}
+
+	return Write(dir, f)
+}
+
+func (m *MockProvider) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var reasons []string
+	for idx, i := range m.pending {
+		mismatches := compareRequest(i.Request, r, body)
+		if len(mismatches) > 0 {
+			for _, mm := range mismatches {
+				reasons = append(reasons, fmt.Sprintf("%s: %s", i.Description, mm))
+			}
+			continue
+		}
+
+		m.matched[idx] = true
+		writeResponse(w, i.Response)
+		return
+	}
+
+	msg := fmt.Sprintf("unexpected request %s %s", r.Method, r.URL.RequestURI())
+	if len(reasons) > 0 {
+		msg += "\n\t" + strings.Join(reasons, "\n\t")
+	}
+	m.unexpected = append(m.unexpected, msg)
+
+	http.Error(w, msg, http.StatusInternalServerError)
+}
+
+// compareRequest checks a received request against the expected one
+func compareRequest(expected Request, r *http.Request, body []byte) []Mismatch {
+	var mismatches []Mismatch
+
+	if !strings.EqualFold(expected.Method, r.Method) {
+		mismatches = append(mismatches, Mismatch{Type: "method", Path: "$.method", Expected: expected.Method, Actual: r.Method, Message: "methods differ"})
+	}
+
+	if expected.Path != r.URL.Path {
+		mismatches = append(mismatches, Mismatch{Type: "path", Path: "$.path", Expected: expected.Path, Actual: r.URL.Path, Message: "paths differ"})
+	}
+
+	query := r.URL.Query()
+	if (len(expected.Query) > 0 || len(query) > 0) && !reflect.DeepEqual(map[string][]string(expected.Query), map[string][]string(query)) {
+		mismatches = append(mismatches, Mismatch{Type: "query", Path: "$.query", Expected: expected.Query.Encode(), Actual: query.Encode(), Message: "queries differ"})
+	}
+
+	mismatches = append(mismatches, compareHeaders(expected.Headers, r.Header, expected.MatchingRules)...)
+	mismatches = append(mismatches, compareBodies(expected.Body, body, expected.MatchingRules)...)
+
+	return mismatches
+}
+
+func writeResponse(w http.ResponseWriter, r Response) {
+	for name, value := range r.Headers {
+		w.Header().Set(name, value)
+	}
+
+	body, err := requestBody(Request{Headers: r.Headers, Body: r.Body})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	w.WriteHeader(status)
+	w.Write(body)
+}
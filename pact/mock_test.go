@@ -0,0 +1,115 @@
+package pact
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestMockProvider_ExecuteTest(t *testing.T) {
+	refund := Interaction{
+		Description:   "a refund request",
+		ProviderState: "user john-doe exists",
+		Request: Request{
+			Method:  http.MethodPost,
+			Path:    "/order/1/refund/",
+			Headers: Headers{"Content-Type": "application/json"},
+			Body:    json.RawMessage(`{"user_key":"john-doe"}`),
+		},
+		Response: Response{
+			Status:  http.StatusOK,
+			Headers: Headers{"Content-Type": "application/json"},
+			Body:    json.RawMessage(`{"user_key":"john-doe"}`),
+		},
+	}
+
+	post := func(path, body string) func(string) error {
+		return func(baseURL string) error {
+			resp, err := http.Post(baseURL+path, "application/json", bytes.NewBufferString(body))
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return errors.New(resp.Status)
+			}
+
+			return nil
+		}
+	}
+
+	tests := []struct {
+		name    string
+		test    func(string) error
+		wantErr bool
+	}{
+		{
+			name:    "fails when interaction is not exercised",
+			test:    func(string) error { return nil },
+			wantErr: true,
+		},
+		{
+			name:    "fails when request is unexpected",
+			test:    post("/order/1/refund/", `{"user_key":"jane-doe"}`),
+			wantErr: true,
+		},
+		{
+			name:    "fails when test fails",
+			test:    func(string) error { return errors.New("client failed") },
+			wantErr: true,
+		},
+		{
+			name:    "verifies interaction successfully",
+			test:    post("/order/1/refund/", `{"user_key":"john-doe"}`),
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mp := NewMockProvider("refund-client", "refund-api")
+			defer mp.Close()
+
+			mp.AddInteraction(refund)
+
+			if err := mp.ExecuteTest(tt.test); (err != nil) != tt.wantErr {
+				t.Errorf("ExecuteTest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got := len(mp.verified); !tt.wantErr && got != 1 {
+				t.Errorf("ExecuteTest() kept %d interactions, want 1", got)
+			}
+		})
+	}
+}
+
+func TestMockProvider_WritePact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mp := NewMockProvider("refund-client", "refund-api")
+	defer mp.Close()
+
+	mp.verified = []Interaction{{Description: "a refund request"}}
+
+	path, err := mp.WritePact(dir)
+	if err != nil {
+		t.Fatalf("WritePact() error = %v", err)
+	}
+
+	f, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if f.Consumer.Name != "refund-client" || f.Provider.Name != "refund-api" || len(f.Interactions) != 1 {
+		t.Errorf("WritePact() wrote %v", f)
+	}
+}
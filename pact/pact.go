@@ -0,0 +1,233 @@
+// Package pact reads, writes and verifies Pact contract files.
+//
+// Both the v2 and v3 specifications are understood when loading a file, files
+// are written using the v3 layout. Everything runs locally; no Pact broker is
+// involved.
+package pact
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SpecificationVersion is the Pact specification version used when writing files
+const SpecificationVersion = "3.0.0"
+
+// File is the content of a single pact file
+type File struct {
+	Consumer     Pacticipant   `json:"consumer"`
+	Provider     Pacticipant   `json:"provider"`
+	Interactions []Interaction `json:"interactions"`
+	Metadata     Metadata      `json:"metadata"`
+}
+
+// Pacticipant is either the consumer or the provider of a contract
+type Pacticipant struct {
+	Name string `json:"name"`
+}
+
+// Metadata holds the specification version the file was written with
+type Metadata struct {
+	PactSpecification struct {
+		Version string `json:"version"`
+	} `json:"pactSpecification"`
+}
+
+// Interaction is a single request/response pair of the contract
+type Interaction struct {
+	Description    string          `json:"description"`
+	ProviderState  string          `json:"providerState,omitempty"` // v2 provider state
+	ProviderStates []ProviderState `json:"providerStates,omitempty"`
+	Request        Request         `json:"request"`
+	Response       Response        `json:"response"`
+}
+
+// ProviderState is a named state the provider must be in before an interaction is replayed
+type ProviderState struct {
+	Name   string                 `json:"name"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// Request is the request the consumer sends
+type Request struct {
+	Method        string          `json:"method"`
+	Path          string          `json:"path"`
+	Query         Query           `json:"query,omitempty"`
+	Headers       Headers         `json:"headers,omitempty"`
+	Body          json.RawMessage `json:"body,omitempty"`
+	MatchingRules MatchingRules   `json:"matchingRules,omitempty"`
+}
+
+// Response is the response the consumer expects
+type Response struct {
+	Status        int             `json:"status"`
+	Headers       Headers         `json:"headers,omitempty"`
+	Body          json.RawMessage `json:"body,omitempty"`
+	MatchingRules MatchingRules   `json:"matchingRules,omitempty"`
+}
+
+// Query holds the query parameters of a request.
+// v2 files store it as a string, v3 files as a map of lists.
+type Query url.Values
+
+// UnmarshalJSON accepts both the v2 and the v3 representation
+func (q *Query) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		values, err := url.ParseQuery(s)
+		if err != nil {
+			return fmt.Errorf("invalid query %q\n%s", s, err)
+		}
+		*q = Query(values)
+		return nil
+	}
+
+	values := map[string][]string{}
+	if err := json.Unmarshal(b, &values); err != nil {
+		return fmt.Errorf("invalid query\n%s", err)
+	}
+
+	*q = Query(values)
+	return nil
+}
+
+// Encode returns the query in URL encoded form
+func (q Query) Encode() string {
+	return url.Values(q).Encode()
+}
+
+// Headers holds the headers of a request or response.
+// v3 files may store a header as a list, those values are joined with a comma.
+type Headers map[string]string
+
+// UnmarshalJSON accepts both single values and lists of values
+func (h *Headers) UnmarshalJSON(b []byte) error {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("invalid headers\n%s", err)
+	}
+
+	headers := Headers{}
+	for name, value := range raw {
+		var s string
+		if err := json.Unmarshal(value, &s); err == nil {
+			headers[name] = s
+			continue
+		}
+
+		var list []string
+		if err := json.Unmarshal(value, &list); err != nil {
+			return fmt.Errorf("invalid value for header %s\n%s", name, err)
+		}
+		headers[name] = strings.Join(list, ", ")
+	}
+
+	*h = headers
+	return nil
+}
+
+// Get returns the value of the named header, the name is case insensitive
+func (h Headers) Get(name string) (string, bool) {
+	for k, v := range h {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+
+	return "", false
+}
+
+// States returns the provider states of the interaction regardless of the specification version
+func (i Interaction) States() []ProviderState {
+	if len(i.ProviderStates) > 0 {
+		return i.ProviderStates
+	}
+
+	if i.ProviderState != "" {
+		return []ProviderState{{Name: i.ProviderState}}
+	}
+
+	return nil
+}
+
+// Load reads the pact file at the given path
+func Load(path string) (*File, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read pact file %s\n%s", path, err)
+	}
+
+	f := &File{}
+	if err = json.Unmarshal(b, f); err != nil {
+		return nil, fmt.Errorf("cannot parse pact file %s\n%s", path, err)
+	}
+
+	return f, nil
+}
+
+// LoadDir reads every pact file with a .json extension in the given directory.
+// Files are returned ordered by name.
+func LoadDir(dir string) ([]*File, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	files := make([]*File, 0, len(paths))
+	for _, p := range paths {
+		f, err := Load(p)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	return files, nil
+}
+
+// Write writes the pact file into the given directory and returns its path.
+// The file name is derived from the consumer and provider names. The file is
+// replaced atomically so a verifier never reads a partially written contract.
+func Write(dir string, f *File) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	f.Metadata.PactSpecification.Version = SpecificationVersion
+
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	name := strings.ToLower(f.Consumer.Name + "-" + f.Provider.Name)
+	path := filepath.Join(dir, strings.Replace(name, " ", "_", -1)+".json")
+
+	tmp, err := ioutil.TempFile(dir, ".pact-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return "", err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return "", err
+	}
+
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return "", err
+	}
+
+	return path, os.Rename(tmp.Name(), path)
+}
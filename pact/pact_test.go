@@ -0,0 +1,110 @@
+package pact
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestLoad(t *testing.T) {
+	wantRules := MatchingRules{
+		"$.body.user_key":        {{Match: "type"}},
+		"$.body.orders":          {{Match: "type", Min: intPtr(1)}},
+		"$.headers.Content-Type": {{Match: "regex", Regex: "application/json.*"}},
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStates []ProviderState
+	}{
+		{
+			name:       "loads v2 pact file",
+			path:       "testdata/v2.json",
+			wantStates: []ProviderState{{Name: "user john-doe exists"}},
+		},
+		{
+			name:       "loads v3 pact file",
+			path:       "testdata/v3.json",
+			wantStates: []ProviderState{{Name: "user john-doe exists", Params: map[string]interface{}{"balance": float64(100)}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Load(tt.path)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+
+			if len(f.Interactions) != 1 {
+				t.Fatalf("Load() got %d interactions, want 1", len(f.Interactions))
+			}
+
+			i := f.Interactions[0]
+			if got := i.States(); !reflect.DeepEqual(got, tt.wantStates) {
+				t.Errorf("States() = %v, want %v", got, tt.wantStates)
+			}
+
+			if got := i.Request.Query.Encode(); got != "dry_run=true&dry_run=false" {
+				t.Errorf("Query = %v, want %v", got, "dry_run=true&dry_run=false")
+			}
+
+			if got, _ := i.Request.Headers.Get("content-type"); got != "application/json" {
+				t.Errorf("Headers.Get() = %v, want %v", got, "application/json")
+			}
+
+			if !reflect.DeepEqual(i.Response.MatchingRules, wantRules) {
+				t.Errorf("MatchingRules = %v, want %v", i.Response.MatchingRules, wantRules)
+			}
+		})
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	files, err := LoadDir("testdata")
+	if err != nil {
+		t.Fatalf("LoadDir() error = %v", err)
+	}
+
+	if len(files) != 2 {
+		t.Errorf("LoadDir() got %d files, want 2", len(files))
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	want, _ := Load("testdata/v2.json")
+
+	path, err := Write(dir, want)
+	if err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if got.Metadata.PactSpecification.Version != SpecificationVersion {
+		t.Errorf("Write() version = %v, want %v", got.Metadata.PactSpecification.Version, SpecificationVersion)
+	}
+
+	// bodies are re-indented on write, compare their compact forms
+	wantJSON, _ := json.Marshal(want.Interactions)
+	gotJSON, _ := json.Marshal(got.Interactions)
+
+	if string(wantJSON) != string(gotJSON) {
+		t.Errorf("Write() round trip failed. want: %s, got: %s", wantJSON, gotJSON)
+	}
+}
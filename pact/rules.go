@@ -0,0 +1,268 @@
+package pact
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Matcher is a single matching rule.
+// v2 files may omit the match type for regex and min/max rules, it is filled in on load.
+type Matcher struct {
+	Match string `json:"match"`
+	Regex string `json:"regex,omitempty"`
+	Value string `json:"value,omitempty"`
+	Min   *int   `json:"min,omitempty"`
+	Max   *int   `json:"max,omitempty"`
+}
+
+// MatchingRules maps a path such as "$.body.user_key" or "$.headers.Content-Type"
+// to the matchers that apply to it and, unless overridden, to everything below it.
+type MatchingRules map[string][]Matcher
+
+var ruleCategories = map[string]string{
+	"body":    "body",
+	"header":  "headers",
+	"headers": "headers",
+	"query":   "query",
+	"path":    "path",
+	"status":  "status",
+}
+
+// UnmarshalJSON accepts both the flat v2 layout and the v3 layout grouped by category
+func (mr *MatchingRules) UnmarshalJSON(b []byte) error {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("invalid matching rules\n%s", err)
+	}
+
+	rules := MatchingRules{}
+	for key, value := range raw {
+		if strings.HasPrefix(key, "$") {
+			var m Matcher
+			if err := json.Unmarshal(value, &m); err != nil {
+				return fmt.Errorf("invalid matching rule for %s\n%s", key, err)
+			}
+			rules[key] = []Matcher{m.normalize()}
+			continue
+		}
+
+		category, ok := ruleCategories[key]
+		if !ok {
+			return fmt.Errorf("unknown matching rule category %s", key)
+		}
+
+		if category == "path" || category == "status" {
+			var rule ruleList
+			if err := json.Unmarshal(value, &rule); err != nil {
+				return fmt.Errorf("invalid %s matching rule\n%s", key, err)
+			}
+			rules["$."+category] = rule.normalized()
+			continue
+		}
+
+		byPath := map[string]ruleList{}
+		if err := json.Unmarshal(value, &byPath); err != nil {
+			return fmt.Errorf("invalid %s matching rules\n%s", key, err)
+		}
+
+		for p, rule := range byPath {
+			rules[categoryPath(category, p)] = rule.normalized()
+		}
+	}
+
+	*mr = rules
+	return nil
+}
+
+// MarshalJSON writes the rules in the v3 layout
+func (mr MatchingRules) MarshalJSON() ([]byte, error) {
+	out := map[string]interface{}{}
+
+	for key, matchers := range mr {
+		rule := ruleList{Matchers: matchers, Combine: "AND"}
+		tokens := parsePath(key)
+		if len(tokens) < 2 {
+			return nil, fmt.Errorf("invalid matching rule path %s", key)
+		}
+
+		switch tokens[1] {
+		case "path", "status":
+			out[tokens[1]] = rule
+		case "body":
+			group, _ := out["body"].(map[string]ruleList)
+			if group == nil {
+				group = map[string]ruleList{}
+				out["body"] = group
+			}
+			group[formatPath(append([]string{"$"}, tokens[2:]...))] = rule
+		case "headers", "query":
+			name := "header"
+			if tokens[1] == "query" {
+				name = "query"
+			}
+			group, _ := out[name].(map[string]ruleList)
+			if group == nil {
+				group = map[string]ruleList{}
+				out[name] = group
+			}
+			group[strings.Join(tokens[2:], ".")] = rule
+		default:
+			return nil, fmt.Errorf("unknown matching rule category in %s", key)
+		}
+	}
+
+	return json.Marshal(out)
+}
+
+// forPath returns the matchers of the most specific rule that covers the given path
+func (mr MatchingRules) forPath(path []string) []Matcher {
+	var best []Matcher
+	bestLen, bestExact := -1, -1
+
+	for key, matchers := range mr {
+		tokens := parsePath(key)
+		if len(tokens) > len(path) {
+			continue
+		}
+
+		exact, ok := 0, true
+		for i, t := range tokens {
+			switch {
+			case t == path[i]:
+				exact++
+			case t == "*" && !isIndex(path[i]), t == "[*]" && isIndex(path[i]):
+			default:
+				ok = false
+			}
+			if !ok {
+				break
+			}
+		}
+
+		if !ok {
+			continue
+		}
+
+		if len(tokens) > bestLen || (len(tokens) == bestLen && exact > bestExact) {
+			best, bestLen, bestExact = matchers, len(tokens), exact
+		}
+	}
+
+	return best
+}
+
+type ruleList struct {
+	Matchers []Matcher `json:"matchers"`
+	Combine  string    `json:"combine,omitempty"`
+}
+
+func (r ruleList) normalized() []Matcher {
+	matchers := make([]Matcher, 0, len(r.Matchers))
+	for _, m := range r.Matchers {
+		matchers = append(matchers, m.normalize())
+	}
+
+	return matchers
+}
+
+func (m Matcher) normalize() Matcher {
+	if m.Match != "" {
+		return m
+	}
+
+	switch {
+	case m.Regex != "":
+		m.Match = "regex"
+	case m.Min != nil || m.Max != nil:
+		m.Match = "type"
+	default:
+		m.Match = "equality"
+	}
+
+	return m
+}
+
+func categoryPath(category, p string) string {
+	if category != "body" {
+		return "$." + category + "." + p
+	}
+
+	return formatPath(append([]string{"$", "body"}, parsePath(p)[1:]...))
+}
+
+// parsePath splits a path such as "$.body.items[*].id" into its tokens.
+// Array indexes keep their brackets so they can be told apart from keys.
+func parsePath(p string) []string {
+	tokens := []string{"$"}
+	p = strings.TrimPrefix(p, "$")
+
+	for len(p) > 0 {
+		switch {
+		case strings.HasPrefix(p, "['"):
+			end := strings.Index(p, "']")
+			if end < 0 {
+				return append(tokens, p[2:])
+			}
+			tokens = append(tokens, p[2:end])
+			p = p[end+2:]
+		case p[0] == '[':
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return append(tokens, p)
+			}
+			tokens = append(tokens, p[:end+1])
+			p = p[end+1:]
+		case p[0] == '.':
+			p = p[1:]
+			end := strings.IndexAny(p, ".[")
+			if end < 0 {
+				end = len(p)
+			}
+			tokens = append(tokens, p[:end])
+			p = p[end:]
+		default:
+			// a path without the leading "$." such as a bare header name
+			end := strings.IndexAny(p, ".[")
+			if end < 0 {
+				end = len(p)
+			}
+			tokens = append(tokens, p[:end])
+			p = p[end:]
+		}
+	}
+
+	return tokens
+}
+
+func formatPath(tokens []string) string {
+	var sb strings.Builder
+	for i, t := range tokens {
+		switch {
+		case i == 0:
+			sb.WriteString(t)
+		case isIndex(t):
+			sb.WriteString(t)
+		default:
+			sb.WriteString("." + t)
+		}
+	}
+
+	return sb.String()
+}
+
+func isIndex(token string) bool {
+	return strings.HasPrefix(token, "[")
+}
+
+// sortedKeys returns the keys of the map in a stable order so reports are deterministic
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
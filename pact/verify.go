@@ -0,0 +1,230 @@
+package pact
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+)
+
+// Verifier replays the interactions of pact files against an in-process provider
+type Verifier struct {
+	Handler http.Handler // the provider under verification
+
+	// Setup is called before each interaction is replayed so the provider can reset its data
+	Setup func(i Interaction) error
+
+	// StateHandler is called for every provider state of an interaction, after Setup
+	StateHandler func(s ProviderState) error
+}
+
+// Result is the outcome of replaying a single interaction
+type Result struct {
+	Interaction Interaction
+	Mismatches  []Mismatch
+	Err         error // set when the interaction could not be replayed at all
+}
+
+// OK reports whether the provider honoured the interaction
+func (r Result) OK() bool {
+	return r.Err == nil && len(r.Mismatches) == 0
+}
+
+// VerifyFile replays every interaction of the file and returns one result per interaction
+func (v *Verifier) VerifyFile(f *File) []Result {
+	results := make([]Result, 0, len(f.Interactions))
+	for _, i := range f.Interactions {
+		results = append(results, v.VerifyInteraction(i))
+	}
+
+	return results
+}
+
+// VerifyInteraction replays a single interaction and compares the response with the expected one
+func (v *Verifier) VerifyInteraction(i Interaction) Result {
+	res := Result{Interaction: i}
+
+	if v.Setup != nil {
+		if err := v.Setup(i); err != nil {
+			res.Err = fmt.Errorf("setup failed\n%s", err)
+			return res
+		}
+	}
+
+	if v.StateHandler != nil {
+		for _, s := range i.States() {
+			if err := v.StateHandler(s); err != nil {
+				res.Err = fmt.Errorf("cannot set up provider state %q\n%s", s.Name, err)
+				return res
+			}
+		}
+	}
+
+	req, err := newHTTPRequest(i.Request)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	rr := httptest.NewRecorder()
+	v.Handler.ServeHTTP(rr, req)
+
+	body, _ := ioutil.ReadAll(rr.Result().Body)
+	res.Mismatches = compareResponse(i.Response, rr.Code, rr.Header(), body)
+
+	return res
+}
+
+func newHTTPRequest(r Request) (*http.Request, error) {
+	target := r.Path
+	if len(r.Query) > 0 {
+		target += "?" + r.Query.Encode()
+	}
+
+	body, err := requestBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	req := httptest.NewRequest(strings.ToUpper(r.Method), target, bytes.NewReader(body))
+	for name, value := range r.Headers {
+		req.Header.Set(name, value)
+	}
+
+	return req, nil
+}
+
+// requestBody returns the raw bytes to send, plain text bodies are stored as JSON strings
+func requestBody(r Request) ([]byte, error) {
+	if len(r.Body) == 0 {
+		return nil, nil
+	}
+
+	contentType, _ := r.Headers.Get("Content-Type")
+	if contentType == "" || isJSONContentType(contentType) {
+		return r.Body, nil
+	}
+
+	v, err := decodeJSON(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request body\n%s", err)
+	}
+
+	if s, ok := v.(string); ok {
+		return []byte(s), nil
+	}
+
+	return r.Body, nil
+}
+
+func compareResponse(expected Response, status int, headers http.Header, body []byte) []Mismatch {
+	var mismatches []Mismatch
+
+	if expected.Status != 0 && expected.Status != status {
+		mismatches = append(mismatches, Mismatch{
+			Type:     "status",
+			Path:     "$.status",
+			Expected: expected.Status,
+			Actual:   status,
+			Message:  "status codes differ",
+		})
+	}
+
+	mismatches = append(mismatches, compareHeaders(expected.Headers, headers, expected.MatchingRules)...)
+	mismatches = append(mismatches, compareBodies(expected.Body, body, expected.MatchingRules)...)
+
+	return mismatches
+}
+
+// compareHeaders checks every expected header, additional headers are allowed
+func compareHeaders(expected Headers, actual http.Header, rules MatchingRules) []Mismatch {
+	var mismatches []Mismatch
+
+	for name, want := range expected {
+		path := "$.headers." + name
+		values := actual[http.CanonicalHeaderKey(name)]
+		got := strings.Join(values, ", ")
+		if len(values) == 0 {
+			mismatches = append(mismatches, Mismatch{Type: "header", Path: path, Expected: want, Message: "header is missing"})
+			continue
+		}
+
+		if msg := compareHeader(name, want, got, rules[path]); msg != "" {
+			mismatches = append(mismatches, Mismatch{Type: "header", Path: path, Expected: want, Actual: got, Message: msg})
+		}
+	}
+
+	return mismatches
+}
+
+func compareHeader(name, want, got string, matchers []Matcher) string {
+	for _, m := range matchers {
+		if m.Match != "regex" {
+			continue
+		}
+
+		re, err := regexp.Compile(m.Regex)
+		if err != nil {
+			return fmt.Sprintf("invalid regex %q: %s", m.Regex, err)
+		}
+		if !re.MatchString(got) {
+			return fmt.Sprintf("value does not match %q", m.Regex)
+		}
+
+		return ""
+	}
+
+	if strings.EqualFold(name, "Content-Type") {
+		return compareContentType(want, got)
+	}
+
+	if normalizeHeader(want) != normalizeHeader(got) {
+		return "values are not equal"
+	}
+
+	return ""
+}
+
+// compareContentType compares media types, parameters are only checked if they are expected
+func compareContentType(want, got string) string {
+	wantType, wantParams, err := mime.ParseMediaType(want)
+	if err != nil {
+		return fmt.Sprintf("invalid expected content type %q", want)
+	}
+
+	gotType, gotParams, err := mime.ParseMediaType(got)
+	if err != nil || wantType != gotType {
+		return "content types differ"
+	}
+
+	for k, v := range wantParams {
+		if !strings.EqualFold(gotParams[k], v) {
+			return fmt.Sprintf("content type parameter %s differs", k)
+		}
+	}
+
+	return ""
+}
+
+// normalizeHeader removes the optional white space around list separators
+func normalizeHeader(v string) string {
+	parts := strings.Split(v, ",")
+	for i, p := range parts {
+		parts[i] = strings.TrimSpace(p)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
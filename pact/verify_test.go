@@ -0,0 +1,106 @@
+package pact
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestVerifier_VerifyInteraction(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.URL.Path != "/order/1/refund/" || r.Method != http.MethodPost {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(body)
+	})
+
+	f, _ := Load("testdata/v2.json")
+	valid := f.Interactions[0]
+	valid.Response.Body = json.RawMessage(`{"user_key": "john-doe"}`)
+
+	wrongStatus := valid
+	wrongStatus.Request.Path = "/order/2/refund/"
+
+	wrongBody := valid
+	wrongBody.Response.MatchingRules = nil
+	wrongBody.Response.Body = json.RawMessage(`{"user_key": "jane-doe"}`)
+
+	wrongHeader := valid
+	wrongHeader.Response.MatchingRules = nil
+	wrongHeader.Response.Headers = Headers{"Content-Type": "text/plain"}
+
+	tests := []struct {
+		name      string
+		i         Interaction
+		setupErr  error
+		stateErr  error
+		wantTypes []string
+		wantErr   bool
+	}{
+		{
+			name: "verifies interaction successfully",
+			i:    valid,
+		},
+		{
+			name:      "reports status mismatch",
+			i:         wrongStatus,
+			wantTypes: []string{"status", "header", "body"},
+		},
+		{
+			name:      "reports body mismatch",
+			i:         wrongBody,
+			wantTypes: []string{"body"},
+		},
+		{
+			name:      "reports header mismatch",
+			i:         wrongHeader,
+			wantTypes: []string{"header"},
+		},
+		{
+			name:     "fails when setup fails",
+			i:        valid,
+			setupErr: errors.New("db is down"),
+			wantErr:  true,
+		},
+		{
+			name:     "fails when provider state cannot be set up",
+			i:        valid,
+			stateErr: errors.New("unknown provider state"),
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &Verifier{
+				Handler: handler,
+				Setup: func(Interaction) error {
+					return tt.setupErr
+				},
+				StateHandler: func(ProviderState) error {
+					return tt.stateErr
+				},
+			}
+
+			got := v.VerifyInteraction(tt.i)
+			if (got.Err != nil) != tt.wantErr {
+				t.Fatalf("VerifyInteraction() error = %v, wantErr %v", got.Err, tt.wantErr)
+			}
+
+			if len(got.Mismatches) != len(tt.wantTypes) {
+				t.Fatalf("VerifyInteraction() mismatches = %v, want %v", got.Mismatches, tt.wantTypes)
+			}
+
+			for i, m := range got.Mismatches {
+				if m.Type != tt.wantTypes[i] {
+					t.Errorf("VerifyInteraction() mismatch type = %v, want %v", m.Type, tt.wantTypes[i])
+				}
+			}
+		})
+	}
+}